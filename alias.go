@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// validSlug matches the slugs TitleUrl produces.
+var validSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// renamePodcast changes the slug of the podcast from to to and keeps from
+// as an alias. Episodes are moved first, so a rename that fails halfway
+// can simply be run again.
+func renamePodcast(ctx context.Context, store Store, from, to string) error {
+	if !validSlug.MatchString(to) {
+		return fmt.Errorf("invalid slug %q, use lower case letters, digits and dashes", to)
+	}
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+
+	var podcast *Podcast
+	for i := range podcasts {
+		p := &podcasts[i]
+		if p.PodlistUrl == from {
+			podcast = p
+			continue
+		}
+		if p.PodlistUrl == to || containsString(p.Aliases, to) {
+			return fmt.Errorf("slug %q is already used by podcast %s", to, p.Title)
+		}
+	}
+	if podcast == nil {
+		return fmt.Errorf("no podcast %q", from)
+	}
+	if pinned := podcast.Settings.PinnedSlug; pinned != "" && pinned != to {
+		return fmt.Errorf("podcast %s is pinned to %s, unpin it with set %s pinnedSlug= first", podcast.Title, pinned, from)
+	}
+	if from == to {
+		return nil
+	}
+
+	moved, err := store.MoveEpisodes(ctx, from, to)
+	if err != nil {
+		return fmt.Errorf("error moving episodes: %v", err)
+	}
+
+	// Renaming back to a former slug takes it off the alias list.
+	aliases := []string{from}
+	for _, a := range podcast.Aliases {
+		if a != to && a != from {
+			aliases = append(aliases, a)
+		}
+	}
+	if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"podlistUrl": to, "aliases": aliases}); err != nil {
+		return fmt.Errorf("error updating podcast: %v", err)
+	}
+	infof(ctx, "Renamed podcast %s from %s to %s, moved %d episodes", podcast.Title, from, to, moved)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import "time"
+
+// The API answers with these rather than the stored documents, which hold
+// what only the crawler needs, like validators and failures, and feed URLs
+// that may carry credentials. Keys are the bson names of the fields.
+
+// apiPodcast is a podcast as the API serves it.
+type apiPodcast struct {
+	ID               string           `json:"id"`
+	StableID         string           `json:"stableId,omitempty"`
+	PodlistUrl       string           `json:"podlistUrl"`
+	Title            string           `json:"title"`
+	Subtitle         string           `json:"subtitle,omitempty"`
+	Description      string           `json:"description,omitempty"`
+	Link             string           `json:"link,omitempty"`
+	Author           string           `json:"author,omitempty"`
+	Image            string           `json:"image,omitempty"`
+	Feed             string           `json:"feed"`
+	Categories       []string         `json:"categories,omitempty"`
+	ITunesCategories []ITunesCategory `json:"itunesCategories,omitempty"`
+	People           []Person         `json:"people,omitempty"`
+	PodcastGUID      string           `json:"podcastGuid,omitempty"`
+	Funding          []Funding        `json:"funding,omitempty"`
+	Locked           bool             `json:"locked,omitempty"`
+	Aliases          []string         `json:"aliases,omitempty"`
+
+	LatestEpisodeAt     *time.Time `json:"latestEpisodeAt,omitempty"`
+	EpisodeCount        int        `json:"episodeCount"`
+	AverageIntervalDays float64    `json:"averageIntervalDays,omitempty"`
+	LastCrawledAt       *time.Time `json:"lastCrawledAt,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+	// Status is ok, failing, dead or retired.
+	Status string `json:"status"`
+
+	FeedType        string `json:"feedType,omitempty"`
+	Generator       string `json:"generator,omitempty"`
+	Copyright       string `json:"copyright,omitempty"`
+	HostingProvider string `json:"hostingProvider,omitempty"`
+	ImageWidth      int    `json:"imageWidth,omitempty"`
+	ImageHeight     int    `json:"imageHeight,omitempty"`
+	ImageFormat     string `json:"imageFormat,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+}
+
+func newAPIPodcast(p Podcast) apiPodcast {
+	return apiPodcast{
+		ID:                  p.ID.Hex(),
+		StableID:            p.StableID,
+		PodlistUrl:          p.PodlistUrl,
+		Title:               p.Title,
+		Subtitle:            p.Subtitle,
+		Description:         p.Description,
+		Link:                p.Link,
+		Author:              p.Author,
+		Image:               p.Image,
+		Feed:                redactURL(p.Feed),
+		Categories:          p.Categories,
+		ITunesCategories:    p.ITunesCategories,
+		People:              p.People,
+		PodcastGUID:         p.PodcastGUID,
+		Funding:             p.Funding,
+		Locked:              p.Locked,
+		Aliases:             p.Aliases,
+		LatestEpisodeAt:     apiTime(p.LatestEpisodeAt),
+		EpisodeCount:        p.EpisodeCount,
+		AverageIntervalDays: p.AverageIntervalDays,
+		LastCrawledAt:       apiTime(p.LastCrawledAt),
+		LastSuccessAt:       apiTime(p.LastSuccessAt),
+		Status:              podcastState(p),
+		FeedType:            p.FeedType,
+		Generator:           p.Generator,
+		Copyright:           p.Copyright,
+		HostingProvider:     p.HostingProvider,
+		ImageWidth:          p.ImageWidth,
+		ImageHeight:         p.ImageHeight,
+		ImageFormat:         p.ImageFormat,
+		Namespace:           p.Namespace,
+	}
+}
+
+// apiEpisode is an episode as the API and the event stream serve it.
+type apiEpisode struct {
+	ID           string    `json:"id"`
+	StableID     string    `json:"stableId,omitempty"`
+	PodlistUrl   string    `json:"podlistUrl"`
+	PodcastId    string    `json:"podcastId,omitempty"`
+	PodcastUrl   string    `json:"podcastUrl"`
+	PodcastTitle string    `json:"podcastTitle,omitempty"`
+	PodcastImage string    `json:"podcastImage,omitempty"`
+	Guid         string    `json:"guid,omitempty"`
+	Link         string    `json:"link,omitempty"`
+	Title        string    `json:"title"`
+	Published    time.Time `json:"published"`
+	// DateEstimated is set if the feed gave no usable date for Published.
+	DateEstimated bool   `json:"dateEstimated,omitempty"`
+	Duration      string `json:"duration,omitempty"`
+	Summary       string `json:"summary,omitempty"`
+	Subtitle      string `json:"subtitle,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Content       string `json:"content,omitempty"`
+	Image         string `json:"image,omitempty"`
+
+	Enclosure  apiEnclosure   `json:"enclosure"`
+	Enclosures []apiEnclosure `json:"enclosures,omitempty"`
+
+	WordCount          int          `json:"wordCount,omitempty"`
+	ReadingTimeSeconds int          `json:"readingTimeSeconds,omitempty"`
+	Soundbites         []Soundbite  `json:"soundbites,omitempty"`
+	People             []Person     `json:"people,omitempty"`
+	Transcripts        []Transcript `json:"transcripts,omitempty"`
+	ChaptersURL        string       `json:"chaptersUrl,omitempty"`
+	Chapters           []Chapter    `json:"chapters,omitempty"`
+
+	DuplicateOf    string     `json:"duplicateOf,omitempty"`
+	AudioRevisedAt *time.Time `json:"audioRevisedAt,omitempty"`
+	Namespace      string     `json:"namespace,omitempty"`
+}
+
+// apiEnclosure is an enclosure of an apiEpisode. Dead is set if
+// --verify-enclosures found it unreachable.
+type apiEnclosure struct {
+	URL  string `json:"url"`
+	Type string `json:"type,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	Dead bool   `json:"dead,omitempty"`
+}
+
+func newAPIEpisode(e Episode) apiEpisode {
+	a := apiEpisode{
+		ID:                 e.ID.Hex(),
+		StableID:           e.StableID,
+		PodlistUrl:         e.PodlistUrl,
+		PodcastUrl:         e.PodcastUrl,
+		PodcastTitle:       e.PodcastTitle,
+		PodcastImage:       e.PodcastImage,
+		Guid:               e.Guid,
+		Link:               e.EpisodeLink,
+		Title:              e.Title,
+		Published:          e.Published,
+		DateEstimated:      e.DateEstimated,
+		Duration:           e.Duration,
+		Summary:            e.Summary,
+		Subtitle:           e.Subtitle,
+		Description:        e.Description,
+		Content:            e.Content,
+		Image:              e.Image,
+		Enclosure:          newAPIEnclosure(e.Enclosure),
+		WordCount:          e.WordCount,
+		ReadingTimeSeconds: e.ReadingTimeSeconds,
+		Soundbites:         e.Soundbites,
+		People:             e.People,
+		Transcripts:        e.Transcripts,
+		ChaptersURL:        e.ChaptersURL,
+		Chapters:           e.Chapters,
+		AudioRevisedAt:     apiTime(e.AudioRevisedAt),
+		Namespace:          e.Namespace,
+	}
+	if !e.PodcastId.IsZero() {
+		a.PodcastId = e.PodcastId.Hex()
+	}
+	if !e.DuplicateOf.IsZero() {
+		a.DuplicateOf = e.DuplicateOf.Hex()
+	}
+	for _, enc := range e.Enclosures {
+		a.Enclosures = append(a.Enclosures, newAPIEnclosure(enc))
+	}
+	return a
+}
+
+func newAPIEnclosure(e EpisodeEnclosure) apiEnclosure {
+	return apiEnclosure{URL: redactURL(e.Url), Type: e.Filetype, Size: e.Size, Dead: e.Dead}
+}
+
+func newAPIEpisodes(episodes []Episode) []apiEpisode {
+	out := make([]apiEpisode, len(episodes))
+	for i, e := range episodes {
+		out[i] = newAPIEpisode(e)
+	}
+	return out
+}
+
+// apiTime returns t for an omitempty field, nil if it is zero.
+func apiTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
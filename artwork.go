@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxArtworkHeader is how much of an image is read to find its size. The
+// dimensions are near the start, but JPEGs may carry a large EXIF block
+// before them.
+const maxArtworkHeader = 1 << 20
+
+const artworkQueueSize = 1000
+
+// artworkInspection is a podcast whose artwork is to be inspected, with
+// the store of its namespace.
+type artworkInspection struct {
+	store   Store
+	podcast Podcast
+}
+
+// artworkInspector looks up the dimensions and format of podcast artwork
+// in the background, so ingestion never waits for it. A nil inspector
+// inspects nothing.
+type artworkInspector struct {
+	ctx   context.Context
+	queue chan artworkInspection
+	wg    sync.WaitGroup
+
+	mu        sync.Mutex
+	inspected int
+}
+
+var artworkChecks *artworkInspector
+
+func newArtworkInspector(ctx context.Context, workers int) *artworkInspector {
+	a := &artworkInspector{ctx: ctx, queue: make(chan artworkInspection, artworkQueueSize)}
+	for i := 0; i < workers; i++ {
+		a.wg.Add(1)
+		go a.run()
+	}
+	return a
+}
+
+// Inspect queues the artwork of podcast unless it was inspected already or
+// the settings of the podcast disable enrichment.
+func (a *artworkInspector) Inspect(store Store, podcast Podcast) {
+	if a == nil || podcast.Image == "" || podcast.InspectedImage == podcast.Image || podcast.Settings.EnrichmentDisabled {
+		return
+	}
+	select {
+	case a.queue <- artworkInspection{store: store, podcast: podcast}:
+	default:
+		debugf(a.ctx, "Artwork queue full, not inspecting the artwork of %s", podcast.PodlistUrl)
+	}
+}
+
+// Close waits for the queued inspections.
+func (a *artworkInspector) Close() {
+	if a == nil {
+		return
+	}
+	close(a.queue)
+	a.wg.Wait()
+	infof(a.ctx, "Inspected the artwork of %d podcasts", a.inspected)
+}
+
+func (a *artworkInspector) run() {
+	defer a.wg.Done()
+	for job := range a.queue {
+		if a.ctx.Err() != nil {
+			continue
+		}
+		p := job.podcast
+		img, err := inspectImage(a.ctx, p.Image)
+		if err != nil {
+			// It is tried again on the next crawl.
+			debugf(a.ctx, "Error inspecting artwork %s of %s: %v", p.Image, p.PodlistUrl, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(a.ctx, config.DBTimeout)
+		err = job.store.UpdatePodcast(ctx, p.ID, bson.M{
+			"imageWidth":     img.Width,
+			"imageHeight":    img.Height,
+			"imageFormat":    img.Format,
+			"inspectedImage": p.Image,
+		})
+		cancel()
+		if err != nil {
+			errorf(ctx, "Error updating podcast %s: %v", p.Title, err)
+			continue
+		}
+		a.mu.Lock()
+		a.inspected++
+		a.mu.Unlock()
+	}
+}
+
+// imageConfig is the size and format of an image. An image that couldn't
+// be decoded has an empty Format.
+type imageConfig struct {
+	Width, Height int
+	Format        string
+}
+
+// inspectImage fetches the start of the image at imageURL and returns its
+// dimensions and format. It only fails if the image couldn't be fetched.
+func inspectImage(ctx context.Context, imageURL string) (imageConfig, error) {
+	// The header is all DecodeConfig needs.
+	data, err := fetchBounded(ctx, imageURL, maxArtworkHeader)
+	if err != nil && err != errResponseTooLarge {
+		return imageConfig{}, err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		debugf(ctx, "Artwork %s is no image we can read: %v", imageURL, err)
+		return imageConfig{}, nil
+	}
+	return imageConfig{Width: cfg.Width, Height: cfg.Height, Format: format}, nil
+}
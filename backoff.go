@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// backoffErrorRate is the share of failed fetches in a batch from which the
+// crawl slows down.
+const backoffErrorRate = 0.3
+
+// backoff decides how long to pause between batches. Healthy batches are
+// followed by the next one right away. Once failures or timeouts in a batch
+// reach backoffErrorRate the pause starts at min and doubles with every
+// further unhealthy batch, up to max.
+type backoff struct {
+	min, max time.Duration
+	delay    time.Duration
+}
+
+// next returns the pause after a batch with the given results.
+func (b *backoff) next(results []feedResult) time.Duration {
+	fetched, failed := 0, 0
+	for _, res := range results {
+		if res.Skipped {
+			continue
+		}
+		fetched++
+		if res.Err != nil {
+			failed++
+		}
+	}
+	if fetched == 0 || float64(failed)/float64(fetched) < backoffErrorRate {
+		b.delay = 0
+		return 0
+	}
+
+	switch {
+	case b.delay == 0:
+		b.delay = b.min
+	case b.delay < b.max:
+		b.delay *= 2
+	}
+	// Throttling hosts get at least the pause they asked for.
+	for _, res := range results {
+		if res.RetryAfter > b.delay {
+			b.delay = res.RetryAfter
+		}
+	}
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+	warnf(context.Background(), "%d of %d feeds in the last batch failed, pausing for %s", failed, fetched, b.delay)
+	return b.delay
+}
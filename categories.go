@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// ITunesCategory is an itunes:category together with the names of its
+// nested subcategories, which the flat feed.Categories list loses.
+type ITunesCategory struct {
+	Name          string   `bson:"name" json:"name"`
+	Subcategories []string `bson:"subcategories,omitempty" json:"subcategories,omitempty"`
+}
+
+// parseITunesCategories returns the iTunes categories of a feed. Feeds may
+// repeat a top level category for each of its subcategories, so entries
+// with the same name are merged.
+func parseITunesCategories(itunes *ext.ITunesFeedExtension) []ITunesCategory {
+	if itunes == nil {
+		return nil
+	}
+	var categories []ITunesCategory
+	index := make(map[string]int)
+	for _, c := range itunes.Categories {
+		if c == nil {
+			continue
+		}
+		name := strings.TrimSpace(c.Text)
+		if name == "" {
+			continue
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(categories)
+			index[name] = i
+			categories = append(categories, ITunesCategory{Name: name})
+		}
+		if c.Subcategory == nil {
+			continue
+		}
+		sub := strings.TrimSpace(c.Subcategory.Text)
+		if sub != "" && !containsString(categories[i].Subcategories, sub) {
+			categories[i].Subcategories = append(categories[i].Subcategories, sub)
+		}
+	}
+	return categories
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
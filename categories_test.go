@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// categoriesFeed is a feed declaring categories the way iTunes feeds do,
+// with a top level category repeated for a second subcategory.
+const categoriesFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title>Tech Talk</title>
+    <link>https://techtalk.example.com/</link>
+    <description>Weekly talk about technology.</description>
+    <itunes:category text="Technology">
+      <itunes:category text="Tech News"/>
+    </itunes:category>
+    <itunes:category text="Technology">
+      <itunes:category text="Podcasting"/>
+    </itunes:category>
+    <itunes:category text="Comedy"/>
+    <itunes:category text=" "/>
+  </channel>
+</rss>`
+
+func TestParseITunesCategories(t *testing.T) {
+	feed, err := gofeed.NewParser().ParseString(categoriesFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ITunesCategory{
+		{Name: "Technology", Subcategories: []string{"Tech News", "Podcasting"}},
+		{Name: "Comedy"},
+	}
+	if got := parseITunesCategories(feed.ITunesExt); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := parseITunesCategories(nil); got != nil {
+		t.Errorf("feed without iTunes tags: got %+v", got)
+	}
+}
+
+func TestPodcastITunesCategories(t *testing.T) {
+	ctx := context.Background()
+	feed, err := gofeed.NewParser().ParseString(categoriesFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.FeedLink = "https://a.example/feed"
+	podcast := createNewPodcast(feed, feedCuration{}, "tech-talk")
+	if len(podcast.ITunesCategories) != 2 || podcast.ITunesCategories[0].Subcategories[0] != "Tech News" {
+		t.Errorf("new podcast has categories %+v", podcast.ITunesCategories)
+	}
+
+	store := newMemoryStore()
+	if err := store.InsertPodcast(ctx, &podcast); err != nil {
+		t.Fatal(err)
+	}
+	feed.ITunesExt.Categories = feed.ITunesExt.Categories[2:3]
+	updatePodcast(ctx, &podcast, feed, feedCuration{}, store)
+	stored, err := store.PodcastByFeed(ctx, feed.FeedLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ITunesCategory{{Name: "Comedy"}}; !reflect.DeepEqual(stored.ITunesCategories, want) {
+		t.Errorf("updated podcast has categories %+v, want %+v", stored.ITunesCategories, want)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Entities and operations of a Change.
+const (
+	entityPodcast = "podcast"
+	entityEpisode = "episode"
+
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// changeBatchSize is how many changes are collected before they are
+// written out together.
+const changeBatchSize = 500
+
+// quietFields change on every crawl and would drown out the real changes,
+// so they are not recorded.
+var quietFields = map[string]bool{"updated": true, "lastCrawledAt": true, "lastSuccessAt": true, "feedHash": true, "nextCrawlAt": true,
+	"failureCount": true, "failingSince": true, "lastError": true}
+
+// Change records that a crawl created, updated or deleted a podcast or an
+// episode, for consumers that follow the catalogue without rereading it.
+// The copies of the podcast image on episodes are not recorded separately,
+// they follow from an update of the podcast image.
+type Change struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RunID     primitive.ObjectID `bson:"runId,omitempty" json:"runId,omitempty"`
+	At        time.Time          `bson:"at" json:"at"`
+	Namespace string             `bson:"namespace,omitempty" json:"namespace,omitempty"`
+	Entity    string             `bson:"entity" json:"entity"`
+	EntityID  primitive.ObjectID `bson:"entityId" json:"entityId"`
+	Operation string             `bson:"operation" json:"operation"`
+	Fields    []string           `bson:"fields,omitempty" json:"fields,omitempty"`
+}
+
+// changeRecorder collects the changes of the current run and stores them
+// in batches. Failing to store them is logged and otherwise ignored. A nil
+// recorder silently drops everything.
+type changeRecorder struct {
+	store Store
+	runID primitive.ObjectID
+
+	mu      sync.Mutex
+	pending []Change
+}
+
+var changes *changeRecorder
+
+// startChangeLog returns a recorder for the changes of run, which may be
+// nil if the run itself isn't recorded.
+func startChangeLog(store Store, run *crawlRecorder) *changeRecorder {
+	r := &changeRecorder{store: store}
+	if run != nil {
+		r.runID = run.run.ID
+	}
+	return r
+}
+
+func (r *changeRecorder) record(namespace, entity string, id primitive.ObjectID, op string, fields []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.pending = append(r.pending, Change{
+		RunID:     r.runID,
+		At:        time.Now(),
+		Namespace: namespace,
+		Entity:    entity,
+		EntityID:  id,
+		Operation: op,
+		Fields:    fields,
+	})
+	full := len(r.pending) >= changeBatchSize
+	r.mu.Unlock()
+	if full {
+		r.flush()
+	}
+}
+
+// podcastUpdated records the fields of set that change podcast, if any.
+func (r *changeRecorder) podcastUpdated(podcast Podcast, set bson.M) {
+	if r == nil {
+		return
+	}
+	if fields := changedFields(podcast, set); len(fields) > 0 {
+		r.record(podcast.Namespace, entityPodcast, podcast.ID, opUpdate, fields)
+	}
+}
+
+// episodeUpdated records an update of episode e with set.
+func (r *changeRecorder) episodeUpdated(e Episode, set bson.M) {
+	if r == nil {
+		return
+	}
+	fields := make([]string, 0, len(set))
+	for k := range set {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	r.record(e.Namespace, entityEpisode, e.ID, opUpdate, fields)
+}
+
+// flush stores the changes collected so far.
+func (r *changeRecorder) flush() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.DBTimeout)
+	defer cancel()
+	if err := r.store.InsertChanges(ctx, pending); err != nil {
+		errorf(ctx, "Error recording %d changes: %v", len(pending), err)
+	}
+}
+
+// changedFields returns the fields of set, except for quietFields, whose
+// values differ from those of podcast.
+func changedFields(podcast Podcast, set bson.M) []string {
+	updated := podcast
+	if err := setFields(&updated, set); err != nil {
+		return nil
+	}
+	before, err := docFields(podcast)
+	if err != nil {
+		return nil
+	}
+	after, err := docFields(updated)
+	if err != nil {
+		return nil
+	}
+	var fields []string
+	for k := range set {
+		if !quietFields[k] && !reflect.DeepEqual(before[k], after[k]) {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// docFields returns the fields of doc as they would be stored.
+func docFields(doc interface{}) (bson.M, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	return m, bson.Unmarshal(data, &m)
+}
+
+// parseChangesSince parses the argument of the changes command: the ID of
+// a crawl run, whose changes are then included, or a date or timestamp.
+func parseChangesSince(arg string) (time.Time, error) {
+	if id, err := primitive.ObjectIDFromHex(arg); err == nil {
+		return id.Timestamp(), nil
+	}
+	var d dateFlag
+	if err := d.Set(arg); err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a crawl run ID nor a date", arg)
+	}
+	return time.Time(d), nil
+}
+
+// printChanges writes the changes recorded since since to stdout as JSON
+// lines, oldest first.
+func printChanges(ctx context.Context, store Store, since time.Time) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	return store.Changes(ctx, since, func(c Change) error {
+		return enc.Encode(c)
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxChaptersSize is the largest JSON chapters document that is fetched.
+const maxChaptersSize = 1 << 20
+
+const chaptersQueueSize = 10000
+
+// chaptersFetch is an episode whose chapters are to be fetched, with the
+// store of its namespace.
+type chaptersFetch struct {
+	store   Store
+	episode Episode
+}
+
+// chaptersFetcher fetches the JSON chapters of episodes in the background,
+// so ingestion never waits for them, and embeds them in the episode. A nil
+// fetcher fetches nothing.
+type chaptersFetcher struct {
+	ctx   context.Context
+	queue chan chaptersFetch
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	fetched int
+}
+
+var chapterFetches *chaptersFetcher
+
+func newChaptersFetcher(ctx context.Context, workers int) *chaptersFetcher {
+	f := &chaptersFetcher{ctx: ctx, queue: make(chan chaptersFetch, chaptersQueueSize)}
+	for i := 0; i < workers; i++ {
+		f.wg.Add(1)
+		go f.run()
+	}
+	return f
+}
+
+// Fetch queues the chapters of those of episodes, which must be stored
+// already, that link to chapters not fetched yet, unless the settings of
+// podcast disable enrichment.
+func (f *chaptersFetcher) Fetch(store Store, podcast Podcast, episodes []Episode) {
+	if f == nil || podcast.Settings.EnrichmentDisabled {
+		return
+	}
+	for _, e := range episodes {
+		if e.ChaptersURL == "" || e.ChaptersURL == e.FetchedChapters {
+			continue
+		}
+		select {
+		case f.queue <- chaptersFetch{store: store, episode: e}:
+		default:
+			debugf(f.ctx, "Chapters queue full, not fetching the chapters of %s", podcast.PodlistUrl)
+			return
+		}
+	}
+}
+
+// Close waits for the queued fetches.
+func (f *chaptersFetcher) Close() {
+	if f == nil {
+		return
+	}
+	close(f.queue)
+	f.wg.Wait()
+	infof(f.ctx, "Fetched the chapters of %d episodes", f.fetched)
+}
+
+func (f *chaptersFetcher) run() {
+	defer f.wg.Done()
+	for job := range f.queue {
+		if f.ctx.Err() != nil {
+			continue
+		}
+		e := job.episode
+		chapters, err := fetchChapters(f.ctx, e.ChaptersURL)
+		if err != nil {
+			// It is tried again on the next crawl.
+			debugf(f.ctx, "Error fetching chapters %s of episode %s of %s: %v", e.ChaptersURL, e.Guid, e.PodcastUrl, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(f.ctx, config.DBTimeout)
+		err = job.store.UpdateEpisode(ctx, e.ID, bson.M{
+			"chapters":        chapters,
+			"fetchedChapters": e.ChaptersURL,
+		})
+		cancel()
+		if err != nil {
+			errorf(ctx, "Error storing chapters of episode %s of %s: %v", e.Guid, e.PodcastUrl, err)
+			continue
+		}
+		f.mu.Lock()
+		f.fetched++
+		f.mu.Unlock()
+	}
+}
+
+// jsonChapters is a JSON chapters document, as podcast:chapters links to.
+type jsonChapters struct {
+	Chapters []struct {
+		StartTime *float64 `json:"startTime"`
+		EndTime   float64  `json:"endTime"`
+		Title     string   `json:"title"`
+		Img       string   `json:"img"`
+		URL       string   `json:"url"`
+	} `json:"chapters"`
+}
+
+// fetchChapters fetches the JSON chapters document at chaptersURL and
+// returns its chapters. Chapters without a valid startTime are skipped, as
+// are images and links that aren't http(s) URLs.
+func fetchChapters(ctx context.Context, chaptersURL string) ([]Chapter, error) {
+	data, err := fetchBounded(ctx, chaptersURL, maxChaptersSize)
+	if err != nil {
+		return nil, err
+	}
+	var doc jsonChapters
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON chapters: %v", err)
+	}
+	var chapters []Chapter
+	for _, c := range doc.Chapters {
+		if c.StartTime == nil || *c.StartTime < 0 {
+			debugf(ctx, "Skipping chapter %q of %s without a valid startTime", c.Title, chaptersURL)
+			continue
+		}
+		chapter := Chapter{StartTime: *c.StartTime, Title: strings.TrimSpace(c.Title)}
+		if c.EndTime > chapter.StartTime {
+			chapter.EndTime = c.EndTime
+		}
+		if img := strings.TrimSpace(c.Img); isHTTPURL(img) {
+			chapter.Img = img
+		}
+		if u := strings.TrimSpace(c.URL); isHTTPURL(u) {
+			chapter.URL = u
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, nil
+}
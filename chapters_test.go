@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFetchChapters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chapters.json":
+			fmt.Fprint(w, `{"version": "1.2.0", "chapters": [
+				{"startTime": 0, "endTime": 60, "title": " Intro ", "img": "https://a.example/intro.jpg"},
+				{"title": "No start"},
+				{"startTime": -5, "title": "Negative start"},
+				{"startTime": 60, "endTime": 30, "title": "News", "url": "javascript:alert(1)"}
+			]}`)
+		case "/broken.json":
+			fmt.Fprint(w, `{"chapters": [`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	newIngester(t, newMemoryStore())
+
+	ctx := context.Background()
+	chapters, err := fetchChapters(ctx, server.URL+"/chapters.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chapters without a valid start are skipped, as are an end before
+	// the start and links that aren't http(s).
+	want := []Chapter{
+		{StartTime: 0, EndTime: 60, Title: "Intro", Img: "https://a.example/intro.jpg"},
+		{StartTime: 60, Title: "News"},
+	}
+	if !reflect.DeepEqual(chapters, want) {
+		t.Errorf("got chapters %+v, want %+v", chapters, want)
+	}
+	for _, path := range []string{"/broken.json", "/missing.json"} {
+		if _, err := fetchChapters(ctx, server.URL+path); err == nil {
+			t.Errorf("%s: fetching chapters didn't fail", path)
+		}
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings of a crawl. It is filled from the
+// command line in main and read by the crawler afterwards.
+type Config struct {
+	Store       string
+	MongoCAFile string
+	Namespace   string
+	FeedsFile   string
+	FeedTimeout time.Duration
+	MaxFeedSize int64
+	// Retries is how often a fetch that failed for a passing reason is
+	// tried again, after RetryDelay and twice as long for every further
+	// attempt.
+	Retries      int
+	RetryDelay   time.Duration
+	DBTimeout    time.Duration
+	PingOnly     bool
+	IgnoreRobots bool
+	RobotsTTL    time.Duration
+	// HostRate and HostConcurrency bound the requests to any one host:
+	// how many per second and how many at the same time, 0 for no limit.
+	HostRate        float64
+	HostConcurrency int
+	AllowHosts      stringList
+	BlockHosts      stringList
+	AllowPrivate    bool
+
+	HonorUpdateHints bool
+	// DeadAfterFailures and DeadAfter are how many crawls in a row over
+	// how long have to fail for a feed to count as dead.
+	DeadAfterFailures int
+	DeadAfter         time.Duration
+	DisabledRules     stringList
+
+	Dupes             string
+	RemovedEpisodes   string
+	DupeSizeTolerance float64
+	DupeMinCopies     int
+
+	Since       time.Duration
+	SinceDate   dateFlag
+	SkipUndated bool
+
+	DefaultTimezone locationFlag
+
+	Prefer     string
+	PreferMIME stringList
+	PreferSize string
+
+	MaxEpisodesPerFeed int
+	SkipIfNoNewer      bool
+	LinkDuplicates     bool
+
+	MongoTLSInsecure            bool
+	MongoMaxPoolSize            uint64
+	MongoMinPoolSize            uint64
+	MongoServerSelectionTimeout time.Duration
+	MongoMajority               bool
+	MongoStartupWait            time.Duration
+
+	BatchSize   int
+	Concurrency int
+	BatchDelay  time.Duration
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxRuntime  time.Duration
+
+	Restart       bool
+	Daemon        bool
+	Interval      scheduleFlag
+	MetricsListen string
+
+	// EpisodeConcurrency is how many episodes are enriched at the same
+	// time, zero for as many as Concurrency.
+	EpisodeConcurrency int
+
+	WebhookURL     string
+	WebhookTimeout time.Duration
+
+	SearchURL    string
+	SearchIndex  string
+	SearchAPIKey string
+
+	Debug         bool
+	LogFormat     string
+	LogLevel      string
+	Progress      bool
+	Report        string
+	ProgressEvery int
+	Only          string
+
+	BackfillStats bool
+	Recount       bool
+	Reprocess     bool
+	RepairGUIDs   bool
+	ExportJSON    string
+	ImportJSON    string
+
+	RefreshEpisodeImages bool
+	BackfillPodcastIDs   bool
+	CheckLinks           bool
+	VerifyEnclosures     bool
+	InspectImages        bool
+	FetchChapters        bool
+	EnclosureCheckDelay  time.Duration
+	Add                  bool
+
+	// Command is the command given after the flags, "" for a crawl, and
+	// CommandArgs its arguments.
+	Command        string
+	CommandArgs    []string
+	HistoryPodcast string
+	BaseURL        string
+	Output         string
+	Listen         string
+	Watch          bool
+}
+
+var config = Config{
+	Store:             mongoURI,
+	Namespace:         defaultNamespace,
+	FeedsFile:         storeFeeds,
+	FeedTimeout:       30 * time.Second,
+	MaxFeedSize:       100 << 20,
+	Retries:           2,
+	RetryDelay:        time.Second,
+	DeadAfterFailures: 10,
+	DeadAfter:         7 * 24 * time.Hour,
+	DBTimeout:         30 * time.Second,
+	RobotsTTL:         24 * time.Hour,
+	HostConcurrency:   2,
+	BatchSize:         10,
+	Concurrency:       3,
+	MinBackoff:        5 * time.Second,
+	MaxBackoff:        2 * time.Minute,
+
+	MongoStartupWait: 30 * time.Second,
+
+	DupeSizeTolerance: 0.01,
+	DupeMinCopies:     2,
+
+	WebhookTimeout: 5 * time.Second,
+	SearchIndex:    "episodes",
+	ProgressEvery:  25,
+	LogFormat:      logFormatPlain,
+	LogLevel:       "info",
+
+	EnclosureCheckDelay: time.Second,
+
+	Prefer: enclosureAudio,
+
+	Output: "sitemap",
+	Listen: ":8080",
+
+	Interval: scheduleFlag{schedule: intervalSchedule(time.Hour), value: "1h"},
+}
+
+// commands are the commands podgo accepts besides crawling, with the
+// number of arguments they take. Those in optionalArgs may leave out their
+// last argument.
+var commands = map[string]int{"crawl": 0, "add": 1, "remove": 1, "enable": 1, "disable": 1, "import-feeds": 1, "list": 0, "history": 0, "rename": 2, "assign-namespace": 1, "sitemap": 0, "dedupe-episodes": 0, "discover": 1, "stats": 0, "changes": 1, "find-dupes": 1, "set": 2, "warnings": 0, "serve": 0, "export": 1, "dead": 0, "revive": 1}
+
+var optionalArgs = map[string]bool{"find-dupes": true, "export": true}
+
+// commandUsage describes the commands in the usage message, in the order
+// shown there.
+var commandUsage = [][2]string{
+	{"crawl", "crawl the feeds of the feed list, the default"},
+	{"add <feed-url>", "add a feed to the feed list"},
+	{"remove <slug|feed-url>", "remove a podcast's feed from the feed list"},
+	{"disable <slug|feed-url>", "stop crawling a feed of the feed list in the store"},
+	{"enable <slug|feed-url>", "crawl a disabled feed again"},
+	{"import-feeds <file>", "add the feeds of a JSON or OPML feed list to the one in the store"},
+	{"list", "list the feeds of the feed list and their podcasts"},
+	{"serve", "serve the podcasts and episodes as JSON on --listen"},
+	{"export [opml]", "write the podcasts to stdout as OPML, the default format"},
+	{"discover <page-url>", "look for the feeds of a web page"},
+	{"history", "show the recent crawls, or with --podcast those of a podcast"},
+	{"stats", "count the podcasts by hosting provider and feed type"},
+	{"changes <since>", "show what crawls changed since a date or duration"},
+	{"warnings", "show the warnings the feeds had in their last crawl"},
+	{"dead", "list the podcasts marked dead"},
+	{"revive <slug>", "crawl a dead podcast again"},
+	{"rename <from> <to>", "change the slug of a podcast"},
+	{"set <slug> <key=value>", "change a setting of a podcast"},
+	{"assign-namespace <ns>", "move the podcasts of the feed list to a namespace"},
+	{"sitemap", "write sitemaps of the podcasts to --output"},
+	{"find-dupes [slug]", "look for near duplicate episodes, see --dupes"},
+	{"dedupe-episodes", "merge episodes whose GUIDs only differ by normalization"},
+}
+
+func printUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: podgo [flags] [command] [arguments]\n\nCommands:\n")
+	for _, c := range commandUsage {
+		fmt.Fprintf(w, "  %-28s %s\n", c[0], c[1])
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+// flags is the flag set config was parsed from.
+var flags *flag.FlagSet
+
+func parseFlags(args []string) error {
+	fs := flag.NewFlagSet("podgo", flag.ContinueOnError)
+	flags = fs
+	fs.StringVar(&config.Store, "store", config.Store, "MongoDB URI, postgres:// URL, sqlite:<file> for an SQLite database or memory: for a dry run")
+	fs.StringVar(&config.MongoCAFile, "mongo-tls-ca-file", config.MongoCAFile, "PEM file with the CA certificates to verify the MongoDB server with")
+	fs.BoolVar(&config.MongoTLSInsecure, "mongo-tls-insecure", config.MongoTLSInsecure, "don't verify the certificate of the MongoDB server, for development only")
+	fs.Uint64Var(&config.MongoMaxPoolSize, "mongo-max-pool-size", config.MongoMaxPoolSize, "most connections to MongoDB kept open (default: the driver's 100)")
+	fs.Uint64Var(&config.MongoMinPoolSize, "mongo-min-pool-size", config.MongoMinPoolSize, "fewest connections to MongoDB kept open")
+	fs.DurationVar(&config.MongoServerSelectionTimeout, "mongo-server-selection-timeout", config.MongoServerSelectionTimeout, "how long to wait for a suitable MongoDB server (default: the driver's 30s)")
+	fs.BoolVar(&config.MongoMajority, "mongo-majority", config.MongoMajority, "wait for writes to reach a majority of the replica set")
+	fs.DurationVar(&config.MongoStartupWait, "mongo-startup-wait", config.MongoStartupWait, "how long to keep trying to reach MongoDB on startup, 0 to fail on the first try")
+	fs.BoolVar(&config.PingOnly, "ping-only", config.PingOnly, "check that the store can be reached and its indexes exist, then exit; for health checks")
+	fs.StringVar(&config.Namespace, "namespace", config.Namespace, "namespace of the podcasts to work on; entries of the feed list may name their own")
+	fs.StringVar(&config.FeedsFile, "feeds", config.FeedsFile, "where the feed list is: store for the one kept in the store, see add and import-feeds, which starts out as a copy of bak/feedbak.json if there is one, a JSON file of feed URLs, an OPML file whose folders become tags, or - to read one URL per line from stdin")
+	fs.DurationVar(&config.FeedTimeout, "feed-timeout", config.FeedTimeout, "time budget for fetching and parsing a single feed")
+	fs.Int64Var(&config.MaxFeedSize, "max-feed-size", config.MaxFeedSize, "largest feed in bytes that is fetched, bigger ones fail")
+	fs.IntVar(&config.Retries, "retries", config.Retries, "how often a feed is fetched again after a timeout, network error, 429 or 5xx status, waiting at least as long as a Retry-After header asks; each attempt gets its own --feed-timeout")
+	fs.DurationVar(&config.RetryDelay, "retry-delay", config.RetryDelay, "pause before the first retry of a feed, doubling for each further one, with some jitter")
+	fs.DurationVar(&config.DBTimeout, "db-timeout", config.DBTimeout, "time budget for storing a single feed once it is fetched")
+	fs.BoolVar(&config.IgnoreRobots, "ignore-robots", config.IgnoreRobots, "fetch feeds even if robots.txt disallows them")
+	fs.DurationVar(&config.RobotsTTL, "robots-ttl", config.RobotsTTL, "how long a fetched robots.txt is cached per host")
+	fs.Float64Var(&config.HostRate, "host-rate", config.HostRate, "most requests per second sent to the same host, lower if its robots.txt asks for a crawl-delay (default: no limit)")
+	fs.IntVar(&config.HostConcurrency, "host-concurrency", config.HostConcurrency, "most feeds fetched from the same host at the same time, 0 for no limit")
+	fs.IntVar(&config.DeadAfterFailures, "dead-after-failures", config.DeadAfterFailures, "how many crawls of a feed in a row have to fail, over at least --dead-after, before its podcast is marked dead and no longer crawled; 0 never marks feeds dead")
+	fs.DurationVar(&config.DeadAfter, "dead-after", config.DeadAfter, "how long a feed has to keep failing before its podcast is marked dead, see --dead-after-failures")
+	fs.BoolVar(&config.HonorUpdateHints, "honor-update-hints", config.HonorUpdateHints, "skip known feeds fetched more recently than the ttl or sy:updatePeriod they declare")
+	fs.Var(&config.AllowHosts, "allow-hosts", "comma separated hosts feeds may be fetched from (default: any)")
+	fs.Var(&config.BlockHosts, "block-hosts", "comma separated hosts feeds are never fetched from")
+	fs.BoolVar(&config.AllowPrivate, "allow-private", config.AllowPrivate, "allow feeds on private, loopback and link-local addresses")
+	fs.Var(&config.DisabledRules, "disable-rules", "comma separated validation rules to skip: "+strings.Join(validationRules, ", "))
+	fs.StringVar(&config.RemovedEpisodes, "removed-episodes", config.RemovedEpisodes, "log, mark (set removedAt) or archive stored episodes missing from their feed that are newer than its oldest item")
+	fs.StringVar(&config.Dupes, "dupes", config.Dupes, "after ingesting new episodes, look for near duplicates and log, mark or merge (delete) them; find-dupes only logs unless told otherwise")
+	fs.Float64Var(&config.DupeSizeTolerance, "dupe-size-tolerance", config.DupeSizeTolerance, "how much the audio sizes of near duplicates may differ, as a fraction")
+	fs.IntVar(&config.DupeMinCopies, "dupe-min-copies", config.DupeMinCopies, "fewest episodes, the original included, that count as near duplicates")
+	fs.DurationVar(&config.Since, "since", config.Since, "only ingest episodes published within this duration, e.g. 2160h")
+	fs.Var(&config.SinceDate, "since-date", "only ingest episodes published on or after this date (YYYY-MM-DD)")
+	fs.Var(&config.DefaultTimezone, "default-timezone", "time zone of publish dates that don't name one, like Europe/Berlin (default: UTC)")
+	fs.BoolVar(&config.SkipUndated, "skip-undated", config.SkipUndated, "with --since or --since-date, also skip episodes without a publish date (default: keep them)")
+	fs.StringVar(&config.Prefer, "prefer", config.Prefer, "kind of enclosure to keep as the primary one of items with several: audio or video")
+	fs.Var(&config.PreferMIME, "prefer-mime", "comma separated MIME types to prefer for the primary enclosure, before --prefer")
+	fs.StringVar(&config.PreferSize, "prefer-size", config.PreferSize, "among otherwise equal enclosures prefer the smallest or largest (default: the first)")
+	fs.IntVar(&config.MaxEpisodesPerFeed, "max-episodes-per-feed", config.MaxEpisodesPerFeed, "ingest at most the newest this many new episodes of a feed per run, the rest in later runs (default: no limit)")
+	fs.BoolVar(&config.SkipIfNoNewer, "skip-if-no-newer", config.SkipIfNoNewer, "don't look at the episodes of feeds without items newer than the latest stored episode; misses older items added later")
+	fs.BoolVar(&config.LinkDuplicates, "link-duplicates", config.LinkDuplicates, "set duplicateOf on new episodes whose audio another podcast has already, at the cost of a lookup per episode")
+	fs.IntVar(&config.BatchSize, "batch-size", config.BatchSize, "how many feeds are crawled per batch (env PODGO_BATCH_SIZE)")
+	fs.IntVar(&config.Concurrency, "concurrency", config.Concurrency, "how many feeds of a batch are crawled at the same time (env PODGO_CONCURRENCY)")
+	fs.IntVar(&config.EpisodeConcurrency, "episode-concurrency", config.EpisodeConcurrency, "how many new episodes are enriched, e.g. by --verify-enclosures, at the same time (default: --concurrency)")
+	fs.DurationVar(&config.BatchDelay, "batch-delay", config.BatchDelay, "pause between batches even if they went well (env PODGO_DELAY)")
+	fs.DurationVar(&config.MinBackoff, "min-backoff", config.MinBackoff, "pause after a batch in which many feeds failed; it doubles while failures continue")
+	fs.DurationVar(&config.MaxBackoff, "max-backoff", config.MaxBackoff, "longest pause between batches while feeds keep failing")
+	fs.DurationVar(&config.MaxRuntime, "max-runtime", config.MaxRuntime, "stop starting feeds in time for the run to end within this duration, and log the feeds left unprocessed (default: end after 10m, whatever is still running)")
+	fs.BoolVar(&config.Daemon, "daemon", config.Daemon, "keep running and crawl the feeds again at every --interval; implies --honor-update-hints")
+	fs.BoolVar(&config.Restart, "restart", config.Restart, "crawl every feed even if the last crawl was cut short; by default a crawl resumes it, skipping the feeds it got to")
+	fs.StringVar(&config.MetricsListen, "metrics-listen", config.MetricsListen, "while crawling, serve Prometheus metrics on /metrics at this address, like :9090")
+	fs.Var(&config.Interval, "interval", "with --daemon, how often to crawl: a duration like 30m or a cron expression like \"*/15 * * * *\"")
+	fs.StringVar(&config.WebhookURL, "webhook-url", config.WebhookURL, "URL to POST new episode notifications to")
+	fs.DurationVar(&config.WebhookTimeout, "webhook-timeout", config.WebhookTimeout, "timeout of a single webhook delivery")
+	fs.StringVar(&config.SearchURL, "search-url", config.SearchURL, "URL of a Meilisearch server to index new and updated episodes in as well")
+	fs.StringVar(&config.SearchIndex, "search-index", config.SearchIndex, "with --search-url, the index to add episodes to")
+	fs.StringVar(&config.SearchAPIKey, "search-api-key", config.SearchAPIKey, "with --search-url, the API key to send")
+	fs.BoolVar(&config.Debug, "debug", config.Debug, "log debug messages, same as --log-level debug")
+	fs.StringVar(&config.LogFormat, "log-format", config.LogFormat, "how to write log lines: plain, or text or json for structured logs with the level, feed, podcast and run as fields")
+	fs.StringVar(&config.LogLevel, "log-level", config.LogLevel, "least level logged: debug, info, warn or error")
+	fs.StringVar(&config.Only, "only", config.Only, "crawl just the feed with this URL or the podcast with this slug, with debug messages")
+	fs.BoolVar(&config.Progress, "progress", config.Progress, "show progress even if stdout is not a terminal")
+	fs.StringVar(&config.Report, "report", config.Report, "after each crawl, write a JSON summary of it to this file, or - for stdout")
+	fs.IntVar(&config.ProgressEvery, "progress-every", config.ProgressEvery, "without a terminal, log progress every this many feeds")
+	fs.BoolVar(&config.BackfillStats, "backfill-stats", config.BackfillStats, "recompute the episode statistics of all podcasts and exit")
+	fs.BoolVar(&config.Recount, "recount", config.Recount, "rebuild the episode counts of all podcasts from scratch and exit (same as --backfill-stats)")
+	fs.BoolVar(&config.Reprocess, "reprocess", config.Reprocess, "derive the derived fields of all stored episodes again, without fetching feeds, and exit")
+	fs.BoolVar(&config.RepairGUIDs, "repair-guids", config.RepairGUIDs, "merge stored episodes whose GUIDs only differ by normalization and exit")
+	fs.BoolVar(&config.RefreshEpisodeImages, "refresh-episode-images", config.RefreshEpisodeImages, "copy the current image of every podcast to its episodes and exit")
+	fs.BoolVar(&config.BackfillPodcastIDs, "backfill-podcast-ids", config.BackfillPodcastIDs, "set the podcastId of every episode to the ID of its podcast and exit")
+	fs.BoolVar(&config.CheckLinks, "check-links", config.CheckLinks, "check the homepage link of every podcast, store the result for the stats command and exit")
+	fs.BoolVar(&config.VerifyEnclosures, "verify-enclosures", config.VerifyEnclosures, "send a HEAD request to the enclosure of every new episode and store whether it is reachable")
+	fs.DurationVar(&config.EnclosureCheckDelay, "enclosure-check-delay", config.EnclosureCheckDelay, "with --verify-enclosures, least time between two checks on the same host")
+	fs.BoolVar(&config.InspectImages, "inspect-images", config.InspectImages, "look up the dimensions and format of podcast artwork that is new or changed")
+	fs.BoolVar(&config.FetchChapters, "fetch-chapters", config.FetchChapters, "fetch the JSON chapters podcast:chapters links to and store them with the episode")
+	fs.StringVar(&config.ExportJSON, "export-json", config.ExportJSON, "write all podcasts and episodes to this JSON file and exit")
+	fs.StringVar(&config.ImportJSON, "import-json-dump", config.ImportJSON, "load podcasts and episodes from a file written by --export-json and exit")
+	fs.StringVar(&config.HistoryPodcast, "podcast", config.HistoryPodcast, "with history, show the crawl history of the podcast with this slug")
+	fs.StringVar(&config.BaseURL, "base-url", config.BaseURL, "with sitemap, the URL the site is served from; the sitemaps are expected at its root")
+	fs.BoolVar(&config.Add, "add", config.Add, "with discover, append the best feed found to the feed list")
+	fs.StringVar(&config.Output, "output", config.Output, "with sitemap, the directory to write the sitemaps to")
+	fs.StringVar(&config.Listen, "listen", config.Listen, "with serve, the address to listen on")
+	fs.BoolVar(&config.Watch, "watch", config.Watch, "with serve, also stream new episodes on /stream/episodes; needs MongoDB running as a replica set")
+	fs.Usage = func() { printUsage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyEnvFlags(fs); err != nil {
+		return err
+	}
+	if err := parseCommand(fs); err != nil {
+		return err
+	}
+	if config.Only != "" {
+		config.Debug = true
+	}
+	if !containsString(logFormats, config.LogFormat) {
+		return usageError(fs, "unknown --log-format %q, use %s", config.LogFormat, strings.Join(logFormats, ", "))
+	}
+	if _, ok := logLevels[config.LogLevel]; !ok {
+		return usageError(fs, "unknown --log-level %q, use debug, info, warn or error", config.LogLevel)
+	}
+	if config.Debug {
+		config.LogLevel = "debug"
+	}
+	config.Debug = config.LogLevel == "debug"
+	if config.BatchSize < 1 || config.Concurrency < 1 {
+		return usageError(fs, "--batch-size and --concurrency must be at least 1")
+	}
+	if config.Concurrency > config.BatchSize && !flagGiven(fs, "concurrency") {
+		config.Concurrency = config.BatchSize
+	}
+	if config.Concurrency > config.BatchSize {
+		return usageError(fs, "--concurrency %d is more than --batch-size %d", config.Concurrency, config.BatchSize)
+	}
+	if config.EpisodeConcurrency < 0 {
+		return usageError(fs, "--episode-concurrency must not be negative")
+	}
+	if config.EpisodeConcurrency == 0 {
+		config.EpisodeConcurrency = config.Concurrency
+	}
+	if config.DeadAfterFailures < 0 {
+		return usageError(fs, "--dead-after-failures must not be negative")
+	}
+	if config.DeadAfter < 0 {
+		return usageError(fs, "--dead-after must not be negative")
+	}
+	if config.Retries < 0 {
+		return usageError(fs, "--retries must not be negative")
+	}
+	if config.RetryDelay <= 0 {
+		return usageError(fs, "--retry-delay must be positive")
+	}
+	if config.HostRate < 0 {
+		return usageError(fs, "--host-rate must not be negative")
+	}
+	if config.HostConcurrency < 0 {
+		return usageError(fs, "--host-concurrency must not be negative")
+	}
+	if config.BatchDelay < 0 {
+		return usageError(fs, "--batch-delay must not be negative")
+	}
+	if config.MinBackoff > config.MaxBackoff {
+		return usageError(fs, "--min-backoff %s is longer than --max-backoff %s", config.MinBackoff, config.MaxBackoff)
+	}
+	if config.MaxRuntime < 0 {
+		return usageError(fs, "--max-runtime must not be negative")
+	}
+	if config.MaxRuntime > 0 && config.MaxRuntime <= config.FeedTimeout+config.DBTimeout {
+		return usageError(fs, "--max-runtime %s leaves no time for a feed, which may take --feed-timeout plus --db-timeout", config.MaxRuntime)
+	}
+	if config.Daemon && (config.Command != "" || config.Only != "") {
+		return usageError(fs, "--daemon only crawls, it doesn't go with a command or --only")
+	}
+	if config.Report != "" && config.Command != "" {
+		return usageError(fs, "--report is for crawls, not commands")
+	}
+	if config.MetricsListen != "" && config.Command != "" {
+		return usageError(fs, "--metrics-listen is for crawls, not commands")
+	}
+	if config.Daemon && !flagGiven(fs, "honor-update-hints") {
+		config.HonorUpdateHints = true
+	}
+	if config.Add && (config.FeedsFile == stdinFeeds || isOPML(config.FeedsFile)) {
+		return usageError(fs, "--add needs the feed list in the store or a JSON file, not stdin or OPML")
+	}
+	if (config.Command == "add" || config.Command == "remove") && (config.FeedsFile == stdinFeeds || isOPML(config.FeedsFile)) {
+		return usageError(fs, "%s needs the feed list in the store or a JSON file, not stdin or OPML", config.Command)
+	}
+	if (config.Command == "enable" || config.Command == "disable") && config.FeedsFile != storeFeeds {
+		return usageError(fs, "%s needs the feed list in the store, --feeds %s", config.Command, storeFeeds)
+	}
+	if config.MaxEpisodesPerFeed < 0 {
+		return usageError(fs, "--max-episodes-per-feed must not be negative")
+	}
+	if config.Prefer != enclosureAudio && config.Prefer != enclosureVideo {
+		return usageError(fs, "unknown --prefer %q, use audio or video", config.Prefer)
+	}
+	if config.PreferSize != "" && config.PreferSize != preferSmallest && config.PreferSize != preferLargest {
+		return usageError(fs, "unknown --prefer-size %q, use smallest or largest", config.PreferSize)
+	}
+	if config.RemovedEpisodes != "" && !containsString(removedActions, config.RemovedEpisodes) {
+		return usageError(fs, "unknown --removed-episodes action %q, use %s", config.RemovedEpisodes, strings.Join(removedActions, ", "))
+	}
+	if config.Dupes != "" && !containsString(dupesActions, config.Dupes) {
+		return usageError(fs, "unknown --dupes action %q, use %s", config.Dupes, strings.Join(dupesActions, ", "))
+	}
+	for _, rule := range config.DisabledRules {
+		if !containsString(validationRules, rule) {
+			return usageError(fs, "unknown validation rule %q", rule)
+		}
+	}
+	return nil
+}
+
+// parseCommand sets the command and its arguments from what follows the
+// flags, parsing the flags given after them as well. crawl is the same as
+// no command.
+func parseCommand(fs *flag.FlagSet) error {
+	if fs.NArg() == 0 {
+		return nil
+	}
+	config.Command = fs.Arg(0)
+	nargs, ok := commands[config.Command]
+	if !ok {
+		return usageError(fs, "unknown command %q", config.Command)
+	}
+	// Flags may follow the command and its arguments as well.
+	rest := fs.Args()[1:]
+	for len(rest) > 0 {
+		if strings.HasPrefix(rest[0], "-") {
+			if err := fs.Parse(rest); err != nil {
+				return err
+			}
+			rest = fs.Args()
+			continue
+		}
+		config.CommandArgs = append(config.CommandArgs, rest[0])
+		rest = rest[1:]
+	}
+	if len(config.CommandArgs) != nargs && !(len(config.CommandArgs) == nargs-1 && optionalArgs[config.Command]) {
+		return usageError(fs, "%s takes %d arguments, got %d", config.Command, nargs, len(config.CommandArgs))
+	}
+	if config.Command == "export" && len(config.CommandArgs) == 0 {
+		config.CommandArgs = []string{"opml"}
+	}
+	if config.Command == "export" && config.CommandArgs[0] != "opml" {
+		return usageError(fs, "unknown export format %q, use opml", config.CommandArgs[0])
+	}
+	if config.Command == "crawl" {
+		config.Command = ""
+	}
+	return nil
+}
+
+// flagGiven reports whether the flag name was set on the command line.
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// envFlags are the flags that fall back to an environment variable when
+// they aren't given on the command line.
+var envFlags = []struct{ flag, env string }{
+	{"batch-size", "PODGO_BATCH_SIZE"},
+	{"concurrency", "PODGO_CONCURRENCY"},
+	{"batch-delay", "PODGO_DELAY"},
+}
+
+// applyEnvFlags sets the flags of envFlags that weren't given from their
+// environment variables, if set. They count as given afterwards.
+func applyEnvFlags(fs *flag.FlagSet) error {
+	for _, f := range envFlags {
+		value, ok := os.LookupEnv(f.env)
+		if !ok || flagGiven(fs, f.flag) {
+			continue
+		}
+		if err := fs.Set(f.flag, value); err != nil {
+			return usageError(fs, "invalid %s %q: %v", f.env, value, err)
+		}
+	}
+	return nil
+}
+
+// usageError reports a command line error the way fs reports its own.
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	fmt.Fprintln(fs.Output(), err)
+	fs.Usage()
+	return err
+}
+
+// configSnapshot returns the value of every flag, for the record of a run.
+// The store URI and the webhook URL are left out as they may hold
+// credentials.
+func configSnapshot() map[string]string {
+	snapshot := make(map[string]string)
+	if flags == nil {
+		return snapshot
+	}
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "store" || f.Name == "webhook-url" {
+			return
+		}
+		snapshot[f.Name] = f.Value.String()
+	})
+	return snapshot
+}
+
+// Cutoff returns the publish date before which episodes are not ingested,
+// or the zero time if every episode is wanted. With both --since and
+// --since-date set the later of the two wins.
+func (c *Config) Cutoff(now time.Time) time.Time {
+	cutoff := time.Time(c.SinceDate)
+	if c.Since > 0 {
+		if t := now.Add(-c.Since); t.After(cutoff) {
+			cutoff = t
+		}
+	}
+	return cutoff
+}
+
+// What --prefer and --prefer-size accept.
+const (
+	enclosureAudio = "audio"
+	enclosureVideo = "video"
+	preferSmallest = "smallest"
+	preferLargest  = "largest"
+)
+
+// PreferredEnclosure returns the index of the enclosure in enclosures, of
+// which there must be at least one, to play: the first of a type in
+// --prefer-mime, otherwise the first of the kind --prefer, otherwise the
+// first. --prefer-size breaks ties by size instead of feed order.
+func (c *Config) PreferredEnclosure(enclosures []EpisodeEnclosure) int {
+	rank := func(e EpisodeEnclosure) int {
+		t := e.mediaType()
+		for i, m := range c.PreferMIME {
+			if strings.EqualFold(m, t) {
+				return i
+			}
+		}
+		if e.kind() == c.Prefer {
+			return len(c.PreferMIME)
+		}
+		return len(c.PreferMIME) + 1
+	}
+	best := 0
+	for i := 1; i < len(enclosures); i++ {
+		r, b := rank(enclosures[i]), rank(enclosures[best])
+		size, bestSize := enclosures[i].size(), enclosures[best].size()
+		switch {
+		case r < b:
+			best = i
+		case r > b:
+		case c.PreferSize == preferSmallest && size > 0 && (bestSize == 0 || size < bestSize):
+			best = i
+		case c.PreferSize == preferLargest && size > bestSize:
+			best = i
+		}
+	}
+	return best
+}
+
+// dateFlag is a flag value holding a date given as YYYY-MM-DD or RFC 3339.
+type dateFlag time.Time
+
+func (d *dateFlag) String() string {
+	if time.Time(*d).IsZero() {
+		return ""
+	}
+	return time.Time(*d).Format("2006-01-02")
+}
+
+func (d *dateFlag) Set(value string) error {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			*d = dateFlag(t)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+}
+
+// locationFlag is a flag value holding a time zone given by its IANA name.
+type locationFlag struct {
+	*time.Location
+}
+
+func (l *locationFlag) String() string {
+	if l.Location == nil {
+		return ""
+	}
+	return l.Location.String()
+}
+
+func (l *locationFlag) Set(value string) error {
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q", value)
+	}
+	l.Location = loc
+	return nil
+}
+
+// scheduleFlag is a flag value holding a schedule, see parseSchedule.
+type scheduleFlag struct {
+	schedule
+	value string
+}
+
+func (f *scheduleFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *scheduleFlag) Set(value string) error {
+	s, err := parseSchedule(value)
+	if err != nil {
+		return err
+	}
+	f.schedule, f.value = s, value
+	return nil
+}
+
+// stringList is a flag value holding a comma separated list. Repeating the
+// flag appends to the list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFlagsExport(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	tests := []struct {
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{[]string{"export"}, []string{"opml"}, false},
+		{[]string{"export", "opml"}, []string{"opml"}, false},
+		{[]string{"export", "csv"}, nil, true},
+		{[]string{"export", "opml", "extra"}, nil, true},
+	}
+	for _, tt := range tests {
+		config = defaults
+		err := parseFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: error %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(config.CommandArgs, tt.want) {
+			t.Errorf("%v: arguments %q, want %q", tt.args, config.CommandArgs, tt.want)
+		}
+	}
+}
+
+func TestParseFlagsBatching(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	tests := []struct {
+		args        []string
+		batchSize   int
+		concurrency int
+		wantErr     bool
+	}{
+		{nil, 10, 3, false},
+		{[]string{"--batch-size", "50", "--concurrency", "20"}, 50, 20, false},
+		{[]string{"--batch-size", "2"}, 2, 2, false},
+		{[]string{"--batch-size", "2", "--concurrency", "3"}, 0, 0, true},
+		{[]string{"--batch-size", "0"}, 0, 0, true},
+		{[]string{"--concurrency", "0"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		config = defaults
+		err := parseFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: error %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (config.BatchSize != tt.batchSize || config.Concurrency != tt.concurrency) {
+			t.Errorf("%v: batches of %d, %d at a time, want %d and %d", tt.args, config.BatchSize, config.Concurrency, tt.batchSize, tt.concurrency)
+		}
+		if err == nil && config.BatchDelay != 0 {
+			t.Errorf("%v: batch delay %s, want none", tt.args, config.BatchDelay)
+		}
+	}
+}
+
+func TestParseFlagsBatchingFromEnv(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	t.Setenv("PODGO_BATCH_SIZE", "4")
+	t.Setenv("PODGO_CONCURRENCY", "2")
+	t.Setenv("PODGO_DELAY", "1s")
+	if err := parseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	if config.BatchSize != 4 || config.Concurrency != 2 || config.BatchDelay != time.Second {
+		t.Errorf("batches of %d, %d at a time, %s apart, want 4, 2 and 1s", config.BatchSize, config.Concurrency, config.BatchDelay)
+	}
+
+	// Flags win over the environment.
+	config = defaults
+	if err := parseFlags([]string{"--concurrency", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.BatchSize != 4 || config.Concurrency != 3 {
+		t.Errorf("batches of %d, %d at a time, want 4 and 3", config.BatchSize, config.Concurrency)
+	}
+
+	// What the flags reject is rejected from the environment as well.
+	config = defaults
+	t.Setenv("PODGO_CONCURRENCY", "5")
+	if err := parseFlags(nil); err == nil {
+		t.Error("PODGO_CONCURRENCY above PODGO_BATCH_SIZE accepted")
+	}
+	config = defaults
+	t.Setenv("PODGO_CONCURRENCY", "2")
+	t.Setenv("PODGO_DELAY", "soon")
+	if err := parseFlags(nil); err == nil {
+		t.Error("PODGO_DELAY=soon accepted")
+	}
+}
+
+func TestParseFlagsDefaultTimezone(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	if err := parseFlags([]string{"--default-timezone", "Europe/Berlin"}); err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	if loc := config.DefaultTimezone.Location; loc == nil || loc.String() != "Europe/Berlin" {
+		t.Errorf("default time zone %v, want Europe/Berlin", loc)
+	}
+	config = defaults
+	if err := parseFlags([]string{"--default-timezone", "Mars/Olympus_Mons"}); err == nil {
+		t.Error("unknown time zone accepted")
+	}
+}
+
+func TestParseFlagsPrefer(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	tests := []struct {
+		args    []string
+		prefer  string
+		mime    []string
+		wantErr bool
+	}{
+		{nil, "audio", nil, false},
+		{[]string{"--prefer", "video"}, "video", nil, false},
+		{[]string{"--prefer-mime", "audio/opus, audio/x-m4a", "--prefer-mime", "audio/mpeg"}, "audio", []string{"audio/opus", "audio/x-m4a", "audio/mpeg"}, false},
+		{[]string{"--prefer", "text"}, "", nil, true},
+		{[]string{"--prefer-size", "medium"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		config = defaults
+		err := parseFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFlags(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if config.Prefer != tt.prefer || strings.Join(config.PreferMIME, ",") != strings.Join(tt.mime, ",") {
+			t.Errorf("parseFlags(%q) preferred %s and %v", tt.args, config.Prefer, config.PreferMIME)
+		}
+	}
+}
+
+func TestParseFlagsHostLimits(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	if err := parseFlags([]string{"--host-rate", "2.5", "--host-concurrency", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.HostRate != 2.5 || config.HostConcurrency != 0 {
+		t.Errorf("host rate %v and concurrency %d, want 2.5 and 0", config.HostRate, config.HostConcurrency)
+	}
+	for _, args := range [][]string{{"--host-rate", "-1"}, {"--host-concurrency", "-1"}} {
+		config = defaults
+		if err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestParseFlagsLogging(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	if err := parseFlags([]string{"--debug", "--log-format", "json"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.LogLevel != "debug" || config.LogFormat != logFormatJSON {
+		t.Errorf("got level %q and format %q", config.LogLevel, config.LogFormat)
+	}
+	config = defaults
+	if err := parseFlags([]string{"--log-level", "debug"}); err != nil || !config.Debug {
+		t.Errorf("--log-level debug doesn't log debug messages (error %v)", err)
+	}
+	for _, args := range [][]string{{"--log-format", "xml"}, {"--log-level", "trace"}} {
+		config = defaults
+		if err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestParseFlagsCommands(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	tests := []struct {
+		args    []string
+		command string
+		cmdArgs []string
+		wantErr bool
+	}{
+		{[]string{"crawl"}, "", nil, false},
+		{[]string{"add", "https://a.example/feed", "--feeds", "other.json"}, "add", []string{"https://a.example/feed"}, false},
+		{[]string{"remove", "tech-talk"}, "remove", []string{"tech-talk"}, false},
+		{[]string{"list"}, "list", nil, false},
+		{[]string{"add"}, "", nil, true},
+		{[]string{"add", "https://a.example/feed", "--feeds", "feeds.opml"}, "", nil, true},
+		{[]string{"list", "--report", "report.json"}, "", nil, true},
+		{[]string{"fetch"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		config = defaults
+		config.CommandArgs = nil
+		err := parseFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: error %v, want error %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (config.Command != tt.command || strings.Join(config.CommandArgs, " ") != strings.Join(tt.cmdArgs, " ")) {
+			t.Errorf("%v: command %q with %q, want %q with %q", tt.args, config.Command, config.CommandArgs, tt.command, tt.cmdArgs)
+		}
+	}
+	config = defaults
+	if err := parseFlags([]string{"add", "https://a.example/feed", "--feeds", "other.json"}); err != nil || config.FeedsFile != "other.json" {
+		t.Errorf("flag after the command ignored, feeds from %q (error %v)", config.FeedsFile, err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// historyRuns is the number of runs the history command looks at.
+const historyRuns = 20
+
+// CrawlRun records one crawl. It is stored when the run starts and
+// completed when it ends, so a run without FinishedAt crashed or was
+// killed.
+type CrawlRun struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	StartedAt   time.Time          `bson:"startedAt"`
+	FinishedAt  time.Time          `bson:"finishedAt,omitempty"`
+	Interrupted bool               `bson:"interrupted,omitempty"`
+	FeedCount   int                `bson:"feedCount"`
+	Config      map[string]string  `bson:"config,omitempty"`
+
+	Processed     int64 `bson:"processed"`
+	Failed        int64 `bson:"failed"`
+	SkippedRobots int64 `bson:"skippedRobots"`
+	NewEpisodes   int64 `bson:"newEpisodes"`
+
+	Outcomes []FeedOutcome `bson:"outcomes,omitempty"`
+
+	// Completed are the feeds the run got to, including those of the run
+	// it resumed, stored after every batch. A run that is resumed skips
+	// them.
+	Completed   []string           `bson:"completed,omitempty"`
+	ResumedFrom primitive.ObjectID `bson:"resumedFrom,omitempty"`
+}
+
+// FeedOutcome is the result of one feed in a CrawlRun.
+type FeedOutcome struct {
+	Feed        string `bson:"feed"`
+	PodlistUrl  string `bson:"podlistUrl,omitempty"`
+	Error       string `bson:"error,omitempty"`
+	ErrorKind   string `bson:"errorKind,omitempty"`
+	Timeout     bool   `bson:"timeout,omitempty"`
+	Skipped     bool   `bson:"skipped,omitempty"`
+	NewEpisodes int    `bson:"newEpisodes,omitempty"`
+	ElapsedMs   int64  `bson:"elapsedMs"`
+}
+
+// crawlRecorder keeps the record of the current run. A nil recorder
+// silently drops everything.
+type crawlRecorder struct {
+	store Store
+	run   CrawlRun
+
+	mu        sync.Mutex
+	outcomes  []FeedOutcome
+	completed []string
+}
+
+var crawlRun *crawlRecorder
+
+// startCrawlRun stores the start of a run over feedCount feeds, which
+// continues the run resumed if that isn't nil. Failing to do so doesn't
+// stop the crawl, it just goes unrecorded.
+func startCrawlRun(ctx context.Context, store Store, feedCount int, resumed *CrawlRun) *crawlRecorder {
+	r := &crawlRecorder{
+		store: store,
+		run: CrawlRun{
+			StartedAt: time.Now(),
+			FeedCount: feedCount,
+			Config:    configSnapshot(),
+		},
+	}
+	if resumed != nil {
+		r.run.ResumedFrom = resumed.ID
+		r.run.Completed = resumed.Completed
+		r.completed = append(r.completed, resumed.Completed...)
+	}
+	if err := store.InsertCrawlRun(ctx, &r.run); err != nil {
+		errorf(ctx, "Error recording crawl run: %v", err)
+		return nil
+	}
+	return r
+}
+
+func (r *crawlRecorder) record(res feedResult) {
+	if r == nil {
+		return
+	}
+	o := FeedOutcome{
+		Feed:        res.URL,
+		PodlistUrl:  res.PodlistUrl,
+		Timeout:     res.Timeout,
+		Skipped:     res.Skipped,
+		NewEpisodes: res.NewEpisodes,
+		ElapsedMs:   res.Elapsed.Milliseconds(),
+	}
+	if res.Err != nil {
+		o.Error = res.Err.Error()
+		if kind, ok := feedErrorKind(res.Err); ok {
+			o.ErrorKind = kind.String()
+		}
+	}
+	r.mu.Lock()
+	r.outcomes = append(r.outcomes, o)
+	// Feeds that timed out, or weren't tried because the run was out of
+	// time or stopped, get another go when the run is resumed.
+	if !res.Skipped && !res.Timeout {
+		r.completed = append(r.completed, res.URL)
+	}
+	r.mu.Unlock()
+}
+
+// checkpoint stores the feeds completed so far, for a run that is cut
+// short to be resumed from.
+func (r *crawlRecorder) checkpoint() {
+	if r == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.DBTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	completed := append([]string(nil), r.completed...)
+	r.mu.Unlock()
+	if err := r.store.UpdateCrawlRun(ctx, r.run.ID, bson.M{"completed": completed}); err != nil {
+		errorf(ctx, "Error recording progress of crawl run: %v", err)
+	}
+}
+
+// resumableRun returns the last run if it was cut short, by a crash or by
+// running out of time, and got to some feeds. A crawl resumes it unless
+// --restart is given.
+func resumableRun(ctx context.Context, store Store) *CrawlRun {
+	runs, err := store.CrawlRuns(ctx, 1)
+	if err != nil {
+		errorf(ctx, "Error fetching last crawl run: %v", err)
+		return nil
+	}
+	if len(runs) == 0 || len(runs[0].Completed) == 0 {
+		return nil
+	}
+	if last := runs[0]; last.FinishedAt.IsZero() || last.Interrupted {
+		return &last
+	}
+	return nil
+}
+
+// withoutCompleted returns feeds without those the resumed run completed.
+func withoutCompleted(feeds []string, resumed *CrawlRun) []string {
+	if resumed == nil {
+		return feeds
+	}
+	completed := make(map[string]bool, len(resumed.Completed))
+	for _, feed := range resumed.Completed {
+		completed[feed] = true
+	}
+	var left []string
+	for _, feed := range feeds {
+		if !completed[feed] {
+			left = append(left, feed)
+		}
+	}
+	return left
+}
+
+// finish completes the record of the run with the outcome of every feed
+// and the totals from stats.
+func (r *crawlRecorder) finish(interrupted bool) {
+	if r == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.DBTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	outcomes := r.outcomes
+	r.mu.Unlock()
+	err := r.store.UpdateCrawlRun(ctx, r.run.ID, bson.M{
+		"finishedAt":    time.Now(),
+		"interrupted":   interrupted,
+		"processed":     stats.get(&stats.processed),
+		"failed":        stats.get(&stats.failed),
+		"skippedRobots": stats.get(&stats.skippedRobots),
+		"newEpisodes":   stats.get(&stats.newEpisodes),
+		"outcomes":      outcomes,
+	})
+	if err != nil {
+		errorf(ctx, "Error recording end of crawl run: %v", err)
+	}
+}
+
+// markCrawlFailed notes a failed crawl on the podcast of feedURL, if we
+// know it under that URL. Successful crawls are noted while the podcast is
+// updated anyway. Only failures to fetch or parse the feed, FeedErrors,
+// count: our own storage failures say nothing about the feed.
+func markCrawlFailed(ctx context.Context, store Store, feedURL string, crawlErr error, retryAfter time.Duration, existingPodcastFeeds map[string]bool) {
+	if _, ok := feedErrorKind(crawlErr); !ok {
+		return
+	}
+	podcastIndex.Lock()
+	known := existingPodcastFeeds[feedURL]
+	podcastIndex.Unlock()
+	if !known {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	podcast, err := store.PodcastByFeed(ctx, feedURL)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	set := failureUpdate(podcast, feedURL, crawlErr, now)
+	set["lastCrawledAt"] = now
+	if retryAfter > 0 {
+		set["retryAfter"] = now.Add(retryAfter)
+	}
+	if err := store.UpdatePodcast(ctx, podcast.ID, set); err != nil {
+		errorf(ctx, "Error recording failed crawl of podcast %s: %v", podcast.Title, err)
+		return
+	}
+	if deadAt, ok := set["deadAt"]; ok {
+		changes.podcastUpdated(podcast, bson.M{"deadAt": deadAt})
+		stats.add(&stats.dead)
+		warnf(ctx, "feed %s failed %d times in a row since %s, marked podcast %s dead", redactURL(feedURL),
+			set["failureCount"], podcast.FailingSince.Format("2006-01-02"), podcast.PodlistUrl)
+	}
+}
+
+// printHistory prints the recent runs, or with slug set what they did with
+// that podcast.
+func printHistory(ctx context.Context, store Store, slug string) error {
+	runs, err := store.CrawlRuns(ctx, historyRuns)
+	if err != nil {
+		return fmt.Errorf("error fetching crawl runs: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	if slug == "" {
+		fmt.Fprintln(w, "STARTED\tDURATION\tFEEDS\tPROCESSED\tFAILED\tSKIPPED\tNEW EPISODES")
+		for _, run := range runs {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\n", run.StartedAt.Local().Format("2006-01-02 15:04"),
+				runDuration(run), run.FeedCount, run.Processed, run.Failed, run.SkippedRobots, run.NewEpisodes)
+		}
+		return nil
+	}
+
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	var podcast *Podcast
+	for i := range podcasts {
+		if podcasts[i].PodlistUrl == slug {
+			podcast = &podcasts[i]
+			break
+		}
+	}
+	if podcast == nil {
+		return fmt.Errorf("no podcast %q", slug)
+	}
+	fmt.Fprintf(w, "%s (%s)\n", podcast.Title, redactURL(podcast.Feed))
+	fmt.Fprintf(w, "Last crawled:\t%s\n", formatTime(podcast.LastCrawledAt))
+	fmt.Fprintf(w, "Last success:\t%s\n", formatTime(podcast.LastSuccessAt))
+	fmt.Fprintf(w, "Episodes:\t%d\n\n", podcast.EpisodeCount)
+	fmt.Fprintln(w, "STARTED\tRESULT\tNEW EPISODES\tTIME")
+	for _, run := range runs {
+		for _, o := range run.Outcomes {
+			if o.PodlistUrl != slug && o.Feed != podcast.Feed {
+				continue
+			}
+			result := "ok"
+			if o.Skipped {
+				result = "skipped"
+			} else if o.Timeout {
+				result = "timed out: " + o.Error
+			} else if o.Error != "" {
+				result = "failed: " + o.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", run.StartedAt.Local().Format("2006-01-02 15:04"),
+				result, o.NewEpisodes, time.Duration(o.ElapsedMs)*time.Millisecond)
+		}
+	}
+	return nil
+}
+
+func runDuration(run CrawlRun) string {
+	if run.FinishedAt.IsZero() {
+		return "unfinished"
+	}
+	d := run.FinishedAt.Sub(run.StartedAt).Round(time.Second).String()
+	if run.Interrupted {
+		d += " (interrupted)"
+	}
+	return d
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
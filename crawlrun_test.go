@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCrawlResumesInterruptedRun(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		server := newFeedServer(t)
+		done := server.setFeed("/podcast.xml", "podcast.xml")
+		left := server.setFeed("/no-itunes.xml", "no-itunes.xml")
+		feeds := []feedEntry{{URL: done}, {URL: left}}
+		newIngester(t, store)
+		defer func() { crawlRun = nil }()
+
+		cut := CrawlRun{StartedAt: time.Now().Add(-time.Hour), Completed: []string{done}}
+		if err := store.InsertCrawlRun(ctx, &cut); err != nil {
+			t.Fatal(err)
+		}
+		crawl(ctx, store, feeds)
+		if server.fetches("/podcast.xml") != 0 || server.fetches("/no-itunes.xml") != 1 {
+			t.Errorf("resumed run fetched %d and %d times, want only the feed left",
+				server.fetches("/podcast.xml"), server.fetches("/no-itunes.xml"))
+		}
+		runs, err := store.CrawlRuns(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs[0].ResumedFrom != cut.ID || len(runs[0].Completed) != 2 {
+			t.Errorf("resumed from %s with %q completed", runs[0].ResumedFrom.Hex(), runs[0].Completed)
+		}
+
+		// A finished run isn't resumed.
+		crawl(ctx, store, feeds)
+		if server.fetches("/podcast.xml") != 1 {
+			t.Error("crawl after a finished run skipped feeds")
+		}
+	})
+}
+
+func TestCrawlRestart(t *testing.T) {
+	ctx := context.Background()
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	newIngester(t, store)
+	config.Restart = true
+	defer func() { crawlRun = nil }()
+
+	cut := CrawlRun{StartedAt: time.Now().Add(-time.Hour), Interrupted: true, FinishedAt: time.Now(), Completed: []string{feedURL}}
+	if err := store.InsertCrawlRun(ctx, &cut); err != nil {
+		t.Fatal(err)
+	}
+	crawl(ctx, store, []feedEntry{{URL: feedURL}})
+	if server.fetches("/podcast.xml") != 1 {
+		t.Error("--restart skipped a feed the last run got to")
+	}
+}
+
+func TestStoppedRunLeavesFeedsToResume(t *testing.T) {
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	newIngester(t, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res := processFeedURL(ctx, feedURL, store, map[string]bool{}, map[string]bool{})
+	if res.Err == nil || !res.Timeout {
+		t.Fatalf("feed of a stopped run failed with %v, timeout %v", res.Err, res.Timeout)
+	}
+	r := &crawlRecorder{}
+	r.record(res)
+	if len(r.completed) != 0 {
+		t.Errorf("feed of a stopped run recorded as completed")
+	}
+}
+
+func TestCutShort(t *testing.T) {
+	stopped, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"stopped run", stopped, errors.New("connection refused"), true},
+		{"deadline", context.Background(), fmt.Errorf("waiting for host: %w", context.DeadlineExceeded), true},
+		{"cancelled", context.Background(), context.Canceled, true},
+		{"failure", context.Background(), errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := cutShort(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: cut short %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
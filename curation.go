@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+)
+
+// Entries of the feed list may curate the categories of their podcast:
+// Tags are added to what the feed declares, OverrideCategories replace it
+// altogether. What the feed declares is kept in Podcast.RawCategories.
+
+// feedCuration is the curation of one feed of the list.
+type feedCuration struct {
+	Tags               []string
+	OverrideCategories []string
+}
+
+// feedCurations holds the curation of the feeds being crawled by feed URL.
+// It is filled before the crawl starts and only read after that.
+var feedCurations = make(map[string]feedCuration)
+
+// setFeedCurations fills feedCurations from the feed list, replacing
+// those of an earlier list.
+func setFeedCurations(feeds []feedEntry) {
+	feedCurations = make(map[string]feedCuration)
+	for _, f := range feeds {
+		if len(f.Tags) > 0 || len(f.OverrideCategories) > 0 {
+			feedCurations[f.URL] = feedCuration{Tags: f.Tags, OverrideCategories: f.OverrideCategories}
+		}
+	}
+}
+
+// categories returns the categories of a podcast whose feed declares
+// declared.
+func (c feedCuration) categories(declared []string) []string {
+	if len(c.OverrideCategories) > 0 {
+		return uniqueCategories(c.OverrideCategories)
+	}
+	if len(c.Tags) == 0 {
+		return declared
+	}
+	return uniqueCategories(append(append([]string(nil), declared...), c.Tags...))
+}
+
+// uniqueCategories returns categories without blanks and without
+// duplicates, ignoring case, in their original order.
+func uniqueCategories(categories []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, c := range categories {
+		c = strings.TrimSpace(c)
+		key := strings.ToLower(c)
+		if c == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, c)
+	}
+	return unique
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFeedCurationCategories(t *testing.T) {
+	declared := []string{"Comedy", "Society"}
+	tests := []struct {
+		name     string
+		curation feedCuration
+		want     []string
+	}{
+		{"none", feedCuration{}, declared},
+		{"tags", feedCuration{Tags: []string{"NL", "comedy", " "}}, []string{"Comedy", "Society", "NL"}},
+		{"override", feedCuration{OverrideCategories: []string{"NL", "Interview"}}, []string{"NL", "Interview"}},
+		{"override wins", feedCuration{Tags: []string{"Extra"}, OverrideCategories: []string{"NL", "nl"}}, []string{"NL"}},
+	}
+	for _, tt := range tests {
+		if got := tt.curation.categories(declared); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIngestCategoryOverride(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/lachen.xml", "comedy.xml")
+		in := newIngester(t, store)
+		defer func(c map[string]feedCuration) { feedCurations = c }(feedCurations)
+		feedCurations = make(map[string]feedCuration)
+		setFeedCurations([]feedEntry{{URL: feedURL, OverrideCategories: []string{"NL", "Interview"}}})
+
+		check := func(when string) {
+			t.Helper()
+			podcast := in.podcast(feedURL)
+			if want := []string{"NL", "Interview"}; !reflect.DeepEqual(podcast.Categories, want) {
+				t.Errorf("%s: categories %q, want %q", when, podcast.Categories, want)
+			}
+			if want := []string{"Comedy"}; !reflect.DeepEqual(podcast.RawCategories, want) {
+				t.Errorf("%s: raw categories %q, want %q", when, podcast.RawCategories, want)
+			}
+		}
+		in.crawl(feedURL)
+		check("new podcast")
+
+		server.setFeed("/lachen.xml", "comedy-updated.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("%d new episodes, want 1", result.NewEpisodes)
+		}
+		check("updated podcast")
+
+		// Dropping the override from the list brings back what the feed
+		// declares, even though the feed didn't change.
+		feedCurations = make(map[string]feedCuration)
+		server.setFeed("/lachen.xml", "comedy-updated.xml")
+		in.crawl(feedURL)
+		if got := in.podcast(feedURL).Categories; !reflect.DeepEqual(got, []string{"Comedy"}) {
+			t.Errorf("categories %q without the override, want Comedy", got)
+		}
+	})
+}
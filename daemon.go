@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// crawlScheduler plans the crawls of the daemon. Every podcast crawled in
+// a run gets the time of the next run as its nextCrawlAt, and is skipped
+// until then. That is stored with the podcast, so a restarted daemon picks
+// up the podcasts an interrupted run didn't get to and leaves the others
+// for their turn. A nil scheduler plans nothing.
+type crawlScheduler struct {
+	schedule schedule
+	next     time.Time
+}
+
+var scheduler *crawlScheduler
+
+// waiting reports whether podcast was crawled by the daemon and isn't due
+// again yet.
+func (s *crawlScheduler) waiting(podcast Podcast, now time.Time) bool {
+	return s != nil && now.Before(podcast.NextCrawlAt)
+}
+
+// planNext sets the next crawl of the podcast of feedURL after it was
+// crawled with result res.
+func (s *crawlScheduler) planNext(ctx context.Context, store Store, feedURL string, res feedResult, existingPodcastFeeds map[string]bool) {
+	if s == nil || res.Skipped {
+		return
+	}
+	podcastIndex.Lock()
+	known := existingPodcastFeeds[feedURL]
+	podcastIndex.Unlock()
+	if !known {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	podcast, err := store.PodcastByFeed(ctx, feedURL)
+	if err != nil {
+		return
+	}
+	if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"nextCrawlAt": s.next}); err != nil {
+		errorf(ctx, "Error scheduling next crawl of podcast %s: %v", podcast.Title, err)
+	}
+}
+
+// runTimeout is how long a crawl may take at most.
+func runTimeout() time.Duration {
+	if config.MaxRuntime > 0 {
+		return config.MaxRuntime
+	}
+	return 600 * time.Second
+}
+
+// runDaemon crawls feeds over and over, at the times --interval gives,
+// until it is interrupted. The feed list is read again for every run,
+// unless it comes from stdin.
+func runDaemon(store Store, feeds []feedEntry) {
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	scheduler = &crawlScheduler{schedule: config.Interval.schedule}
+	for run := 1; ; run++ {
+		start := time.Now()
+		scheduler.next = scheduler.schedule.Next(start)
+		if run > 1 && config.FeedsFile != stdinFeeds {
+			feeds = loadFeeds(stop, store, config.FeedsFile)
+			setFeedCurations(feeds)
+		}
+		stats = runStats{}
+		if config.MaxRuntime > 0 {
+			budget = newRunBudget(start, config.MaxRuntime)
+		}
+
+		ctx, cancelRun := context.WithTimeout(stop, runTimeout())
+		crawl(ctx, store, feeds)
+		cancelRun()
+
+		if stop.Err() != nil {
+			infof(ctx, "Daemon stopped")
+			return
+		}
+		wait := time.Until(scheduler.next)
+		if wait <= 0 {
+			infof(ctx, "Crawl took longer than the interval, starting the next one right away")
+			continue
+		}
+		infof(ctx, "Next crawl at %s", scheduler.next.Format(time.RFC3339))
+		select {
+		case <-time.After(wait):
+		case <-stop.Done():
+			infof(ctx, "Daemon stopped")
+			return
+		}
+	}
+}
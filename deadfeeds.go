@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Unlike a retired feed, which said it is gone, a dead feed merely kept
+// failing: at least --dead-after-failures crawls in a row over at least
+// --dead-after. Its podcast is no longer crawled until it is revived with
+// the revive command.
+
+// failureUpdate returns the fields to set on podcast for another failed
+// crawl with err at now, including deadAt once the feed counts as dead.
+func failureUpdate(podcast Podcast, feedURL string, err error, now time.Time) bson.M {
+	set := bson.M{"failureCount": podcast.FailureCount + 1}
+	if err != nil {
+		set["lastError"] = redactError(err, feedURL)
+	}
+	since := podcast.FailingSince
+	if podcast.FailureCount == 0 || since.IsZero() {
+		since = now
+		set["failingSince"] = since
+	}
+	if config.DeadAfterFailures > 0 && podcast.DeadAt.IsZero() &&
+		podcast.FailureCount+1 >= config.DeadAfterFailures && now.Sub(since) >= config.DeadAfter {
+		set["deadAt"] = now
+	}
+	return set
+}
+
+// clearFailures adds to set what resets the failures of podcast after a
+// successful crawl.
+func clearFailures(podcast Podcast, set bson.M) {
+	if podcast.FailureCount == 0 && podcast.LastError == "" {
+		return
+	}
+	set["failureCount"] = 0
+	set["failingSince"] = time.Time{}
+	set["lastError"] = ""
+}
+
+// printDeadFeeds is the dead command: it lists the podcasts whose feeds
+// are dead, the longest dead first.
+func printDeadFeeds(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	var dead []Podcast
+	for _, p := range podcasts {
+		if !p.DeadAt.IsZero() {
+			dead = append(dead, p)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool { return dead[i].DeadAt.Before(dead[j].DeadAt) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "PODCAST\tDEAD SINCE\tFAILING SINCE\tFAILURES\tLAST ERROR")
+	for _, p := range dead {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", p.PodlistUrl, p.DeadAt.Local().Format("2006-01-02 15:04"),
+			p.FailingSince.Local().Format("2006-01-02 15:04"), p.FailureCount, p.LastError)
+	}
+	return nil
+}
+
+// revivePodcast is the revive command: it takes the podcast slug off the
+// dead feeds, so it is crawled again from the next run on.
+func revivePodcast(ctx context.Context, store Store, slug string) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	var podcast *Podcast
+	for i := range podcasts {
+		if podcasts[i].PodlistUrl == slug {
+			podcast = &podcasts[i]
+			break
+		}
+	}
+	if podcast == nil {
+		return fmt.Errorf("no podcast %q", slug)
+	}
+	if podcast.DeadAt.IsZero() {
+		return fmt.Errorf("podcast %s is not dead", podcast.Title)
+	}
+	set := bson.M{"deadAt": time.Time{}}
+	clearFailures(*podcast, set)
+	if err := store.UpdatePodcast(ctx, podcast.ID, set); err != nil {
+		return fmt.Errorf("error updating podcast: %v", err)
+	}
+	changes.podcastUpdated(*podcast, bson.M{"deadAt": time.Time{}})
+	infof(ctx, "Revived podcast %s", podcast.Title)
+	return nil
+}
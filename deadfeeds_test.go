@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// failCrawl processes feedURL like ingester.crawl, expecting it to fail.
+func failCrawl(t *testing.T, store Store, feedURL string) {
+	t.Helper()
+	ctx := context.Background()
+	feeds, titles, _ := loadExistingPodcasts(ctx, store)
+	if result := processFeedURL(ctx, feedURL, store, feeds, titles); result.Err == nil {
+		t.Fatalf("crawling %s didn't fail", feedURL)
+	}
+}
+
+func TestIngestDeadFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+		in.crawl(feedURL)
+		config.DeadAfterFailures = 2
+		config.DeadAfter = 0
+
+		server.fail("/podcast.xml", http.StatusNotFound)
+		failCrawl(t, store, feedURL)
+		podcast := in.podcast(feedURL)
+		if podcast.FailureCount != 1 || podcast.FailingSince.IsZero() || podcast.LastError == "" {
+			t.Errorf("after a failed crawl: %d failures since %s, last error %q", podcast.FailureCount, podcast.FailingSince, podcast.LastError)
+		}
+		if !podcast.DeadAt.IsZero() {
+			t.Error("podcast is dead after one failure")
+		}
+
+		failCrawl(t, store, feedURL)
+		if podcast := in.podcast(feedURL); podcast.DeadAt.IsZero() || podcast.FailureCount != 2 {
+			t.Fatalf("podcast isn't dead after %d failures", podcast.FailureCount)
+		}
+		if _, _, skipped := loadExistingPodcasts(ctx, store); !skipped[feedURL] {
+			t.Error("dead feed is still crawled")
+		}
+
+		if err := revivePodcast(ctx, store, "tech-talk"); err != nil {
+			t.Fatal(err)
+		}
+		podcast = in.podcast(feedURL)
+		if !podcast.DeadAt.IsZero() || podcast.FailureCount != 0 || podcast.LastError != "" {
+			t.Errorf("revived podcast is dead since %s with %d failures", podcast.DeadAt, podcast.FailureCount)
+		}
+		if err := revivePodcast(ctx, store, "tech-talk"); err == nil {
+			t.Error("reviving a podcast that isn't dead succeeded")
+		}
+	})
+}
+
+func TestSuccessfulCrawlClearsFailures(t *testing.T) {
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	in := newIngester(t, newMemoryStore())
+	in.crawl(feedURL)
+	config.DeadAfterFailures = 10
+
+	server.fail("/podcast.xml", http.StatusInternalServerError)
+	failCrawl(t, in.store, feedURL)
+	server.setFeed("/podcast.xml", "podcast-updated.xml")
+	in.crawl(feedURL)
+	if podcast := in.podcast(feedURL); podcast.FailureCount != 0 || !podcast.FailingSince.IsZero() || podcast.LastError != "" {
+		t.Errorf("after a successful crawl: %d failures since %s, last error %q", podcast.FailureCount, podcast.FailingSince, podcast.LastError)
+	}
+}
+
+func TestMarkCrawlFailedCountsOnlyFeedErrors(t *testing.T) {
+	ctx := context.Background()
+	feed := "https://a.example/feed"
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"fetch", &FeedError{URL: feed, Kind: FeedNetwork, Err: errors.New("connection refused")}, 1},
+		{"parse", &FeedError{URL: feed, Kind: FeedParse, Err: errors.New("unexpected EOF")}, 1},
+		{"storage", errors.New("error inserting podcast: disk full"), 0},
+		{"validation", errors.New("invalid podcast: no title"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk", Feed: feed}
+			if err := store.InsertPodcast(ctx, &podcast); err != nil {
+				t.Fatal(err)
+			}
+			markCrawlFailed(ctx, store, feed, tt.err, 0, map[string]bool{feed: true})
+			got, err := store.PodcastByFeed(ctx, feed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.FailureCount != tt.want {
+				t.Errorf("failure count %d, want %d", got.FailureCount, tt.want)
+			}
+		})
+	}
+}
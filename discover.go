@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/html"
+)
+
+// maxPageSize is the most of a web page that is read looking for feeds.
+const maxPageSize = 5 << 20
+
+// feedLinkTypes are the link types a page announces its feeds with.
+var feedLinkTypes = []string{"application/rss+xml", "application/atom+xml"}
+
+// commonFeedPaths are tried when a page doesn't link any feed.
+var commonFeedPaths = []string{"/feed", "/rss", "/podcast.xml", "/feed.xml", "/rss.xml", "/feed/podcast"}
+
+// discoveredFeed is a feed found for a website.
+type discoveredFeed struct {
+	URL        string
+	Title      string
+	Episodes   int
+	Enclosures int
+}
+
+// discoverFeeds fetches the page at pageURL and returns the feeds it links
+// to, or failing that, the feeds found at commonFeedPaths on its host. Each
+// candidate is fetched and parsed, only actual feeds are returned. Feeds
+// with enclosures come first, as a site often has a feed for its blog or
+// comments besides the podcast.
+func discoverFeeds(ctx context.Context, pageURL string) ([]discoveredFeed, error) {
+	if err := checkFeedURL(ctx, pageURL); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("fetching %s failed with status %s", pageURL, resp.Status)
+	}
+	base := resp.Request.URL
+
+	var candidates []string
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		candidates = feedLinks(&sizeLimitReader{r: resp.Body, n: maxPageSize}, base)
+	} else {
+		// Not a web page; maybe the URL is a feed already.
+		debugf(ctx, "%s is %q, not HTML, trying it as a feed", pageURL, mediaType)
+		candidates = []string{base.String()}
+	}
+	if len(candidates) == 0 {
+		debugf(ctx, "%s links no feeds, trying common paths", pageURL)
+		for _, p := range commonFeedPaths {
+			candidates = append(candidates, base.ResolveReference(&url.URL{Path: p}).String())
+		}
+	}
+
+	var found []discoveredFeed
+	for _, c := range candidates {
+		feed, _, err := LoadFeed(ctx, c)
+		if err != nil {
+			debugf(ctx, "Candidate %s is no feed: %v", c, err)
+			continue
+		}
+		d := discoveredFeed{URL: c, Title: strings.TrimSpace(feed.Title), Episodes: len(feed.Items)}
+		for _, item := range feed.Items {
+			if len(item.Enclosures) > 0 {
+				d.Enclosures++
+			}
+		}
+		found = append(found, d)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if (found[i].Enclosures > 0) != (found[j].Enclosures > 0) {
+			return found[i].Enclosures > 0
+		}
+		return found[i].Enclosures > found[j].Enclosures
+	})
+	return found, nil
+}
+
+// feedLinks returns the feeds announced by <link rel="alternate"> in the
+// page read from r, resolved against base.
+func feedLinks(r *sizeLimitReader, base *url.URL) []string {
+	var links []string
+	seen := make(map[string]bool)
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) == "body" {
+				return links
+			}
+			if string(name) != "link" || !hasAttr {
+				continue
+			}
+			attrs := make(map[string]string)
+			for {
+				key, val, more := z.TagAttr()
+				attrs[string(key)] = string(val)
+				if !more {
+					break
+				}
+			}
+			if !containsString(strings.Fields(strings.ToLower(attrs["rel"])), "alternate") ||
+				!containsString(feedLinkTypes, strings.ToLower(strings.TrimSpace(attrs["type"]))) {
+				continue
+			}
+			href, err := base.Parse(strings.TrimSpace(attrs["href"]))
+			if err != nil || attrs["href"] == "" {
+				continue
+			}
+			if s := href.String(); !seen[s] {
+				seen[s] = true
+				links = append(links, s)
+			}
+		}
+	}
+}
+
+// discover prints the feeds found for pageURL. With add set, the first of
+// them is added to the feed list, see addFeed.
+func discover(ctx context.Context, store Store, pageURL, feedsFile string, add bool) error {
+	found, err := discoverFeeds(ctx, pageURL)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no feeds found for %s", pageURL)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FEED\tTITLE\tEPISODES\tWITH AUDIO")
+	for _, d := range found {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", d.URL, d.Title, d.Episodes, d.Enclosures)
+	}
+	w.Flush()
+	if !add {
+		return nil
+	}
+
+	chosen := found[0]
+	if chosen.Enclosures == 0 {
+		warnf(ctx, "%s has no episodes with audio, adding it anyway", chosen.URL)
+	}
+	return addFeed(ctx, store, feedsFile, chosen.URL)
+}
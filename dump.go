@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A dump is a JSON array with one entry per podcast, holding the podcast
+// and all its episodes. Documents are written as relaxed Extended JSON, so
+// IDs and dates survive the round trip through --import-json-dump.
+type dumpEntry struct {
+	Podcast  json.RawMessage   `json:"podcast"`
+	Episodes []json.RawMessage `json:"episodes"`
+}
+
+// exportJSON writes all podcasts and their episodes to filename. Entries are
+// written one podcast at a time, so only a single podcast's episodes are
+// ever held in memory.
+func exportJSON(ctx context.Context, store Store, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+
+	episodeCount := 0
+	if _, err := w.WriteString("[\n"); err != nil {
+		return err
+	}
+	for i, p := range podcasts {
+		episodes, err := store.Episodes(ctx, p.PodlistUrl)
+		if err != nil {
+			return fmt.Errorf("error fetching episodes of %s: %v", p.PodlistUrl, err)
+		}
+		var entry dumpEntry
+		if entry.Podcast, err = bson.MarshalExtJSON(p, false, false); err != nil {
+			return err
+		}
+		entry.Episodes = make([]json.RawMessage, 0, len(episodes))
+		for _, e := range episodes {
+			data, err := bson.MarshalExtJSON(e, false, false)
+			if err != nil {
+				return err
+			}
+			entry.Episodes = append(entry.Episodes, data)
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := w.WriteString(",\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		episodeCount += len(episodes)
+	}
+	if _, err := w.WriteString("\n]\n"); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	infof(ctx, "Exported %d podcasts with %d episodes to %s", len(podcasts), episodeCount, filename)
+	return f.Close()
+}
+
+// importJSONDump loads a dump written by exportJSON into store. Podcasts
+// whose feed or stable ID is already stored are skipped, so an interrupted
+// import can be run again, and a dump can be imported into a database
+// that already crawled some of its podcasts.
+func importJSONDump(ctx context.Context, store Store, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return fmt.Errorf("%s is not a PodGo dump", filename)
+	}
+
+	existing, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	storedIDs := make(map[string]bool)
+	for _, p := range existing {
+		if p.StableID != "" {
+			storedIDs[p.StableID] = true
+		}
+	}
+
+	imported, skipped, episodeCount := 0, 0, 0
+	for dec.More() {
+		var entry dumpEntry
+		if err := dec.Decode(&entry); err != nil {
+			return fmt.Errorf("error reading dump: %v", err)
+		}
+		var podcast Podcast
+		if err := bson.UnmarshalExtJSON(entry.Podcast, false, &podcast); err != nil {
+			return fmt.Errorf("error decoding podcast: %v", err)
+		}
+		if podcast.StableID != "" && storedIDs[podcast.StableID] {
+			skipped++
+			continue
+		}
+		if _, err := store.PodcastByFeed(ctx, podcast.Feed); err == nil {
+			skipped++
+			continue
+		} else if err != errNotFound {
+			return fmt.Errorf("error looking up podcast %s: %v", podcast.PodlistUrl, err)
+		}
+
+		var episodes []Episode
+		ids := make(map[primitive.ObjectID]bool)
+		for _, data := range entry.Episodes {
+			var e Episode
+			if err := bson.UnmarshalExtJSON(data, false, &e); err != nil {
+				return fmt.Errorf("error decoding episode of %s: %v", podcast.PodlistUrl, err)
+			}
+			episodes = append(episodes, e)
+			ids[e.ID] = true
+		}
+
+		// Episodes go first: a podcast is only skipped on a rerun once it
+		// is stored, so it must not be stored without its episodes. What an
+		// interrupted import left of them is removed beforehand.
+		stored, err := store.Episodes(ctx, podcast.PodlistUrl)
+		if err != nil {
+			return fmt.Errorf("error fetching episodes of %s: %v", podcast.PodlistUrl, err)
+		}
+		var leftovers []primitive.ObjectID
+		for _, e := range stored {
+			if ids[e.ID] {
+				leftovers = append(leftovers, e.ID)
+			}
+		}
+		if len(leftovers) > 0 {
+			if err := store.DeleteEpisodes(ctx, leftovers); err != nil {
+				return fmt.Errorf("error removing partially imported episodes of %s: %v", podcast.PodlistUrl, err)
+			}
+		}
+
+		for len(episodes) > 0 {
+			n := len(episodes)
+			if n > insertBatchSize {
+				n = insertBatchSize
+			}
+			if _, err := store.InsertEpisodes(ctx, episodes[:n]); err != nil {
+				return fmt.Errorf("error inserting episodes of %s: %v", podcast.PodlistUrl, err)
+			}
+			episodes = episodes[n:]
+			episodeCount += n
+		}
+		if err := store.InsertPodcast(ctx, &podcast); err != nil {
+			return fmt.Errorf("error inserting podcast %s: %v", podcast.PodlistUrl, err)
+		}
+		imported++
+	}
+	if _, err := dec.Token(); err != nil && err != io.EOF {
+		return fmt.Errorf("error reading dump: %v", err)
+	}
+	infof(ctx, "Imported %d podcasts with %d episodes from %s, skipped %d already stored podcasts", imported, episodeCount, filename, skipped)
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Some shows republish their trailer or a "best of" episode under a new
+// GUID every season. Such near duplicates are found by their title, with
+// punctuation, numbering and rebroadcast markers left out, and by the size
+// of their audio.
+
+// What to do with near duplicates, see --dupes.
+const (
+	dupesLog   = "log"
+	dupesMark  = "mark"
+	dupesMerge = "merge"
+)
+
+var dupesActions = []string{dupesLog, dupesMark, dupesMerge}
+
+var (
+	// rebroadcastMarker matches a trailing "(Rebroadcast)", "[Encore]" and
+	// the like.
+	rebroadcastMarker = regexp.MustCompile(`(?i)\s*[(\[]\s*(rebroadcast|repeat|encore|replay|rerun|re-run|best of|wiederholung)\s*[)\]]\s*$`)
+	// episodeNumbering matches "#12", "Ep. 3", "Episode 4", "S2E5" and a
+	// leading "12:" or "12 -".
+	episodeNumbering = regexp.MustCompile(`(?i)#\d+|\b(episode|ep|folge|part|teil|no|nr)\.?\s*\d+\b|\bs\d+\s*e\d+\b|^\s*\d+\s*[:.\-–—|]\s*`)
+)
+
+// dupeTitleKey returns what is left of title to compare it with those of
+// other episodes, or "" if nothing is.
+func dupeTitleKey(title string) string {
+	t := strings.TrimSpace(plainText(title))
+	for {
+		stripped := rebroadcastMarker.ReplaceAllString(t, "")
+		if stripped == t {
+			break
+		}
+		t = stripped
+	}
+	t = episodeNumbering.ReplaceAllString(t, " ")
+	t = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, t)
+	return strings.Join(strings.Fields(t), " ")
+}
+
+// dupeGroup is an episode and its near duplicates published after it.
+type dupeGroup struct {
+	Original Episode
+	Copies   []Episode
+}
+
+// findDupeGroups returns the groups of near duplicates among episodes:
+// episodes with the same dupeTitleKey whose audio sizes differ by at most
+// tolerance, a fraction of the size. Episodes of unknown size are never
+// duplicates. Only groups of at least minCopies episodes are returned.
+func findDupeGroups(episodes []Episode, tolerance float64, minCopies int) []dupeGroup {
+	byTitle := make(map[string][]Episode)
+	var keys []string
+	for _, e := range episodes {
+		key := dupeTitleKey(e.Title)
+		if key == "" || e.Enclosure.size() == 0 {
+			continue
+		}
+		if _, ok := byTitle[key]; !ok {
+			keys = append(keys, key)
+		}
+		byTitle[key] = append(byTitle[key], e)
+	}
+
+	var groups []dupeGroup
+	for _, key := range keys {
+		candidates := byTitle[key]
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].Enclosure.size() < candidates[j].Enclosure.size()
+		})
+		for start := 0; start < len(candidates); {
+			base := float64(candidates[start].Enclosure.size())
+			end := start + 1
+			for end < len(candidates) && math.Abs(float64(candidates[end].Enclosure.size())-base) <= base*tolerance {
+				end++
+			}
+			if end-start >= minCopies && end-start > 1 {
+				groups = append(groups, newDupeGroup(candidates[start:end]))
+			}
+			start = end
+		}
+	}
+	return groups
+}
+
+// newDupeGroup makes the first published of episodes the original.
+func newDupeGroup(episodes []Episode) dupeGroup {
+	sorted := append([]Episode(nil), episodes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].Published.Equal(sorted[j].Published) {
+			return sorted[i].Published.Before(sorted[j].Published)
+		}
+		return sorted[i].ID.Hex() < sorted[j].ID.Hex()
+	})
+	return dupeGroup{Original: sorted[0], Copies: sorted[1:]}
+}
+
+// handleDupes looks for near duplicates among the episodes of podcast and
+// logs them, marks the copies with DuplicateOf or deletes them, depending
+// on action. Deleted copies that are still in the feed come back with the
+// next crawl that processes it, and are merged again if the crawl runs
+// with --dupes=merge.
+func handleDupes(ctx context.Context, store Store, podcast Podcast, action string) ([]dupeGroup, error) {
+	episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching episodes of %s: %v", podcast.PodlistUrl, err)
+	}
+	groups := findDupeGroups(episodes, config.DupeSizeTolerance, config.DupeMinCopies)
+
+	var deleted []primitive.ObjectID
+	for _, g := range groups {
+		for _, c := range g.Copies {
+			switch action {
+			case dupesMark:
+				if c.DuplicateOf == g.Original.ID {
+					continue
+				}
+				set := bson.M{"duplicateOf": g.Original.ID}
+				if err := store.UpdateEpisode(ctx, c.ID, set); err != nil {
+					return groups, fmt.Errorf("error marking episode %s: %v", c.ID.Hex(), err)
+				}
+				changes.episodeUpdated(c, set)
+			case dupesMerge:
+				deleted = append(deleted, c.ID)
+			}
+		}
+	}
+	if len(deleted) > 0 {
+		if err := store.DeleteEpisodes(ctx, deleted); err != nil {
+			return groups, fmt.Errorf("error removing duplicates of %s: %v", podcast.PodlistUrl, err)
+		}
+		for _, id := range deleted {
+			changes.record(podcast.Namespace, entityEpisode, id, opDelete, nil)
+		}
+		if err := store.RefreshPodcastStats(ctx, podcast.PodlistUrl); err != nil {
+			errorf(ctx, "Error updating stats for podcast %s: %v", podcast.Title, err)
+		}
+	}
+	return groups, nil
+}
+
+// logDupes logs what handleDupes found in podcast during a crawl.
+func logDupes(podcast Podcast, groups []dupeGroup, action string) {
+	for _, g := range groups {
+		verb := map[string]string{dupesLog: "Found", dupesMark: "Marked", dupesMerge: "Removed"}[action]
+		infof(context.Background(), "%s %d near duplicates of episode %q of podcast %s", verb, len(g.Copies), g.Original.Title, podcast.PodlistUrl)
+	}
+}
+
+// findDupes is the find-dupes command: it reports the near duplicates of
+// the podcast with the given slug, or of all podcasts, and only changes
+// anything if --dupes says so.
+func findDupes(ctx context.Context, store Store, slug, action string) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	if slug != "" {
+		var found []Podcast
+		for _, p := range podcasts {
+			if p.PodlistUrl == slug {
+				found = append(found, p)
+			}
+		}
+		if len(found) == 0 {
+			return fmt.Errorf("no podcast %q", slug)
+		}
+		podcasts = found
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "PODCAST\tPUBLISHED\tTITLE\tDUPLICATE OF")
+	copies := 0
+	for _, p := range podcasts {
+		groups, err := handleDupes(ctx, store, p, action)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", p.PodlistUrl, g.Original.Published.Format("2006-01-02"), g.Original.Title)
+			for _, c := range g.Copies {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.PodlistUrl, c.Published.Format("2006-01-02"), c.Title, g.Original.ID.Hex())
+			}
+			copies += len(g.Copies)
+		}
+	}
+	switch action {
+	case dupesMark:
+		fmt.Fprintf(w, "\nMarked %d near duplicates\n", copies)
+	case dupesMerge:
+		fmt.Fprintf(w, "\nRemoved %d near duplicates\n", copies)
+	default:
+		fmt.Fprintf(w, "\n%d near duplicates, nothing changed; use --dupes=mark or --dupes=merge to act on them\n", copies)
+	}
+	return nil
+}
+
+// linkCrossPosts sets DuplicateOf on each of episodes, new episodes of
+// podcast, whose audio is stored already with an episode of another
+// podcast, as when a network reruns an episode of one of its shows. The
+// earliest published of those is linked. Episodes stored before
+// EnclosureKey existed are never found.
+func linkCrossPosts(ctx context.Context, store Store, podcast Podcast, episodes []Episode) {
+	linked := 0
+	for i, e := range episodes {
+		if e.EnclosureKey == "" {
+			continue
+		}
+		original, err := store.EpisodeByEnclosure(ctx, e.EnclosureKey, podcast.PodlistUrl)
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			errorf(ctx, "Error looking up the enclosure of episode %s of podcast %s: %v", e.Guid, podcast.Title, err)
+			continue
+		}
+		episodes[i].DuplicateOf = original.ID
+		linked++
+		debugf(ctx, "Episode %q of %s is a cross-post of %q of %s", e.Title, podcast.PodlistUrl, original.Title, original.PodcastUrl)
+	}
+	if linked > 0 {
+		infof(ctx, "Linked %d cross-posted episodes of podcast %s", linked, podcast.Title)
+	}
+}
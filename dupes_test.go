@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDupeTitleKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Trailer: Season Two!", "Trailer - Season Two", true},
+		{"Meet the hosts", "Meet the Hosts...", true},
+		{"#12 The Best of 2023", "#48 The Best of 2023", true},
+		{"Episode 4: Welcome", "Ep. 17 - Welcome", true},
+		{"S2E5 Welcome back", "S3E1 Welcome back", true},
+		{"12: Our Story", "57 - Our Story", true},
+		{"Our Story", "Our Story (Rebroadcast)", true},
+		{"Our Story", "Our Story [Encore]", true},
+		{"Our Story", "Our Story (Best of) (Repeat)", true},
+		{"Our Story", "Their Story", false},
+		{"Our Story", "Our Story Continues", false},
+		{"Part 1 of Us", "Part 2 of Them", false},
+	}
+	for _, tt := range tests {
+		a, b := dupeTitleKey(tt.a), dupeTitleKey(tt.b)
+		if (a == b) != tt.same {
+			t.Errorf("%q gives %q and %q gives %q, same %v, want %v", tt.a, a, tt.b, b, a == b, tt.same)
+		}
+	}
+	for _, title := range []string{"", "#12", "(Rebroadcast)", "!!!"} {
+		if key := dupeTitleKey(title); key != "" {
+			t.Errorf("dupeTitleKey(%q) = %q, want nothing", title, key)
+		}
+	}
+}
+
+// dupeEpisode returns an episode of podcast with audio of size bytes,
+// published days after the epoch.
+func dupeEpisode(podcast Podcast, guid, title string, size int64, days int) Episode {
+	e := testEpisode(podcast, guid, feedEpoch.AddDate(0, 0, days))
+	e.Title = title
+	e.Enclosure.Size = size
+	return e
+}
+
+func TestFindDupeGroups(t *testing.T) {
+	podcast := Podcast{PodlistUrl: "tech-talk"}
+	episodes := []Episode{
+		dupeEpisode(podcast, "trailer-3", "Trailer (Rebroadcast)", 1000500, 200),
+		dupeEpisode(podcast, "trailer-1", "Trailer", 1000000, 0),
+		dupeEpisode(podcast, "trailer-2", "Trailer!", 1000900, 100),
+		// The same title with other audio is another episode.
+		dupeEpisode(podcast, "trailer-long", "Trailer", 5000000, 300),
+		// Episodes of unknown size are never duplicates.
+		dupeEpisode(podcast, "trailer-unknown", "Trailer", 0, 400),
+		dupeEpisode(podcast, "ep-1", "Episode 1: Hello", 2000000, 1),
+	}
+	groups := findDupeGroups(episodes, 0.01, 2)
+	if len(groups) != 1 {
+		t.Fatalf("%d groups, want 1: %+v", len(groups), groups)
+	}
+	g := groups[0]
+	if g.Original.Guid != "trailer-1" {
+		t.Errorf("original %s, want the oldest, trailer-1", g.Original.Guid)
+	}
+	if len(g.Copies) != 2 || g.Copies[0].Guid != "trailer-2" || g.Copies[1].Guid != "trailer-3" {
+		t.Errorf("copies %v, want trailer-2 and trailer-3", g.Copies)
+	}
+
+	if groups := findDupeGroups(episodes, 0.01, 4); len(groups) != 0 {
+		t.Errorf("%d groups of at least 4, want none", len(groups))
+	}
+	if groups := findDupeGroups(episodes, 0.0001, 2); len(groups) != 0 {
+		t.Errorf("%d groups with a tolerance of 100 bytes, want none", len(groups))
+	}
+}
+
+func TestHandleDupes(t *testing.T) {
+	for _, action := range dupesActions {
+		t.Run(action, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, store Store) {
+				ctx := context.Background()
+				podcast := testPodcast(t, store, "tech-talk")
+				episodes := []Episode{
+					dupeEpisode(podcast, "trailer-1", "Trailer", 1000000, 0),
+					dupeEpisode(podcast, "trailer-2", "Trailer (Rebroadcast)", 1000000, 100),
+					dupeEpisode(podcast, "ep-1", "Episode 1: Hello", 2000000, 1),
+				}
+				if _, err := store.InsertEpisodes(ctx, episodes); err != nil {
+					t.Fatal(err)
+				}
+
+				groups, err := handleDupes(ctx, store, podcast, action)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(groups) != 1 {
+					t.Fatalf("%d groups, want 1", len(groups))
+				}
+				stored, err := store.Episodes(ctx, podcast.PodlistUrl)
+				if err != nil {
+					t.Fatal(err)
+				}
+				byGUID := map[string]Episode{}
+				for _, e := range stored {
+					byGUID[e.Guid] = e
+				}
+				dupe, kept := byGUID["trailer-2"]
+				switch action {
+				case dupesLog:
+					if !kept || !dupe.DuplicateOf.IsZero() {
+						t.Errorf("logging changed the copy: kept %v, duplicate of %s", kept, dupe.DuplicateOf.Hex())
+					}
+				case dupesMark:
+					if !kept || dupe.DuplicateOf != episodes[0].ID {
+						t.Errorf("copy duplicate of %s, want %s", dupe.DuplicateOf.Hex(), episodes[0].ID.Hex())
+					}
+				case dupesMerge:
+					if kept {
+						t.Error("merging kept the copy")
+					}
+				}
+				for _, guid := range []string{"trailer-1", "ep-1"} {
+					if _, ok := byGUID[guid]; !ok {
+						t.Errorf("episode %s is gone", guid)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestEnclosureKey(t *testing.T) {
+	want := "cdn.example.com/techtalk/2.mp3"
+	for _, raw := range []string{
+		"https://cdn.example.com/techtalk/2.mp3",
+		"http://www.cdn.example.com/techtalk/2.mp3?source=rss#t=10",
+		"https://dts.podtrac.com/redirect.mp3/cdn.example.com/techtalk/2.mp3",
+		"https://chtbl.com/track/ABC123/https://op3.dev/e/cdn.example.com/techtalk/2.mp3",
+		" https://pdst.fm/e/CDN.example.com/techtalk/2.mp3 ",
+	} {
+		if got := enclosureKey(raw); got != want {
+			t.Errorf("enclosureKey(%q) = %q, want %q", raw, got, want)
+		}
+	}
+	if got := enclosureKey(""); got != "" {
+		t.Errorf("enclosureKey of no URL is %q", got)
+	}
+	if enclosureKey("https://cdn.example.com/techtalk/3.mp3") == want {
+		t.Error("other audio has the same key")
+	}
+}
+
+func TestIngestLinksCrossPosts(t *testing.T) {
+	for _, link := range []bool{true, false} {
+		t.Run(fmt.Sprintf("link %v", link), func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, store Store) {
+				server := newFeedServer(t)
+				showURL := server.setFeed("/podcast.xml", "podcast.xml")
+				networkURL := server.setFeed("/network.xml", "network.xml")
+				in := newIngester(t, store)
+				config.LinkDuplicates = link
+
+				in.crawl(showURL)
+				in.crawl(networkURL)
+				var original Episode
+				for _, e := range in.episodes(in.podcast(showURL)) {
+					if e.Guid == "techtalk-2" {
+						original = e
+					}
+				}
+				for _, e := range in.episodes(in.podcast(networkURL)) {
+					want := primitive.NilObjectID
+					if link && e.Guid == "network-7" {
+						want = original.ID
+					}
+					if e.DuplicateOf != want {
+						t.Errorf("episode %s duplicate of %s, want %s", e.Guid, e.DuplicateOf.Hex(), want.Hex())
+					}
+				}
+			})
+		})
+	}
+}
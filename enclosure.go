@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"html"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// itemEnclosures returns the enclosures of a feed item with a usable URL.
+func itemEnclosures(item *gofeed.Item) []EpisodeEnclosure {
+	var enclosures []EpisodeEnclosure
+	for _, e := range item.Enclosures {
+		u := cleanEnclosureURL(e.URL)
+		if u == "" {
+			continue
+		}
+		enclosures = append(enclosures, EpisodeEnclosure{
+			Filetype: e.Type,
+			Filesize: e.Length,
+			Url:      u,
+			Size:     parseEnclosureSize(e.Length),
+		})
+	}
+	return enclosures
+}
+
+// itemEnclosure returns the enclosure of a feed item --prefer and its
+// relatives pick, see Config.PreferredEnclosure.
+func itemEnclosure(item *gofeed.Item) EpisodeEnclosure {
+	enclosures := itemEnclosures(item)
+	if len(enclosures) == 0 {
+		return EpisodeEnclosure{}
+	}
+	return enclosures[config.PreferredEnclosure(enclosures)]
+}
+
+// PrimaryEnclosure returns the enclosure of the episode to play and its
+// index in Enclosures, as picked by Config.PreferredEnclosure. Episodes
+// with a single enclosure keep no Enclosures, theirs is Enclosure.
+func (e Episode) PrimaryEnclosure() (EpisodeEnclosure, int) {
+	if len(e.Enclosures) == 0 {
+		return e.Enclosure, 0
+	}
+	i := config.PreferredEnclosure(e.Enclosures)
+	return e.Enclosures[i], i
+}
+
+// mediaType returns the MIME type of the enclosure without parameters,
+// guessed from the extension of its URL if the feed gave no type.
+func (e EpisodeEnclosure) mediaType() string {
+	t := e.Filetype
+	if strings.TrimSpace(t) == "" {
+		if u, err := url.Parse(e.Url); err == nil {
+			t = mime.TypeByExtension(path.Ext(u.Path))
+		}
+	}
+	if i := strings.Index(t, ";"); i >= 0 {
+		t = t[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// kind returns the top level media type of the enclosure, like audio or
+// video.
+func (e EpisodeEnclosure) kind() string {
+	t := e.mediaType()
+	if i := strings.Index(t, "/"); i >= 0 {
+		return t[:i]
+	}
+	return ""
+}
+
+// cleanEnclosureURL repairs what commonly goes wrong with enclosure URLs in
+// feeds: surrounding whitespace, HTML entities such as &amp; and
+// protocol-relative URLs, which get https. It returns "" for URLs that are
+// still not absolute http(s) URLs after that.
+func cleanEnclosureURL(raw string) string {
+	s := strings.TrimSpace(html.UnescapeString(strings.TrimSpace(raw)))
+	if s == "" {
+		return ""
+	}
+	if strings.HasPrefix(s, "//") {
+		s = "https:" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		warnf(context.Background(), "Skipping enclosure with invalid URL %q", raw)
+		return ""
+	}
+	return u.String()
+}
+
+// trackingPrefix matches the redirect of a measurement service that some
+// feeds put in front of their enclosure URLs, with the scheme removed.
+var trackingPrefix = regexp.MustCompile(`^(dts\.podtrac\.com/redirect\.[^/]+/|(www\.)?podtrac\.com/pts/redirect\.[^/]+/|chtbl\.com/track/[^/]+/|pdst\.fm/e/|op3\.dev/e/(,[^/]*/)?|arttrk\.com/p/[^/]+/|verifi\.podscribe\.com/rss/p/)`)
+
+// enclosureKey returns what is left of an enclosure URL to tell whether two
+// episodes have the same audio: the host, without www, and the path, once
+// tracking redirects, the scheme, the query and the fragment are removed.
+// It returns "" for an empty URL.
+func enclosureKey(raw string) string {
+	s := strings.TrimSpace(raw)
+	if i := strings.IndexAny(s, "?#"); i >= 0 {
+		s = s[:i]
+	}
+	for {
+		stripped := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://"), "//")
+		stripped = trackingPrefix.ReplaceAllString(stripped, "")
+		if stripped == s {
+			break
+		}
+		s = stripped
+	}
+	host, path := s, ""
+	if i := strings.Index(s, "/"); i >= 0 {
+		host, path = s[:i], s[i:]
+	}
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	if host == "" {
+		return ""
+	}
+	return host + path
+}
+
+// parseEnclosureSize parses the length attribute of an enclosure. Feeds put
+// all sorts of garbage there ("", "0", "unknown"), all of which yield zero.
+func parseEnclosureSize(length string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(length), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// size returns the enclosure size, falling back to parsing Filesize for
+// episodes stored before Size existed.
+func (e EpisodeEnclosure) size() int64 {
+	if e.Size > 0 {
+		return e.Size
+	}
+	return parseEnclosureSize(e.Filesize)
+}
+
+// refreshEnclosures updates the stored enclosures of known episodes whose
+// audio changed in the feed. A different URL or size marks the audio as
+// revised, except where the size merely appears or disappears. Items
+// without a GUID can't be told apart and are skipped.
+func refreshEnclosures(ctx context.Context, store Store, podcast Podcast, episodes []Episode, items []*gofeed.Item) error {
+	current := make(map[string][]EpisodeEnclosure)
+	for _, item := range items {
+		guid := normalizeGUID(item.GUID)
+		if enclosures := itemEnclosures(item); guid != "" && len(enclosures) > 0 {
+			current[guid] = enclosures
+		}
+	}
+
+	revised := 0
+	for _, e := range episodes {
+		enclosures, ok := current[normalizeGUID(e.Guid)]
+		if !ok {
+			continue
+		}
+		primary := config.PreferredEnclosure(enclosures)
+		ee := enclosures[primary]
+		// Episodes stored before enclosure URLs were cleaned up get the
+		// cleaned URL without their audio counting as revised.
+		oldURL := cleanEnclosureURL(e.Enclosure.Url)
+		oldSize, newSize := e.Enclosure.size(), ee.Size
+		revisedAudio := (oldURL != "" && oldURL != ee.Url) ||
+			(oldSize != 0 && newSize != 0 && oldSize != newSize)
+		filledIn := oldURL == "" || e.Enclosure.Url != ee.Url || (oldSize == 0 && newSize != 0)
+		if !revisedAudio && !filledIn {
+			continue
+		}
+
+		set := bson.M{"enclosure": ee, "enclosureKey": enclosureKey(ee.Url)}
+		if len(enclosures) > 1 {
+			set["enclosures"] = enclosures
+			set["primaryEnclosureIndex"] = primary
+		}
+		if revisedAudio {
+			set["audioRevisedAt"] = time.Now()
+			revised++
+			debugf(ctx, "Audio of episode %s of podcast %s was revised", e.Guid, podcast.Title)
+		}
+		if err := store.UpdateEpisode(ctx, e.ID, set); err != nil {
+			return err
+		}
+		changes.episodeUpdated(e, set)
+		indexUpdatedEpisode(e, set)
+	}
+	if revised > 0 {
+		infof(ctx, "Detected revised audio on %d episodes of podcast %s", revised, podcast.Title)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestParseEnclosureSize(t *testing.T) {
+	tests := []struct {
+		length string
+		want   int64
+	}{
+		{"3000000", 3000000},
+		{" 42 ", 42},
+		{"0", 0},
+		{"", 0},
+		{"unknown", 0},
+		{"-1", 0},
+		{"12.5MB", 0},
+	}
+	for _, tt := range tests {
+		if got := parseEnclosureSize(tt.length); got != tt.want {
+			t.Errorf("parseEnclosureSize(%q) = %d, want %d", tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestIngestRevisedAudio(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		for _, e := range in.episodes(in.podcast(feedURL)) {
+			if !e.AudioRevisedAt.IsZero() {
+				t.Errorf("new episode %s has revised audio", e.Guid)
+			}
+		}
+
+		// Episode 3 was uploaded again under a new URL, the size of
+		// episode 2 turned to garbage.
+		server.setFeed("/podcast.xml", "podcast-reuploaded.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 {
+			t.Errorf("%d new episodes, want none", result.NewEpisodes)
+		}
+		episodes := in.episodes(in.podcast(feedURL))
+		if len(episodes) != 3 {
+			t.Fatalf("%d episodes stored, want 3", len(episodes))
+		}
+		revised, garbage, unchanged := episodes[0], episodes[1], episodes[2]
+		if revised.AudioRevisedAt.IsZero() {
+			t.Errorf("reuploaded episode %s isn't marked revised", revised.Guid)
+		}
+		if revised.Enclosure.Url != "https://cdn.example.com/techtalk/3-fixed.mp3" || revised.Enclosure.Size != 3100000 {
+			t.Errorf("reuploaded episode enclosure %+v", revised.Enclosure)
+		}
+		if !garbage.AudioRevisedAt.IsZero() {
+			t.Errorf("episode %s whose size went missing is marked revised", garbage.Guid)
+		}
+		if garbage.Enclosure.Size != 2000000 {
+			t.Errorf("episode %s enclosure size %d, want the stored 2000000", garbage.Guid, garbage.Enclosure.Size)
+		}
+		if !unchanged.AudioRevisedAt.IsZero() {
+			t.Errorf("unchanged episode %s is marked revised", unchanged.Guid)
+		}
+	})
+}
+
+func TestRefreshEnclosuresSkipsItemsWithoutGUID(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := testPodcast(t, store, "tech-talk")
+	e := testEpisode(podcast, "", feedEpoch)
+	if _, err := store.InsertEpisodes(ctx, []Episode{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	item := &gofeed.Item{Enclosures: []*gofeed.Enclosure{{URL: "https://cdn.example.com/other.mp3", Type: "audio/mpeg", Length: "1000"}}}
+	if err := refreshEnclosures(ctx, store, podcast, []Episode{e}, []*gofeed.Item{item}); err != nil {
+		t.Fatal(err)
+	}
+	episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 1 || episodes[0].Enclosure.Url != e.Enclosure.Url || !episodes[0].AudioRevisedAt.IsZero() {
+		t.Errorf("episode without a GUID got the audio of another item: %+v", episodes)
+	}
+}
+
+func TestCleanEnclosureURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"https://cdn.example.com/1.mp3", "https://cdn.example.com/1.mp3"},
+		{"  https://cdn.example.com/1.mp3\n", "https://cdn.example.com/1.mp3"},
+		{"//cdn.example.com/1.mp3", "https://cdn.example.com/1.mp3"},
+		{" //cdn.example.com/1.mp3 ", "https://cdn.example.com/1.mp3"},
+		{"https://cdn.example.com/1.mp3?a=1&amp;b=2", "https://cdn.example.com/1.mp3?a=1&b=2"},
+		{"https://cdn.example.com/1.mp3?a=1&amp;amp;b=2", "https://cdn.example.com/1.mp3?a=1&amp;b=2"},
+		{"http://cdn.example.com/1.mp3", "http://cdn.example.com/1.mp3"},
+		{"", ""},
+		{"   ", ""},
+		{"/episodes/1.mp3", ""},
+		{"1.mp3", ""},
+		{"ftp://cdn.example.com/1.mp3", ""},
+		{"https:///1.mp3", ""},
+		{"https://cdn example.com/1.mp3", ""},
+	}
+	for _, tt := range tests {
+		if got := cleanEnclosureURL(tt.raw); got != tt.want {
+			t.Errorf("cleanEnclosureURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestCreateEpisodeCleansEnclosure(t *testing.T) {
+	podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk", Feed: "https://a.example/feed"}
+	item := testItem("ep-1", "Episode 1", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	item.Enclosures = []*gofeed.Enclosure{
+		{URL: "not a url", Type: "audio/mpeg", Length: "100"},
+		{URL: " //cdn.example.com/1.mp3?id=1&amp;src=rss ", Type: "audio/mpeg", Length: "2000"},
+	}
+	e := createEpisode(item, podcast)
+	if want := "https://cdn.example.com/1.mp3?id=1&src=rss"; e.Enclosure.Url != want {
+		t.Errorf("enclosure %q, want %q", e.Enclosure.Url, want)
+	}
+	if len(e.Enclosures) > 1 {
+		t.Errorf("invalid enclosure kept: %+v", e.Enclosures)
+	}
+}
+
+func TestPreferredEnclosure(t *testing.T) {
+	video := EpisodeEnclosure{Url: "https://cdn.example.com/1.mp4", Filetype: "video/mp4", Size: 90000000}
+	mp3 := EpisodeEnclosure{Url: "https://cdn.example.com/1.mp3", Filetype: "audio/mpeg", Size: 30000000}
+	m4a := EpisodeEnclosure{Url: "https://cdn.example.com/1.m4a", Filetype: "audio/x-m4a; codecs=aac", Size: 20000000}
+	opus := EpisodeEnclosure{Url: "https://cdn.example.com/1.opus", Filetype: "audio/opus", Size: 40000000}
+	// Without a type it is guessed from the extension.
+	untyped := EpisodeEnclosure{Url: "https://cdn.example.com/1.mp3?source=rss"}
+
+	tests := []struct {
+		name       string
+		prefer     string
+		mime       stringList
+		size       string
+		enclosures []EpisodeEnclosure
+		want       int
+	}{
+		{"single", enclosureAudio, nil, "", []EpisodeEnclosure{video}, 0},
+		{"audio over video", enclosureAudio, nil, "", []EpisodeEnclosure{video, mp3}, 1},
+		{"video over audio", enclosureVideo, nil, "", []EpisodeEnclosure{mp3, video}, 1},
+		{"first audio", enclosureAudio, nil, "", []EpisodeEnclosure{video, mp3, m4a}, 1},
+		{"untyped audio", enclosureAudio, nil, "", []EpisodeEnclosure{video, untyped}, 1},
+		{"MIME type", enclosureAudio, stringList{"audio/x-m4a"}, "", []EpisodeEnclosure{mp3, video, m4a}, 2},
+		{"MIME types in order", enclosureAudio, stringList{"audio/opus", "AUDIO/X-M4A"}, "", []EpisodeEnclosure{m4a, opus}, 1},
+		{"MIME type over kind", enclosureAudio, stringList{"video/mp4"}, "", []EpisodeEnclosure{mp3, video}, 1},
+		{"MIME type missing", enclosureAudio, stringList{"audio/flac"}, "", []EpisodeEnclosure{video, mp3}, 1},
+		{"smallest", enclosureAudio, nil, preferSmallest, []EpisodeEnclosure{opus, mp3, video, m4a}, 3},
+		{"largest", enclosureAudio, nil, preferLargest, []EpisodeEnclosure{mp3, video, opus, m4a}, 2},
+		{"smallest of unknown size", enclosureAudio, nil, preferSmallest, []EpisodeEnclosure{untyped, mp3}, 1},
+	}
+	for _, tt := range tests {
+		c := Config{Prefer: tt.prefer, PreferMIME: tt.mime, PreferSize: tt.size}
+		if got := c.PreferredEnclosure(tt.enclosures); got != tt.want {
+			t.Errorf("%s: got enclosure %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIngestPrimaryEnclosure(t *testing.T) {
+	tests := []struct {
+		prefer  string
+		wantURL string
+		want    int
+	}{
+		{enclosureAudio, "https://cdn.example.com/screen/2.mp3", 1},
+		{enclosureVideo, "https://cdn.example.com/screen/2.mp4", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefer, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, store Store) {
+				server := newFeedServer(t)
+				feedURL := server.setFeed("/multi-enclosure.xml", "multi-enclosure.xml")
+				in := newIngester(t, store)
+				config.Prefer = tt.prefer
+
+				in.crawl(feedURL)
+				episodes := in.episodes(in.podcast(feedURL))
+				if len(episodes) != 2 {
+					t.Fatalf("got %d episodes, want 2", len(episodes))
+				}
+				both, single := episodes[0], episodes[1]
+				if len(both.Enclosures) != 2 {
+					t.Fatalf("stored %d enclosures, want 2", len(both.Enclosures))
+				}
+				if both.PrimaryEnclosureIndex != tt.want || both.Enclosure.Url != tt.wantURL {
+					t.Errorf("primary enclosure %d %s, want %d %s", both.PrimaryEnclosureIndex, both.Enclosure.Url, tt.want, tt.wantURL)
+				}
+				if primary, i := both.PrimaryEnclosure(); i != tt.want || primary.Url != tt.wantURL {
+					t.Errorf("PrimaryEnclosure() = %d %s, want %d %s", i, primary.Url, tt.want, tt.wantURL)
+				}
+
+				// An item with one enclosure keeps it as Enclosure only.
+				if single.Enclosures != nil || single.PrimaryEnclosureIndex != 0 {
+					t.Errorf("episode with one enclosure stored %v, primary %d", single.Enclosures, single.PrimaryEnclosureIndex)
+				}
+				if primary, i := single.PrimaryEnclosure(); i != 0 || primary.Url != "https://cdn.example.com/screen/1.mp3" {
+					t.Errorf("PrimaryEnclosure() of one enclosure = %d %s", i, primary.Url)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const enclosureCheckQueueSize = 10000
+
+// enclosureCheck is a new episode whose enclosure is to be verified, with
+// the store of its namespace.
+type enclosureCheck struct {
+	store   Store
+	episode Episode
+}
+
+// enclosureVerifier sends a HEAD request to the enclosure of every new
+// episode in the background, so ingestion never waits for it, and stores
+// the outcome on the episode. A nil verifier checks nothing.
+type enclosureVerifier struct {
+	ctx    context.Context
+	limits *hostLimiter
+	queue  chan enclosureCheck
+	wg     sync.WaitGroup
+
+	mu             sync.Mutex
+	checked, dead  int
+	droppedWarning bool
+}
+
+var enclosureChecks *enclosureVerifier
+
+// newEnclosureVerifier starts workers checking enclosures, spacing out the
+// requests to each host by delay.
+func newEnclosureVerifier(ctx context.Context, workers int, delay time.Duration) *enclosureVerifier {
+	v := &enclosureVerifier{
+		ctx:    ctx,
+		limits: newHostLimiter(),
+		queue:  make(chan enclosureCheck, enclosureCheckQueueSize),
+	}
+	v.limits.delay = delay
+	for i := 0; i < workers; i++ {
+		v.wg.Add(1)
+		go v.run()
+	}
+	return v
+}
+
+// Check queues the enclosures of episodes, which must be stored already.
+// If the queue is full they are left unchecked.
+func (v *enclosureVerifier) Check(store Store, episodes []Episode) {
+	if v == nil {
+		return
+	}
+	for _, e := range episodes {
+		select {
+		case v.queue <- enclosureCheck{store: store, episode: e}:
+		default:
+			v.mu.Lock()
+			if !v.droppedWarning {
+				warnf(v.ctx, "enclosure check queue full, leaving enclosures unchecked")
+				v.droppedWarning = true
+			}
+			v.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Close waits for the queued checks and logs how they went.
+func (v *enclosureVerifier) Close() {
+	if v == nil {
+		return
+	}
+	close(v.queue)
+	v.wg.Wait()
+	infof(v.ctx, "Verified %d enclosures, %d of them dead", v.checked, v.dead)
+}
+
+func (v *enclosureVerifier) run() {
+	defer v.wg.Done()
+	for c := range v.queue {
+		if v.ctx.Err() != nil {
+			continue
+		}
+		e := c.episode
+		enclosure := verifyEnclosure(v.ctx, e.Enclosure, v.limits)
+		if enclosure.Dead {
+			debugf(v.ctx, "Enclosure %s of episode %s of %s is dead, status %d", e.Enclosure.Url, e.Guid, e.PodcastUrl, enclosure.Status)
+		}
+		ctx, cancel := context.WithTimeout(v.ctx, config.DBTimeout)
+		err := c.store.UpdateEpisode(ctx, e.ID, bson.M{"enclosure": enclosure})
+		cancel()
+		if err != nil {
+			errorf(ctx, "Error storing enclosure check of episode %s of %s: %v", e.Guid, e.PodcastUrl, err)
+			continue
+		}
+		v.mu.Lock()
+		v.checked++
+		if enclosure.Dead {
+			v.dead++
+		}
+		v.mu.Unlock()
+	}
+}
+
+// verifyEnclosure returns enclosure with the outcome of headCheck on its
+// URL, spacing out the checks on each host by limits. A missing length is
+// taken from the Content-Length.
+func verifyEnclosure(ctx context.Context, enclosure EpisodeEnclosure, limits *hostLimiter) EpisodeEnclosure {
+	status, length := 0, int64(-1)
+	if err := limits.Wait(ctx, hostOf(enclosure.Url)); err == nil {
+		var err error
+		if status, length, err = headCheck(ctx, enclosure.Url); err != nil {
+			debugf(ctx, "Error checking enclosure %s: %v", enclosure.Url, err)
+		}
+	}
+	enclosure.Status = status
+	enclosure.CheckedAt = time.Now()
+	enclosure.Dead = status < http.StatusOK || status >= http.StatusBadRequest
+	if enclosure.Size == 0 && length > 0 && !enclosure.Dead {
+		enclosure.Size = length
+		enclosure.Filesize = strconv.FormatInt(length, 10)
+	}
+	return enclosure
+}
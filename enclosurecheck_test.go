@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mediaServer serves enclosures: /ok.mp3 is there, /head-not-allowed.mp3
+// only answers GET, everything else is missing. It counts the requests by
+// method. Connections aren't reused, so no dial of the transport outlives
+// the request it was for and reads the config a test restores.
+type mediaServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newMediaServer(t *testing.T) *mediaServer {
+	s := &mediaServer{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.Method]++
+		s.mu.Unlock()
+		w.Header().Set("Connection", "close")
+		switch {
+		case r.URL.Path == "/head-not-allowed.mp3" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.URL.Path == "/ok.mp3" || r.URL.Path == "/head-not-allowed.mp3":
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Header().Set("Content-Length", "4242")
+			w.Write([]byte(strings.Repeat("x", 4242)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// count returns how many requests with method were made.
+func (s *mediaServer) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+func TestVerifyEnclosure(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = true
+	server := newMediaServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		in       EpisodeEnclosure
+		status   int
+		dead     bool
+		wantSize int64
+	}{
+		{"ok", EpisodeEnclosure{Url: server.URL + "/ok.mp3"}, http.StatusOK, false, 4242},
+		{"ok with length", EpisodeEnclosure{Url: server.URL + "/ok.mp3", Size: 1000}, http.StatusOK, false, 1000},
+		{"missing", EpisodeEnclosure{Url: server.URL + "/missing.mp3"}, http.StatusNotFound, true, 0},
+		{"no HEAD", EpisodeEnclosure{Url: server.URL + "/head-not-allowed.mp3"}, http.StatusOK, false, 4242},
+	}
+	for _, tt := range tests {
+		got := verifyEnclosure(ctx, tt.in, newHostLimiter())
+		if got.Status != tt.status || got.Dead != tt.dead || got.Size != tt.wantSize {
+			t.Errorf("%s: status %d, dead %v, size %d, want %d %v %d", tt.name, got.Status, got.Dead, got.Size, tt.status, tt.dead, tt.wantSize)
+		}
+		if got.CheckedAt.IsZero() {
+			t.Errorf("%s: check time not set", tt.name)
+		}
+	}
+	if n := server.count(http.MethodGet); n != 1 {
+		t.Errorf("%d GET requests, want 1 for the server refusing HEAD", n)
+	}
+}
+
+func TestEnclosureVerifier(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = true
+	server := newMediaServer(t)
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := testPodcast(t, store, "tech-talk")
+	alive := testEpisode(podcast, "ep-1", feedEpoch)
+	alive.Enclosure.Url = server.URL + "/ok.mp3"
+	dead := testEpisode(podcast, "ep-2", feedEpoch)
+	dead.Enclosure.Url = server.URL + "/missing.mp3"
+	episodes := []Episode{alive, dead}
+	if _, err := store.InsertEpisodes(ctx, episodes); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newEnclosureVerifier(ctx, 2, 0)
+	v.Check(store, episodes)
+	v.Close()
+	if v.checked != 2 || v.dead != 1 {
+		t.Errorf("checked %d, %d dead, want 2 and 1", v.checked, v.dead)
+	}
+	stored, err := store.EpisodesByGUID(ctx, podcast.PodlistUrl, []string{"ep-1", "ep-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range stored {
+		wantDead := e.Guid == "ep-2"
+		if e.Enclosure.Dead != wantDead || e.Enclosure.CheckedAt.IsZero() {
+			t.Errorf("episode %s enclosure %+v, want dead %v", e.Guid, e.Enclosure, wantDead)
+		}
+	}
+}
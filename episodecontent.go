@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Publishers fix titles, show notes and durations of episodes they have
+// published already. The text of an item is hashed into ContentHash when
+// its episode is stored, so a known item only has to be compared field by
+// field once the hash differs. Enclosures are refreshed on their own, see
+// refreshEnclosures.
+
+// episodeContent is the text of an item that is copied to its episode.
+type episodeContent struct {
+	Title       string
+	Description string
+	Summary     string
+	Subtitle    string
+	Content     string
+	Duration    string
+	Link        string
+}
+
+func itemContent(item *gofeed.Item) episodeContent {
+	c := episodeContent{
+		Title:       item.Title,
+		Description: item.Description,
+		Content:     item.Content,
+		Link:        episodeLink(item),
+	}
+	if item.ITunesExt != nil {
+		c.Duration = item.ITunesExt.Duration
+		c.Summary = item.ITunesExt.Summary
+		c.Subtitle = item.ITunesExt.Subtitle
+	}
+	return c
+}
+
+func (c episodeContent) hash() string {
+	return stableHash("content", c.Title, c.Description, c.Summary, c.Subtitle, c.Content, c.Duration, c.Link)
+}
+
+// changedContent returns the fields of e that differ from c. A title that
+// disappeared from the feed is kept.
+func changedContent(e Episode, c episodeContent) bson.M {
+	set := bson.M{}
+	update := func(key, old, current string) {
+		if old != current {
+			set[key] = current
+		}
+	}
+	if strings.TrimSpace(c.Title) != "" {
+		update("title", e.Title, c.Title)
+	}
+	update("description", e.Description, c.Description)
+	update("summary", e.Summary, c.Summary)
+	update("subtitle", e.Subtitle, c.Subtitle)
+	update("content", e.Content, c.Content)
+	update("Duration", e.Duration, c.Duration)
+	update("link", e.EpisodeLink, c.Link)
+
+	if e.Description != c.Description || e.Content != c.Content {
+		notes := c.Content
+		if strings.TrimSpace(notes) == "" {
+			notes = c.Description
+		}
+		words, readingSeconds := showNotesStats(notes)
+		if words != e.WordCount || readingSeconds != e.ReadingTimeSeconds {
+			set["wordCount"] = words
+			set["readingTimeSeconds"] = readingSeconds
+		}
+	}
+	return set
+}
+
+// refreshContent updates known episodes whose text changed in the feed,
+// in bulk. Episodes stored before ContentHash existed get it on the way.
+func refreshContent(ctx context.Context, store Store, podcast Podcast, episodes []Episode, items []*gofeed.Item) error {
+	current := make(map[string]episodeContent, len(items))
+	for _, item := range items {
+		// Without a GUID nothing ties an item to a stored episode.
+		if guid := normalizeGUID(item.GUID); guid != "" {
+			current[guid] = itemContent(item)
+		}
+	}
+
+	type change struct {
+		episode Episode
+		set     bson.M
+	}
+	var updates []EpisodeUpdate
+	var changed []change
+	for _, e := range episodes {
+		c, ok := current[normalizeGUID(e.Guid)]
+		if !ok {
+			continue
+		}
+		hash := c.hash()
+		if e.ContentHash == hash {
+			continue
+		}
+		set := changedContent(e, c)
+		if len(set) > 0 {
+			changed = append(changed, change{e, set})
+		}
+		update := bson.M{"contentHash": hash}
+		for k, v := range set {
+			update[k] = v
+		}
+		updates = append(updates, EpisodeUpdate{ID: e.ID, Set: update})
+	}
+
+	for start := 0; start < len(updates); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(updates) {
+			end = len(updates)
+		}
+		if err := store.UpdateEpisodes(ctx, updates[start:end]); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range changed {
+		debugf(ctx, "Text of episode %s of podcast %s changed", c.episode.Guid, podcast.Title)
+		changes.episodeUpdated(c.episode, c.set)
+		indexUpdatedEpisode(c.episode, c.set)
+	}
+	if len(changed) > 0 {
+		infof(ctx, "Updated %d known episodes of podcast %s whose text changed in the feed", len(changed), podcast.Title)
+	}
+	return nil
+}
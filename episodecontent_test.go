@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+func TestChangedContent(t *testing.T) {
+	item := &gofeed.Item{
+		Title:       "Episode 2: Compilers",
+		Description: "How compilers work.",
+		ITunesExt:   &ext.ITunesItemExtension{Duration: "45:00"},
+	}
+	c := itemContent(item)
+	e := Episode{Title: c.Title, Description: c.Description, Duration: c.Duration}
+	e.WordCount, e.ReadingTimeSeconds = showNotesStats(c.Description)
+	if set := changedContent(e, c); len(set) != 0 {
+		t.Errorf("unchanged item updates %v", set)
+	}
+
+	item.Title = " "
+	item.Description = "How compilers and linkers work."
+	item.ITunesExt.Duration = "47:30"
+	set := changedContent(e, itemContent(item))
+	if _, ok := set["title"]; ok {
+		t.Error("a title that disappeared from the feed is overwritten")
+	}
+	if set["description"] != item.Description || set["Duration"] != "47:30" {
+		t.Errorf("got update %v", set)
+	}
+	if set["wordCount"] != 5 {
+		t.Errorf("word count %v, want 5", set["wordCount"])
+	}
+
+	if itemContent(item).hash() == c.hash() {
+		t.Error("changed item hashes like the old one")
+	}
+}
+
+func TestRefreshContentSkipsItemsWithoutGUID(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := testPodcast(t, store, "tech-talk")
+	e := testEpisode(podcast, "", feedEpoch)
+	if _, err := store.InsertEpisodes(ctx, []Episode{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing says the item is the stored episode.
+	item := &gofeed.Item{Title: "Another episode", Description: "Other show notes."}
+	if err := refreshContent(ctx, store, podcast, []Episode{e}, []*gofeed.Item{item}); err != nil {
+		t.Fatal(err)
+	}
+	episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 1 || episodes[0].Title != e.Title {
+		t.Errorf("episode without a GUID got the text of another item: %+v", episodes)
+	}
+}
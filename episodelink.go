@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// guidPermalinkKey is the key of item.Custom rssTranslator keeps the
+// isPermaLink attribute of the item GUID under.
+const guidPermalinkKey = "podgo:isPermaLink"
+
+// episodeLink returns the web page of the episode of item: its link, or
+// else its GUID if that is a permalink. RSS makes GUIDs permalinks unless
+// isPermaLink="false", but plenty of feeds leave the attribute out of
+// GUIDs that are no URLs, so the GUID must be one as well. gofeed only
+// reads the attribute when spelled isPermalink, so for most feeds whether
+// the GUID is a permalink comes down to whether it is a URL.
+func episodeLink(item *gofeed.Item) string {
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return link
+	}
+	guid := strings.TrimSpace(item.GUID)
+	if !strings.EqualFold(item.Custom[guidPermalinkKey], "false") && isHTTPURL(guid) {
+		return guid
+	}
+	return ""
+}
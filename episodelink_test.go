@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const permalinkFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Tech Talk</title>
+    <item>
+      <title>Linked</title>
+      <link>https://techtalk.example.com/episodes/4</link>
+      <guid isPermaLink="true">https://techtalk.example.com/?p=4</guid>
+    </item>
+    <item>
+      <title>Permalink</title>
+      <guid isPermaLink="true"> https://techtalk.example.com/episodes/3 </guid>
+    </item>
+    <item>
+      <title>Permalink by default</title>
+      <guid>https://techtalk.example.com/episodes/2</guid>
+    </item>
+    <item>
+      <title>Not a permalink</title>
+      <guid isPermalink="false">https://techtalk.example.com/episodes/1</guid>
+    </item>
+    <item>
+      <title>Not a permalink as spelled by RSS</title>
+      <guid isPermaLink="false">https://techtalk.example.com/episodes/1b</guid>
+    </item>
+    <item>
+      <title>Not a URL</title>
+      <guid>techtalk-0</guid>
+    </item>
+  </channel>
+</rss>`
+
+func TestEpisodeLink(t *testing.T) {
+	fp := gofeed.NewParser()
+	fp.RSSTranslator = &rssTranslator{}
+	feed, err := fp.ParseString(permalinkFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Linked":               "https://techtalk.example.com/episodes/4",
+		"Permalink":            "https://techtalk.example.com/episodes/3",
+		"Permalink by default": "https://techtalk.example.com/episodes/2",
+		"Not a permalink":      "",
+		// gofeed doesn't see the attribute spelled this way, see
+		// episodeLink.
+		"Not a permalink as spelled by RSS": "https://techtalk.example.com/episodes/1b",
+		"Not a URL":                         "",
+	}
+	podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk"}
+	for _, item := range feed.Items {
+		if got := episodeLink(item); got != want[item.Title] {
+			t.Errorf("%s: link %q, want %q", item.Title, got, want[item.Title])
+		}
+		if got := createEpisode(item, podcast).EpisodeLink; got != want[item.Title] {
+			t.Errorf("%s: episode link %q, want %q", item.Title, got, want[item.Title])
+		}
+	}
+}
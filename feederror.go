@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// FeedErrorKind tells why a feed couldn't be loaded.
+type FeedErrorKind int
+
+const (
+	// FeedNetwork is a failure to talk to the server, other than a timeout.
+	FeedNetwork FeedErrorKind = iota
+	// FeedTimeout is a fetch that ran out of time.
+	FeedTimeout
+	// FeedHTTPStatus is a response with a non-2xx status.
+	FeedHTTPStatus
+	// FeedParse is a response that looked like a feed but didn't parse.
+	FeedParse
+	// FeedNotAFeed is a response that isn't RSS, Atom or JSON Feed at all,
+	// typically an HTML page.
+	FeedNotAFeed
+	// FeedTooLarge is a feed larger than --max-feed-size.
+	FeedTooLarge
+	// FeedRejected is a feed we may not fetch, see checkFeedURL.
+	FeedRejected
+)
+
+var feedErrorKinds = [...]string{
+	FeedNetwork:    "network",
+	FeedTimeout:    "timeout",
+	FeedHTTPStatus: "http-status",
+	FeedParse:      "parse",
+	FeedNotAFeed:   "not-a-feed",
+	FeedTooLarge:   "too-large",
+	FeedRejected:   "rejected",
+}
+
+func (k FeedErrorKind) String() string {
+	if int(k) < len(feedErrorKinds) {
+		return feedErrorKinds[k]
+	}
+	return fmt.Sprintf("FeedErrorKind(%d)", int(k))
+}
+
+// FeedError is the error LoadFeed fails with.
+type FeedError struct {
+	URL  string
+	Kind FeedErrorKind
+	// StatusCode is the HTTP status of a FeedHTTPStatus error.
+	StatusCode int
+	// RetryAfter is how long a throttling server asked us to wait, see
+	// parseRetryAfter.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *FeedError) Error() string {
+	if e.Kind == FeedRejected {
+		return fmt.Sprintf("feed rejected: %v", e.Err)
+	}
+	return fmt.Sprintf("feed error: %v", e.Err)
+}
+
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
+
+// newFeedError returns err, which came up loading the feed at url with ctx,
+// as a FeedError of kind, unless it is recognizably of another kind.
+func newFeedError(ctx context.Context, url string, kind FeedErrorKind, err error) *FeedError {
+	fe := &FeedError{URL: url, Kind: kind, Err: err}
+	var httpErr gofeed.HTTPError
+	var rejected *rejectedRedirect
+	switch {
+	case errors.As(err, &rejected):
+		fe.Kind = FeedRejected
+	case errors.As(err, &httpErr):
+		fe.Kind = FeedHTTPStatus
+		fe.StatusCode = httpErr.StatusCode
+	case errors.Is(err, errFeedTooLarge):
+		fe.Kind = FeedTooLarge
+	case ctx.Err() == context.DeadlineExceeded || isTimeout(err):
+		fe.Kind = FeedTimeout
+	case errors.Is(err, gofeed.ErrFeedTypeNotDetected):
+		fe.Kind = FeedNotAFeed
+	}
+	return fe
+}
+
+// isTransient reports whether err is a failure to load a feed that may
+// well go away if we try again: a timeout, a network error, a server error
+// or throttling. How long a throttling server asked us to wait is in the
+// RetryAfter of the FeedError.
+func isTransient(err error) bool {
+	var fe *FeedError
+	if !errors.As(err, &fe) {
+		return false
+	}
+	switch fe.Kind {
+	case FeedNetwork, FeedTimeout:
+		return true
+	case FeedHTTPStatus:
+		return fe.StatusCode == http.StatusTooManyRequests || (fe.StatusCode >= 500 && fe.StatusCode != http.StatusNotImplemented)
+	}
+	return false
+}
+
+// feedErrorKind returns the kind of err if it is a FeedError.
+func feedErrorKind(err error) (FeedErrorKind, bool) {
+	var fe *FeedError
+	if !errors.As(err, &fe) {
+		return 0, false
+	}
+	return fe.Kind, true
+}
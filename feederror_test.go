@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+func TestProcessFeedURLTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	in := newIngester(t, newMemoryStore())
+	config.FeedTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	result := processFeedURL(context.Background(), slow.URL+"/feed.xml", in.store, map[string]bool{}, map[string]bool{})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fetch took %s with a timeout of %s", elapsed, config.FeedTimeout)
+	}
+	if kind, ok := feedErrorKind(result.Err); !ok || kind != FeedTimeout {
+		t.Errorf("got error %v of kind %v, want a timeout", result.Err, kind)
+	}
+	if !result.Timeout {
+		t.Error("result isn't marked as timed out")
+	}
+	if !isTransient(result.Err) {
+		t.Error("timeout isn't transient")
+	}
+}
+
+func TestProcessFeedURLFailureIsNoTimeout(t *testing.T) {
+	in := newIngester(t, newMemoryStore())
+	server := newFeedServer(t)
+	result := processFeedURL(context.Background(), server.URL+"/missing.xml", in.store, map[string]bool{}, map[string]bool{})
+	if result.Err == nil {
+		t.Fatal("fetching a missing feed didn't fail")
+	}
+	if result.Timeout {
+		t.Errorf("result with error %v is marked as timed out", result.Err)
+	}
+}
+
+func TestNewFeedErrorKind(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want FeedErrorKind
+	}{
+		{"network", context.Background(), errors.New("connection refused"), FeedNetwork},
+		{"deadline", expired, errors.New("reading body: context deadline exceeded"), FeedTimeout},
+		{"too large", context.Background(), errFeedTooLarge, FeedTooLarge},
+	}
+	for _, tt := range tests {
+		if got := newFeedError(tt.ctx, "https://a.example/feed", FeedNetwork, tt.err).Kind; got != tt.want {
+			t.Errorf("%s: kind %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFeedErrors(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = true
+	config.MaxFeedSize = 1 << 10
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/broken.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Tech Talk</title><item><title>Ep`))
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<!DOCTYPE html><html><head><title>Tech Talk</title></head><body>Subscribe!</body></html>`))
+	})
+	mux.HandleFunc("/huge.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>` + strings.Repeat("Tech Talk ", 1000) + `</title></channel></rss>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	closed := httptest.NewServer(mux)
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		kind   FeedErrorKind
+		status int
+	}{
+		{"network", closed.URL + "/feed.xml", FeedNetwork, 0},
+		{"http status", server.URL + "/missing.xml", FeedHTTPStatus, http.StatusNotFound},
+		{"parse", server.URL + "/broken.xml", FeedParse, 0},
+		{"not a feed", server.URL + "/page.html", FeedNotAFeed, 0},
+		{"too large", server.URL + "/huge.xml", FeedTooLarge, 0},
+		{"rejected", "file:///etc/passwd", FeedRejected, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := LoadFeed(context.Background(), tt.url)
+			var fe *FeedError
+			if !errors.As(err, &fe) {
+				t.Fatalf("got error %v, want a FeedError", err)
+			}
+			if fe.Kind != tt.kind || fe.StatusCode != tt.status {
+				t.Errorf("got kind %v status %d, want %v %d", fe.Kind, fe.StatusCode, tt.kind, tt.status)
+			}
+			if fe.URL != tt.url {
+				t.Errorf("error for %s, want %s", fe.URL, tt.url)
+			}
+			if errors.Unwrap(err) == nil {
+				t.Error("error doesn't wrap its cause")
+			}
+		})
+	}
+
+	_, _, err := LoadFeed(context.Background(), server.URL+"/missing.xml")
+	var httpErr gofeed.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("HTTP status error %v doesn't unwrap to the status", err)
+	}
+}
+
+func TestFeedErrorKindString(t *testing.T) {
+	for kind, want := range map[FeedErrorKind]string{
+		FeedNetwork:       "network",
+		FeedTimeout:       "timeout",
+		FeedHTTPStatus:    "http-status",
+		FeedParse:         "parse",
+		FeedNotAFeed:      "not-a-feed",
+		FeedTooLarge:      "too-large",
+		FeedRejected:      "rejected",
+		FeedErrorKind(42): "FeedErrorKind(42)",
+	} {
+		if got := kind.String(); got != want {
+			t.Errorf("kind %d is %q, want %q", int(kind), got, want)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", &FeedError{Kind: FeedNetwork}, true},
+		{"timeout", &FeedError{Kind: FeedTimeout}, true},
+		{"server error", &FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusBadGateway}, true},
+		{"not implemented", &FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusNotImplemented}, false},
+		{"unavailable", &FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Minute}, true},
+		{"throttled", &FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusTooManyRequests}, true},
+		{"not found", &FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusNotFound}, false},
+		{"parse", &FeedError{Kind: FeedParse}, false},
+		{"other", errors.New("disk full"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: transient %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFeedRetrying(t *testing.T) {
+	in := newIngester(t, newMemoryStore())
+	config.Retries = 2
+	config.RetryDelay = time.Millisecond
+	server := newFeedServer(t)
+	failing := server.fail("/broken.xml", http.StatusInternalServerError)
+	missing := server.fail("/missing.xml", http.StatusNotFound)
+
+	for _, url := range []string{failing, missing} {
+		if result := processFeedURL(context.Background(), url, in.store, map[string]bool{}, map[string]bool{}); result.Err == nil {
+			t.Errorf("%s: fetch didn't fail", url)
+		}
+	}
+	if got := server.fetches("/broken.xml"); got != 3 {
+		t.Errorf("failing feed fetched %d times, want 3", got)
+	}
+	if got := server.fetches("/missing.xml"); got != 1 {
+		t.Errorf("missing feed fetched %d times, want 1", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.RetryDelay = time.Second
+	config.MaxBackoff = 3 * time.Second
+	for attempt, full := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 8: 3 * time.Second} {
+		if got := retryDelay(attempt); got < full/2 || got > full {
+			t.Errorf("retry %d waits %s, want between %s and %s", attempt, got, full/2, full)
+		}
+	}
+}
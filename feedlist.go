@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// storeFeeds is the --feeds value that takes the feed list from the store,
+// where add, remove, enable and disable change it and import-feeds fills
+// it from a file.
+const storeFeeds = "store"
+
+// legacyFeedsFile is the JSON feed list podgo crawled before the list moved
+// to the store. It is imported once if the list in the store is empty.
+const legacyFeedsFile = "bak/feedbak.json"
+
+// ListedFeed is an entry of the feed list kept in the store. Disabled feeds
+// stay listed but aren't crawled.
+type ListedFeed struct {
+	URL                string    `bson:"url"`
+	Namespace          string    `bson:"namespace,omitempty"`
+	Tags               []string  `bson:"tags,omitempty"`
+	OverrideCategories []string  `bson:"overrideCategories,omitempty"`
+	Enabled            bool      `bson:"enabled"`
+	AddedAt            time.Time `bson:"addedAt"`
+}
+
+func (f ListedFeed) entry() feedEntry {
+	return feedEntry{URL: f.URL, Namespace: f.Namespace, Tags: f.Tags, OverrideCategories: f.OverrideCategories}
+}
+
+// listedFeed returns feed list entry e as an enabled ListedFeed added at.
+func listedFeed(e feedEntry, at time.Time) ListedFeed {
+	return ListedFeed{URL: e.URL, Namespace: e.Namespace, Tags: e.Tags, OverrideCategories: e.OverrideCategories, Enabled: true, AddedAt: at}
+}
+
+// loadListedFeeds returns the enabled feeds of the feed list in store,
+// which is filled from legacyFeedsFile first if it is empty.
+func loadListedFeeds(ctx context.Context, store Store) []feedEntry {
+	listed, err := store.ListedFeeds(ctx)
+	if err != nil {
+		fatalf(ctx, "Failed to load feeds from the store: %v", err)
+	}
+	if _, err := os.Stat(legacyFeedsFile); len(listed) == 0 && err == nil {
+		infof(ctx, "No feeds in the store yet, importing %s; from now on the feed list is kept in the store", legacyFeedsFile)
+		if err := importFeeds(ctx, store, legacyFeedsFile); err != nil {
+			fatalf(ctx, "Failed to import %s: %v", legacyFeedsFile, err)
+		}
+		if listed, err = store.ListedFeeds(ctx); err != nil {
+			fatalf(ctx, "Failed to load feeds from the store: %v", err)
+		}
+	}
+	var feeds []feedEntry
+	for _, f := range listed {
+		if f.Enabled {
+			feeds = append(feeds, f.entry())
+		}
+	}
+	if len(listed) == 0 {
+		warnf(ctx, "no feeds in the store yet, add them with add or import-feeds <file>, or crawl a file with --feeds <file>")
+	}
+	return feeds
+}
+
+// addFeed is the add command: it appends feedURL to the feed list, in the
+// namespace given by --namespace. The list is in store for storeFeeds,
+// otherwise in the JSON file feedsFile.
+func addFeed(ctx context.Context, store Store, feedsFile, feedURL string) error {
+	if !isHTTPURL(feedURL) {
+		return fmt.Errorf("%q is not a feed URL", feedURL)
+	}
+	entry := feedEntry{URL: feedURL, Namespace: storedNamespace(config.Namespace)}
+	if feedsFile == storeFeeds {
+		added, err := store.InsertListedFeed(ctx, listedFeed(entry, time.Now()))
+		if err != nil {
+			return fmt.Errorf("error adding feed: %v", err)
+		}
+		if !added {
+			infof(ctx, "%s is already listed", redactURL(feedURL))
+			return nil
+		}
+		infof(ctx, "Added %s", redactURL(feedURL))
+		return nil
+	}
+
+	feeds := loadFeedsFromJSON(feedsFile)
+	for _, f := range feeds {
+		if f.URL == feedURL {
+			infof(ctx, "%s is already in %s", redactURL(feedURL), feedsFile)
+			return nil
+		}
+	}
+	feeds = append(feeds, entry)
+	if err := writeFeedList(feedsFile, feeds); err != nil {
+		return err
+	}
+	infof(ctx, "Added %s to %s", redactURL(feedURL), feedsFile)
+	return nil
+}
+
+// feedOf returns the feed of the podcast with slug arg, or arg itself if
+// it is a feed URL.
+func feedOf(ctx context.Context, store Store, arg string) (string, error) {
+	if isHTTPURL(arg) {
+		return arg, nil
+	}
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	for _, p := range podcasts {
+		if p.PodlistUrl == arg {
+			return p.Feed, nil
+		}
+	}
+	return "", fmt.Errorf("no podcast %q", arg)
+}
+
+// removeFeed is the remove command: it takes the feed of the podcast with
+// slug arg, or the feed URL arg, off the feed list. The podcast and its
+// episodes stay in store.
+func removeFeed(ctx context.Context, store Store, feedsFile, arg string) error {
+	feedURL, err := feedOf(ctx, store, arg)
+	if err != nil {
+		return err
+	}
+	if feedsFile == storeFeeds {
+		err := store.DeleteListedFeed(ctx, feedURL, storedNamespace(config.Namespace))
+		if err == errNotFound {
+			return fmt.Errorf("%s is not listed", redactURL(feedURL))
+		}
+		if err != nil {
+			return fmt.Errorf("error removing feed: %v", err)
+		}
+		infof(ctx, "Removed %s", redactURL(feedURL))
+		return nil
+	}
+
+	feeds := loadFeedsFromJSON(feedsFile)
+	kept := feeds[:0]
+	for _, f := range feeds {
+		if f.URL == feedURL && (f.Namespace == "" || f.Namespace == config.Namespace) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if len(kept) == len(feeds) {
+		return fmt.Errorf("%s is not in %s", redactURL(feedURL), feedsFile)
+	}
+	if err := writeFeedList(feedsFile, kept); err != nil {
+		return err
+	}
+	infof(ctx, "Removed %s from %s", redactURL(feedURL), feedsFile)
+	return nil
+}
+
+// enableFeed is the enable and disable command: it sets whether the feed
+// of the podcast with slug arg, or the feed URL arg, is crawled.
+func enableFeed(ctx context.Context, store Store, arg string, enabled bool) error {
+	feedURL, err := feedOf(ctx, store, arg)
+	if err != nil {
+		return err
+	}
+	err = store.UpdateListedFeed(ctx, feedURL, storedNamespace(config.Namespace), bson.M{"enabled": enabled})
+	if err == errNotFound {
+		return fmt.Errorf("%s is not listed", redactURL(feedURL))
+	}
+	if err != nil {
+		return fmt.Errorf("error updating feed: %v", err)
+	}
+	if enabled {
+		infof(ctx, "Enabled %s", redactURL(feedURL))
+	} else {
+		infof(ctx, "Disabled %s", redactURL(feedURL))
+	}
+	return nil
+}
+
+// importFeeds is the import-feeds command: it adds the feeds of the JSON or
+// OPML feed list in filename to the feed list in store. Feeds listed
+// already are left as they are.
+func importFeeds(ctx context.Context, store Store, filename string) error {
+	feeds := loadFeeds(ctx, store, filename)
+	now := time.Now()
+	added := 0
+	for _, f := range feeds {
+		ok, err := store.InsertListedFeed(ctx, listedFeed(f, now))
+		if err != nil {
+			return fmt.Errorf("error adding feed %s: %v", redactURL(f.URL), err)
+		}
+		if ok {
+			added++
+		}
+	}
+	infof(ctx, "Imported %d feeds, %d were listed already", added, len(feeds)-added)
+	return nil
+}
+
+// listFeeds is the list command: it prints the feeds of the list and the
+// podcasts stored for them, if any.
+func listFeeds(ctx context.Context, store Store, feedsFile string) error {
+	var listed []ListedFeed
+	if feedsFile == storeFeeds {
+		var err error
+		if listed, err = store.ListedFeeds(ctx); err != nil {
+			return fmt.Errorf("error fetching feeds: %v", err)
+		}
+	} else {
+		for _, f := range loadFeeds(ctx, store, feedsFile) {
+			listed = append(listed, listedFeed(f, time.Time{}))
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "FEED\tNAMESPACE\tPODCAST\tSTATUS\tLAST CRAWLED")
+	podcasts := make(map[string]map[string]Podcast)
+	for _, f := range listed {
+		ns := f.Namespace
+		if ns == "" {
+			ns = config.Namespace
+		}
+		if podcasts[ns] == nil {
+			stored, err := store.InNamespace(ns).Podcasts(ctx)
+			if err != nil {
+				return fmt.Errorf("error fetching podcasts: %v", err)
+			}
+			podcasts[ns] = make(map[string]Podcast, len(stored))
+			for _, p := range stored {
+				podcasts[ns][p.Feed] = p
+			}
+		}
+		p, ok := podcasts[ns][f.URL]
+		status := "not crawled yet"
+		if ok {
+			status = podcastStatus(p)
+		}
+		if !f.Enabled {
+			status = "disabled"
+		}
+		slug := "-"
+		if ok {
+			slug = p.PodlistUrl
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", redactURL(f.URL), ns, slug, status, formatTime(p.LastCrawledAt))
+	}
+	return nil
+}
+
+// podcastState returns whether the podcast is ok, failing, dead or retired.
+func podcastState(p Podcast) string {
+	switch {
+	case !p.RetiredAt.IsZero():
+		return "retired"
+	case !p.DeadAt.IsZero():
+		return "dead"
+	case p.FailureCount > 0:
+		return "failing"
+	}
+	return "ok"
+}
+
+// podcastStatus is podcastState with the number of failures in a row.
+func podcastStatus(p Podcast) string {
+	if s := podcastState(p); s != "failing" {
+		return s
+	}
+	return fmt.Sprintf("failing (%d)", p.FailureCount)
+}
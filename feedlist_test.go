@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddAndRemoveFeeds(t *testing.T) {
+	ctx := context.Background()
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	in.crawl(feedURL)
+	feedsFile := filepath.Join(t.TempDir(), "feeds.json")
+	if err := os.WriteFile(feedsFile, []byte(`[{"url": "https://b.example/feed"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addFeed(ctx, store, feedsFile, "not a url"); err == nil {
+		t.Error("added something that isn't a feed URL")
+	}
+	for i := 0; i < 2; i++ {
+		if err := addFeed(ctx, store, feedsFile, feedURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if feeds := loadFeedsFromJSON(feedsFile); len(feeds) != 2 || feeds[1].URL != feedURL {
+		t.Fatalf("feed list after adding twice: %+v", feeds)
+	}
+
+	// A podcast is removed by its slug, a feed without one by its URL.
+	if err := removeFeed(ctx, store, feedsFile, "tech-talk"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeFeed(ctx, store, feedsFile, "https://b.example/feed"); err != nil {
+		t.Fatal(err)
+	}
+	if feeds := loadFeedsFromJSON(feedsFile); len(feeds) != 0 {
+		t.Errorf("feed list after removing both: %+v", feeds)
+	}
+	if err := removeFeed(ctx, store, feedsFile, "tech-talk"); err == nil {
+		t.Error("removing a feed that isn't listed succeeded")
+	}
+	if _, err := store.PodcastByFeed(ctx, feedURL); err != nil {
+		t.Errorf("podcast of the removed feed is gone: %v", err)
+	}
+}
+
+func TestPodcastStatus(t *testing.T) {
+	tests := []struct {
+		podcast Podcast
+		want    string
+	}{
+		{Podcast{}, "ok"},
+		{Podcast{FailureCount: 3}, "failing (3)"},
+		{Podcast{FailureCount: 10, DeadAt: feedEpoch}, "dead"},
+		{Podcast{RetiredAt: feedEpoch}, "retired"},
+	}
+	for _, tt := range tests {
+		if got := podcastStatus(tt.podcast); got != tt.want {
+			t.Errorf("status %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestFeedListInStore(t *testing.T) {
+	ctx := context.Background()
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	in.crawl(feedURL)
+	feedsFile := filepath.Join(t.TempDir(), "feeds.json")
+	if err := os.WriteFile(feedsFile, []byte(`[{"url": "https://b.example/feed", "tags": ["tech"]}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := importFeeds(ctx, store, feedsFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := addFeed(ctx, store, storeFeeds, feedURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := importFeeds(ctx, store, feedsFile); err != nil {
+		t.Fatal(err)
+	}
+	feeds := loadListedFeeds(ctx, store)
+	if len(feeds) != 2 || feeds[0].Tags[0] != "tech" || feeds[1].URL != feedURL {
+		t.Fatalf("listed %+v, want the imported and the added feed", feeds)
+	}
+
+	if err := enableFeed(ctx, store, "tech-talk", false); err != nil {
+		t.Fatal(err)
+	}
+	if feeds := loadListedFeeds(ctx, store); len(feeds) != 1 {
+		t.Errorf("disabled feed is still crawled: %+v", feeds)
+	}
+	if err := enableFeed(ctx, store, "tech-talk", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeFeed(ctx, store, storeFeeds, "https://b.example/feed"); err != nil {
+		t.Fatal(err)
+	}
+	if feeds := loadListedFeeds(ctx, store); len(feeds) != 1 || feeds[0].URL != feedURL {
+		t.Errorf("listed %+v after enabling and removing", feeds)
+	}
+	if err := enableFeed(ctx, store, "https://b.example/feed", true); err == nil {
+		t.Error("enabled a feed that isn't listed")
+	}
+}
+
+func TestLegacyFeedsFileImportedOnce(t *testing.T) {
+	ctx := context.Background()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Mkdir("bak", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacyFeedsFile, []byte(`[{"url": "https://a.example/feed"}, {"url": "https://b.example/feed"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryStore()
+
+	if feeds := loadListedFeeds(ctx, store); len(feeds) != 2 {
+		t.Fatalf("listed %+v, want the two feeds of %s", feeds, legacyFeedsFile)
+	}
+	// Once the store has a list, the file is left alone.
+	if err := removeFeed(ctx, store, storeFeeds, "https://a.example/feed"); err != nil {
+		t.Fatal(err)
+	}
+	if feeds := loadListedFeeds(ctx, store); len(feeds) != 1 || feeds[0].URL != "https://b.example/feed" {
+		t.Errorf("listed %+v after removing a feed", feeds)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FeedMeta are the validators a feed answered its last fetch with, sent
+// back with the next fetch so an unchanged feed needn't be downloaded
+// again.
+type FeedMeta struct {
+	Feed         string `bson:"_id"`
+	ETag         string `bson:"etag,omitempty"`
+	LastModified string `bson:"lastModified,omitempty"`
+	// Hash is the hash of the feed body the validators belong to, see
+	// Podcast.FeedHash.
+	Hash      string    `bson:"hash"`
+	FetchedAt time.Time `bson:"fetchedAt"`
+}
+
+// conditional reports whether there is anything to make a conditional
+// request with.
+func (m FeedMeta) conditional() bool {
+	return m.ETag != "" || m.LastModified != ""
+}
+
+// Keys of feed.Custom LoadFeed keeps the validators of the feed under.
+const (
+	feedETagKey         = "podgo:etag"
+	feedLastModifiedKey = "podgo:last-modified"
+)
+
+// errNotModified is returned by loadFeed for a feed that is unchanged
+// since it was fetched with the validators given.
+var errNotModified = errors.New("feed not modified")
+
+// feedValidators returns what to fetch feedURL with and the podcast of
+// the feed. Validators are only sent for a podcast that processed the body
+// they belong to in full and whose curation didn't change since, so that
+// nothing is missed by not looking at the feed.
+func feedValidators(ctx context.Context, store Store, feedURL string, existingPodcastFeeds map[string]bool) (FeedMeta, Podcast) {
+	podcastIndex.Lock()
+	known := existingPodcastFeeds[feedURL]
+	podcastIndex.Unlock()
+	if !known {
+		return FeedMeta{}, Podcast{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	meta, err := store.FeedMeta(ctx, feedURL)
+	if err != nil {
+		if err != errNotFound {
+			errorf(ctx, "Error fetching validators of feed %s: %v", redactURL(feedURL), err)
+		}
+		return FeedMeta{}, Podcast{}
+	}
+	podcast, err := store.PodcastByFeed(ctx, feedURL)
+	if err != nil || meta.Hash == "" || meta.Hash != podcast.FeedHash {
+		return FeedMeta{}, Podcast{}
+	}
+	curation := podcast.Settings.curation(feedCurations[feedURL])
+	if !reflect.DeepEqual(curation.categories(podcast.RawCategories), podcast.Categories) {
+		return FeedMeta{}, Podcast{}
+	}
+	return meta, podcast
+}
+
+// recordFeedMeta stores the validators feed was fetched from feedURL with.
+// Failing to store them is only logged, the feed is downloaded in full
+// next time.
+func recordFeedMeta(ctx context.Context, store Store, feedURL string, feed *gofeed.Feed) {
+	meta := FeedMeta{
+		Feed:         feedURL,
+		ETag:         feed.Custom[feedETagKey],
+		LastModified: feed.Custom[feedLastModifiedKey],
+		Hash:         feed.Custom[feedHashKey],
+		FetchedAt:    time.Now(),
+	}
+	if err := store.SetFeedMeta(ctx, meta); err != nil {
+		errorf(ctx, "Error storing validators of feed %s: %v", redactURL(feedURL), err)
+	}
+}
+
+// markNotModified notes a fetch of podcast that found its feed unchanged
+// like processFeed does for an unchanged body.
+func markNotModified(ctx context.Context, store Store, podcast Podcast) error {
+	now := time.Now()
+	set := bson.M{"lastCrawledAt": now, "lastSuccessAt": now}
+	clearFailures(podcast, set)
+	return store.UpdatePodcast(ctx, podcast.ID, set)
+}
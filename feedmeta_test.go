@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// countingStore counts the calls that go through it which look up or write
+// the episodes of a podcast.
+type countingStore struct {
+	Store
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *countingStore) count() {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+}
+
+func (s *countingStore) episodeCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func (s *countingStore) EpisodeGUIDs(ctx context.Context, podlistUrl string, guids []string) (map[string]bool, error) {
+	s.count()
+	return s.Store.EpisodeGUIDs(ctx, podlistUrl, guids)
+}
+
+func (s *countingStore) EpisodesByGUID(ctx context.Context, podlistUrl string, guids []string) ([]Episode, error) {
+	s.count()
+	return s.Store.EpisodesByGUID(ctx, podlistUrl, guids)
+}
+
+func (s *countingStore) Episodes(ctx context.Context, podlistUrl string) ([]Episode, error) {
+	s.count()
+	return s.Store.Episodes(ctx, podlistUrl)
+}
+
+func (s *countingStore) RefreshPodcastStats(ctx context.Context, podlistUrl string) error {
+	s.count()
+	return s.Store.RefreshPodcastStats(ctx, podlistUrl)
+}
+
+func (s *countingStore) WalkEpisodes(ctx context.Context, podlistUrl string, fn func(Episode) error) error {
+	s.count()
+	return s.Store.WalkEpisodes(ctx, podlistUrl, fn)
+}
+
+func (s *countingStore) InsertEpisodes(ctx context.Context, episodes []Episode) ([]Episode, error) {
+	s.count()
+	return s.Store.InsertEpisodes(ctx, episodes)
+}
+
+func (s *countingStore) UpdateEpisode(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	s.count()
+	return s.Store.UpdateEpisode(ctx, id, set)
+}
+
+func (s *countingStore) UpdateEpisodes(ctx context.Context, updates []EpisodeUpdate) error {
+	s.count()
+	return s.Store.UpdateEpisodes(ctx, updates)
+}
+
+func (s *countingStore) DeleteEpisodes(ctx context.Context, ids []primitive.ObjectID) error {
+	s.count()
+	return s.Store.DeleteEpisodes(ctx, ids)
+}
+
+func (s *countingStore) ArchiveEpisodes(ctx context.Context, episodes []Episode) error {
+	s.count()
+	return s.Store.ArchiveEpisodes(ctx, episodes)
+}
+
+func TestIngestUnchangedFeedBody(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		server.ignoreValidators("/podcast.xml")
+		counting := &countingStore{Store: store}
+		in := newIngester(t, counting)
+
+		in.crawl(feedURL)
+		if hash := in.podcast(feedURL).FeedHash; len(hash) != 64 {
+			t.Fatalf("feed hash %q isn't a SHA-256", hash)
+		}
+		before := counting.episodeCalls()
+		if before == 0 {
+			t.Fatal("first crawl didn't go through the counting store")
+		}
+
+		// The feed is downloaded again, but its body is the same.
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 {
+			t.Errorf("%d new episodes in the same feed", result.NewEpisodes)
+		}
+		if n := server.fetches("/podcast.xml"); n != 2 {
+			t.Errorf("feed fetched %d times, want 2", n)
+		}
+		if n := counting.episodeCalls() - before; n != 0 {
+			t.Errorf("second crawl of the same feed went through its episodes %d times", n)
+		}
+
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("%d new episodes in the changed feed, want 1", result.NewEpisodes)
+		}
+	})
+}
+
+func TestIngestNotModifiedFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		counting := &countingStore{Store: store}
+		in := newIngester(t, counting)
+
+		in.crawl(feedURL)
+		meta, err := store.FeedMeta(context.Background(), feedURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := feedEpoch.Format(http.TimeFormat); meta.LastModified != want {
+			t.Errorf("stored Last-Modified %q, want %q", meta.LastModified, want)
+		}
+
+		// The server answers the conditional fetch with 304.
+		before := counting.episodeCalls()
+		notModified := stats.get(&stats.notModified)
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 || result.PodlistUrl != "tech-talk" {
+			t.Errorf("unmodified feed crawled with %d new episodes into %q", result.NewEpisodes, result.PodlistUrl)
+		}
+		if n := stats.get(&stats.notModified) - notModified; n != 1 {
+			t.Errorf("%d feeds counted as not modified, want 1", n)
+		}
+		if n := counting.episodeCalls() - before; n != 0 {
+			t.Errorf("unmodified feed went through its episodes %d times", n)
+		}
+
+		// A changed feed is downloaded again.
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("%d new episodes in the changed feed, want 1", result.NewEpisodes)
+		}
+	})
+}
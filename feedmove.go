@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// feedMoveSet collects feeds that moved to a new URL during a run, so the
+// feed list can be rewritten once all feeds are done.
+type feedMoveSet struct {
+	mu    sync.Mutex
+	moves map[string]string
+}
+
+var feedMoves = feedMoveSet{moves: make(map[string]string)}
+
+func (m *feedMoveSet) record(from, to string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.moves[from] = to
+}
+
+// apply replaces every moved feed in the feed list with its new URL, in
+// store for storeFeeds.
+func (m *feedMoveSet) apply(store Store, filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.moves) == 0 {
+		return nil
+	}
+	if filename == storeFeeds {
+		return m.applyToStore(store)
+	}
+	// Only JSON lists are rewritten.
+	if filename == stdinFeeds || isOPML(filename) {
+		for from, to := range m.moves {
+			warnf(context.Background(), "feed %s moved to %s, update your feed list", redactURL(from), redactURL(to))
+		}
+		return nil
+	}
+
+	feeds := loadFeedsFromJSON(filename)
+	seen := make(map[[2]string]bool)
+	var updated []feedEntry
+	for _, f := range feeds {
+		if to, ok := m.moves[f.URL]; ok {
+			f.URL = to
+		}
+		key := [2]string{f.URL, f.Namespace}
+		if !seen[key] {
+			seen[key] = true
+			updated = append(updated, f)
+		}
+	}
+
+	if err := writeFeedList(filename, updated); err != nil {
+		return err
+	}
+	infof(context.Background(), "Updated %d moved feeds in %s", len(m.moves), filename)
+	return nil
+}
+
+// applyToStore lists every moved feed under its new URL instead of the
+// old one, unless the new one is listed already. The run may have run out
+// of time, so it gets a time budget of its own.
+func (m *feedMoveSet) applyToStore(store Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DBTimeout)
+	defer cancel()
+
+	listed, err := store.ListedFeeds(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching feeds: %v", err)
+	}
+	moved := 0
+	for _, f := range listed {
+		to, ok := m.moves[f.URL]
+		if !ok {
+			continue
+		}
+		from := f.URL
+		f.URL = to
+		if _, err := store.InsertListedFeed(ctx, f); err != nil {
+			return fmt.Errorf("error adding moved feed %s: %v", redactURL(to), err)
+		}
+		if err := store.DeleteListedFeed(ctx, from, f.Namespace); err != nil {
+			return fmt.Errorf("error removing moved feed %s: %v", redactURL(from), err)
+		}
+		moved++
+	}
+	infof(ctx, "Updated %d moved feeds in the store", moved)
+	return nil
+}
+
+// writeFeedList replaces the feed list in filename with feeds. The list is
+// written to a temporary file first, so a failed write leaves the old one.
+func writeFeedList(filename string, feeds []feedEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(feeds); err != nil {
+		return fmt.Errorf("error encoding feed list: %v", err)
+	}
+
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes.TrimRight(buf.Bytes(), "\n"), 0644); err != nil {
+		return fmt.Errorf("error writing feed list: %v", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("error replacing feed list: %v", err)
+	}
+	return nil
+}
+
+// newFeedURL returns the URL announced by <itunes:new-feed-url> if the feed
+// declares one that differs from where we got it.
+func newFeedURL(feed *gofeed.Feed) string {
+	if feed.ITunesExt == nil {
+		return ""
+	}
+	newURL := strings.TrimSpace(feed.ITunesExt.NewFeedURL)
+	if newURL == "" || newURL == feed.FeedLink {
+		return ""
+	}
+	u, err := url.Parse(newURL)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+		infof(context.Background(), "Ignoring invalid new-feed-url %q in %s", redactURL(newURL), redactURL(feed.FeedLink))
+		return ""
+	}
+	return newURL
+}
+
+// migratePodcastFeed points the stored podcast with feed from to its new
+// feed URL.
+func migratePodcastFeed(ctx context.Context, store Store, from, to string, existingPodcastFeeds map[string]bool) error {
+	podcastIndex.Lock()
+	skip := !existingPodcastFeeds[from] || existingPodcastFeeds[to]
+	podcastIndex.Unlock()
+	if skip {
+		return nil
+	}
+	podcast, err := store.PodcastByFeed(ctx, from)
+	if err != nil {
+		return fmt.Errorf("error fetching podcast to move: %v", err)
+	}
+	set := bson.M{"feed": to}
+	if err := store.UpdatePodcast(ctx, podcast.ID, set); err != nil {
+		return fmt.Errorf("error moving podcast feed: %v", err)
+	}
+	changes.podcastUpdated(podcast, set)
+	podcastIndex.Lock()
+	existingPodcastFeeds[to] = true
+	podcastIndex.Unlock()
+	infof(ctx, "Podcast feed moved from %s to %s", redactURL(from), redactURL(to))
+	return nil
+}
+
+// feedRedirect is one redirect followed while fetching a feed.
+type feedRedirect struct {
+	From       string
+	To         string
+	StatusCode int
+}
+
+// redirectChain returns the redirects that led to resp, in the order they
+// were followed.
+func redirectChain(resp *http.Response) []feedRedirect {
+	var chain []feedRedirect
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		r := feedRedirect{From: req.Response.Request.URL.String(), To: req.URL.String(), StatusCode: req.Response.StatusCode}
+		chain = append([]feedRedirect{r}, chain...)
+	}
+	return chain
+}
+
+// permanentLocation returns where the permanent redirects at the start of
+// chain lead, or "" if the feed wasn't moved permanently. A temporary
+// redirect after them doesn't change where the feed lives.
+func permanentLocation(chain []feedRedirect) string {
+	var location string
+	for _, r := range chain {
+		if r.StatusCode != http.StatusMovedPermanently && r.StatusCode != http.StatusPermanentRedirect {
+			break
+		}
+		location = r.To
+	}
+	return location
+}
+
+// followPermanentRedirect moves the podcast of feed from to the feed URL to
+// it was permanently redirected to. feed is what was found there, so the
+// target is known to parse; the move is only made if its title matches the
+// stored podcast, as a redirect to a parking page or an unrelated show is
+// not a move.
+func followPermanentRedirect(ctx context.Context, store Store, from, to string, feed *gofeed.Feed, existingPodcastFeeds map[string]bool) {
+	podcastIndex.Lock()
+	known := existingPodcastFeeds[from]
+	podcastIndex.Unlock()
+	if known {
+		podcast, err := store.PodcastByFeed(ctx, from)
+		if err != nil {
+			errorf(ctx, "Error fetching podcast redirected to %s: %v", redactURL(to), err)
+			return
+		}
+		if !strings.EqualFold(strings.TrimSpace(podcast.Title), strings.TrimSpace(feed.Title)) {
+			warnf(ctx, "Not following redirect of %s to %s: title %q doesn't match %q", redactURL(from), redactURL(to), feed.Title, podcast.Title)
+			return
+		}
+		if err := migratePodcastFeed(ctx, store, from, to, existingPodcastFeeds); err != nil {
+			errorf(ctx, "Error following redirect of %s: %v", redactURL(from), err)
+			return
+		}
+	}
+	if feed.FeedLink == from {
+		feed.FeedLink = to
+	}
+	feedMoves.record(from, to)
+	stats.add(&stats.moved)
+	infof(ctx, "Feed %s moved permanently to %s", redactURL(from), redactURL(to))
+}
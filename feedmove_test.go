@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+func TestNewFeedURL(t *testing.T) {
+	tests := []struct {
+		declared string
+		want     string
+	}{
+		{"", ""},
+		{"https://a.example/feed", ""},
+		{" https://b.example/feed ", "https://b.example/feed"},
+		{"/feed.xml", ""},
+		{"ftp://b.example/feed", ""},
+	}
+	for _, tt := range tests {
+		feed := &gofeed.Feed{FeedLink: "https://a.example/feed", ITunesExt: &ext.ITunesFeedExtension{NewFeedURL: tt.declared}}
+		if got := newFeedURL(feed); got != tt.want {
+			t.Errorf("new-feed-url %q: got %q, want %q", tt.declared, got, tt.want)
+		}
+	}
+	if got := newFeedURL(&gofeed.Feed{FeedLink: "https://a.example/feed"}); got != "" {
+		t.Errorf("feed without iTunes tags: got %q", got)
+	}
+}
+
+func TestIngestNewFeedURL(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		defer func(moves map[string]string) { feedMoves.moves = moves }(feedMoves.moves)
+		feedMoves.moves = make(map[string]string)
+
+		server := newFeedServer(t)
+		oldURL := server.setFeed("/old.xml", "podcast.xml")
+		newURL := server.setFeed("/podcast.xml", "podcast.xml")
+		if _, err := store.InsertListedFeed(ctx, ListedFeed{URL: oldURL, Enabled: true, AddedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		in := newIngester(t, store)
+		in.crawl(oldURL)
+
+		// The feed announces its move, the podcast follows it and keeps
+		// its episodes.
+		server.setFeed("/old.xml", "podcast-moved.xml")
+		in.crawl(oldURL)
+		podcast := in.podcast(newURL)
+		if podcast.Feed != newURL {
+			t.Errorf("podcast feed %s, want %s", podcast.Feed, newURL)
+		}
+		if n := len(in.episodes(podcast)); n != 3 {
+			t.Errorf("%d episodes after the move, want 3", n)
+		}
+		if n := in.countPodcasts(); n != 1 {
+			t.Errorf("%d podcasts stored, want 1", n)
+		}
+
+		if err := feedMoves.apply(store, storeFeeds); err != nil {
+			t.Fatal(err)
+		}
+		listed, err := store.ListedFeeds(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listed) != 1 || listed[0].URL != newURL {
+			t.Errorf("feed list %+v, want just %s", listed, newURL)
+		}
+	})
+}
+
+func TestPermanentLocation(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain []feedRedirect
+		want  string
+	}{
+		{"none", nil, ""},
+		{"moved", []feedRedirect{{From: "https://a.example/feed", To: "https://b.example/feed", StatusCode: http.StatusMovedPermanently}}, "https://b.example/feed"},
+		{"permanent redirect", []feedRedirect{{From: "https://a.example/feed", To: "https://b.example/feed", StatusCode: http.StatusPermanentRedirect}}, "https://b.example/feed"},
+		{"temporary", []feedRedirect{{From: "https://a.example/feed", To: "https://b.example/feed", StatusCode: http.StatusFound}}, ""},
+		{"moved twice", []feedRedirect{
+			{From: "https://a.example/feed", To: "https://b.example/feed", StatusCode: http.StatusMovedPermanently},
+			{From: "https://b.example/feed", To: "https://c.example/feed", StatusCode: http.StatusPermanentRedirect},
+		}, "https://c.example/feed"},
+		{"moved then temporary", []feedRedirect{
+			{From: "https://a.example/feed", To: "https://b.example/feed", StatusCode: http.StatusMovedPermanently},
+			{From: "https://b.example/feed", To: "https://cdn.example/feed", StatusCode: http.StatusTemporaryRedirect},
+		}, "https://b.example/feed"},
+	}
+	for _, tt := range tests {
+		if got := permanentLocation(tt.chain); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIngestPermanentRedirect(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		defer func(moves map[string]string) { feedMoves.moves = moves }(feedMoves.moves)
+		feedMoves.moves = make(map[string]string)
+
+		server := newFeedServer(t)
+		oldURL := server.setFeed("/old.xml", "podcast.xml")
+		newURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+		in.crawl(oldURL)
+
+		// The feed changed since, so the redirected fetch isn't answered
+		// with Not Modified.
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		server.redirect("/old.xml", "/podcast.xml")
+		in.crawl(oldURL)
+		podcast := in.podcast(newURL)
+		if podcast.Feed != newURL {
+			t.Errorf("podcast feed %s, want %s", podcast.Feed, newURL)
+		}
+		if n := len(in.episodes(podcast)); n != 4 {
+			t.Errorf("%d episodes after the move, want 4", n)
+		}
+		if n := in.countPodcasts(); n != 1 {
+			t.Errorf("%d podcasts stored, want 1", n)
+		}
+		if got := feedMoves.moves[oldURL]; got != newURL {
+			t.Errorf("recorded move to %q, want %s", got, newURL)
+		}
+	})
+}
+
+func TestIngestRedirectToAnotherShow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		defer func(moves map[string]string) { feedMoves.moves = moves }(feedMoves.moves)
+		feedMoves.moves = make(map[string]string)
+
+		server := newFeedServer(t)
+		oldURL := server.setFeed("/old.xml", "podcast.xml")
+		server.setFeed("/blog.xml", "no-itunes.xml")
+		in := newIngester(t, store)
+		in.crawl(oldURL)
+
+		// A redirect to a feed with another title is not a move.
+		server.setFeed("/blog.xml", "no-itunes.xml")
+		server.redirect("/old.xml", "/blog.xml")
+		in.crawl(oldURL)
+		if podcast := in.podcast(oldURL); podcast.Title != "Tech Talk" {
+			t.Errorf("podcast of %s is %q, want Tech Talk", oldURL, podcast.Title)
+		}
+		if _, err := store.PodcastByFeed(context.Background(), server.URL+"/blog.xml"); err == nil {
+			t.Error("podcast followed a redirect to another show")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Feed warnings are what is sloppy about a feed without keeping it from
+// being ingested: missing artwork, dates that had to be estimated and the
+// like. They are purely diagnostic, nothing in the crawl depends on them.
+
+// FeedWarnings are the warnings of one feed as of its last crawl.
+type FeedWarnings struct {
+	Feed      string    `bson:"_id"`
+	Warnings  []string  `bson:"warnings"`
+	CheckedAt time.Time `bson:"checkedAt"`
+}
+
+// validDuration matches the itunes:duration formats: seconds, MM:SS and
+// HH:MM:SS, optionally with fractions of a second.
+var validDuration = regexp.MustCompile(`^\d+(:\d{1,2}){0,2}(\.\d+)?$`)
+
+// itemIssues counts the items of a feed with one kind of issue and keeps
+// the title of the first as an example.
+type itemIssues struct {
+	count   int
+	example string
+}
+
+// lintFeed returns the warnings of feed.
+func lintFeed(feed *gofeed.Feed, now time.Time) []string {
+	var warnings []string
+	if feed.ITunesExt == nil {
+		warnings = append(warnings, "no iTunes tags")
+	}
+	if feedImage(feed) == "" {
+		warnings = append(warnings, "no artwork")
+	}
+	if strings.TrimSpace(feed.Description) == "" {
+		warnings = append(warnings, "no description")
+	}
+
+	kinds := []string{"no publish date", "unparseable publish date", "implausible publish date", "no enclosure", "unparseable duration", "duplicate GUID"}
+	issues := make(map[string]*itemIssues)
+	add := func(kind string, item *gofeed.Item) {
+		i := issues[kind]
+		if i == nil {
+			i = &itemIssues{example: item.Title}
+			issues[kind] = i
+		}
+		i.count++
+	}
+	estimated := estimatePublished(feed.Items, now)
+	for _, item := range feed.Items {
+		switch _, ok := estimated[item]; {
+		case item.PublishedParsed == nil && strings.TrimSpace(item.Published) == "":
+			add("no publish date", item)
+		case item.PublishedParsed == nil:
+			add("unparseable publish date", item)
+		case ok:
+			add("implausible publish date", item)
+		}
+		if itemEnclosure(item).Url == "" {
+			add("no enclosure", item)
+		}
+		if item.ITunesExt != nil {
+			if d := strings.TrimSpace(item.ITunesExt.Duration); d != "" && !validDuration.MatchString(d) {
+				add("unparseable duration", item)
+			}
+		}
+	}
+	_, dropped := uniqueItems(feed.Items)
+	for _, item := range dropped {
+		add("duplicate GUID", item)
+	}
+	for _, kind := range kinds {
+		if i := issues[kind]; i != nil {
+			warnings = append(warnings, fmt.Sprintf("%d items with %s, e.g. %q", i.count, kind, i.example))
+		}
+	}
+	return warnings
+}
+
+// recordFeedWarnings stores the warnings of feed, replacing those of its
+// previous crawl. Failing to store them is only logged.
+func recordFeedWarnings(ctx context.Context, store Store, feed *gofeed.Feed) {
+	now := time.Now()
+	w := FeedWarnings{Feed: feed.FeedLink, Warnings: lintFeed(feed, now), CheckedAt: now}
+	if err := store.SetFeedWarnings(ctx, w); err != nil {
+		errorf(ctx, "Error storing warnings of feed %s: %v", redactURL(feed.FeedLink), err)
+	}
+}
+
+// printFeedWarnings is the warnings command: it lists the feeds that had
+// warnings on their last crawl.
+func printFeedWarnings(ctx context.Context, store Store) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "FEED\tCHECKED\tWARNING")
+	return store.FeedWarnings(ctx, func(fw FeedWarnings) error {
+		for _, warning := range fw.Warnings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", redactURL(fw.Feed), fw.CheckedAt.Format("2006-01-02 15:04"), warning)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Enrichment looks up things a feed only links to, like artwork, enclosures
+// and homepages. It fetches them the way feeds are fetched: only from hosts
+// checkFeedURL allows, spaced out by hostLimits, with our user agent and
+// within --feed-timeout.
+
+// errResponseTooLarge is returned by fetchBounded for bodies larger than
+// it may read.
+var errResponseTooLarge = errors.New("response exceeds the size limit")
+
+// enrichmentRequest sends a request for rawURL with method. The caller
+// closes the body, within the lifetime of ctx.
+func enrichmentRequest(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	if err := checkFeedURL(ctx, rawURL); err != nil {
+		return nil, err
+	}
+	if err := hostLimits.Wait(ctx, hostOf(rawURL)); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return httpClient.Do(req)
+}
+
+// fetchBounded returns the body of a GET request for rawURL, which must
+// answer with a 2xx status. Bodies longer than maxBytes fail with
+// errResponseTooLarge, which comes with their first maxBytes bytes for
+// callers that only need the beginning.
+func fetchBounded(ctx context.Context, rawURL string, maxBytes int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.FeedTimeout)
+	defer cancel()
+	resp, err := enrichmentRequest(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return data[:maxBytes], errResponseTooLarge
+	}
+	return data, nil
+}
+
+// headCheck returns the status code rawURL answers a HEAD request with and
+// its Content-Length, -1 if there is none. Servers that don't do HEAD get a
+// GET, whose body is left unread.
+func headCheck(ctx context.Context, rawURL string) (int, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.FeedTimeout)
+	defer cancel()
+	status, length := 0, int64(-1)
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		resp, err := enrichmentRequest(ctx, method, rawURL)
+		if err != nil {
+			return 0, -1, err
+		}
+		resp.Body.Close()
+		status, length = resp.StatusCode, resp.ContentLength
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	return status, length, nil
+}
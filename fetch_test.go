@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchBounded(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = true
+	server := newMediaServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		path     string
+		maxBytes int64
+		wantLen  int
+		wantErr  error
+	}{
+		{"below the cap", "/ok.mp3", 5000, 4242, nil},
+		{"at the cap", "/ok.mp3", 4242, 4242, nil},
+		{"above the cap", "/ok.mp3", 100, 100, errResponseTooLarge},
+	}
+	for _, tt := range tests {
+		data, err := fetchBounded(ctx, server.URL+tt.path, tt.maxBytes)
+		if err != tt.wantErr {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if len(data) != tt.wantLen {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(data), tt.wantLen)
+		}
+	}
+	if _, err := fetchBounded(ctx, server.URL+"/missing.mp3", 5000); err == nil {
+		t.Error("fetching a missing file succeeded")
+	}
+}
+
+func TestFetchBoundedPrivateAddress(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = false
+	server := newMediaServer(t)
+
+	// The private address guard applies as to feeds.
+	if _, err := fetchBounded(context.Background(), server.URL+"/ok.mp3", 5000); err == nil {
+		t.Error("fetched from a private address")
+	}
+	if server.count(http.MethodGet) != 0 {
+		t.Error("request sent to a private address")
+	}
+}
+
+func TestFetchBoundedCancel(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = true
+	// The server answers only once the client gives up.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := fetchBounded(ctx, server.URL+"/chapters.json", maxChaptersSize); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context canceled", err)
+	}
+	if _, _, err := headCheck(ctx, server.URL+"/ep.mp3"); !errors.Is(err, context.Canceled) {
+		t.Errorf("headCheck got error %v, want context canceled", err)
+	}
+
+	// Each fetch gets --feed-timeout at most.
+	config.FeedTimeout = 50 * time.Millisecond
+	if _, err := fetchBounded(context.Background(), server.URL+"/chapters.json", maxChaptersSize); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want deadline exceeded", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("fetches took %s to give up", d)
+	}
+}
+
+func TestHeadCheck(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = true
+	server := newMediaServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		path   string
+		status int
+		length int64
+		heads  int
+		gets   int
+	}{
+		{"/ok.mp3", http.StatusOK, 4242, 1, 0},
+		{"/missing.mp3", http.StatusNotFound, int64(len("404 page not found\n")), 1, 0},
+		{"/head-not-allowed.mp3", http.StatusOK, 4242, 1, 1},
+	}
+	for _, tt := range tests {
+		heads, gets := server.count(http.MethodHead), server.count(http.MethodGet)
+		status, length, err := headCheck(ctx, server.URL+tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if status != tt.status || length != tt.length {
+			t.Errorf("%s: got %d and length %d, want %d and %d", tt.path, status, length, tt.status, tt.length)
+		}
+		if h, g := server.count(http.MethodHead)-heads, server.count(http.MethodGet)-gets; h != tt.heads || g != tt.gets {
+			t.Errorf("%s: sent %d HEAD and %d GET requests, want %d and %d", tt.path, h, g, tt.heads, tt.gets)
+		}
+	}
+}
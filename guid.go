@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// normalizeGUID maps the variants of a GUID that feeds produce from one
+// day to the next onto the same value. URL GUIDs get a lowercase scheme and
+// host and lose trailing slashes; anything else is an opaque identifier and
+// only trimmed.
+func normalizeGUID(guid string) string {
+	g := strings.TrimSpace(guid)
+	u, err := url.Parse(g)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return g
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	return u.String()
+}
+
+// normalizedSet returns the set of the normalized guids, leaving out blank
+// ones.
+func normalizedSet(guids []string) map[string]bool {
+	set := make(map[string]bool, len(guids))
+	for _, g := range guids {
+		if n := normalizeGUID(g); n != "" {
+			set[n] = true
+		}
+	}
+	return set
+}
+
+// lookupGUIDs returns guids without the blank ones, which would match every
+// stored episode without a GUID.
+func lookupGUIDs(guids []string) []string {
+	var lookup []string
+	for _, g := range guids {
+		if normalizeGUID(g) != "" {
+			lookup = append(lookup, g)
+		}
+	}
+	return lookup
+}
+
+// itemKey returns what an item is matched against the stored episodes by:
+// its normalized GUID or, for an item without one, its enclosure or else
+// the hash of its text. See episodeKey.
+func itemKey(item *gofeed.Item) string {
+	if guid := normalizeGUID(item.GUID); guid != "" {
+		return guid
+	}
+	if enclosures := itemEnclosures(item); len(enclosures) > 0 {
+		if key := enclosureKey(enclosures[config.PreferredEnclosure(enclosures)].Url); key != "" {
+			return "enclosure " + key
+		}
+	}
+	return "content " + itemContent(item).hash()
+}
+
+// episodeKey is itemKey for a stored episode.
+func episodeKey(e Episode) string {
+	if guid := normalizeGUID(e.Guid); guid != "" {
+		return guid
+	}
+	if key := enclosureKey(e.Enclosure.Url); key != "" {
+		return "enclosure " + key
+	}
+	return "content " + e.ContentHash
+}
+
+// uniqueItems returns items with one item per normalized GUID, and the
+// items it left out. Of items sharing a GUID the one published last is
+// kept, in its own place, or the first if that can't be told. Items without
+// a GUID are all kept.
+func uniqueItems(items []*gofeed.Item) (unique, dropped []*gofeed.Item) {
+	kept := make(map[string]int)
+	for _, item := range items {
+		guid := normalizeGUID(item.GUID)
+		if guid == "" {
+			unique = append(unique, item)
+			continue
+		}
+		i, ok := kept[guid]
+		if !ok {
+			kept[guid] = len(unique)
+			unique = append(unique, item)
+			continue
+		}
+		if prev := unique[i]; item.PublishedParsed != nil && (prev.PublishedParsed == nil || item.PublishedParsed.After(*prev.PublishedParsed)) {
+			dropped = append(dropped, prev)
+			unique[i] = nil
+			kept[guid] = len(unique)
+			unique = append(unique, item)
+			continue
+		}
+		dropped = append(dropped, item)
+	}
+	if len(dropped) == 0 {
+		return items, nil
+	}
+	compact := unique[:0]
+	for _, item := range unique {
+		if item != nil {
+			compact = append(compact, item)
+		}
+	}
+	return compact, dropped
+}
+
+// guidChunkSize bounds how many GUIDs are looked up in one query.
+const guidChunkSize = 500
+
+// itemGUIDs returns the GUIDs of items in chunks of at most guidChunkSize.
+// Items without a GUID are left out.
+func itemGUIDs(items []*gofeed.Item) [][]string {
+	var chunks [][]string
+	var chunk []string
+	for _, item := range items {
+		if normalizeGUID(item.GUID) == "" {
+			continue
+		}
+		chunk = append(chunk, item.GUID)
+		if len(chunk) == guidChunkSize {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// storedGUIDs returns the keys, see itemKey, of the items of a podcast that
+// are stored already. Only the feed's GUIDs are looked up, instead of
+// loading every stored episode of the show. Items without a GUID are rare
+// and matched against the stored episodes without one.
+func storedGUIDs(ctx context.Context, store Store, podlistUrl string, items []*gofeed.Item) (map[string]bool, error) {
+	stored := make(map[string]bool)
+	for _, guids := range itemGUIDs(items) {
+		found, err := store.EpisodeGUIDs(ctx, podlistUrl, guids)
+		if err != nil {
+			return nil, err
+		}
+		for g := range found {
+			stored[g] = true
+		}
+	}
+
+	guidless := make(map[string]bool)
+	for _, item := range items {
+		if normalizeGUID(item.GUID) == "" {
+			guidless[itemKey(item)] = true
+		}
+	}
+	if len(guidless) == 0 {
+		return stored, nil
+	}
+	err := store.WalkEpisodes(ctx, podlistUrl, func(e Episode) error {
+		if key := episodeKey(e); normalizeGUID(e.Guid) == "" && guidless[key] {
+			stored[key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// storedEpisodes returns the stored episodes of a podcast for items.
+func storedEpisodes(ctx context.Context, store Store, podlistUrl string, items []*gofeed.Item) ([]Episode, error) {
+	var episodes []Episode
+	for _, guids := range itemGUIDs(items) {
+		found, err := store.EpisodesByGUID(ctx, podlistUrl, guids)
+		if err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, found...)
+	}
+	return episodes, nil
+}
+
+// repairGUIDs merges stored episodes whose GUIDs only differ by
+// normalization, keeping the oldest copy, and fills in the normalized GUID
+// on episodes stored before it existed. Episodes without a GUID are left
+// alone.
+func repairGUIDs(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+
+	removed := 0
+	for _, p := range podcasts {
+		episodes, err := store.Episodes(ctx, p.PodlistUrl)
+		if err != nil {
+			return fmt.Errorf("error fetching episodes of %s: %v", p.PodlistUrl, err)
+		}
+		// ObjectIDs start with their creation time, so sorting by ID puts
+		// the first inserted copy first.
+		sort.Slice(episodes, func(i, j int) bool {
+			return episodes[i].ID.Hex() < episodes[j].ID.Hex()
+		})
+
+		kept := make(map[string]bool)
+		var duplicates []primitive.ObjectID
+		var stale []Episode
+		for _, e := range episodes {
+			n := normalizeGUID(e.Guid)
+			// Episodes without a GUID aren't copies of each other.
+			if n == "" {
+				continue
+			}
+			if kept[n] {
+				duplicates = append(duplicates, e.ID)
+				continue
+			}
+			kept[n] = true
+			if e.NormalizedGuid != n {
+				e.NormalizedGuid = n
+				stale = append(stale, e)
+			}
+		}
+
+		// Duplicates go before the kept copies get their normalized GUID,
+		// which the unique episode index would reject while they are there.
+		if len(duplicates) > 0 {
+			if err := store.DeleteEpisodes(ctx, duplicates); err != nil {
+				return fmt.Errorf("error removing duplicates of %s: %v", p.PodlistUrl, err)
+			}
+		}
+		for _, e := range stale {
+			if err := store.UpdateEpisode(ctx, e.ID, bson.M{"normalizedGuid": e.NormalizedGuid}); err != nil {
+				return fmt.Errorf("error updating episode %s: %v", e.ID.Hex(), err)
+			}
+		}
+		if len(duplicates) == 0 {
+			continue
+		}
+
+		if err := store.RefreshPodcastStats(ctx, p.PodlistUrl); err != nil {
+			errorf(ctx, "Error updating stats for podcast %s: %v", p.Title, err)
+		}
+		infof(ctx, "Removed %d duplicate episodes from %s", len(duplicates), p.PodlistUrl)
+		removed += len(duplicates)
+	}
+	infof(ctx, "GUID repair done, %d duplicate episodes removed", removed)
+	return nil
+}
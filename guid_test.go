@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// largeShow stores a podcast with n episodes and returns it with the items
+// of its feed, which lists the newest 50 of them.
+func largeShow(tb testing.TB, store Store, n int) (Podcast, []*gofeed.Item) {
+	tb.Helper()
+	podcast := testPodcast(tb, store, "large-show")
+	episodes := make([]Episode, n)
+	for i := range episodes {
+		episodes[i] = testEpisode(podcast, fmt.Sprintf("ep-%d", i), feedEpoch.Add(time.Duration(i)*time.Hour))
+		episodes[i].Description = fmt.Sprintf("Show notes of episode %d, which go on for a while.", i)
+	}
+	if _, err := store.InsertEpisodes(context.Background(), episodes); err != nil {
+		tb.Fatal(err)
+	}
+	var items []*gofeed.Item
+	for i := n - 1; i >= n-50; i-- {
+		items = append(items, &gofeed.Item{GUID: fmt.Sprintf("ep-%d", i)})
+	}
+	return podcast, items
+}
+
+func TestStoredGUIDs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		podcast, items := largeShow(t, store, 100)
+		items = append(items, &gofeed.Item{GUID: "new-episode"})
+		stored, err := storedGUIDs(context.Background(), store, podcast.PodlistUrl, items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored) != 50 {
+			t.Errorf("%d stored GUIDs, want 50", len(stored))
+		}
+		if stored["new-episode"] || stored["ep-0"] {
+			t.Errorf("GUIDs not in the store or not in the feed found: %v", stored)
+		}
+	})
+}
+
+func TestItemGUIDsChunks(t *testing.T) {
+	items := make([]*gofeed.Item, 2*guidChunkSize+1)
+	for i := range items {
+		items[i] = &gofeed.Item{GUID: fmt.Sprint(i)}
+	}
+	chunks := itemGUIDs(items)
+	if len(chunks) != 3 || len(chunks[0]) != guidChunkSize || len(chunks[2]) != 1 {
+		t.Errorf("got %d chunks", len(chunks))
+	}
+}
+
+// The GUIDs of a feed are looked up rather than loading every stored
+// episode of the show, which costs a fraction of the memory on a large
+// show. Compare:
+//
+//	go test -run '^$' -bench GUIDs -benchmem
+func BenchmarkStoredGUIDs(b *testing.B) {
+	store := benchmarkStore(b)
+	podcast, items := largeShow(b, store, 5000)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := storedGUIDs(ctx, store, podcast.PodlistUrl, items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAllEpisodeGUIDs builds the set of GUIDs from all stored
+// episodes, as processEpisodes used to.
+func BenchmarkAllEpisodeGUIDs(b *testing.B) {
+	store := benchmarkStore(b)
+	podcast, _ := largeShow(b, store, 5000)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stored := make(map[string]bool, len(episodes))
+		for _, e := range episodes {
+			stored[normalizeGUID(e.Guid)] = true
+		}
+	}
+}
+
+func benchmarkStore(b *testing.B) Store {
+	store, err := openSQLiteStore(filepath.Join(b.TempDir(), "podgo.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { store.Close(context.Background()) })
+	if err := store.Init(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	return store
+}
+
+func TestUniqueItems(t *testing.T) {
+	at := func(day int) *time.Time {
+		d := feedEpoch.AddDate(0, 0, day)
+		return &d
+	}
+	item := func(title, guid string, published *time.Time) *gofeed.Item {
+		return &gofeed.Item{Title: title, GUID: guid, PublishedParsed: published}
+	}
+	tests := []struct {
+		name        string
+		items       []*gofeed.Item
+		want        []string
+		wantDropped []string
+	}{
+		{"unique",
+			[]*gofeed.Item{item("a", "1", at(2)), item("b", "2", at(1))},
+			[]string{"a", "b"}, nil},
+		{"later one kept",
+			[]*gofeed.Item{item("a", "1", at(1)), item("b", "2", at(2)), item("c", "1", at(3))},
+			[]string{"b", "c"}, []string{"a"}},
+		{"earlier one dropped",
+			[]*gofeed.Item{item("a", "1", at(3)), item("b", "1", at(1)), item("c", "2", at(2))},
+			[]string{"a", "c"}, []string{"b"}},
+		{"first kept without dates",
+			[]*gofeed.Item{item("a", "1", nil), item("b", "1", nil)},
+			[]string{"a"}, []string{"b"}},
+		{"dated over undated",
+			[]*gofeed.Item{item("a", "1", nil), item("b", "1", at(1))},
+			[]string{"b"}, []string{"a"}},
+		{"normalized",
+			[]*gofeed.Item{item("a", "https://Example.com/ep/1/", at(1)), item("b", " https://example.com/ep/1", at(1))},
+			[]string{"a"}, []string{"b"}},
+		{"no GUID",
+			[]*gofeed.Item{item("a", "", at(1)), item("b", " ", at(2))},
+			[]string{"a", "b"}, nil},
+	}
+	titles := func(items []*gofeed.Item) []string {
+		var titles []string
+		for _, item := range items {
+			titles = append(titles, item.Title)
+		}
+		return titles
+	}
+	for _, tt := range tests {
+		unique, dropped := uniqueItems(tt.items)
+		if got := titles(unique); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+		if got := titles(dropped); fmt.Sprint(got) != fmt.Sprint(tt.wantDropped) {
+			t.Errorf("%s: dropped %v, want %v", tt.name, got, tt.wantDropped)
+		}
+	}
+}
+
+func TestRepairGUIDs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := testPodcast(t, store, "tech-talk")
+		first := testEpisode(podcast, "https://example.com/ep1", feedEpoch)
+		// A copy stored before normalized GUIDs existed.
+		copied := testEpisode(podcast, "https://Example.com/ep1/", feedEpoch)
+		copied.NormalizedGuid = ""
+		noGUID := []Episode{testEpisode(podcast, "", feedEpoch), testEpisode(podcast, "", feedEpoch.Add(time.Hour))}
+		noGUID[1].Enclosure.Url = "https://cdn.example.com/other.mp3"
+		if _, err := store.InsertEpisodes(ctx, append([]Episode{first, copied}, noGUID...)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repairGUIDs(ctx, store); err != nil {
+			t.Fatal(err)
+		}
+		episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept := make(map[primitive.ObjectID]bool)
+		for _, e := range episodes {
+			kept[e.ID] = true
+		}
+		if len(episodes) != 3 || !kept[first.ID] || !kept[noGUID[0].ID] || !kept[noGUID[1].ID] {
+			t.Errorf("kept %d episodes, want the first copy and both episodes without a GUID", len(episodes))
+		}
+	})
+}
+
+func TestIngestRepeatedGUID(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/repeated-guid.xml", "repeated-guid.xml")
+		in := newIngester(t, store)
+		logs := captureLogs(t)
+
+		in.crawl(feedURL)
+		episodes := in.episodes(in.podcast(feedURL))
+		if len(episodes) != 2 {
+			t.Fatalf("stored %d episodes, want 2", len(episodes))
+		}
+		if e := episodes[0]; e.Guid != "copypaste-2" || e.Title != "Episode 3: Forgot the GUID" {
+			t.Errorf("kept %s %q of the repeated GUID, want the later item", e.Guid, e.Title)
+		}
+		if !strings.Contains(logs.String(), `skipping \"Episode 2: Again\"`) {
+			t.Errorf("dropped item not logged:\n%s", logs)
+		}
+
+		// The feed still repeats it on the next crawl.
+		server.setFeed("/repeated-guid.xml", "repeated-guid.xml")
+		in.crawl(feedURL)
+		if got := len(in.episodes(in.podcast(feedURL))); got != 2 {
+			t.Errorf("stored %d episodes after the second crawl, want 2", got)
+		}
+	})
+}
+
+func TestIngestItemsWithoutGUID(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/no-guid.xml", "no-guid.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		if got := len(in.episodes(in.podcast(feedURL))); got != 3 {
+			t.Fatalf("stored %d episodes, want 3", got)
+		}
+		// Known items without a GUID are recognized by their audio, and
+		// a new one is still stored.
+		server.setFeed("/no-guid.xml", "no-guid.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 {
+			t.Errorf("second crawl stored %d new episodes, want 0", result.NewEpisodes)
+		}
+		server.setFeed("/no-guid.xml", "no-guid-updated.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("crawl of the updated feed stored %d new episodes, want 1", result.NewEpisodes)
+		}
+		if got := len(in.episodes(in.podcast(feedURL))); got != 4 {
+			t.Errorf("stored %d episodes after the update, want 4", got)
+		}
+	})
+}
+
+func TestLintFeedRepeatedGUID(t *testing.T) {
+	feed := &gofeed.Feed{Items: []*gofeed.Item{
+		{Title: "a", GUID: "1", PublishedParsed: &feedEpoch},
+		{Title: "b", GUID: "1", PublishedParsed: &feedEpoch},
+	}}
+	for _, w := range lintFeed(feed, feedEpoch) {
+		if strings.HasPrefix(w, "1 items with duplicate GUID") {
+			return
+		}
+	}
+	t.Errorf("no warning about the repeated GUID in %q", lintFeed(feed, feedEpoch))
+}
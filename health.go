@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthTimeout bounds the store lookups of a health check, so a probe
+// gets an answer before it gives up itself.
+const healthTimeout = 5 * time.Second
+
+// healthStatus is the answer to GET /healthz.
+type healthStatus struct {
+	Store string `json:"store"`
+	// LastSuccessfulRun is when the most recent crawl that ran to the end
+	// finished, if any did within the last historyRuns runs.
+	LastSuccessfulRun *time.Time `json:"lastSuccessfulRun,omitempty"`
+}
+
+// healthHandler serves GET /healthz for liveness and readiness probes. It
+// answers 503 Service Unavailable while store can't be reached.
+func healthHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+		defer cancel()
+
+		status := healthStatus{Store: "ok"}
+		code := http.StatusOK
+		if err := store.Check(ctx); err != nil {
+			status.Store = err.Error()
+			code = http.StatusServiceUnavailable
+		} else if runs, err := store.CrawlRuns(ctx, historyRuns); err == nil {
+			for _, run := range runs {
+				if !run.FinishedAt.IsZero() && !run.Interrupted {
+					finished := run.FinishedAt
+					status.LastSuccessfulRun = &finished
+					break
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	}
+}
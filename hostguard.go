@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// checkFeedURL decides whether we are willing to fetch rawURL at all. It
+// enforces the host allow and block lists and, unless private targets are
+// allowed, refuses hosts that resolve to private, loopback or link-local
+// addresses. This keeps a user submitted feed list from probing our network.
+func checkFeedURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid feed URL %q: %v", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q in feed URL", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("feed URL %q has no host", rawURL)
+	}
+
+	if matchesHost(host, config.BlockHosts) {
+		return fmt.Errorf("host %s is blocked", host)
+	}
+	if len(config.AllowHosts) > 0 && !matchesHost(host, config.AllowHosts) {
+		return fmt.Errorf("host %s is not in the allowed hosts", host)
+	}
+
+	if config.AllowPrivate {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("host %s is a private address", host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("error resolving host %s: %v", host, err)
+	}
+	for _, a := range addrs {
+		if isPrivateIP(a.IP) {
+			return fmt.Errorf("host %s resolves to private address %s", host, a.IP)
+		}
+	}
+	return nil
+}
+
+// matchesHost reports whether host equals one of the patterns or is a
+// subdomain of one.
+func matchesHost(host string, patterns []string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimPrefix(p, "."))
+		if host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// guardDial refuses connections to private addresses. checkFeedURL catches
+// most cases up front with a readable error; this also covers redirects and
+// DNS answers that change between the check and the actual connection.
+func guardDial(network, address string, _ syscall.RawConn) error {
+	if config.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && isPrivateIP(ip) {
+		return fmt.Errorf("connection to private address %s refused", ip)
+	}
+	return nil
+}
+
+// rejectedRedirect is the error of a redirect to a URL checkFeedURL
+// refused. Loading the feed fails with FeedRejected then, like for the
+// URL itself.
+type rejectedRedirect struct {
+	err error
+}
+
+func (e *rejectedRedirect) Error() string { return "redirect rejected: " + e.err.Error() }
+func (e *rejectedRedirect) Unwrap() error { return e.err }
+
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   guardDial,
+	}).DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			if err := checkFeedURL(req.Context(), req.URL.String()); err != nil {
+				return &rejectedRedirect{err: err}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckFeedURL(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	// IP literals keep the test off DNS.
+	tests := []struct {
+		url          string
+		allow, block []string
+		allowPrivate bool
+		wantErr      string
+	}{
+		{url: "https://93.184.216.34/feed.xml"},
+		{url: "ftp://93.184.216.34/feed.xml", wantErr: "unsupported scheme"},
+		{url: "https:///feed.xml", wantErr: "has no host"},
+		{url: "https://feeds.bad.example/feed.xml", block: []string{"bad.example"}, wantErr: "is blocked"},
+		{url: "https://BAD.example/feed.xml", block: []string{".bad.example"}, wantErr: "is blocked"},
+		{url: "https://93.184.216.34/feed.xml", allow: []string{"93.184.216.34"}},
+		{url: "https://93.184.216.35/feed.xml", allow: []string{"93.184.216.34"}, wantErr: "not in the allowed hosts"},
+		{url: "http://127.0.0.1:8080/feed.xml", wantErr: "private address"},
+		{url: "http://10.1.2.3/feed.xml", wantErr: "private address"},
+		{url: "http://192.168.0.10/feed.xml", wantErr: "private address"},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: "private address"},
+		{url: "http://[::1]/feed.xml", wantErr: "private address"},
+		{url: "http://0.0.0.0/feed.xml", wantErr: "private address"},
+		{url: "http://127.0.0.1:8080/feed.xml", allowPrivate: true},
+		{url: "http://127.0.0.1/feed.xml", block: []string{"127.0.0.1"}, allowPrivate: true, wantErr: "is blocked"},
+	}
+	for _, tt := range tests {
+		config.AllowHosts, config.BlockHosts, config.AllowPrivate = tt.allow, tt.block, tt.allowPrivate
+		err := checkFeedURL(context.Background(), tt.url)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.url, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error %v, want one with %q", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadFeedRejectsPrivateAddress(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = false
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+	}))
+	defer server.Close()
+
+	_, _, err := LoadFeed(context.Background(), server.URL+"/feed.xml")
+	if kind, ok := feedErrorKind(err); !ok || kind != FeedRejected {
+		t.Errorf("error %v, want a rejected FeedError", err)
+	}
+	if fetched {
+		t.Error("the feed was fetched")
+	}
+}
+
+func TestLoadFeedRejectsRedirect(t *testing.T) {
+	newIngester(t, newMemoryStore())
+	config.Retries = 2
+	config.RetryDelay = time.Millisecond
+	config.BlockHosts = []string{"blocked.example"}
+	server := newFeedServer(t)
+	feedURL := server.redirect("/moved.xml", "http://feeds.blocked.example/feed.xml")
+
+	_, _, err := loadFeedRetrying(context.Background(), feedURL, FeedMeta{})
+	if kind, ok := feedErrorKind(err); !ok || kind != FeedRejected {
+		t.Errorf("error %v, want a rejected FeedError", err)
+	}
+	if got := server.fetches("/moved.xml"); got != 1 {
+		t.Errorf("feed fetched %d times, want 1: a rejected redirect isn't retried", got)
+	}
+}
+
+func TestGuardDial(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.AllowPrivate = false
+	for address, wantErr := range map[string]bool{
+		"127.0.0.1:80":      true,
+		"[fe80::1]:443":     true,
+		"10.0.0.1:80":       true,
+		"93.184.216.34:443": false,
+	} {
+		if err := guardDial("tcp", address, nil); (err != nil) != wantErr {
+			t.Errorf("guardDial(%s) = %v, want error %v", address, err, wantErr)
+		}
+	}
+	config.AllowPrivate = true
+	if err := guardDial("tcp", "127.0.0.1:80", nil); err != nil {
+		t.Errorf("guardDial with --allow-private: %v", err)
+	}
+}
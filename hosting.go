@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// selfHosted is the hosting provider of feeds on none of hostingProviders.
+const selfHosted = "self-hosted"
+
+// hostingProviders maps the domains of podcast hosting platforms to their
+// name. Subdomains count as well.
+var hostingProviders = map[string]string{
+	"libsyn.com":     "libsyn",
+	"anchor.fm":      "anchor",
+	"buzzsprout.com": "buzzsprout",
+	"acast.com":      "acast",
+}
+
+// hostingProvider returns the platform feed is hosted on, judged by the
+// domains of its enclosures and of the feed itself.
+func hostingProvider(feed *gofeed.Feed) string {
+	var urls []string
+	for _, item := range feed.Items {
+		for _, enc := range item.Enclosures {
+			urls = append(urls, enc.URL)
+		}
+	}
+	urls = append(urls, feed.FeedLink)
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		for domain, provider := range hostingProviders {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return provider
+			}
+		}
+	}
+	return selfHosted
+}
+
+// checkLinks sends a HEAD request to the homepage of every podcast and
+// stores the status code it answers with, zero if it can't be reached.
+// Requests go through the host guard and the per-host limiter like feed
+// fetches.
+func checkLinks(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, 3)
+	var mu sync.Mutex
+	checked, broken := 0, 0
+	for _, p := range podcasts {
+		if p.Link == "" || p.Settings.EnrichmentDisabled {
+			continue
+		}
+		wg.Add(1)
+		go func(p Podcast) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			status := linkStatus(ctx, p.Link)
+			if err := store.UpdatePodcast(ctx, p.ID, bson.M{"linkStatus": status, "linkCheckedAt": time.Now()}); err != nil {
+				errorf(ctx, "Error updating podcast %s: %v", p.Title, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			checked++
+			if linkHealth(status) != "ok" {
+				debugf(ctx, "Link %s of %s is broken, status %d", p.Link, p.PodlistUrl, status)
+				broken++
+			}
+		}(p)
+	}
+	wg.Wait()
+	infof(ctx, "Checked %d podcast links, %d broken", checked, broken)
+	return nil
+}
+
+// linkStatus returns the status code link answers a HEAD request with, or
+// zero if the request fails. Servers that don't do HEAD get a GET.
+func linkStatus(ctx context.Context, link string) int {
+	status, _, err := headCheck(ctx, link)
+	if err != nil {
+		debugf(ctx, "Error checking link %s: %v", link, err)
+	}
+	return status
+}
+
+// linkHealth sums up the stored link check of a podcast.
+func linkHealth(status int) string {
+	switch {
+	case status == 0:
+		return "unreachable"
+	case status < 400:
+		return "ok"
+	default:
+		return "broken"
+	}
+}
+
+// printCatalogueStats prints how many podcasts each hosting provider and
+// feed type has, and how their homepage links fared in the last
+// --check-links.
+func printCatalogueStats(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	providers := make(map[string]int)
+	feedTypes := make(map[string]int)
+	links := make(map[string]int)
+	for _, p := range podcasts {
+		feedType := p.FeedType
+		if feedType == "" {
+			feedType = "unknown"
+		}
+		feedTypes[feedType]++
+		provider := p.HostingProvider
+		if provider == "" {
+			provider = "unknown"
+		}
+		providers[provider]++
+		switch {
+		case p.Link == "":
+			links["no link"]++
+		case p.LinkCheckedAt.IsZero():
+			links["unchecked"]++
+		default:
+			links[linkHealth(p.LinkStatus)]++
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%d podcasts\n\n", len(podcasts))
+	fmt.Fprintln(w, "HOSTING PROVIDER\tPODCASTS")
+	for _, name := range sortedByCount(providers) {
+		fmt.Fprintf(w, "%s\t%d\n", name, providers[name])
+	}
+	fmt.Fprintln(w, "\nFEED TYPE\tPODCASTS")
+	for _, name := range sortedByCount(feedTypes) {
+		fmt.Fprintf(w, "%s\t%d\n", name, feedTypes[name])
+	}
+	fmt.Fprintln(w, "\nLINK\tPODCASTS")
+	for _, name := range sortedByCount(links) {
+		fmt.Fprintf(w, "%s\t%d\n", name, links[name])
+	}
+	return nil
+}
+
+// sortedByCount returns the keys of counts, largest count first.
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Artwork is looked for in the iTunes image first and in the plain RSS
+// elements after that. Relative URLs are resolved against the feed URL and
+// anything that isn't an absolute http(s) URL then is dropped.
+
+// feedImage returns the artwork of feed, or "" if it has none.
+func feedImage(feed *gofeed.Feed) string {
+	var candidates []string
+	if feed.ITunesExt != nil {
+		candidates = append(candidates, feed.ITunesExt.Image)
+	}
+	if feed.Image != nil {
+		candidates = append(candidates, feed.Image.URL)
+	}
+	return firstImageURL(candidates, feed.FeedLink)
+}
+
+// itemImage returns the artwork of item, falling back to the image of its
+// podcast.
+func itemImage(item *gofeed.Item, podcast Podcast) string {
+	var candidates []string
+	if item.ITunesExt != nil {
+		candidates = append(candidates, item.ITunesExt.Image)
+	}
+	for _, c := range item.Extensions["media"]["content"] {
+		if strings.HasPrefix(c.Attrs["type"], "image/") || c.Attrs["medium"] == "image" {
+			candidates = append(candidates, c.Attrs["url"])
+		}
+	}
+	for _, t := range item.Extensions["media"]["thumbnail"] {
+		candidates = append(candidates, t.Attrs["url"])
+	}
+	if image := firstImageURL(candidates, podcast.Feed); image != "" {
+		return image
+	}
+	return podcast.Image
+}
+
+// firstImageURL returns the first of candidates that is a usable image URL
+// once resolved against base.
+func firstImageURL(candidates []string, base string) string {
+	for _, c := range candidates {
+		if image := resolveImageURL(c, base); image != "" {
+			return image
+		}
+	}
+	return ""
+}
+
+func resolveImageURL(raw, base string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if !u.IsAbs() {
+		b, err := url.Parse(base)
+		if err != nil {
+			return ""
+		}
+		u = b.ResolveReference(u)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// Episodes carry a copy of their podcast's image, so when a show changes its
+// cover art the copies have to follow.
+
+// refreshEpisodeImages sets the podcastImage of all episodes of every podcast
+// to the podcast's current image.
+func refreshEpisodeImages(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	updated, changed := 0, 0
+	for _, p := range podcasts {
+		n, err := store.SetEpisodesPodcastImage(ctx, p.PodlistUrl, p.Image)
+		if err != nil {
+			return fmt.Errorf("error updating episodes of %s: %v", p.PodlistUrl, err)
+		}
+		if n > 0 {
+			debugf(ctx, "Refreshed the image of %d episodes of %s", n, p.PodlistUrl)
+			updated += n
+			changed++
+		}
+	}
+	infof(ctx, "Refreshed the image of %d episodes of %d podcasts", updated, changed)
+	return nil
+}
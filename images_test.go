@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestIngestImages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/images.xml", "images.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		podcast := in.podcast(feedURL)
+		cover := server.URL + "/art/cover.png"
+		if podcast.Image != cover {
+			t.Errorf("podcast image %q, want the RSS image %s", podcast.Image, cover)
+		}
+		want := map[string]string{
+			"pictures-itunes":    "https://pictures.example.com/art/itunes.jpg",
+			"pictures-media":     server.URL + "/art/media.jpg",
+			"pictures-thumbnail": "https://pictures.example.com/art/thumbnail.jpg",
+			"pictures-none":      cover,
+		}
+		episodes := in.episodes(podcast)
+		if len(episodes) != len(want) {
+			t.Fatalf("%d episodes stored, want %d", len(episodes), len(want))
+		}
+		for _, e := range episodes {
+			if e.Image != want[e.Guid] {
+				t.Errorf("episode %s image %q, want %q", e.Guid, e.Image, want[e.Guid])
+			}
+		}
+
+		// The iTunes image wins once the feed has one.
+		server.setFeed("/images.xml", "images-itunes.xml")
+		in.crawl(feedURL)
+		if got := in.podcast(feedURL).Image; got != "https://pictures.example.com/art/itunes-cover.jpg" {
+			t.Errorf("updated podcast image %q, want the iTunes image", got)
+		}
+	})
+}
+
+func TestResolveImageURL(t *testing.T) {
+	base := "https://pictures.example.com/feeds/show.xml"
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{" https://cdn.example.com/cover.jpg ", "https://cdn.example.com/cover.jpg"},
+		{"http://cdn.example.com/cover.jpg", "http://cdn.example.com/cover.jpg"},
+		{"cover.jpg", "https://pictures.example.com/feeds/cover.jpg"},
+		{"/art/cover.jpg", "https://pictures.example.com/art/cover.jpg"},
+		{"//cdn.example.com/cover.jpg", "https://cdn.example.com/cover.jpg"},
+		{"ftp://cdn.example.com/cover.jpg", ""},
+		{"data:image/png;base64,AAAA", ""},
+		{"javascript:alert(1)", ""},
+	}
+	for _, tt := range tests {
+		if got := resolveImageURL(tt.raw, base); got != tt.want {
+			t.Errorf("resolveImageURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
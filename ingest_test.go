@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// feedServer serves the feeds of testdata/feeds. Each path serves a
+// fixture, which setFeed can swap for another to change the feed between
+// fetches. {{server}} in a fixture stands for the URL of the server. Feeds
+// are served with Last-Modified, so fetches are conditional the way they
+// are for real hosts, unless ignoreValidators says otherwise. fail makes a
+// path answer with an error status, slow makes it answer late.
+type feedServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	feeds    map[string]servedFeed
+	requests map[string]int
+}
+
+type servedFeed struct {
+	fixture  string
+	modified time.Time
+	status   int
+	// retryAfter is the Retry-After sent with status.
+	retryAfter string
+	// unconditional feeds are served in full every time, without
+	// validators.
+	unconditional bool
+	delay         time.Duration
+}
+
+// feedEpoch is when the first version of every served feed changed.
+var feedEpoch = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func newFeedServer(t *testing.T) *feedServer {
+	s := &feedServer{t: t, feeds: make(map[string]servedFeed), requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setFeed makes path serve the fixture of that name, changed later than
+// what path served before.
+func (s *feedServer) setFeed(path, fixture string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	modified := feedEpoch
+	prev, ok := s.feeds[path]
+	if ok {
+		modified = prev.modified.Add(time.Hour)
+	}
+	s.feeds[path] = servedFeed{fixture: fixture, modified: modified, unconditional: prev.unconditional, delay: prev.delay}
+	return s.URL + path
+}
+
+// redirect makes path redirect permanently to the feed at to.
+func (s *feedServer) redirect(path, to string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feeds[path] = servedFeed{fixture: "->" + to}
+	return s.URL + path
+}
+
+// ignoreValidators makes path serve its feed like hosts that don't
+// support conditional requests.
+func (s *feedServer) ignoreValidators(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed := s.feeds[path]
+	feed.unconditional = true
+	s.feeds[path] = feed
+}
+
+// fail makes path answer with status.
+func (s *feedServer) fail(path string, status int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feeds[path] = servedFeed{status: status}
+	return s.URL + path
+}
+
+// throttle makes path answer with status and the Retry-After header
+// retryAfter.
+func (s *feedServer) throttle(path string, status int, retryAfter string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feeds[path] = servedFeed{status: status, retryAfter: retryAfter}
+	return s.URL + path
+}
+
+// slow makes path answer only after delay.
+func (s *feedServer) slow(path string, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed := s.feeds[path]
+	feed.delay = delay
+	s.feeds[path] = feed
+}
+
+// fetches returns how often path was requested.
+func (s *feedServer) fetches(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func (s *feedServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	feed, ok := s.feeds[r.URL.Path]
+	s.requests[r.URL.Path]++
+	s.mu.Unlock()
+	time.Sleep(feed.delay)
+	switch {
+	case !ok:
+		http.NotFound(w, r)
+	case feed.status != 0:
+		if feed.retryAfter != "" {
+			w.Header().Set("Retry-After", feed.retryAfter)
+		}
+		http.Error(w, http.StatusText(feed.status), feed.status)
+	case len(feed.fixture) > 2 && feed.fixture[:2] == "->":
+		http.Redirect(w, r, feed.fixture[2:], http.StatusMovedPermanently)
+	default:
+		data, err := os.ReadFile(filepath.Join("testdata", "feeds", feed.fixture))
+		if err != nil {
+			s.t.Errorf("reading fixture: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data = bytes.ReplaceAll(data, []byte("{{server}}"), []byte(s.URL))
+		w.Header().Set("Content-Type", "application/rss+xml")
+		if feed.unconditional {
+			w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, feed.fixture, feed.modified, bytes.NewReader(data))
+	}
+}
+
+// ingester crawls feeds into a store the way a crawl run does.
+type ingester struct {
+	t     *testing.T
+	store Store
+}
+
+// newIngester returns an ingester for store, with the settings a crawl of
+// a local test server needs.
+func newIngester(t *testing.T, store Store) *ingester {
+	defaults := config
+	t.Cleanup(func() { config = defaults })
+	config.AllowPrivate = true
+	config.IgnoreRobots = true
+	config.Retries = 0
+	return &ingester{t: t, store: store}
+}
+
+// crawl processes feedURL as a new run would, which loads the known feeds
+// and slugs from the store first, and fails the test if that fails.
+func (in *ingester) crawl(feedURL string) feedResult {
+	in.t.Helper()
+	ctx := context.Background()
+	feeds, titles, _ := loadExistingPodcasts(ctx, in.store)
+	result := processFeedURL(ctx, feedURL, in.store, feeds, titles)
+	if result.Err != nil {
+		in.t.Fatalf("crawling %s: %v", feedURL, result.Err)
+	}
+	return result
+}
+
+// podcast returns the podcast stored for feedURL.
+func (in *ingester) podcast(feedURL string) Podcast {
+	in.t.Helper()
+	p, err := in.store.PodcastByFeed(context.Background(), feedURL)
+	if err != nil {
+		in.t.Fatalf("fetching podcast of %s: %v", feedURL, err)
+	}
+	return p
+}
+
+// episodes returns the episodes stored for podcast, newest first.
+func (in *ingester) episodes(podcast Podcast) []Episode {
+	in.t.Helper()
+	episodes, err := in.store.Episodes(context.Background(), podcast.PodlistUrl)
+	if err != nil {
+		in.t.Fatalf("fetching episodes of %s: %v", podcast.PodlistUrl, err)
+	}
+	sort.Slice(episodes, func(i, j int) bool { return episodes[i].Published.After(episodes[j].Published) })
+	return episodes
+}
+
+// countPodcasts returns how many podcasts are stored.
+func (in *ingester) countPodcasts() int {
+	in.t.Helper()
+	podcasts, err := in.store.Podcasts(context.Background())
+	if err != nil {
+		in.t.Fatal(err)
+	}
+	return len(podcasts)
+}
+
+func TestIngestFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+
+		result := in.crawl(feedURL)
+		if result.NewEpisodes != 3 {
+			t.Errorf("%d new episodes, want 3", result.NewEpisodes)
+		}
+		if n := in.countPodcasts(); n != 1 {
+			t.Errorf("%d podcasts stored, want 1", n)
+		}
+		podcast := in.podcast(feedURL)
+		if podcast.Title != "Tech Talk" || podcast.PodlistUrl != "tech-talk" || podcast.Author != "Jane Doe" {
+			t.Errorf("podcast %q %q by %q, want Tech Talk tech-talk by Jane Doe", podcast.Title, podcast.PodlistUrl, podcast.Author)
+		}
+		if podcast.EpisodeCount != 3 {
+			t.Errorf("podcast episode count %d, want 3", podcast.EpisodeCount)
+		}
+		if want := time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC); !podcast.LatestEpisodeAt.Equal(want) {
+			t.Errorf("latest episode at %s, want %s", podcast.LatestEpisodeAt, want)
+		}
+
+		episodes := in.episodes(podcast)
+		if len(episodes) != 3 {
+			t.Fatalf("%d episodes stored, want 3", len(episodes))
+		}
+		e := episodes[0]
+		if e.Title != "Episode 3: Databases" || e.Guid != "techtalk-3" || e.PodcastUrl != "tech-talk" {
+			t.Errorf("newest episode %q with GUID %q of %q", e.Title, e.Guid, e.PodcastUrl)
+		}
+		if e.Enclosure.Url != "https://cdn.example.com/techtalk/3.mp3" || e.Enclosure.Size != 3000000 {
+			t.Errorf("newest episode enclosure %+v", e.Enclosure)
+		}
+		if e.Duration != "00:31:00" {
+			t.Errorf("newest episode duration %q, want 00:31:00", e.Duration)
+		}
+		if e.PodcastId != podcast.ID {
+			t.Errorf("newest episode belongs to podcast %s, want %s", e.PodcastId.Hex(), podcast.ID.Hex())
+		}
+	})
+}
+
+func TestIngestIsIdempotent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		first := in.episodes(in.podcast(feedURL))
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 {
+			t.Errorf("second run found %d new episodes", result.NewEpisodes)
+		}
+		if n := in.countPodcasts(); n != 1 {
+			t.Errorf("%d podcasts stored after two runs, want 1", n)
+		}
+		second := in.episodes(in.podcast(feedURL))
+		if len(second) != len(first) {
+			t.Fatalf("%d episodes after the second run, %d after the first", len(second), len(first))
+		}
+		for i := range first {
+			if first[i].ID != second[i].ID || first[i].PodlistUrl != second[i].PodlistUrl {
+				t.Errorf("episode %q changed from %s %s to %s %s", first[i].Title,
+					first[i].ID.Hex(), first[i].PodlistUrl, second[i].ID.Hex(), second[i].PodlistUrl)
+			}
+		}
+		if n := server.fetches("/podcast.xml"); n != 2 {
+			t.Errorf("feed fetched %d times, want 2", n)
+		}
+	})
+}
+
+func TestIngestUpdatedFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("%d new episodes in the updated feed, want 1", result.NewEpisodes)
+		}
+		podcast := in.podcast(feedURL)
+		if want := "Weekly talk about technology, now with guests."; podcast.Description != want {
+			t.Errorf("podcast description %q, want %q", podcast.Description, want)
+		}
+		if podcast.EpisodeCount != 4 {
+			t.Errorf("podcast episode count %d, want 4", podcast.EpisodeCount)
+		}
+		episodes := in.episodes(podcast)
+		if len(episodes) != 4 {
+			t.Fatalf("%d episodes stored, want 4", len(episodes))
+		}
+		if episodes[0].Title != "Episode 4: Networks" {
+			t.Errorf("newest episode %q, want Episode 4: Networks", episodes[0].Title)
+		}
+		if got := episodes[2].Title; got != "Episode 2: Compilers and Linkers" {
+			t.Errorf("retitled episode is %q", got)
+		}
+	})
+}
+
+func TestIngestFeedWithoutITunesTags(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/blog.xml", "no-itunes.xml")
+		in := newIngester(t, store)
+
+		// Only items with iTunes tags count as episodes.
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 {
+			t.Errorf("%d new episodes, want none", result.NewEpisodes)
+		}
+		podcast := in.podcast(feedURL)
+		if podcast.Title != "Plain Blog" {
+			t.Errorf("podcast title %q, want Plain Blog", podcast.Title)
+		}
+		if episodes := in.episodes(podcast); len(episodes) != 0 {
+			t.Errorf("%d episodes stored, want none", len(episodes))
+		}
+	})
+}
+
+func TestIngestRedirectedFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		newURL := server.setFeed("/podcast.xml", "podcast.xml")
+		oldURL := server.redirect("/old.xml", "/podcast.xml")
+		in := newIngester(t, store)
+
+		if result := in.crawl(oldURL); result.NewEpisodes != 3 {
+			t.Errorf("%d new episodes, want 3", result.NewEpisodes)
+		}
+		// The podcast is stored under the URL the feed moved to, so the
+		// next run crawls that and finds nothing new.
+		podcast := in.podcast(newURL)
+		if podcast.Feed != newURL {
+			t.Errorf("podcast feed %s, want %s", podcast.Feed, newURL)
+		}
+		if result := in.crawl(newURL); result.NewEpisodes != 0 {
+			t.Errorf("crawling the new URL found %d new episodes", result.NewEpisodes)
+		}
+		if n := in.countPodcasts(); n != 1 {
+			t.Errorf("%d podcasts stored, want 1", n)
+		}
+	})
+}
+
+func TestIngestKeepsUpdatedOfUnchangedPodcast(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		created := in.podcast(feedURL)
+		if created.Updated.IsZero() {
+			t.Fatal("new podcast has no updated time")
+		}
+
+		// The hash is forgotten so the same feed is processed in full.
+		if err := store.UpdatePodcast(ctx, created.ID, bson.M{"feedHash": ""}); err != nil {
+			t.Fatal(err)
+		}
+		server.setFeed("/podcast.xml", "podcast.xml")
+		in.crawl(feedURL)
+		if got := in.podcast(feedURL).Updated; !got.Equal(created.Updated) {
+			t.Errorf("unchanged podcast updated at %s, was %s", got, created.Updated)
+		}
+
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		in.crawl(feedURL)
+		if got := in.podcast(feedURL).Updated; !got.After(created.Updated) {
+			t.Errorf("podcast with a new description updated at %s, was %s", got, created.Updated)
+		}
+	})
+}
+
+func TestIngestSkipIfNoNewer(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+		config.SkipIfNoNewer = true
+		in.crawl(feedURL)
+
+		// An older episode added to the feed is no reason to look at it
+		// with --skip-if-no-newer.
+		server.setFeed("/podcast.xml", "podcast-backfilled.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 0 {
+			t.Errorf("%d new episodes in the backfilled feed, want none", result.NewEpisodes)
+		}
+		// Without it the feed is processed and the episode ingested.
+		config.SkipIfNoNewer = false
+		server.setFeed("/podcast.xml", "podcast-backfilled.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("%d new episodes in the backfilled feed without --skip-if-no-newer, want 1", result.NewEpisodes)
+		}
+
+		// A newer episode gets the feed processed either way.
+		config.SkipIfNoNewer = true
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		if result := in.crawl(feedURL); result.NewEpisodes != 1 {
+			t.Errorf("%d new episodes in the updated feed, want 1", result.NewEpisodes)
+		}
+	})
+}
+
+func TestIngestFeedType(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		jsonURL := server.setFeed("/feed.json", "feed.json")
+		in := newIngester(t, store)
+		check := func(feedURL, feedType, generator string) {
+			t.Helper()
+			p := in.podcast(feedURL)
+			if p.FeedType != feedType || p.Generator != generator {
+				t.Errorf("podcast %s has feed type %q and generator %q, want %q and %q", p.Title, p.FeedType, p.Generator, feedType, generator)
+			}
+		}
+
+		in.crawl(feedURL)
+		check(feedURL, "rss", "")
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		in.crawl(feedURL)
+		check(feedURL, "rss", "Castomatic 2.1")
+		// The show moved to a static site generator publishing Atom.
+		server.setFeed("/podcast.xml", "atom.xml")
+		in.crawl(feedURL)
+		check(feedURL, "atom", "Hugo v0.125.0 https://gohugo.io/")
+
+		in.crawl(jsonURL)
+		check(jsonURL, "json", "")
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// hostLimiter spaces out requests to the same host. Each host gets its own
+// minimum delay between requests, e.g. the Crawl-delay from its robots.txt.
+// Fetches that go through Acquire are also limited in how many may run
+// against a host at the same time.
+type hostLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+	// delay is the delay of hosts SetDelay wasn't called for, and the
+	// least delay of all hosts.
+	delay time.Duration
+	// maxActive is how many fetches Acquire lets run against a host at
+	// the same time, 0 for any number.
+	maxActive int
+}
+
+type hostSlot struct {
+	delay time.Duration
+	next  time.Time
+	// active holds a token per running fetch, nil until the first.
+	active chan struct{}
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{hosts: make(map[string]*hostSlot)}
+}
+
+func (l *hostLimiter) slot(host string) *hostSlot {
+	s, ok := l.hosts[host]
+	if !ok {
+		s = &hostSlot{delay: l.delay}
+		l.hosts[host] = s
+	}
+	return s
+}
+
+// SetDelay sets the minimum time between two requests to host. It never
+// gets shorter than the delay of all hosts.
+func (l *hostLimiter) SetDelay(host string, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if delay < l.delay {
+		delay = l.delay
+	}
+	l.slot(host).delay = delay
+}
+
+// Defer holds off requests to host until at least until.
+func (l *hostLimiter) Defer(host string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s := l.slot(host); until.After(s.next) {
+		s.next = until
+	}
+}
+
+// Wait blocks until the next request to host may be sent or ctx is done.
+func (l *hostLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	s := l.slot(host)
+	now := time.Now()
+	start := now
+	if s.next.After(now) {
+		start = s.next
+	}
+	s.next = start.Add(s.delay)
+	l.mu.Unlock()
+
+	wait := start.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Acquire blocks until fewer than maxActive fetches run against host and
+// then like Wait. The fetch counts as running until release is called.
+func (l *hostLimiter) Acquire(ctx context.Context, host string) (release func(), err error) {
+	l.mu.Lock()
+	s := l.slot(host)
+	if s.active == nil && l.maxActive > 0 {
+		s.active = make(chan struct{}, l.maxActive)
+	}
+	active := s.active
+	l.mu.Unlock()
+
+	release = func() {}
+	if active != nil {
+		select {
+		case active <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-active }
+	}
+	if err := l.Wait(ctx, host); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// hostOf returns the host part of rawURL, or rawURL itself if it can't be parsed.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterSpacesRequests(t *testing.T) {
+	l := newHostLimiter()
+	l.delay = 50 * time.Millisecond
+	// A crawl-delay below the delay of all hosts doesn't shorten it.
+	l.SetDelay("a.example.com", time.Millisecond)
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(ctx, "a.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("three requests to a host took %s, want at least 100ms", d)
+	}
+
+	// Other hosts have slots of their own.
+	start = time.Now()
+	if err := l.Wait(ctx, "b.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 25*time.Millisecond {
+		t.Errorf("first request to another host waited %s", d)
+	}
+}
+
+func TestHostLimiterAcquire(t *testing.T) {
+	l := newHostLimiter()
+	l.maxActive = 2
+	ctx := context.Background()
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(ctx, "a.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	// A third fetch of the host waits for one of the others.
+	acquired := make(chan func())
+	go func() {
+		release, err := l.Acquire(ctx, "a.example.com")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("third fetch of a host ran next to two others")
+	case <-time.After(50 * time.Millisecond):
+	}
+	releases[0]()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("third fetch didn't start once another was done")
+	}
+
+	// Waiting for a slot ends with ctx.
+	release, err := l.Acquire(ctx, "a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	defer releases[1]()
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(cancelled, "a.example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want deadline exceeded", err)
+	}
+}
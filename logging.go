@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Everything is logged through slog with debugf, infof, warnf and errorf,
+// which take the level from their name and the feed, podcast and run the
+// line is about from ctx, see withLogAttrs. Lines below --log-level are
+// dropped. --log-format text and json write them with slog's handlers,
+// plain writes the message alone, as podgo always did.
+
+const (
+	logFormatPlain = "plain"
+	logFormatText  = "text"
+	logFormatJSON  = "json"
+)
+
+var (
+	logFormats = []string{logFormatPlain, logFormatText, logFormatJSON}
+	logLevels  = map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError}
+)
+
+// setupLogging makes slog log in format from level on. What the standard
+// logger gets, from libraries, is logged at info.
+func setupLogging(format, level string) {
+	opts := &slog.HandlerOptions{Level: logLevels[level]}
+	var h slog.Handler
+	switch format {
+	case logFormatText:
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case logFormatJSON:
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		h = &plainHandler{out: os.Stderr, level: opts.Level, mu: new(sync.Mutex)}
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// plainHandler writes the time and the message of a record, warnings and
+// debug messages marked as such, and leaves out the attributes.
+type plainHandler struct {
+	out   io.Writer
+	level slog.Leveler
+	mu    *sync.Mutex
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var prefix string
+	switch {
+	case r.Level < slog.LevelInfo:
+		prefix = "DEBUG "
+	case r.Level >= slog.LevelWarn && r.Level < slog.LevelError:
+		prefix = "WARN "
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.out, "%s %s%s\n", r.Time.Format("2006/01/02 15:04:05"), prefix, r.Message)
+	return err
+}
+
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *plainHandler) WithGroup(string) slog.Handler      { return h }
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns ctx with the key value pairs of attrs added to what
+// is logged with it.
+func withLogAttrs(ctx context.Context, attrs ...interface{}) context.Context {
+	prev, _ := ctx.Value(logAttrsKey{}).([]interface{})
+	all := make([]interface{}, 0, len(prev)+len(attrs))
+	return context.WithValue(ctx, logAttrsKey{}, append(append(all, prev...), attrs...))
+}
+
+// logAt logs the message format and args make at level, with the attributes
+// of ctx. A trailing newline is dropped.
+func logAt(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]interface{})
+	logger.Log(ctx, level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"), attrs...)
+}
+
+// debugf logs only with --debug or --log-level debug.
+func debugf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelDebug, format, args...)
+}
+
+func infof(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelInfo, format, args...)
+}
+
+func warnf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelWarn, format, args...)
+}
+
+func errorf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelError, format, args...)
+}
+
+// fatalf logs at error and exits.
+func fatalf(ctx context.Context, format string, args ...interface{}) {
+	errorf(ctx, format, args...)
+	os.Exit(1)
+}
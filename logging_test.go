@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useLogger makes h handle what is logged until the test ends.
+func useLogger(t *testing.T, h slog.Handler) {
+	prev := slog.Default()
+	slog.SetDefault(slog.New(h))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+}
+
+func TestLogAttrsFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	useLogger(t, slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	ctx := withLogAttrs(withLogAttrs(context.Background(), "run", "r1"), "podcast", "tech-talk")
+
+	debugf(ctx, "dropped")
+	warnf(ctx, "feed %s failed\n", "x")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %q, want one line", lines)
+	}
+	var got map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["level"] != "WARN" || got["msg"] != "feed x failed" || got["run"] != "r1" || got["podcast"] != "tech-talk" {
+		t.Errorf("logged %v", got)
+	}
+}
+
+func TestPlainHandler(t *testing.T) {
+	var buf bytes.Buffer
+	useLogger(t, &plainHandler{out: &buf, level: slog.LevelInfo, mu: new(sync.Mutex)})
+	ctx := withLogAttrs(context.Background(), "run", "r1")
+
+	debugf(ctx, "dropped")
+	infof(ctx, "Processed 3 feeds")
+	warnf(ctx, "feed failed")
+	errorf(ctx, "Error inserting podcast: disk full")
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{" Processed 3 feeds", " WARN feed failed", " Error inserting podcast: disk full"}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q", lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d is %q, want it to end in %q", i, line, want[i])
+		}
+	}
+}
@@ -1,24 +1,28 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
-	"net/url"
+	"net"
+	"net/http"
 	"os"
-	"regexp"
-	"runtime"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mmcdole/gofeed"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type JsonFeeds []string
@@ -36,6 +40,99 @@ type Podcast struct {
 	Feed        string             `bson:"feed,omitempty"`
 	PodlistUrl  string             `bson:"podlistUrl,omitempty"`
 	Updated     time.Time          `bson:"updated,omitempty"`
+	People      []Person           `bson:"people,omitempty"`
+
+	// PodcastGUID is the podcast:guid of the feed, Funding its
+	// podcast:funding links. Locked is set if podcast:locked asks other
+	// platforms not to import the feed, LockedOwner is who may unlock it.
+	PodcastGUID string    `bson:"podcastGuid,omitempty"`
+	Funding     []Funding `bson:"funding,omitempty"`
+	Locked      bool      `bson:"locked,omitempty"`
+	LockedOwner string    `bson:"lockedOwner,omitempty"`
+
+	// StableID identifies the podcast across databases, see
+	// podcastStableID. It is set once and kept when the feed moves.
+	StableID string `bson:"stableId,omitempty"`
+
+	// Aliases are former slugs of the podcast, which keep pointing at it.
+	Aliases []string `bson:"aliases,omitempty"`
+
+	// ITunesCategories keeps the iTunes category hierarchy that Categories
+	// flattens.
+	ITunesCategories []ITunesCategory `bson:"itunesCategories,omitempty"`
+
+	// RawCategories are the categories the feed declares, which the feed
+	// list may have curated into Categories, see feedCuration.
+	RawCategories []string `bson:"rawCategories,omitempty"`
+
+	LatestEpisodeAt     time.Time `bson:"latestEpisodeAt,omitempty"`
+	EpisodeCount        int       `bson:"episodeCount,omitempty"`
+	AverageIntervalDays float64   `bson:"averageIntervalDays,omitempty"`
+
+	// LastCrawledAt is when the feed was last fetched, LastSuccessAt when
+	// that last went through.
+	LastCrawledAt time.Time `bson:"lastCrawledAt,omitempty"`
+	LastSuccessAt time.Time `bson:"lastSuccessAt,omitempty"`
+	// RetryAfter is when the host asked us to come back after it last
+	// throttled a fetch; with --honor-update-hints the feed isn't fetched
+	// before.
+	RetryAfter time.Time `bson:"retryAfter,omitempty"`
+	// NextCrawlAt is when the daemon crawls the podcast next, see
+	// crawlScheduler.
+	NextCrawlAt time.Time `bson:"nextCrawlAt,omitempty"`
+
+	// LastBuildDate and UpdateIntervalMinutes are what the feed says about
+	// when it last changed and how often it does.
+	LastBuildDate         time.Time `bson:"lastBuildDate,omitempty"`
+	UpdateIntervalMinutes int       `bson:"updateIntervalMinutes,omitempty"`
+
+	// Generator and Copyright are copied from the feed, HostingProvider is
+	// derived from where it and its audio are hosted, see hostingProvider.
+	// FeedType is the format of the feed as gofeed names it: rss, atom or
+	// json.
+	FeedType        string `bson:"feedType,omitempty"`
+	Generator       string `bson:"generator,omitempty"`
+	Copyright       string `bson:"copyright,omitempty"`
+	HostingProvider string `bson:"hostingProvider,omitempty"`
+
+	// LinkStatus is the status code Link answered with when --check-links
+	// last ran at LinkCheckedAt, zero if it couldn't be reached.
+	LinkStatus    int       `bson:"linkStatus,omitempty"`
+	LinkCheckedAt time.Time `bson:"linkCheckedAt,omitempty"`
+
+	// ImageWidth, ImageHeight and ImageFormat describe the artwork, as
+	// found by --inspect-images when it last looked at InspectedImage.
+	// They are zero and empty for artwork that couldn't be decoded.
+	ImageWidth     int    `bson:"imageWidth,omitempty"`
+	ImageHeight    int    `bson:"imageHeight,omitempty"`
+	ImageFormat    string `bson:"imageFormat,omitempty"`
+	InspectedImage string `bson:"inspectedImage,omitempty"`
+
+	// LastFeedOrder is the highest FeedOrder given to an episode of the
+	// podcast so far.
+	LastFeedOrder int `bson:"lastFeedOrder,omitempty"`
+
+	// FeedHash is the SHA-256 of the feed body whose episodes were last
+	// processed. A feed that comes back unchanged is not processed again.
+	FeedHash string `bson:"feedHash,omitempty"`
+
+	// Settings are exceptions made for this podcast with the set command.
+	Settings PodcastSettings `bson:"settings,omitempty"`
+
+	// RetiredAt is when the feed answered 410 Gone. Retired podcasts are
+	// no longer crawled.
+	RetiredAt time.Time `bson:"retiredAt,omitempty"`
+	// FailureCount is how many crawls in a row failed since FailingSince,
+	// the last with LastError. DeadAt is when that made the feed dead,
+	// see failureUpdate; dead podcasts are no longer crawled either.
+	FailureCount int       `bson:"failureCount,omitempty"`
+	FailingSince time.Time `bson:"failingSince,omitempty"`
+	LastError    string    `bson:"lastError,omitempty"`
+	DeadAt       time.Time `bson:"deadAt,omitempty"`
+
+	// Namespace is the catalogue the podcast belongs to, empty for the
+	// default namespace.
+	Namespace string `bson:"namespace,omitempty"`
 }
 
 type Episode struct {
@@ -46,15 +143,75 @@ type Episode struct {
 	PodcastTitle string             `bson:"podcastTitle,omitempty"`
 	PodcastImage string             `bson:"podcastImage,omitempty"`
 	Guid         string             `bson:"guid,omitempty"`
-	Title        string             `bson:"title,omitempty"`
-	Published    time.Time          `bson:"published,omitempty"`
-	Duration     string             `bson:"Duration,omitempty"`
-	Summary      string             `bson:"summary,omitempty"`
-	Subtitle     string             `bson:"subtitle,omitempty"`
-	Description  string             `bson:"description,omitempty"`
-	Image        string             `bson:"image,omitempty"`
-	Content      string             `bson:"content,omitempty"`
-	Enclosure    EpisodeEnclosure   `bson:"enclosure,omitempty"`
+	// StableID identifies the episode across databases, see
+	// episodeStableID.
+	StableID string `bson:"stableId,omitempty"`
+	// EpisodeLink is the web page of the episode, see episodeLink.
+	EpisodeLink string `bson:"link,omitempty"`
+	// NormalizedGuid is Guid passed through normalizeGUID. Episodes are
+	// matched against the feed by this value.
+	NormalizedGuid string           `bson:"normalizedGuid,omitempty"`
+	Title          string           `bson:"title,omitempty"`
+	Published      time.Time        `bson:"published,omitempty"`
+	Duration       string           `bson:"Duration,omitempty"`
+	Summary        string           `bson:"summary,omitempty"`
+	Subtitle       string           `bson:"subtitle,omitempty"`
+	Description    string           `bson:"description,omitempty"`
+	Image          string           `bson:"image,omitempty"`
+	Content        string           `bson:"content,omitempty"`
+	Enclosure      EpisodeEnclosure `bson:"enclosure,omitempty"`
+	// EnclosureKey is the enclosure URL passed through enclosureKey.
+	// Cross-posted episodes are found by this value.
+	EnclosureKey string `bson:"enclosureKey,omitempty"`
+	// Enclosures are all enclosures of an item that has more than one,
+	// and PrimaryEnclosureIndex the one of them that is Enclosure, see
+	// PrimaryEnclosure.
+	Enclosures            []EpisodeEnclosure `bson:"enclosures,omitempty"`
+	PrimaryEnclosureIndex int                `bson:"primaryEnclosureIndex,omitempty"`
+
+	WordCount          int `bson:"wordCount,omitempty"`
+	ReadingTimeSeconds int `bson:"readingTimeSeconds,omitempty"`
+
+	Soundbites  []Soundbite  `bson:"soundbites,omitempty"`
+	People      []Person     `bson:"people,omitempty"`
+	Transcripts []Transcript `bson:"transcripts,omitempty"`
+
+	// ChaptersURL is the podcast:chapters of the item. With --fetch-chapters
+	// its JSON chapters are embedded as Chapters, and FetchedChapters is the
+	// URL they were fetched from.
+	ChaptersURL     string    `bson:"chaptersUrl,omitempty"`
+	Chapters        []Chapter `bson:"chapters,omitempty"`
+	FetchedChapters string    `bson:"fetchedChapters,omitempty"`
+
+	// FeedOrder is where the episode was in the feed when it was ingested,
+	// counted from the bottom and across runs: a higher FeedOrder was
+	// higher up in the feed, which for newest-first feeds means newer. It
+	// is zero for episodes ingested before it existed.
+	FeedOrder int `bson:"feedOrder,omitempty"`
+
+	// DateEstimated is set when the feed had no usable publish date for
+	// the episode and Published was estimated, see estimatePublished.
+	DateEstimated bool `bson:"dateEstimated,omitempty"`
+
+	// DuplicateOf is the episode this one is a near duplicate of, if
+	// --dupes=mark found one, see handleDupes, or the episode of another
+	// podcast with the same audio, see linkCrossPosts.
+	DuplicateOf primitive.ObjectID `bson:"duplicateOf,omitempty"`
+
+	// AudioRevisedAt is set when the enclosure of a known episode changed,
+	// which usually means the publisher uploaded corrected audio.
+	AudioRevisedAt time.Time `bson:"audioRevisedAt,omitempty"`
+
+	// RemovedAt is set when the episode disappeared from its feed, see
+	// handleRemovedEpisodes. Removed episodes are left out of the API.
+	RemovedAt time.Time `bson:"removedAt,omitempty"`
+
+	// ContentHash is the hash of the text of the item the episode was
+	// last updated from, see refreshContent.
+	ContentHash string `bson:"contentHash,omitempty"`
+
+	// Namespace is the namespace of the podcast, see Podcast.Namespace.
+	Namespace string `bson:"namespace,omitempty"`
 }
 
 type PodcastOwner struct {
@@ -66,174 +223,685 @@ type EpisodeEnclosure struct {
 	Filesize string `bson:"filesize,omitempty"`
 	Filetype string `bson:"filetype,omitempty"`
 	Url      string `bson:"url,omitempty"`
+	// Size is Filesize parsed to bytes, zero if the feed didn't give a
+	// usable length.
+	Size int64 `bson:"size,omitempty"`
+
+	// Status is what the URL answered when --verify-enclosures checked it
+	// at CheckedAt, zero if it couldn't be reached. Dead is set if that
+	// was not a success.
+	Status    int       `bson:"status,omitempty"`
+	CheckedAt time.Time `bson:"checkedAt,omitempty"`
+	Dead      bool      `bson:"dead,omitempty"`
 }
 
 const (
-	mongoURI          = "mongodb://localhost" // Consider moving this to an environment variable
-	dbName            = "podgo"
-	podcastCollection = "podcasts"
-	episodeCollection = "episodes"
-	maxConcurrent     = 10 // Limit concurrent operations
+	mongoURI             = "mongodb://localhost" // Consider moving this to an environment variable
+	dbName               = "podgo"
+	podcastCollection    = "podcasts"
+	episodeCollection    = "episodes"
+	crawlRunCollection   = "crawl_runs"
+	quarantineCollection = "quarantine"
+	changeCollection     = "changes"
+	warningCollection    = "feed_warnings"
+	feedMetaCollection   = "feedmeta"
+	archiveCollection    = "episodes_archive"
+	feedListCollection   = "feeds"
+	userAgent            = "PodGo/1.0 (+https://github.com/Keldrik/PodGo)"
+	insertBatchSize      = 500 // Maximum number of episodes written at once
+)
+
+var (
+	httpClient = newHTTPClient()
+	hostLimits = newHostLimiter()
+	robots     *robotsCache
+
+	// podcastIndex guards the maps of known feeds and slugs, which are
+	// shared by all concurrently processed feeds.
+	podcastIndex sync.Mutex
 )
 
-func LoadFeed(ctx context.Context, url string) (*gofeed.Feed, error) {
+// LoadFeed fetches and parses the feed at url. Besides the feed it returns
+// the redirects that were followed to get it. It fails with a *FeedError.
+func LoadFeed(ctx context.Context, url string) (*gofeed.Feed, []feedRedirect, error) {
+	return loadFeed(ctx, url, FeedMeta{})
+}
+
+// loadFeed is LoadFeed with a conditional request if validators has any.
+// It returns errNotModified if the server answers that the feed is
+// unchanged.
+func loadFeed(ctx context.Context, url string, validators FeedMeta) (*gofeed.Feed, []feedRedirect, error) {
+	if err := checkFeedURL(ctx, url); err != nil {
+		return nil, nil, newFeedError(ctx, url, FeedRejected, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, newFeedError(ctx, url, FeedNetwork, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, newFeedError(ctx, url, FeedNetwork, err)
+	}
+	defer resp.Body.Close()
+	redirects := redirectChain(resp)
+	if resp.StatusCode == http.StatusNotModified && validators.conditional() {
+		return nil, redirects, errNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := gofeed.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		fe := newFeedError(ctx, url, FeedHTTPStatus, err)
+		if throttled(resp.StatusCode) {
+			fe.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, redirects, fe
+	}
+	if resp.ContentLength > config.MaxFeedSize {
+		return nil, redirects, newFeedError(ctx, url, FeedTooLarge, errFeedTooLarge)
+	}
+
+	// The feed is parsed straight off the wire and capped in size, which
+	// bounds what a single feed can cost in memory.
 	fp := gofeed.NewParser()
-	feed, err := fp.ParseURLWithContext(url, ctx)
+	fp.RSSTranslator = &rssTranslator{}
+	hash := sha256.New()
+	body := io.TeeReader(&sizeLimitReader{r: resp.Body, n: config.MaxFeedSize}, hash)
+	feed, err := fp.Parse(body)
 	if err != nil {
-		return nil, fmt.Errorf("feed error: %v", err)
+		return nil, redirects, newFeedError(ctx, url, FeedParse, err)
+	}
+	// The parser may stop at the end of the document, the hash covers
+	// anything after it as well.
+	if _, err := io.Copy(ioutil.Discard, body); err == errFeedTooLarge {
+		return nil, redirects, newFeedError(ctx, url, FeedTooLarge, err)
+	} else if err != nil {
+		return nil, redirects, newFeedError(ctx, url, FeedNetwork, err)
 	}
+	applyDefaultTimezone(feed, config.DefaultTimezone.Location)
+	if feed.Custom == nil {
+		feed.Custom = make(map[string]string)
+	}
+	feed.Custom[feedHashKey] = hex.EncodeToString(hash.Sum(nil))
+	feed.Custom[feedETagKey] = resp.Header.Get("ETag")
+	feed.Custom[feedLastModifiedKey] = resp.Header.Get("Last-Modified")
 	if len(feed.FeedLink) <= 0 {
 		feed.FeedLink = url
 	}
-	log.Printf("Feed Loaded: %s\n", url)
-	return feed, nil
+	infof(ctx, "Feed Loaded: %s", redactURL(url))
+	return feed, redirects, nil
+}
+
+// feedHashKey is the key of feed.Custom LoadFeed keeps the hash of the
+// feed body under, see Podcast.FeedHash.
+const feedHashKey = "podgo:hash"
+
+// errFeedTooLarge is returned for feeds larger than --max-feed-size.
+var errFeedTooLarge = errors.New("feed exceeds the maximum feed size")
+
+// sizeLimitReader reads from r until n bytes have been read and fails with
+// errFeedTooLarge after that, unlike io.LimitReader which just stops.
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
 }
 
-func GetTitleUrl(title string, otherPodcasts map[string]bool) string {
-	t := TitleUrl(title)
-	for otherPodcasts[t] {
-		t += "x"
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		return int(l.n), errFeedTooLarge
 	}
-	return t
+	l.n -= int64(n)
+	return n, err
 }
 
-func TitleUrl(title string) string {
-	t := strings.ToLower(title)
-	t = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss").Replace(t)
-	re := regexp.MustCompile(`[^a-zA-Z0-9 ]`)
-	t = re.ReplaceAllString(t, "")
-	t = regexp.MustCompile(` +`).ReplaceAllString(t, "-")
-	t = regexp.MustCompile(`-{2,10}`).ReplaceAllString(t, "-")
-	return url.PathEscape(t)
+// isTimeout reports whether err is a network timeout, e.g. of a TLS
+// handshake, as opposed to a refused connection or a bad response.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
-func processFeed(ctx context.Context, feed *gofeed.Feed, podcastsCollection, episodesCollection *mongo.Collection, existingPodcastFeeds map[string]bool, podcastTitles map[string]bool) error {
-	pTitleUrl := GetTitleUrl(feed.Title, podcastTitles)
+// cutShort reports whether err came from ctx, or a context derived from it,
+// running out of time or being cancelled, so the feed wasn't really tried.
+func cutShort(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// processFeed stores the podcast of feed and its new episodes. It returns
+// the podcast and the number of episodes inserted.
+func processFeed(ctx context.Context, feed *gofeed.Feed, curation feedCuration, store Store, existingPodcastFeeds map[string]bool, podcastTitles map[string]bool) (Podcast, int, error) {
+	if newURL := newFeedURL(feed); newURL != "" {
+		if err := migratePodcastFeed(ctx, store, feed.FeedLink, newURL, existingPodcastFeeds); err != nil {
+			return Podcast{}, 0, err
+		}
+		feed.FeedLink = newURL
+	}
+
+	// Nil items are nothing; the rest of the feed is judged by the items
+	// that could become episodes.
+	feed.Items = nonNilItems(feed.Items)
+	items, _ := usableItems(feed.Items)
 
 	var podcast Podcast
-	if existingPodcastFeeds[feed.FeedLink] {
-		log.Printf("Updating existing podcast... %s\n", pTitleUrl)
-		err := podcastsCollection.FindOne(ctx, bson.M{"feed": feed.FeedLink}).Decode(&podcast)
+	podcastIndex.Lock()
+	exists := existingPodcastFeeds[feed.FeedLink]
+	var pTitleUrl string
+	if !exists {
+		// Reserve the slug right away, so concurrently processed feeds
+		// with the same title can't pick it too.
+		var author string
+		if feed.ITunesExt != nil {
+			author = feed.ITunesExt.Author
+		}
+		pTitleUrl = GetTitleUrl(feed.Title, author, feed.FeedLink, podcastTitles)
+		podcastTitles[pTitleUrl] = true
+	}
+	podcastIndex.Unlock()
+	slugTurnFrom(ctx).done()
+
+	if exists {
+		var err error
+		podcast, err = store.PodcastByFeed(ctx, feed.FeedLink)
 		if err != nil {
-			return fmt.Errorf("error fetching existing podcast: %v", err)
+			return podcast, 0, fmt.Errorf("error fetching existing podcast: %v", err)
 		}
+		ctx = withLogAttrs(ctx, "podcast", podcast.PodlistUrl)
+		curation = podcast.Settings.curation(curation)
+		// A feed that is unchanged may still have had its curation in the
+		// feed list changed.
+		curated := reflect.DeepEqual(curation.categories(podcast.RawCategories), podcast.Categories)
+		if hash := feed.Custom[feedHashKey]; hash != "" && hash == podcast.FeedHash && curated {
+			debugf(ctx, "Feed %s is unchanged since the last crawl", redactURL(feed.FeedLink))
+			now := time.Now()
+			set := bson.M{"lastCrawledAt": now, "lastSuccessAt": now}
+			clearFailures(podcast, set)
+			if err := store.UpdatePodcast(ctx, podcast.ID, set); err != nil {
+				return podcast, 0, fmt.Errorf("error updating podcast: %v", err)
+			}
+			return podcast, 0, nil
+		}
+		infof(ctx, "Updating existing podcast... %s", podcast.PodlistUrl)
 		// Update podcast info if needed
-		updatePodcast(ctx, &podcast, feed, podcastsCollection)
+		updatePodcast(ctx, &podcast, feed, curation, store)
+		if config.SkipIfNoNewer && noNewerItems(items, podcast) {
+			debugf(ctx, "Feed %s has no items newer than %s", redactURL(feed.FeedLink), podcast.LatestEpisodeAt.Format(time.RFC3339))
+			return podcast, 0, nil
+		}
 	} else {
-		log.Printf("Creating new podcast... %s\n", pTitleUrl)
-		podcast = createNewPodcast(feed, pTitleUrl)
-		_, err := podcastsCollection.InsertOne(ctx, podcast)
+		ctx = withLogAttrs(ctx, "podcast", pTitleUrl)
+		infof(ctx, "Creating new podcast... %s", pTitleUrl)
+		podcast = createNewPodcast(feed, curation, pTitleUrl)
+		if errs := validatePodcast(podcast); len(errs) > 0 {
+			return podcast, 0, fmt.Errorf("invalid podcast: %s", strings.Join(errs, "; "))
+		}
+		err := store.InsertPodcast(ctx, &podcast)
 		if err != nil {
-			return fmt.Errorf("error inserting podcast: %v", err)
+			return podcast, 0, fmt.Errorf("error inserting podcast: %v", err)
 		}
+		stats.add(&stats.newPodcasts)
+		changes.record(podcast.Namespace, entityPodcast, podcast.ID, opCreate, nil)
+		podcastIndex.Lock()
 		existingPodcastFeeds[feed.FeedLink] = true
-		podcastTitles[pTitleUrl] = true
+		podcastIndex.Unlock()
 	}
 
+	artworkChecks.Inspect(store, podcast)
+	recordFeedWarnings(ctx, store, feed)
+
 	// Process episodes
-	err := processEpisodes(ctx, feed, podcast, episodesCollection)
+	inserted, deferred, err := processEpisodes(ctx, feed, podcast, store)
 	if err != nil {
-		return fmt.Errorf("error processing episodes: %v", err)
+		return podcast, 0, fmt.Errorf("error processing episodes: %v", err)
+	}
+	if config.Dupes != "" && inserted > 0 {
+		if groups, err := handleDupes(ctx, store, podcast, config.Dupes); err != nil {
+			errorf(ctx, "Error handling near duplicates of podcast %s: %v", podcast.Title, err)
+		} else {
+			logDupes(podcast, groups, config.Dupes)
+		}
+	}
+	if config.RemovedEpisodes != "" {
+		if err := handleRemovedEpisodes(ctx, store, podcast, items, config.RemovedEpisodes); err != nil {
+			errorf(ctx, "Error handling removed episodes of podcast %s: %v", podcast.Title, err)
+		}
+	}
+
+	// Only now the feed counts as processed, so one that failed halfway is
+	// processed again next time even if it didn't change. The same goes
+	// for one with episodes left for later runs.
+	hash := feed.Custom[feedHashKey]
+	if deferred > 0 {
+		hash = ""
+	}
+	if hash != podcast.FeedHash {
+		if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"feedHash": hash}); err != nil {
+			errorf(ctx, "Error updating podcast %s: %v", podcast.Title, err)
+		}
+	}
+
+	if err := store.RefreshPodcastStats(ctx, podcast.PodlistUrl); err != nil {
+		errorf(ctx, "Error updating stats for podcast %s: %v", podcast.Title, err)
 	}
 
-	return nil
+	return podcast, inserted, nil
 }
 
-func createNewPodcast(feed *gofeed.Feed, pTitleUrl string) Podcast {
+func createNewPodcast(feed *gofeed.Feed, curation feedCuration, pTitleUrl string) Podcast {
 	t := time.Now()
 	if feed.PublishedParsed != nil {
 		t = *feed.PublishedParsed
 	}
 
 	var o PodcastOwner
-	var subtitle, author, image string
+	var subtitle, author string
 	if feed.ITunesExt != nil {
 		if feed.ITunesExt.Owner != nil {
 			o = PodcastOwner{Name: feed.ITunesExt.Owner.Name, Email: feed.ITunesExt.Owner.Email}
 		}
 		subtitle = feed.ITunesExt.Subtitle
 		author = feed.ITunesExt.Author
-		image = feed.ITunesExt.Image
 	}
 
+	locked, lockedOwner := parseLocked(feed.Extensions)
 	return Podcast{
-		Title:       feed.Title,
-		Categories:  feed.Categories,
-		Link:        feed.Link,
-		Description: feed.Description,
-		Subtitle:    subtitle,
-		Owner:       o,
-		Author:      author,
-		Image:       image,
-		Feed:        feed.FeedLink,
-		PodlistUrl:  pTitleUrl,
-		Updated:     t,
+		Title:            feed.Title,
+		Categories:       curation.categories(feed.Categories),
+		RawCategories:    feed.Categories,
+		ITunesCategories: parseITunesCategories(feed.ITunesExt),
+		Link:             feed.Link,
+		Description:      feed.Description,
+		Subtitle:         subtitle,
+		Owner:            o,
+		Author:           author,
+		Image:            feedImage(feed),
+		Feed:             feed.FeedLink,
+		StableID:         podcastStableID(feed),
+		PodlistUrl:       pTitleUrl,
+		Updated:          t,
+		People:           parsePeople(feed.Extensions),
+		PodcastGUID:      parsePodcastGUID(feed.Extensions),
+		Funding:          parseFunding(feed.Extensions),
+		Locked:           locked,
+		LockedOwner:      lockedOwner,
+		FeedType:         feed.FeedType,
+		Generator:        feed.Generator,
+		Copyright:        feed.Copyright,
+		HostingProvider:  hostingProvider(feed),
+		LastCrawledAt:    time.Now(),
+		LastSuccessAt:    time.Now(),
+
+		LastBuildDate:         lastBuildDate(feed),
+		UpdateIntervalMinutes: updateIntervalMinutes(feed),
 	}
 }
 
-func updatePodcast(ctx context.Context, podcast *Podcast, feed *gofeed.Feed, podcastsCollection *mongo.Collection) {
+// trackedPodcastFields are the fields of a podcast whose changes bump
+// Podcast.Updated.
+var trackedPodcastFields = []string{"title", "categories", "link", "description", "subtitle", "author", "image"}
+
+func updatePodcast(ctx context.Context, podcast *Podcast, feed *gofeed.Feed, curation feedCuration, store Store) {
 	// Update fields that might have changed
 	update := bson.M{
-		"$set": bson.M{
-			"categories":  feed.Categories,
-			"link":        feed.Link,
-			"description": feed.Description,
-			"updated":     time.Now(),
-		},
+		"categories":    curation.categories(feed.Categories),
+		"rawCategories": feed.Categories,
+		"link":          feed.Link,
+		"people":        parsePeople(feed.Extensions),
+		"podcastGuid":   parsePodcastGUID(feed.Extensions),
+		"funding":       parseFunding(feed.Extensions),
+
+		"feedType":        feed.FeedType,
+		"generator":       feed.Generator,
+		"copyright":       feed.Copyright,
+		"hostingProvider": hostingProvider(feed),
+
+		// A feed that made it here was fetched and parsed fine.
+		"lastCrawledAt": time.Now(),
+		"lastSuccessAt": time.Now(),
+
+		"lastBuildDate":         lastBuildDate(feed),
+		"updateIntervalMinutes": updateIntervalMinutes(feed),
+	}
+
+	update["locked"], update["lockedOwner"] = parseLocked(feed.Extensions)
+	clearFailures(*podcast, update)
+
+	if !podcast.Settings.SkipDescriptionUpdates {
+		update["description"] = feed.Description
 	}
 
 	if feed.ITunesExt != nil {
-		update["$set"].(bson.M)["subtitle"] = feed.ITunesExt.Subtitle
-		update["$set"].(bson.M)["author"] = feed.ITunesExt.Author
-		update["$set"].(bson.M)["image"] = feed.ITunesExt.Image
+		update["subtitle"] = feed.ITunesExt.Subtitle
+		update["author"] = feed.ITunesExt.Author
+		update["itunesCategories"] = parseITunesCategories(feed.ITunesExt)
 	}
 
-	_, err := podcastsCollection.UpdateOne(ctx, bson.M{"_id": podcast.ID}, update)
+	// A feed that lost its artwork keeps the one we have.
+	if image := feedImage(feed); image != "" {
+		update["image"] = image
+	}
+
+	// Podcasts stored before stable IDs existed get one now.
+	if podcast.StableID == "" {
+		update["stableId"] = podcastStableID(feed)
+	}
+
+	// Updated tells when the show itself last changed, not when it was
+	// last crawled.
+	tracked := bson.M{}
+	for _, k := range trackedPodcastFields {
+		if v, ok := update[k]; ok {
+			tracked[k] = v
+		}
+	}
+	if len(changedFields(*podcast, tracked)) > 0 {
+		update["updated"] = time.Now()
+	}
+
+	err := store.UpdatePodcast(ctx, podcast.ID, update)
 	if err != nil {
-		log.Printf("Error updating podcast %s: %v\n", podcast.Title, err)
+		errorf(ctx, "Error updating podcast %s: %v", podcast.Title, err)
+		return
+	}
+	changes.podcastUpdated(*podcast, update)
+	if id, ok := update["stableId"].(string); ok {
+		podcast.StableID = id
+	}
+
+	// Episodes carry a copy of the podcast image, see createEpisode.
+	if image, ok := update["image"].(string); ok && image != podcast.Image {
+		n, err := store.SetEpisodesPodcastImage(ctx, podcast.PodlistUrl, image)
+		if err != nil {
+			errorf(ctx, "Error updating episode images of podcast %s: %v", podcast.Title, err)
+			return
+		}
+		debugf(ctx, "Podcast %s changed its image, updated %d episodes", podcast.Title, n)
+		podcast.Image = image
 	}
 }
 
-func processEpisodes(ctx context.Context, feed *gofeed.Feed, podcast Podcast, episodesCollection *mongo.Collection) error {
-	existingEpisodes := make(map[string]bool)
-	cursor, err := episodesCollection.Find(ctx, bson.M{"podcastUrl": podcast.PodlistUrl})
+// processEpisodes inserts the episodes of feed that aren't stored yet and
+// returns how many there were.
+func processEpisodes(ctx context.Context, feed *gofeed.Feed, podcast Podcast, store Store) (int, int, error) {
+	items, empty := usableItems(feed.Items)
+	for _, item := range empty {
+		warnf(ctx, "Podcast %s has an item without title, enclosure or content, skipping GUID %q", podcast.Title, item.GUID)
+	}
+	items, dropped := uniqueItems(items)
+	for _, item := range dropped {
+		warnf(ctx, "Podcast %s has more than one item with GUID %q, skipping %q", podcast.Title, item.GUID, item.Title)
+	}
+	existingEpisodes, err := storedGUIDs(ctx, store, podcast.PodlistUrl, items)
 	if err != nil {
-		return fmt.Errorf("error fetching existing episodes: %v", err)
+		return 0, 0, fmt.Errorf("error fetching existing episodes: %v", err)
+	}
+
+	cutoff := config.Cutoff(time.Now())
+	tooOld := 0
+	inserted := 0
+	quarantined := 0
+	now := time.Now()
+
+	// New episodes are written in batches, so a full archive feed never
+	// has to be held as episodes all at once.
+	var newEpisodes []Episode
+	flush := func() error {
+		if len(newEpisodes) == 0 {
+			return nil
+		}
+		for i := range newEpisodes {
+			newEpisodes[i].ID = primitive.NewObjectID()
+		}
+		if config.LinkDuplicates {
+			linkCrossPosts(ctx, store, podcast, newEpisodes)
+		}
+		// Another run may have stored some of them in the meantime; only
+		// those inserted here are announced.
+		stored, err := store.InsertEpisodes(ctx, newEpisodes)
+		newEpisodes = nil
+		if err != nil {
+			return fmt.Errorf("error inserting new episodes: %v", err)
+		}
+		if len(stored) == 0 {
+			return nil
+		}
+		for _, e := range stored {
+			changes.record(podcast.Namespace, entityEpisode, e.ID, opCreate, nil)
+		}
+		inserted += len(stored)
+		webhooks.Notify(podcast, stored)
+		searchIndex.IndexEpisodes(stored)
+		enclosureChecks.Check(store, stored)
+		chapterFetches.Fetch(store, podcast, stored)
+		return nil
 	}
-	var episodes []Episode
-	if err := cursor.All(ctx, &episodes); err != nil {
-		return fmt.Errorf("error decoding existing episodes: %v", err)
+
+	// New items are numbered above all earlier ones, from the bottom of
+	// the feed up. Numbers are taken before anything is inserted, so they
+	// are never given out twice even if inserting fails halfway.
+	fresh := 0
+	for _, e := range items {
+		if e.ITunesExt != nil && !existingEpisodes[itemKey(e)] {
+			fresh++
+		}
 	}
-	for _, e := range episodes {
-		existingEpisodes[e.Guid] = true
+	nextOrder := podcast.LastFeedOrder + fresh
+	if fresh > 0 {
+		if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"lastFeedOrder": nextOrder}); err != nil {
+			return 0, 0, fmt.Errorf("error updating podcast: %v", err)
+		}
 	}
 
-	var newEpisodes []interface{}
-	for _, e := range feed.Items {
+	estimated := estimatePublished(items, now)
+	beyondMax := beyondMaxEpisodes(items, podcast.Settings.MaxEpisodes, estimated)
+	excess := excessItems(items, existingEpisodes, cutoff, estimated)
+	tooMany := 0
+	deferred := 0
+
+	var knownItems []*gofeed.Item
+	for _, e := range items {
 		if e.ITunesExt != nil {
-			if !existingEpisodes[e.GUID] {
-				episode := createEpisode(e, podcast)
-				newEpisodes = append(newEpisodes, episode)
+			if existingEpisodes[itemKey(e)] {
+				knownItems = append(knownItems, e)
+				continue
+			}
+			order := nextOrder
+			nextOrder--
+			if beforeCutoff(e, cutoff) {
+				tooOld++
+				continue
+			}
+			if beyondMax[e] {
+				tooMany++
+				continue
+			}
+			if excess[e] {
+				deferred++
+				continue
+			}
+			episode := createEpisode(e, podcast)
+			episode.FeedOrder = order
+			if t, ok := estimated[e]; ok {
+				episode.Published = t
+				episode.DateEstimated = true
+			}
+			if errs := validateEpisode(episode, now); len(errs) > 0 {
+				quarantineEpisode(ctx, store, episode, errs)
+				quarantined++
+				continue
+			}
+			newEpisodes = append(newEpisodes, episode)
+			if len(newEpisodes) >= insertBatchSize {
+				if err := flush(); err != nil {
+					return inserted, 0, err
+				}
 			}
 		}
 	}
+	if err := flush(); err != nil {
+		return inserted, 0, err
+	}
+	if quarantined > 0 {
+		infof(ctx, "Quarantined %d invalid episodes of podcast %s", quarantined, podcast.Title)
+		stats.addQuarantined(podcast.Feed, quarantined)
+	}
+	if tooOld > 0 {
+		infof(ctx, "Skipped %d episodes published before %s for podcast %s", tooOld, cutoff.Format("2006-01-02"), podcast.Title)
+	}
+	if tooMany > 0 {
+		infof(ctx, "Skipped %d episodes beyond the newest %d of podcast %s", tooMany, podcast.Settings.MaxEpisodes, podcast.Title)
+	}
+	if deferred > 0 {
+		infof(ctx, "Left %d older new episodes of podcast %s for later runs", deferred, podcast.Title)
+	}
 
-	if len(newEpisodes) > 0 {
-		var operations []mongo.WriteModel
-		for _, episode := range newEpisodes {
-			operations = append(operations, mongo.NewInsertOneModel().SetDocument(episode))
-		}
+	if inserted > 0 {
+		infof(ctx, "Inserted %d new episodes for podcast %s", inserted, podcast.Title)
+	} else {
+		infof(ctx, "No new episodes for podcast %s", podcast.Title)
+	}
 
-		_, err = episodesCollection.BulkWrite(ctx, operations)
+	if len(knownItems) > 0 {
+		known, err := storedEpisodes(ctx, store, podcast.PodlistUrl, knownItems)
 		if err != nil {
-			return fmt.Errorf("error inserting new episodes: %v", err)
+			errorf(ctx, "Error fetching known episodes of podcast %s: %v", podcast.Title, err)
+		} else {
+			if err := refreshCredits(ctx, store, podcast, known, knownItems); err != nil {
+				errorf(ctx, "Error refreshing soundbites, people, transcripts and chapters for podcast %s: %v", podcast.Title, err)
+			}
+			if err := refreshEnclosures(ctx, store, podcast, known, knownItems); err != nil {
+				errorf(ctx, "Error refreshing enclosures for podcast %s: %v", podcast.Title, err)
+			}
+			if err := refreshContent(ctx, store, podcast, known, knownItems); err != nil {
+				errorf(ctx, "Error refreshing episodes of podcast %s: %v", podcast.Title, err)
+			}
 		}
-		log.Printf("Inserted %d new episodes for podcast %s\n", len(newEpisodes), podcast.Title)
-	} else {
-		log.Printf("No new episodes for podcast %s\n", podcast.Title)
 	}
 
-	return nil
+	return inserted, deferred, nil
+}
+
+// noNewerItems reports whether none of items, those of a feed, was
+// published after the latest stored episode of podcast. Feeds without any
+// dates never qualify, nor do podcasts without episodes.
+func noNewerItems(items []*gofeed.Item, podcast Podcast) bool {
+	if podcast.LatestEpisodeAt.IsZero() {
+		return false
+	}
+	dated := false
+	for _, e := range items {
+		if e.PublishedParsed == nil {
+			continue
+		}
+		if e.PublishedParsed.After(podcast.LatestEpisodeAt) {
+			return false
+		}
+		dated = true
+	}
+	return dated
+}
+
+// excessItems returns the new items not published before cutoff that are
+// beyond the newest --max-episodes-per-feed of them. They are left for
+// later runs, so a huge feed is ingested a bit at a time.
+func excessItems(items []*gofeed.Item, existing map[string]bool, cutoff time.Time, estimated map[*gofeed.Item]time.Time) map[*gofeed.Item]bool {
+	if config.MaxEpisodesPerFeed <= 0 {
+		return nil
+	}
+	var fresh []*gofeed.Item
+	for _, e := range items {
+		if e.ITunesExt != nil && !existing[itemKey(e)] && !beforeCutoff(e, cutoff) {
+			fresh = append(fresh, e)
+		}
+	}
+	return allButNewest(fresh, config.MaxEpisodesPerFeed, estimated)
+}
+
+// beyondMaxEpisodes returns the items of the feed beyond the newest max of
+// them, which the settings of the podcast say not to ingest at all.
+func beyondMaxEpisodes(items []*gofeed.Item, max int, estimated map[*gofeed.Item]time.Time) map[*gofeed.Item]bool {
+	if max <= 0 {
+		return nil
+	}
+	var episodes []*gofeed.Item
+	for _, e := range items {
+		if e.ITunesExt != nil {
+			episodes = append(episodes, e)
+		}
+	}
+	return allButNewest(episodes, max, estimated)
+}
+
+// allButNewest returns the items beyond the newest n of items, going by
+// their publish date or the estimate of it.
+func allButNewest(items []*gofeed.Item, n int, estimated map[*gofeed.Item]time.Time) map[*gofeed.Item]bool {
+	if len(items) <= n {
+		return nil
+	}
+	published := func(e *gofeed.Item) time.Time {
+		if t, ok := estimated[e]; ok {
+			return t
+		}
+		return *e.PublishedParsed
+	}
+	sorted := append([]*gofeed.Item(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return published(sorted[i]).After(published(sorted[j]))
+	})
+	rest := make(map[*gofeed.Item]bool)
+	for _, e := range sorted[n:] {
+		rest[e] = true
+	}
+	return rest
+}
+
+// beforeCutoff reports whether item was published before cutoff and should
+// therefore not be ingested. A zero cutoff lets everything through; items
+// without a publish date are kept unless --skip-undated is set.
+func beforeCutoff(item *gofeed.Item, cutoff time.Time) bool {
+	if cutoff.IsZero() {
+		return false
+	}
+	if item.PublishedParsed == nil {
+		return config.SkipUndated
+	}
+	return item.PublishedParsed.Before(cutoff)
+}
+
+// nonNilItems returns items without the nil ones.
+func nonNilItems(items []*gofeed.Item) []*gofeed.Item {
+	out := items[:0:0]
+	for _, item := range items {
+		if item != nil {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// usableItems returns the items that have a title, an enclosure or some
+// content, and the items it left out. Nothing could be shown of the others.
+func usableItems(items []*gofeed.Item) (usable, empty []*gofeed.Item) {
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		if strings.TrimSpace(item.Title) == "" && len(itemEnclosures(item)) == 0 &&
+			strings.TrimSpace(item.Content) == "" && strings.TrimSpace(item.Description) == "" {
+			empty = append(empty, item)
+			continue
+		}
+		usable = append(usable, item)
+	}
+	return usable, empty
 }
 
 func createEpisode(e *gofeed.Item, podcast Podcast) Episode {
@@ -241,179 +909,760 @@ func createEpisode(e *gofeed.Item, podcast Podcast) Episode {
 	if e.PublishedParsed != nil {
 		et = *e.PublishedParsed
 	}
+	enclosures := itemEnclosures(e)
 	var ee EpisodeEnclosure
-	if e.Enclosures != nil && len(e.Enclosures) > 0 {
-		ee = EpisodeEnclosure{
-			Filetype: e.Enclosures[0].Type,
-			Filesize: e.Enclosures[0].Length,
-			Url:      e.Enclosures[0].URL,
-		}
+	primary := 0
+	if len(enclosures) > 0 {
+		primary = config.PreferredEnclosure(enclosures)
+		ee = enclosures[primary]
+	}
+	if len(enclosures) < 2 {
+		enclosures, primary = nil, 0
 	}
 
-	var duration, summary, subtitle, image string
+	var duration, summary, subtitle string
 	if e.ITunesExt != nil {
 		duration = e.ITunesExt.Duration
 		summary = e.ITunesExt.Summary
 		subtitle = e.ITunesExt.Subtitle
-		image = e.ITunesExt.Image
 	}
 
+	notes := e.Content
+	if strings.TrimSpace(notes) == "" {
+		notes = e.Description
+	}
+	words, readingSeconds := showNotesStats(notes)
+
 	return Episode{
-		PodlistUrl:   GetTitleUrl(e.Title, make(map[string]bool)),
-		PodcastUrl:   podcast.PodlistUrl,
-		PodcastTitle: podcast.Title,
-		PodcastImage: podcast.Image,
-		Guid:         e.GUID,
-		Title:        e.Title,
-		Published:    et,
-		Duration:     duration,
-		Summary:      summary,
-		Subtitle:     subtitle,
-		Description:  e.Description,
-		Image:        image,
-		Content:      e.Content,
-		Enclosure:    ee,
+		PodlistUrl:     episodeSlug(e.Title, e.GUID, ee.Url),
+		PodcastId:      podcast.ID,
+		PodcastUrl:     podcast.PodlistUrl,
+		PodcastTitle:   podcast.Title,
+		PodcastImage:   podcast.Image,
+		Guid:           e.GUID,
+		StableID:       episodeStableID(podcast.StableID, normalizeGUID(e.GUID)),
+		EpisodeLink:    episodeLink(e),
+		NormalizedGuid: normalizeGUID(e.GUID),
+		Title:          e.Title,
+		Published:      et,
+		Duration:       duration,
+		Summary:        summary,
+		Subtitle:       subtitle,
+		Description:    e.Description,
+		Image:          itemImage(e, podcast),
+		Content:        e.Content,
+		Enclosure:      ee,
+		EnclosureKey:   enclosureKey(ee.Url),
+
+		Enclosures:            enclosures,
+		PrimaryEnclosureIndex: primary,
+
+		WordCount:          words,
+		ReadingTimeSeconds: readingSeconds,
+
+		Soundbites:  parseSoundbites(e.Extensions),
+		People:      parsePeople(e.Extensions),
+		Transcripts: parseTranscripts(e.Extensions),
+		ChaptersURL: parseChaptersURL(e.Extensions),
+
+		ContentHash: itemContent(e).hash(),
+
+		Namespace: podcast.Namespace,
 	}
 }
 
 func main() {
-	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Second)
+	if err := parseFlags(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	setupLogging(config.LogFormat, config.LogLevel)
+	hostLimits.maxActive = config.HostConcurrency
+	if config.HostRate > 0 {
+		hostLimits.delay = time.Duration(float64(time.Second) / config.HostRate)
+	}
+	robots = newRobotsCache(config.RobotsTTL, hostLimits)
+	if config.WebhookURL != "" {
+		webhooks = newWebhookNotifier(config.WebhookURL, config.WebhookTimeout)
+		defer webhooks.Close()
+	}
+	if config.SearchURL != "" {
+		indexer := newHTTPIndexer(config.SearchURL, config.SearchIndex, config.SearchAPIKey)
+		searchIndex = indexer
+		defer indexer.Close()
+	}
+
+	if config.MaxRuntime > 0 {
+		budget = newRunBudget(time.Now(), config.MaxRuntime)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runTimeout())
 	defer cancel()
 
-	client := connectToMongoDB(ctx)
-	defer client.Disconnect(ctx)
+	store, err := openStore(ctx, config.Store)
+	if err != nil {
+		fatalf(ctx, "Failed to open store: %v", err)
+	}
+	defer closeStore(store)
+	// fatalf skips deferred calls, so failing from here on closes the
+	// store first.
+	failf := func(format string, args ...interface{}) {
+		closeStore(store)
+		fatalf(ctx, format, args...)
+	}
+
+	// A health check must not change anything, so it comes before Init.
+	if config.PingOnly {
+		if err := store.Check(ctx); err != nil {
+			failf("Store check failed: %v", err)
+		}
+		infof(ctx, "Store is reachable and up to date")
+		return
+	}
+
+	// The GUID repair removes the duplicates a unique index fails on, so
+	// it runs anyway.
+	if err := store.Init(ctx); err != nil {
+		if !config.RepairGUIDs && config.Command != "dedupe-episodes" {
+			failf("Failed to initialize store: %v", err)
+		}
+		warnf(ctx, "Failed to initialize store: %v", err)
+	}
+	// Maintenance commands work on the namespace given by --namespace.
+	nsStore := store.InNamespace(config.Namespace)
+
+	if config.RepairGUIDs {
+		if err := repairGUIDs(ctx, nsStore); err != nil {
+			failf("Failed to repair GUIDs: %v", err)
+		}
+		return
+	}
+
+	// dedupe-episodes is --repair-guids followed by another go at the
+	// unique episode index, which fails to build while duplicates exist.
+	if config.Command == "dedupe-episodes" {
+		if err := repairGUIDs(ctx, nsStore); err != nil {
+			failf("Failed to remove duplicate episodes: %v", err)
+		}
+		if err := store.Init(ctx); err != nil {
+			failf("Failed to create indexes: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "history" {
+		if err := printHistory(ctx, nsStore, config.HistoryPodcast); err != nil {
+			failf("Failed to show history: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "discover" {
+		if err := discover(ctx, nsStore, config.CommandArgs[0], config.FeedsFile, config.Add); err != nil {
+			failf("Failed to discover feeds: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "add" {
+		if err := addFeed(ctx, nsStore, config.FeedsFile, config.CommandArgs[0]); err != nil {
+			failf("Failed to add feed: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "import-feeds" {
+		if err := importFeeds(ctx, nsStore, config.CommandArgs[0]); err != nil {
+			failf("Failed to import feeds: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "enable" || config.Command == "disable" {
+		if err := enableFeed(ctx, nsStore, config.CommandArgs[0], config.Command == "enable"); err != nil {
+			failf("Failed to %s feed: %v", config.Command, err)
+		}
+		return
+	}
+
+	if config.Command == "remove" {
+		if err := removeFeed(ctx, nsStore, config.FeedsFile, config.CommandArgs[0]); err != nil {
+			failf("Failed to remove feed: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "list" {
+		if err := listFeeds(ctx, store, config.FeedsFile); err != nil {
+			failf("Failed to list feeds: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "rename" {
+		if err := renamePodcast(ctx, nsStore, config.CommandArgs[0], config.CommandArgs[1]); err != nil {
+			failf("Failed to rename podcast: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "set" {
+		if err := setPodcastSetting(ctx, nsStore, config.CommandArgs[0], config.CommandArgs[1]); err != nil {
+			failf("Failed to change settings: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "sitemap" {
+		if err := writeSitemap(ctx, nsStore, config.BaseURL, config.Output); err != nil {
+			failf("Failed to write sitemap: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "assign-namespace" {
+		feeds := loadFeeds(ctx, store, config.FeedsFile)
+		if err := assignNamespace(ctx, nsStore, feeds, config.CommandArgs[0]); err != nil {
+			failf("Failed to assign namespace: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "changes" {
+		since, err := parseChangesSince(config.CommandArgs[0])
+		if err != nil {
+			failf("Failed to show changes: %v", err)
+		}
+		if err := printChanges(ctx, store, since); err != nil {
+			failf("Failed to show changes: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "find-dupes" {
+		var slug string
+		if len(config.CommandArgs) > 0 {
+			slug = config.CommandArgs[0]
+		}
+		action := config.Dupes
+		if action == "" {
+			action = dupesLog
+		}
+		if err := findDupes(ctx, nsStore, slug, action); err != nil {
+			failf("Failed to find duplicates: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "export" {
+		if err := exportOPML(ctx, nsStore, os.Stdout); err != nil {
+			failf("Failed to export: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "serve" {
+		if err := serve(nsStore, config.Listen, config.Watch); err != nil {
+			failf("Failed to serve: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "dead" {
+		if err := printDeadFeeds(ctx, nsStore); err != nil {
+			failf("Failed to list dead feeds: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "revive" {
+		if err := revivePodcast(ctx, nsStore, config.CommandArgs[0]); err != nil {
+			failf("Failed to revive podcast: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "warnings" {
+		if err := printFeedWarnings(ctx, store); err != nil {
+			failf("Failed to show feed warnings: %v", err)
+		}
+		return
+	}
+
+	if config.Command == "stats" {
+		if err := printCatalogueStats(ctx, nsStore); err != nil {
+			failf("Failed to show stats: %v", err)
+		}
+		return
+	}
+
+	if config.CheckLinks {
+		if err := checkLinks(ctx, nsStore); err != nil {
+			failf("Failed to check links: %v", err)
+		}
+		return
+	}
+
+	if config.BackfillPodcastIDs {
+		if err := backfillPodcastIDs(ctx, nsStore); err != nil {
+			failf("Failed to backfill podcast IDs: %v", err)
+		}
+		return
+	}
+
+	if config.RefreshEpisodeImages {
+		if err := refreshEpisodeImages(ctx, nsStore); err != nil {
+			failf("Failed to refresh episode images: %v", err)
+		}
+		return
+	}
 
-	database := client.Database(dbName)
-	podcastsCollection := database.Collection(podcastCollection)
-	episodesCollection := database.Collection(episodeCollection)
+	if config.ExportJSON != "" {
+		if err := exportJSON(ctx, nsStore, config.ExportJSON); err != nil {
+			failf("Failed to export: %v", err)
+		}
+		return
+	}
 
-	createIndexes(ctx, podcastsCollection, episodesCollection)
+	if config.ImportJSON != "" {
+		if err := importJSONDump(ctx, nsStore, config.ImportJSON); err != nil {
+			failf("Failed to import: %v", err)
+		}
+		return
+	}
 
-	feeds := loadFeedsFromJSON("bak/feedbak.json")
-	log.Printf("%d Podcast Feeds loaded from JSON File!\n", len(feeds))
+	if config.Reprocess {
+		if err := reprocess(ctx, nsStore); err != nil {
+			failf("Failed to reprocess: %v", err)
+		}
+		return
+	}
 
-	existingPodcastFeeds, podcastTitles := loadExistingPodcasts(ctx, podcastsCollection)
+	if config.BackfillStats || config.Recount {
+		n, err := nsStore.BackfillPodcastStats(ctx)
+		if err != nil {
+			failf("Failed to backfill podcast stats: %v", err)
+		}
+		infof(ctx, "Backfilled stats for %d podcasts", n)
+		return
+	}
 
-	processFeedsInBatches(ctx, feeds, podcastsCollection, episodesCollection, existingPodcastFeeds, podcastTitles)
+	feeds := loadFeeds(ctx, store, config.FeedsFile)
+	setFeedCurations(feeds)
+	if config.Only != "" {
+		if feeds, err = onlyFeed(ctx, store, feeds, config.Only); err != nil {
+			failf("Failed to find feed: %v", err)
+		}
+		infof(ctx, "Crawling only %s", redactURL(feeds[0].URL))
+	}
 
-	log.Println("All feeds processed!")
+	if config.MetricsListen != "" {
+		metrics = newCrawlMetrics()
+		store = timedStore{store}
+		go serveMetrics(metrics, config.MetricsListen)
+	}
+	if config.Daemon {
+		runDaemon(store, feeds)
+		return
+	}
+	crawl(ctx, store, feeds)
 }
 
-func connectToMongoDB(ctx context.Context) *mongo.Client {
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		log.Fatalf("Failed to create MongoDB client: %v", err)
+// crawl fetches feeds and stores what they hold in store. All it needs
+// from outside are the store and the package level httpClient, so it can
+// run against any Store, like the memory store, and any HTTP client.
+func crawl(ctx context.Context, store Store, feeds []feedEntry) {
+	// Each namespace is crawled on its own, so known feeds and taken slugs
+	// are those of the namespace.
+	type namespaceCrawl struct {
+		name                 string
+		store                Store
+		feeds                []string
+		existingPodcastFeeds map[string]bool
+		podcastTitles        map[string]bool
+	}
+	// The daemon has its own way to pick up where it left off, see
+	// crawlScheduler.
+	var resumed *CrawlRun
+	if !config.Restart && scheduler == nil && config.Only == "" {
+		resumed = resumableRun(ctx, store)
+	}
+	var crawls []namespaceCrawl
+	total := 0
+	namespaces, groups := groupByNamespace(feeds, config.Namespace)
+	for _, ns := range namespaces {
+		c := namespaceCrawl{name: ns, store: store.InNamespace(ns)}
+		var retiredFeeds map[string]bool
+		c.existingPodcastFeeds, c.podcastTitles, retiredFeeds = loadExistingPodcasts(ctx, c.store)
+		c.feeds = withoutCompleted(withoutRetired(groups[ns], retiredFeeds), resumed)
+		crawls = append(crawls, c)
+		total += len(c.feeds)
+	}
+	if resumed != nil {
+		infof(ctx, "Resuming the crawl started %s, %d feeds done already; --restart starts over",
+			resumed.StartedAt.Local().Format("2006-01-02 15:04"), len(resumed.Completed))
 	}
 
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB server: %v", err)
+	if config.Progress || isTerminal(os.Stdout) {
+		progress = newProgressReporter(total, isTerminal(os.Stdout), config.ProgressEvery)
+	}
+	crawlRun = startCrawlRun(ctx, store, total, resumed)
+	if config.Report != "" {
+		report = newRunReporter(total)
+	}
+	if crawlRun != nil {
+		ctx = withLogAttrs(ctx, "run", crawlRun.run.ID.Hex())
+	}
+	changes = startChangeLog(store, crawlRun)
+	if config.VerifyEnclosures {
+		enclosureChecks = newEnclosureVerifier(ctx, config.EpisodeConcurrency, config.EnclosureCheckDelay)
+	}
+	if config.InspectImages {
+		artworkChecks = newArtworkInspector(ctx, config.Concurrency)
 	}
+	if config.FetchChapters {
+		chapterFetches = newChaptersFetcher(ctx, config.EpisodeConcurrency)
+	}
+	infof(ctx, "Crawling in batches of %d feeds, %d at a time, at least %s between batches", config.BatchSize, config.Concurrency, config.BatchDelay)
+	for _, c := range crawls {
+		if len(crawls) > 1 {
+			infof(ctx, "Crawling %d feeds of namespace %s", len(c.feeds), c.name)
+		}
+		processFeedsInBatches(ctx, c.feeds, c.store, c.existingPodcastFeeds, c.podcastTitles)
+	}
+	enclosureChecks.Close()
+	artworkChecks.Close()
+	chapterFetches.Close()
+	progress.finish()
+	changes.flush()
+	crawlRun.finish(ctx.Err() != nil || budget.stopped())
+	metrics.runFinished()
 
-	log.Println("Successfully connected to MongoDB")
-	return client
+	infof(ctx, "All feeds processed!")
+	if err := feedMoves.apply(store, config.FeedsFile); err != nil {
+		errorf(ctx, "Error updating feed list: %v", err)
+	}
+	stats.logSummary()
+	budget.logSummary()
+	if err := report.write(config.Report, ctx.Err() != nil || budget.stopped()); err != nil {
+		errorf(ctx, "Error writing report: %v", err)
+	}
 }
 
-func createIndexes(ctx context.Context, podcastsCollection, episodesCollection *mongo.Collection) {
-	_, err := podcastsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "podlistUrl", Value: 1}},
-	})
-	if err != nil {
-		log.Printf("Error creating index on podcasts collection: %v\n", err)
+// stdinFeeds is the --feeds value that reads the feed list from stdin.
+const stdinFeeds = "-"
+
+// loadFeeds loads the feed list from filename, which is JSON or, with an
+// .opml extension, OPML, from stdin if filename is stdinFeeds, or from
+// store if it is storeFeeds.
+func loadFeeds(ctx context.Context, store Store, filename string) []feedEntry {
+	if filename == storeFeeds {
+		feeds := loadListedFeeds(ctx, store)
+		infof(ctx, "%d Podcast Feeds loaded from the store!", len(feeds))
+		return feeds
+	}
+	if filename == stdinFeeds {
+		feeds := loadFeedsFromLines(os.Stdin)
+		infof(ctx, "%d Podcast Feeds loaded from stdin!", len(feeds))
+		return feeds
 	}
+	if isOPML(filename) {
+		feeds := loadFeedsFromOPML(filename)
+		infof(ctx, "%d Podcast Feeds loaded from OPML File!", len(feeds))
+		return feeds
+	}
+	feeds := loadFeedsFromJSON(filename)
+	infof(ctx, "%d Podcast Feeds loaded from JSON File!", len(feeds))
+	return feeds
+}
 
-	_, err = episodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "podcastUrl", Value: 1}},
-	})
-	if err != nil {
-		log.Printf("Error creating index on episodes collection: %v\n", err)
+// loadFeedsFromLines reads one feed URL per line from r. Blank lines and
+// lines starting with # are skipped, and so are lines that aren't URLs.
+func loadFeedsFromLines(r io.Reader) []feedEntry {
+	var feeds []feedEntry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isHTTPURL(line) {
+			warnf(context.Background(), "line %d is not a feed URL: %q", n, line)
+			continue
+		}
+		feeds = append(feeds, feedEntry{URL: line})
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf(context.Background(), "Failed to read feeds: %v", err)
 	}
+	return feeds
 }
 
-func loadFeedsFromJSON(filename string) []string {
+func loadFeedsFromJSON(filename string) []feedEntry {
 	jsonFile, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Failed to open JSON file: %v", err)
+		fatalf(context.Background(), "Failed to open JSON file: %v", err)
 	}
 	defer jsonFile.Close()
 
 	byteValue, _ := ioutil.ReadAll(jsonFile)
-	var feeds []string
+	var feeds []feedEntry
 	if err := json.Unmarshal(byteValue, &feeds); err != nil {
-		log.Fatalf("Failed to unmarshal JSON: %v", err)
+		fatalf(context.Background(), "Failed to unmarshal JSON: %v", err)
 	}
 
 	return feeds
 }
 
-func loadExistingPodcasts(ctx context.Context, podcastsCollection *mongo.Collection) (map[string]bool, map[string]bool) {
+// loadExistingPodcasts returns the feeds and slugs of all stored podcasts,
+// and the feeds of the retired and dead ones.
+func loadExistingPodcasts(ctx context.Context, store Store) (map[string]bool, map[string]bool, map[string]bool) {
 	existingPodcastFeeds := make(map[string]bool)
 	podcastTitles := make(map[string]bool)
+	retiredFeeds := make(map[string]bool)
 
-	cursor, err := podcastsCollection.Find(ctx, bson.M{})
+	podcasts, err := store.Podcasts(ctx)
 	if err != nil {
-		log.Fatalf("Failed to fetch existing podcasts: %v", err)
-	}
-
-	var podcasts []Podcast
-	if err := cursor.All(ctx, &podcasts); err != nil {
-		log.Fatalf("Failed to decode existing podcasts: %v", err)
+		fatalf(ctx, "Failed to fetch existing podcasts: %v", err)
 	}
 
 	for _, p := range podcasts {
 		existingPodcastFeeds[p.Feed] = true
 		podcastTitles[p.PodlistUrl] = true
+		// Retired slugs stay taken, so old links never lead to another show.
+		for _, alias := range p.Aliases {
+			podcastTitles[alias] = true
+		}
+		if !p.RetiredAt.IsZero() || !p.DeadAt.IsZero() {
+			retiredFeeds[p.Feed] = true
+		}
 	}
 
-	return existingPodcastFeeds, podcastTitles
+	return existingPodcastFeeds, podcastTitles, retiredFeeds
 }
 
-func processFeedsInBatches(ctx context.Context, feeds []string, podcastsCollection, episodesCollection *mongo.Collection, existingPodcastFeeds, podcastTitles map[string]bool) {
-	batchSize := 10 // Process 10 feeds at a time
+func processFeedsInBatches(ctx context.Context, feeds []string, store Store, existingPodcastFeeds, podcastTitles map[string]bool) {
+	batchSize := config.BatchSize
+	batches := (len(feeds) + batchSize - 1) / batchSize
+	pause := backoff{min: config.MinBackoff, max: config.MaxBackoff}
 	for i := 0; i < len(feeds); i += batchSize {
+		if budget.spent() {
+			budget.leave(feeds[i:]...)
+			return
+		}
 		end := i + batchSize
 		if end > len(feeds) {
 			end = len(feeds)
 		}
 
-		processBatch(ctx, feeds[i:end], podcastsCollection, episodesCollection, existingPodcastFeeds, podcastTitles)
+		progress.startBatch(i/batchSize+1, batches)
+
+		results := processBatch(ctx, feeds[i:end], store, existingPodcastFeeds, podcastTitles)
+		crawlRun.checkpoint()
 
-		log.Printf("Processed batch %d to %d\n", i, end-1)
-		time.Sleep(5 * time.Second) // Sleep between batches to allow system to recover
+		infof(ctx, "Processed batch %d to %d", i, end-1)
+		if end == len(feeds) {
+			break
+		}
+		delay := pause.next(results)
+		if delay < config.BatchDelay {
+			delay = config.BatchDelay
+		}
+		if delay == 0 {
+			continue
+		}
+		// Give failing hosts, or our own network, time to recover, or
+		// just keep to --batch-delay.
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			budget.leave(feeds[end:]...)
+			return
+		}
 	}
 }
 
-func processBatch(ctx context.Context, feeds []string, podcastsCollection, episodesCollection *mongo.Collection, existingPodcastFeeds, podcastTitles map[string]bool) {
+func processBatch(ctx context.Context, feeds []string, store Store, existingPodcastFeeds, podcastTitles map[string]bool) []feedResult {
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, 3) // Reduce max concurrent operations
+	semaphore := make(chan struct{}, config.Concurrency)
+	results := make([]feedResult, len(feeds))
+
+	// Feeds are started in the order they claim slugs in, so a feed
+	// waiting for its turn never holds the slot of one it waits for.
+	order := make([]int, len(feeds))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return feeds[order[a]] < feeds[order[b]] })
+	turns := newSlugTurns(len(feeds))
 
-	for _, feedURL := range feeds {
+	for k, i := range order {
+		semaphore <- struct{}{}
 		wg.Add(1)
-		go func(url string) {
+		go func(i int, url string, turn *slugTurn) {
 			defer wg.Done()
-			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
+			defer turn.done()
 
-			processFeedURL(ctx, url, podcastsCollection, episodesCollection, existingPodcastFeeds, podcastTitles)
-		}(feedURL)
+			// Feeds still waiting for a slot when the budget runs out
+			// are left for the next run.
+			if budget.spent() {
+				budget.leave(url)
+				results[i] = feedResult{URL: url, Skipped: true}
+				return
+			}
+			res := processFeedURL(withSlugTurn(ctx, turn), url, store, existingPodcastFeeds, podcastTitles)
+			scheduler.planNext(ctx, store, url, res, existingPodcastFeeds)
+			progress.report(res)
+			crawlRun.record(res)
+			metrics.record(res)
+			report.record(res)
+			results[i] = res
+		}(i, feeds[i], turns[k])
 	}
 
 	wg.Wait()
+	return results
 }
 
-func processFeedURL(ctx context.Context, url string, podcastsCollection, episodesCollection *mongo.Collection, existingPodcastFeeds, podcastTitles map[string]bool) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// feedResult is the outcome of processing a single feed URL.
+type feedResult struct {
+	URL        string
+	PodlistUrl string
+	Err        error
+	// Timeout is set if Err is a fetch or database timeout rather than a
+	// genuine failure, or the run ran out of time or was stopped before
+	// the feed was really tried.
+	Timeout bool
+	// RetryAfter is how long the host of a throttled feed asked us to
+	// wait.
+	RetryAfter  time.Duration
+	Skipped     bool
+	NewEpisodes int
+	Elapsed     time.Duration
+}
+
+func processFeedURL(ctx context.Context, url string, store Store, existingPodcastFeeds, podcastTitles map[string]bool) (result feedResult) {
+	result.URL = url
+	start := time.Now()
+	defer func() { result.Elapsed = time.Since(start) }()
+	ctx = withLogAttrs(ctx, "feed", redactURL(url))
+
+	if (config.HonorUpdateHints || scheduler != nil) && notDueForFetch(ctx, store, url, existingPodcastFeeds) {
+		debugf(ctx, "Skipping feed %s: not due according to its update interval", redactURL(url))
+		stats.add(&stats.skippedNotDue)
+		result.Skipped = true
+		return
+	}
+
+	if !config.IgnoreRobots {
+		robotsCtx, cancel := context.WithTimeout(ctx, config.FeedTimeout)
+		allowed, err := robots.Allowed(robotsCtx, url)
+		cancel()
+		if err != nil {
+			errorf(ctx, "Error checking robots.txt for %s: %s", redactURL(url), redactError(err, url))
+			stats.add(&stats.failed)
+			result.Err = err
+			result.Timeout = cutShort(ctx, err)
+			return
+		}
+		if !allowed {
+			infof(ctx, "Skipping feed %s: disallowed by robots.txt", redactURL(url))
+			stats.add(&stats.skippedRobots)
+			result.Skipped = true
+			return
+		}
+	}
 
-	feed, err := LoadFeed(ctx, url)
+	// Other feeds of the host may be fetched meanwhile, it is only
+	// held for the download.
+	release, err := hostLimits.Acquire(ctx, hostOf(url))
+	if err != nil {
+		errorf(ctx, "Error waiting for host of %s: %v", redactURL(url), err)
+		stats.add(&stats.failed)
+		result.Err = err
+		result.Timeout = cutShort(ctx, err)
+		return
+	}
+
+	// Fetching and storing get separate budgets, so a slow download can't
+	// eat up the time needed to persist what it fetched.
+	validators, known := feedValidators(ctx, store, url, existingPodcastFeeds)
+	feed, redirects, err := loadFeedRetrying(ctx, url, validators)
+	release()
+	if err == errNotModified {
+		debugf(ctx, "Feed %s is not modified since the last crawl", redactURL(url))
+		result.PodlistUrl = known.PodlistUrl
+		dbCtx, cancelDB := context.WithTimeout(ctx, config.DBTimeout)
+		defer cancelDB()
+		if err := markNotModified(dbCtx, store, known); err != nil {
+			errorf(ctx, "Error updating podcast %s: %v", known.Title, err)
+		}
+		stats.add(&stats.notModified)
+		return
+	}
 	if err != nil {
-		log.Printf("Error loading feed %s: %v\n", url, err)
+		if kind, _ := feedErrorKind(err); kind == FeedTimeout {
+			errorf(ctx, "Error loading feed %s: fetch timed out after %v: %s", redactURL(url), config.FeedTimeout, redactError(err, url))
+			stats.add(&stats.fetchTimeouts)
+			result.Timeout = true
+		} else {
+			errorf(ctx, "Error loading feed %s: %s", redactURL(url), redactError(err, url))
+			result.Timeout = ctx.Err() != nil
+		}
+		stats.add(&stats.failed)
+		result.Err = err
+		if result.RetryAfter = retryAfter(err); result.RetryAfter > 0 {
+			warnf(ctx, "host of %s asks to retry after %s", redactURL(url), result.RetryAfter.Round(time.Second))
+			// Later feeds of the host wait for it, though no longer than
+			// the longest pause between batches.
+			wait := result.RetryAfter
+			if wait > config.MaxBackoff {
+				wait = config.MaxBackoff
+			}
+			hostLimits.Defer(hostOf(url), time.Now().Add(wait))
+		}
+		markCrawlFailed(ctx, store, url, err, result.RetryAfter, existingPodcastFeeds)
+		if isGone(err) {
+			retirePodcast(ctx, store, url, existingPodcastFeeds)
+		}
 		return
 	}
 
-	if err := processFeed(ctx, feed, podcastsCollection, episodesCollection, existingPodcastFeeds, podcastTitles); err != nil {
-		log.Printf("Error processing feed %s: %v\n", url, err)
+	if newURL := newFeedURL(feed); newURL != "" {
+		feedMoves.record(url, newURL)
+	}
+
+	// New podcasts are named in feed URL order. Waiting for that doesn't
+	// count against the time budget for storing the feed.
+	podcastIndex.Lock()
+	exists := existingPodcastFeeds[feed.FeedLink] || existingPodcastFeeds[url]
+	podcastIndex.Unlock()
+	if !exists {
+		slugTurnFrom(ctx).wait(ctx)
 	}
 
-	runtime.GC() // Force garbage collection after processing each feed
+	dbCtx, cancelDB := context.WithTimeout(ctx, config.DBTimeout)
+	defer cancelDB()
+	recordFeedMeta(dbCtx, store, url, feed)
+	if to := permanentLocation(redirects); to != "" {
+		followPermanentRedirect(dbCtx, store, url, to, feed, existingPodcastFeeds)
+	}
+	podcast, inserted, err := processFeed(dbCtx, feed, feedCurations[url], store, existingPodcastFeeds, podcastTitles)
+	result.PodlistUrl = podcast.PodlistUrl
+	if err != nil {
+		if dbCtx.Err() == context.DeadlineExceeded {
+			errorf(ctx, "Error processing feed %s: database timed out after %v: %v", redactURL(url), config.DBTimeout, err)
+			stats.add(&stats.dbTimeouts)
+			result.Timeout = true
+		} else {
+			errorf(ctx, "Error processing feed %s: %v", redactURL(url), err)
+			result.Timeout = ctx.Err() != nil
+		}
+		stats.add(&stats.failed)
+		result.Err = err
+		return
+	}
+	stats.add(&stats.processed)
+	atomic.AddInt64(&stats.newEpisodes, int64(inserted))
+	result.NewEpisodes = inserted
+	return
 }
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// testFeed returns a feed at feedURL titled title with items. Only items
+// with iTunes tags become episodes, see testItem.
+func testFeed(feedURL, title string, items ...*gofeed.Item) *gofeed.Feed {
+	return &gofeed.Feed{Title: title, FeedLink: feedURL, Link: "https://example.com/", Items: items}
+}
+
+func testItem(guid, title string, published time.Time) *gofeed.Item {
+	return &gofeed.Item{
+		GUID:            guid,
+		Title:           title,
+		Description:     "About " + title,
+		PublishedParsed: &published,
+		Enclosures:      []*gofeed.Enclosure{{URL: "https://cdn.example.com/" + guid + ".mp3", Type: "audio/mpeg"}},
+		ITunesExt:       &ext.ITunesItemExtension{Duration: "30:00"},
+	}
+}
+
+func TestProcessFeedWithoutUsableItems(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+	config.RemovedEpisodes = removedMark
+	config.SkipIfNoNewer = true
+
+	published := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		items []*gofeed.Item
+	}{
+		{"no items", nil},
+		{"nil item", []*gofeed.Item{nil}},
+		{"nil and empty items", []*gofeed.Item{nil, {GUID: "empty"}, nil}},
+		{"nil item among episodes", []*gofeed.Item{testItem("ep1", "Episode 1", published), nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemoryStore()
+			feeds, titles := make(map[string]bool), make(map[string]bool)
+			feed := testFeed("https://a.example/feed", "Tech Talk", tt.items...)
+			// The second run finds the podcast stored, which takes the
+			// removed episodes and no-newer-items paths.
+			for run := 1; run <= 2; run++ {
+				if _, _, err := processFeed(ctx, feed, feedCuration{}, store, feeds, titles); err != nil {
+					t.Fatalf("run %d: %v", run, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateEpisodeShowNotesStats(t *testing.T) {
+	podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk"}
+	item := testItem("ep1", "Episode 1", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	item.Description = "Short summary."
+	item.Content = "<p>The <em>full</em> show notes, with links.</p>"
+	e := createEpisode(item, podcast)
+	if e.WordCount != 6 || e.ReadingTimeSeconds != 2 {
+		t.Errorf("%d words, %d seconds, want the 6 words of the content in 2 seconds", e.WordCount, e.ReadingTimeSeconds)
+	}
+	item.Content = ""
+	if e := createEpisode(item, podcast); e.WordCount != 2 {
+		t.Errorf("%d words without content, want the 2 of the description", e.WordCount)
+	}
+}
+
+func TestProcessEpisodes(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk", Feed: "https://a.example/feed"}
+	if err := store.InsertPodcast(ctx, &podcast); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	noITunes := testItem("blog-1", "Blog post", day)
+	noITunes.ITunesExt = nil
+	duplicate := testItem("ep2", "Episode 2 again", day)
+	feed := testFeed(podcast.Feed, podcast.Title,
+		testItem("ep2", "Episode 2", day.AddDate(0, 0, 7)),
+		testItem("ep1", "Episode 1", day),
+		noITunes,
+		duplicate,
+	)
+
+	inserted, deferred, err := processEpisodes(ctx, feed, podcast, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 2 || deferred != 0 {
+		t.Errorf("inserted %d, deferred %d, want 2 and 0", inserted, deferred)
+	}
+	episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := make(map[string]int)
+	for _, e := range episodes {
+		orders[e.Guid] = e.FeedOrder
+		if e.PodcastUrl != podcast.PodlistUrl || e.PodcastId != podcast.ID {
+			t.Errorf("episode %s belongs to %s %s", e.Guid, e.PodcastUrl, e.PodcastId.Hex())
+		}
+	}
+	// Items are numbered from the bottom of the feed up.
+	if len(orders) != 2 || orders["ep1"] >= orders["ep2"] {
+		t.Errorf("stored episodes and their feed order: %v", orders)
+	}
+
+	// Stored episodes aren't inserted again.
+	feed.Items = append(feed.Items, testItem("ep3", "Episode 3", day.AddDate(0, 0, 14)))
+	if inserted, _, err = processEpisodes(ctx, feed, podcast, store); err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 1 {
+		t.Errorf("second pass inserted %d episodes, want 1", inserted)
+	}
+}
+
+func TestProcessEpisodesMaxEpisodesPerFeed(t *testing.T) {
+	defer func(old Config) { config = old }(config)
+	config.MaxEpisodesPerFeed = 10
+
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk", Feed: "https://a.example/feed"}
+	if err := store.InsertPodcast(ctx, &podcast); err != nil {
+		t.Fatal(err)
+	}
+	// 50 weekly episodes, the newest first and a week ago.
+	newest := time.Now().AddDate(0, 0, -7).Truncate(time.Second)
+	var items []*gofeed.Item
+	for i := 50; i >= 1; i-- {
+		items = append(items, testItem(fmt.Sprintf("ep%d", i), fmt.Sprintf("Episode %d", i), newest.AddDate(0, 0, 7*(i-50))))
+	}
+	feed := testFeed(podcast.Feed, podcast.Title, items...)
+
+	// pass processes the feed and checks that episodes newest down to
+	// oldest were inserted and wantDeferred left for later.
+	pass := func(newest, oldest, wantDeferred int) {
+		t.Helper()
+		p, err := store.PodcastByFeed(ctx, podcast.Feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before, _ := store.Episodes(ctx, podcast.PodlistUrl)
+		inserted, deferred, err := processEpisodes(ctx, feed, p, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantInserted := newest - oldest + 1
+		if inserted != wantInserted || deferred != wantDeferred {
+			t.Errorf("inserted %d, deferred %d, want %d and %d", inserted, deferred, wantInserted, wantDeferred)
+		}
+		after, _ := store.Episodes(ctx, podcast.PodlistUrl)
+		if n := len(after) - len(before); n != wantInserted {
+			t.Errorf("%d episodes stored, want %d", n, wantInserted)
+		}
+		stored := make(map[string]bool)
+		for _, e := range after {
+			stored[e.Guid] = true
+		}
+		for i := oldest; i <= newest; i++ {
+			if !stored[fmt.Sprintf("ep%d", i)] {
+				t.Errorf("episode %d isn't stored", i)
+			}
+		}
+	}
+	// The newest 10 come first, the next 10 in the next run.
+	pass(50, 41, 40)
+	pass(40, 31, 30)
+
+	// What --since leaves out is never ingested, nor counted as deferred.
+	// Episode 26 is 175 days old.
+	config.Since = 175*24*time.Hour + 12*time.Hour
+	pass(30, 26, 0)
+	// Nothing is left then.
+	pass(25, 26, 0)
+}
+
+// inFlightStore keeps track of how many podcasts are being stored at the
+// same time, each of which takes a while.
+type inFlightStore struct {
+	Store
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (s *inFlightStore) InsertPodcast(ctx context.Context, podcast *Podcast) error {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.max {
+		s.max = s.inFlight
+	}
+	s.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	err := s.Store.InsertPodcast(ctx, podcast)
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	return err
+}
+
+func TestProcessFeedsInBatchesConcurrency(t *testing.T) {
+	server := newFeedServer(t)
+	var feeds []string
+	for i := 0; i < 12; i++ {
+		feeds = append(feeds, server.setFeed(fmt.Sprintf("/podcast-%d.xml", i), "podcast.xml"))
+	}
+	store := &inFlightStore{Store: newMemoryStore()}
+	newIngester(t, store)
+	config.BatchSize = 6
+	config.Concurrency = 3
+
+	processFeedsInBatches(context.Background(), feeds, store, make(map[string]bool), make(map[string]bool))
+	if store.max != config.Concurrency {
+		t.Errorf("%d feeds in flight at most, want %d", store.max, config.Concurrency)
+	}
+	podcasts, err := store.Podcasts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podcasts) != len(feeds) {
+		t.Errorf("%d podcasts stored, want %d", len(podcasts), len(feeds))
+	}
+}
+
+func TestProcessBatchNumbersSlugsInFeedOrder(t *testing.T) {
+	// The feed first in the list answers last, but still gets the slug
+	// without a number.
+	server := newFeedServer(t)
+	feeds := []string{
+		server.setFeed("/c.xml", "no-itunes.xml"),
+		server.setFeed("/a.xml", "no-itunes.xml"),
+		server.setFeed("/b.xml", "no-itunes.xml"),
+	}
+	server.slow("/a.xml", 100*time.Millisecond)
+	server.slow("/b.xml", 50*time.Millisecond)
+	store := newMemoryStore()
+	newIngester(t, store)
+	config.Concurrency = 3
+
+	processBatch(context.Background(), feeds, store, make(map[string]bool), make(map[string]bool))
+	want := map[string]string{"/a.xml": "plain-blog", "/b.xml": "plain-blog-2", "/c.xml": "plain-blog-3"}
+	for path, slug := range want {
+		p, err := store.PodcastByFeed(context.Background(), server.URL+path)
+		if err != nil {
+			t.Fatalf("fetching podcast of %s: %v", path, err)
+		}
+		if p.PodlistUrl != slug {
+			t.Errorf("podcast of %s got slug %q, want %q", path, p.PodlistUrl, slug)
+		}
+	}
+}
+
+func TestNoNewerItems(t *testing.T) {
+	latest := time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC)
+	podcast := Podcast{LatestEpisodeAt: latest}
+	older := testItem("ep1", "Episode 1", latest.AddDate(0, 0, -7))
+	same := testItem("ep2", "Episode 2", latest)
+	newer := testItem("ep3", "Episode 3", latest.AddDate(0, 0, 7))
+	undated := testItem("ep4", "Episode 4", latest)
+	undated.PublishedParsed = nil
+
+	tests := []struct {
+		name    string
+		podcast Podcast
+		items   []*gofeed.Item
+		want    bool
+	}{
+		{"nothing newer", podcast, []*gofeed.Item{same, older}, true},
+		{"backfilled", podcast, []*gofeed.Item{same, older, undated}, true},
+		{"newer", podcast, []*gofeed.Item{newer, same, older}, false},
+		{"newer at the bottom", podcast, []*gofeed.Item{older, same, newer}, false},
+		{"no dates", podcast, []*gofeed.Item{undated}, false},
+		{"no episodes yet", Podcast{}, []*gofeed.Item{older}, false},
+	}
+	for _, tt := range tests {
+		if got := noNewerItems(tt.items, tt.podcast); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// bigShowServer serves /show-N.xml, feeds of the same show with n episodes
+// each, whose enclosures and chapters it serves as well.
+func bigShowServer(t *testing.T, n int) *httptest.Server {
+	server := httptest.NewServer(nil)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/show-"):
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:podcast="https://podcastindex.org/namespace/1.0">
+<channel><title>Big Show</title><link>https://big.example.com/</link><description>Daily.</description>`)
+			for i := n; i > 0; i-- {
+				published := feedEpoch.AddDate(0, 0, i-n).Format(time.RFC1123Z)
+				fmt.Fprintf(w, `<item><title>Day %d</title><guid>big-%d</guid><pubDate>%s</pubDate>
+<enclosure url="%s/audio/%d.mp3" length="4242" type="audio/mpeg"/><itunes:duration>00:10:00</itunes:duration>
+<podcast:chapters url="%s/chapters/%d.json" type="application/json+chapters"/></item>`, i, i, published, server.URL, i, server.URL, i)
+			}
+			fmt.Fprint(w, `</channel></rss>`)
+		case strings.HasPrefix(r.URL.Path, "/audio/"):
+			w.Header().Set("Content-Length", "4242")
+			if r.Method == http.MethodGet {
+				w.Write([]byte(strings.Repeat("x", 4242)))
+			}
+		case strings.HasPrefix(r.URL.Path, "/chapters/"):
+			fmt.Fprint(w, `{"version":"1.2.0","chapters":[{"startTime":0,"title":"Intro"},{"startTime":60,"title":"News"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestEpisodeEnrichmentConcurrency crawls feeds with many episodes at the
+// same time with enclosure verification and chapters on; run it with -race.
+func TestEpisodeEnrichmentConcurrency(t *testing.T) {
+	const shows, episodes = 4, 150
+	server := bigShowServer(t, episodes)
+	var feeds []string
+	for i := 0; i < shows; i++ {
+		feeds = append(feeds, fmt.Sprintf("%s/show-%d.xml", server.URL, i))
+	}
+	store := newMemoryStore()
+	newIngester(t, store)
+	config.BatchSize = shows
+	config.Concurrency = shows
+	config.EpisodeConcurrency = 8
+	ctx := context.Background()
+	enclosureChecks = newEnclosureVerifier(ctx, config.EpisodeConcurrency, 0)
+	chapterFetches = newChaptersFetcher(ctx, config.EpisodeConcurrency)
+	defer func() { enclosureChecks, chapterFetches = nil, nil }()
+	stats = runStats{}
+
+	processFeedsInBatches(ctx, feeds, store, make(map[string]bool), make(map[string]bool))
+	enclosureChecks.Close()
+	chapterFetches.Close()
+
+	if enclosureChecks.checked != shows*episodes || chapterFetches.fetched != shows*episodes {
+		t.Errorf("verified %d enclosures and fetched %d chapters, want %d", enclosureChecks.checked, chapterFetches.fetched, shows*episodes)
+	}
+	if stats.get(&stats.newEpisodes) != shows*episodes {
+		t.Errorf("counted %d new episodes, want %d", stats.get(&stats.newEpisodes), shows*episodes)
+	}
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slugs := make(map[string]bool)
+	for _, p := range podcasts {
+		if slugs[p.PodlistUrl] {
+			t.Errorf("slug %s given to two podcasts", p.PodlistUrl)
+		}
+		slugs[p.PodlistUrl] = true
+		stored, err := store.Episodes(ctx, p.PodlistUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored) != episodes {
+			t.Errorf("podcast %s has %d episodes, want %d", p.PodlistUrl, len(stored), episodes)
+		}
+		for _, e := range stored {
+			if e.Enclosure.CheckedAt.IsZero() || len(e.Chapters) != 2 {
+				t.Errorf("episode %s of %s not enriched: checked %v, %d chapters", e.Guid, p.PodlistUrl, e.Enclosure.CheckedAt, len(e.Chapters))
+				break
+			}
+		}
+	}
+	if len(slugs) != shows {
+		t.Errorf("%d podcasts stored, want %d", len(slugs), shows)
+	}
+}
+
+func TestCreateEpisodeBlankTitle(t *testing.T) {
+	podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk"}
+	published := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	noGUID := testItem("", "", published)
+	noGUID.Enclosures[0].URL = "https://cdn.example.com/1.mp3"
+	tests := []struct {
+		name string
+		item *gofeed.Item
+		want string
+	}{
+		{"title", testItem("ep1", "Episode 1", published), "episode-1"},
+		{"blank title", testItem("ep1", "  ", published), "e-dfc815db"},
+		{"blank title without GUID", noGUID, "e-30ea2762"},
+	}
+	for _, tt := range tests {
+		got := createEpisode(tt.item, podcast).PodlistUrl
+		if got != tt.want {
+			t.Errorf("%s: got slug %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUsableItems(t *testing.T) {
+	withTitle := &gofeed.Item{Title: "Episode 1"}
+	withEnclosure := &gofeed.Item{Enclosures: []*gofeed.Enclosure{{URL: "https://cdn.example.com/1.mp3"}}}
+	withContent := &gofeed.Item{Description: "Show notes"}
+	blank := &gofeed.Item{GUID: "blank", Title: " "}
+	usable, empty := usableItems([]*gofeed.Item{withTitle, nil, blank, withEnclosure, withContent})
+	if len(usable) != 3 || usable[0] != withTitle || usable[1] != withEnclosure || usable[2] != withContent {
+		t.Errorf("usable items %v, want the ones with a title, an enclosure and content", usable)
+	}
+	if len(empty) != 1 || empty[0] != blank {
+		t.Errorf("empty items %v, want the blank one", empty)
+	}
+}
+
+func TestProcessEpisodesWithoutItems(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := testPodcast(t, store, "empty-show")
+	for name, items := range map[string][]*gofeed.Item{"no items": nil, "nil item": {nil}, "empty item": {{GUID: "ep1"}}} {
+		feed := testFeed("https://example.com/empty.xml", "Empty Show", items...)
+		inserted, _, err := processEpisodes(ctx, feed, podcast, store)
+		if err != nil || inserted != 0 {
+			t.Errorf("%s: inserted %d episodes, error %v", name, inserted, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// crawlMetrics counts what crawls did since podgo started, for Prometheus
+// to scrape from /metrics with --metrics-listen. Unlike stats it isn't
+// reset between the runs of the daemon; Prometheus wants counters that only
+// go up. A nil crawlMetrics counts nothing.
+type crawlMetrics struct {
+	mu             sync.Mutex
+	runs           int64
+	lastRunEnd     time.Time
+	feedsProcessed int64
+	feedsFailed    int64
+	feedsSkipped   int64
+	// fetchErrors are by FeedErrorKind, parse errors among them.
+	fetchErrors map[FeedErrorKind]int64
+	newEpisodes int64
+	fetches     histogram
+	// writes are by Store method.
+	writes map[string]*histogram
+}
+
+var metrics *crawlMetrics
+
+// fetchBuckets and writeBuckets are the upper bounds in seconds of the
+// histogram buckets of feed fetches and store writes.
+var (
+	fetchBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	writeBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 10}
+)
+
+func newCrawlMetrics() *crawlMetrics {
+	return &crawlMetrics{
+		fetchErrors: make(map[FeedErrorKind]int64),
+		fetches:     histogram{bounds: fetchBuckets},
+		writes:      make(map[string]*histogram),
+	}
+}
+
+// record counts the outcome of a feed.
+func (m *crawlMetrics) record(res feedResult) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case res.Skipped:
+		m.feedsSkipped++
+	case res.Err != nil:
+		m.feedsFailed++
+		if kind, ok := feedErrorKind(res.Err); ok {
+			m.fetchErrors[kind]++
+		}
+	default:
+		m.feedsProcessed++
+	}
+	m.newEpisodes += int64(res.NewEpisodes)
+}
+
+// observeFetch records how long one attempt at fetching a feed took.
+func (m *crawlMetrics) observeFetch(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.fetches.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *crawlMetrics) observeWrite(op string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.writes[op]
+	if h == nil {
+		h = &histogram{bounds: writeBuckets}
+		m.writes[op] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// runFinished counts a finished crawl run.
+func (m *crawlMetrics) runFinished() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.runs++
+	m.lastRunEnd = time.Now()
+	m.mu.Unlock()
+}
+
+// writeTo writes the metrics to w in the Prometheus text format.
+func (m *crawlMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetric(w, "podgo_crawl_runs_total", "counter", "Crawl runs finished.", m.runs)
+	var lastRunEnd int64
+	if !m.lastRunEnd.IsZero() {
+		lastRunEnd = m.lastRunEnd.Unix()
+	}
+	writeMetric(w, "podgo_last_crawl_run_timestamp_seconds", "gauge", "When the last crawl run finished, as a Unix time.", lastRunEnd)
+	writeMetric(w, "podgo_feeds_processed_total", "counter", "Feeds fetched and stored.", m.feedsProcessed)
+	writeMetric(w, "podgo_feeds_failed_total", "counter", "Feeds that failed to be fetched or stored.", m.feedsFailed)
+	writeMetric(w, "podgo_feeds_skipped_total", "counter", "Feeds skipped as not due, disallowed by robots.txt or out of time.", m.feedsSkipped)
+	writeMetric(w, "podgo_episodes_inserted_total", "counter", "New episodes inserted.", m.newEpisodes)
+
+	var fetchErrors, parseErrors []string
+	for kind, n := range m.fetchErrors {
+		line := fmt.Sprintf("{kind=%q} %d", kind.String(), n)
+		if kind == FeedParse || kind == FeedNotAFeed {
+			parseErrors = append(parseErrors, line)
+		} else {
+			fetchErrors = append(fetchErrors, line)
+		}
+	}
+	writeMetricLines(w, "podgo_feed_fetch_errors_total", "counter", "Feeds that failed to be fetched, by kind of error.", fetchErrors)
+	writeMetricLines(w, "podgo_feed_parse_errors_total", "counter", "Feeds that were fetched but couldn't be read as a feed.", parseErrors)
+
+	fmt.Fprintf(w, "# HELP podgo_feed_fetch_duration_seconds Time taken by one attempt at fetching a feed.\n")
+	fmt.Fprintf(w, "# TYPE podgo_feed_fetch_duration_seconds histogram\n")
+	m.fetches.writeTo(w, "podgo_feed_fetch_duration_seconds", "")
+
+	ops := make([]string, 0, len(m.writes))
+	for op := range m.writes {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	fmt.Fprintf(w, "# HELP podgo_store_write_duration_seconds Time taken by writes to the store, by operation.\n")
+	fmt.Fprintf(w, "# TYPE podgo_store_write_duration_seconds histogram\n")
+	for _, op := range ops {
+		m.writes[op].writeTo(w, "podgo_store_write_duration_seconds", fmt.Sprintf("op=%q", op))
+	}
+}
+
+func writeMetric(w io.Writer, name, typ, help string, value int64) {
+	writeMetricLines(w, name, typ, help, []string{fmt.Sprintf(" %d", value)})
+}
+
+// writeMetricLines writes a metric with a sample for each of lines, which
+// are its labels and value.
+func writeMetricLines(w io.Writer, name, typ, help string, lines []string) {
+	sort.Strings(lines)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s%s\n", name, line)
+	}
+}
+
+// histogram counts observations into buckets by upper bound, like a
+// Prometheus histogram.
+type histogram struct {
+	bounds []float64
+	// counts[i] are the observations up to bounds[i] but above the bound
+	// before it, counts[len(bounds)] those above all bounds.
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(h.bounds)+1)
+	}
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// writeTo writes the samples of h as metric name with labels, with the
+// buckets cumulative as Prometheus has them.
+func (h *histogram) writeTo(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative int64
+	for i, bound := range h.bounds {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// handler serves GET /metrics.
+func (m *crawlMetrics) handler(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+// serveMetrics serves /metrics on addr for as long as podgo runs. A crawl
+// goes on without it if addr can't be listened on.
+func serveMetrics(m *crawlMetrics, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handler)
+	infof(context.Background(), "Serving metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		warnf(context.Background(), "not serving metrics: %v", err)
+	}
+}
+
+// timedStore is a Store that times its writes for crawlMetrics.
+type timedStore struct {
+	Store
+}
+
+func (s timedStore) time(op string, start time.Time) {
+	metrics.observeWrite(op, time.Since(start))
+}
+
+func (s timedStore) InNamespace(ns string) Store {
+	return timedStore{s.Store.InNamespace(ns)}
+}
+
+func (s timedStore) InsertPodcast(ctx context.Context, podcast *Podcast) error {
+	defer s.time("InsertPodcast", time.Now())
+	return s.Store.InsertPodcast(ctx, podcast)
+}
+
+func (s timedStore) UpdatePodcast(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer s.time("UpdatePodcast", time.Now())
+	return s.Store.UpdatePodcast(ctx, id, set)
+}
+
+func (s timedStore) InsertEpisodes(ctx context.Context, episodes []Episode) ([]Episode, error) {
+	defer s.time("InsertEpisodes", time.Now())
+	return s.Store.InsertEpisodes(ctx, episodes)
+}
+
+func (s timedStore) UpdateEpisode(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer s.time("UpdateEpisode", time.Now())
+	return s.Store.UpdateEpisode(ctx, id, set)
+}
+
+func (s timedStore) UpdateEpisodes(ctx context.Context, updates []EpisodeUpdate) error {
+	defer s.time("UpdateEpisodes", time.Now())
+	return s.Store.UpdateEpisodes(ctx, updates)
+}
+
+func (s timedStore) DeleteEpisodes(ctx context.Context, ids []primitive.ObjectID) error {
+	defer s.time("DeleteEpisodes", time.Now())
+	return s.Store.DeleteEpisodes(ctx, ids)
+}
+
+func (s timedStore) ArchiveEpisodes(ctx context.Context, episodes []Episode) error {
+	defer s.time("ArchiveEpisodes", time.Now())
+	return s.Store.ArchiveEpisodes(ctx, episodes)
+}
+
+func (s timedStore) SetFeedMeta(ctx context.Context, m FeedMeta) error {
+	defer s.time("SetFeedMeta", time.Now())
+	return s.Store.SetFeedMeta(ctx, m)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrawlMetrics(t *testing.T) {
+	m := newCrawlMetrics()
+	m.record(feedResult{NewEpisodes: 3})
+	m.record(feedResult{Skipped: true})
+	m.record(feedResult{Err: &FeedError{Kind: FeedTimeout, Err: errors.New("deadline exceeded")}})
+	m.record(feedResult{Err: &FeedError{Kind: FeedParse, Err: errors.New("unexpected EOF")}})
+	m.observeFetch(300 * time.Millisecond)
+	m.observeFetch(time.Minute + time.Second)
+	m.observeWrite("InsertEpisodes", 20*time.Millisecond)
+	m.runFinished()
+
+	rec := httptest.NewRecorder()
+	m.handler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		"podgo_crawl_runs_total 1",
+		"podgo_feeds_processed_total 1",
+		"podgo_feeds_failed_total 2",
+		"podgo_feeds_skipped_total 1",
+		"podgo_episodes_inserted_total 3",
+		`podgo_feed_fetch_errors_total{kind="timeout"} 1`,
+		`podgo_feed_parse_errors_total{kind="parse"} 1`,
+		`podgo_feed_fetch_duration_seconds_bucket{le="0.25"} 0`,
+		`podgo_feed_fetch_duration_seconds_bucket{le="0.5"} 1`,
+		`podgo_feed_fetch_duration_seconds_bucket{le="60"} 1`,
+		`podgo_feed_fetch_duration_seconds_bucket{le="+Inf"} 2`,
+		"podgo_feed_fetch_duration_seconds_count 2",
+		`podgo_store_write_duration_seconds_bucket{op="InsertEpisodes",le="0.025"} 1`,
+		`podgo_store_write_duration_seconds_count{op="InsertEpisodes"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics lack %q:\n%s", line, out)
+		}
+	}
+
+	var none *crawlMetrics
+	none.record(feedResult{NewEpisodes: 1})
+	none.runFinished()
+}
+
+func TestTimedStoreObservesWrites(t *testing.T) {
+	metrics = newCrawlMetrics()
+	defer func() { metrics = nil }()
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	in := newIngester(t, timedStore{newMemoryStore()})
+	in.crawl(feedURL)
+
+	for _, op := range []string{"InsertPodcast", "InsertEpisodes"} {
+		if h := metrics.writes[op]; h == nil || h.count == 0 {
+			t.Errorf("%s wasn't timed", op)
+		}
+	}
+}
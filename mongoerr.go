@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/auth"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// mongoAttempts is how often a write is tried when MongoDB reports a
+// transient error.
+const mongoAttempts = 3
+
+// mongoErrorClass describes what kind of failure a MongoDB error is.
+func mongoErrorClass(err error) string {
+	switch {
+	case mongo.IsTimeout(err):
+		return "timeout"
+	case mongo.IsNetworkError(err):
+		return "network error"
+	case mongo.IsDuplicateKeyError(err):
+		return "duplicate key"
+	default:
+		return "error"
+	}
+}
+
+// mongoConnectError turns a failed ping into an error that says whether
+// the server turned down our credentials or couldn't be reached at all.
+func mongoConnectError(err error) error {
+	var authErr *auth.Error
+	if errors.As(err, &authErr) {
+		return fmt.Errorf("MongoDB server rejected the credentials in the store URI: %v", err)
+	}
+	if mongo.IsTimeout(err) || mongo.IsNetworkError(err) || errors.As(err, new(topology.ServerSelectionError)) {
+		return fmt.Errorf("failed to reach MongoDB server: %v", err)
+	}
+	return fmt.Errorf("failed to connect to MongoDB server: %v", err)
+}
+
+// isTransientMongoError reports whether err is worth retrying.
+func isTransientMongoError(err error) bool {
+	if mongo.IsTimeout(err) || mongo.IsNetworkError(err) {
+		return true
+	}
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel("RetryableWriteError")
+}
+
+// retryMongo runs the write op, retrying it with a short backoff as long as
+// it fails transiently. fn is told which attempt it is, so writes that may
+// have partially succeeded can tell a duplicate key from a real conflict.
+func retryMongo(ctx context.Context, op string, fn func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= mongoAttempts; attempt++ {
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		if !isTransientMongoError(err) || ctx.Err() != nil {
+			break
+		}
+		if attempt < mongoAttempts {
+			warnf(ctx, "%s failed with %s, retrying (attempt %d of %d): %v", op, mongoErrorClass(err), attempt, mongoAttempts, err)
+			select {
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			case <-ctx.Done():
+				return err
+			}
+		}
+	}
+	infof(ctx, "%s failed with %s: %v", op, mongoErrorClass(err), err)
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Namespaces keep several catalogues apart in one database. Every podcast
+// and episode belongs to one; slugs only have to be unique within it. The
+// default namespace is stored as no namespace at all, so documents from
+// before namespaces existed belong to it.
+const defaultNamespace = "default"
+
+// storedNamespace returns how namespace ns is stored on documents.
+func storedNamespace(ns string) string {
+	if ns == defaultNamespace {
+		return ""
+	}
+	return ns
+}
+
+// feedEntry is an entry of the feed list: either just the feed URL or an
+// object with the URL, the namespace of the feed and the curation of its
+// categories, see feedCuration.
+type feedEntry struct {
+	URL                string   `json:"url"`
+	Namespace          string   `json:"namespace,omitempty"`
+	Tags               []string `json:"tags,omitempty"`
+	OverrideCategories []string `json:"overrideCategories,omitempty"`
+}
+
+func (f *feedEntry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*f = feedEntry{}
+		return json.Unmarshal(data, &f.URL)
+	}
+	type entry feedEntry
+	return json.Unmarshal(data, (*entry)(f))
+}
+
+func (f feedEntry) MarshalJSON() ([]byte, error) {
+	if f.Namespace == "" && len(f.Tags) == 0 && len(f.OverrideCategories) == 0 {
+		return json.Marshal(f.URL)
+	}
+	type entry feedEntry
+	return json.Marshal(entry(f))
+}
+
+// groupByNamespace splits feeds by namespace, in the order the namespaces
+// first appear. Feeds without a namespace go to namespace ns.
+func groupByNamespace(feeds []feedEntry, ns string) ([]string, map[string][]string) {
+	var namespaces []string
+	groups := make(map[string][]string)
+	for _, f := range feeds {
+		fns := f.Namespace
+		if fns == "" {
+			fns = ns
+		}
+		if _, ok := groups[fns]; !ok {
+			namespaces = append(namespaces, fns)
+		}
+		groups[fns] = append(groups[fns], f.URL)
+	}
+	return namespaces, groups
+}
+
+// assignNamespace moves the podcasts of store whose feed is in feeds, with
+// their episodes, to namespace ns. Podcasts whose feed or slug is already
+// taken there are left alone.
+func assignNamespace(ctx context.Context, store Store, feeds []feedEntry, ns string) error {
+	listed := make(map[string]bool)
+	for _, f := range feeds {
+		listed[f.URL] = true
+	}
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	target, err := store.InNamespace(ns).Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts of namespace %s: %v", ns, err)
+	}
+	taken := make(map[string]bool)
+	for _, p := range target {
+		taken[p.Feed] = true
+		taken[p.PodlistUrl] = true
+		for _, a := range p.Aliases {
+			taken[a] = true
+		}
+	}
+
+	moved, skipped := 0, 0
+	for _, p := range podcasts {
+		if !listed[p.Feed] {
+			continue
+		}
+		if taken[p.Feed] || taken[p.PodlistUrl] {
+			warnf(ctx, "Not moving podcast %s: its feed or slug is already used in namespace %s", p.PodlistUrl, ns)
+			skipped++
+			continue
+		}
+		n, err := store.MoveToNamespace(ctx, p, ns)
+		if err != nil {
+			return fmt.Errorf("error moving podcast %s: %v", p.PodlistUrl, err)
+		}
+		taken[p.Feed] = true
+		taken[p.PodlistUrl] = true
+		for _, a := range p.Aliases {
+			taken[a] = true
+		}
+		debugf(ctx, "Moved podcast %s with %d episodes to namespace %s", p.PodlistUrl, n, ns)
+		moved++
+	}
+	infof(ctx, "Moved %d podcasts to namespace %s, skipped %d", moved, ns, skipped)
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// onlyFeed picks the feed --only asks for: the entry of feeds with that
+// URL, or else the feed of the podcast with that slug. A feed URL that
+// isn't in the list is crawled as well, in the namespace of --namespace.
+func onlyFeed(ctx context.Context, store Store, feeds []feedEntry, only string) ([]feedEntry, error) {
+	for _, f := range feeds {
+		if f.URL == only {
+			return []feedEntry{f}, nil
+		}
+	}
+
+	namespaces, _ := groupByNamespace(append([]feedEntry{{}}, feeds...), config.Namespace)
+	for _, ns := range namespaces {
+		podcasts, err := store.InNamespace(ns).Podcasts(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching podcasts: %v", err)
+		}
+		for _, p := range podcasts {
+			if p.PodlistUrl == only || containsString(p.Aliases, only) {
+				return []feedEntry{{URL: p.Feed, Namespace: ns}}, nil
+			}
+		}
+	}
+
+	if u, err := url.Parse(only); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		infof(ctx, "%s is not in the feed list, crawling it anyway", redactURL(only))
+		return []feedEntry{{URL: only}}, nil
+	}
+	return nil, fmt.Errorf("no feed or podcast %q", only)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// opmlDocument is the part of an OPML subscription list PodGo reads and
+// writes.
+type opmlDocument struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Version  string        `xml:"version,attr"`
+	Head     opmlHead      `xml:"head"`
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+// opmlOutline is a feed if it has an xmlUrl, otherwise a folder of the
+// outlines in it.
+type opmlOutline struct {
+	Type     string        `xml:"type,attr,omitempty"`
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+func (o opmlOutline) name() string {
+	if t := strings.TrimSpace(o.Text); t != "" {
+		return t
+	}
+	return strings.TrimSpace(o.Title)
+}
+
+// isOPML reports whether the feed list filename is an OPML file rather than
+// JSON, judged by its extension.
+func isOPML(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".opml")
+}
+
+func loadFeedsFromOPML(filename string) []feedEntry {
+	f, err := os.Open(filename)
+	if err != nil {
+		fatalf(context.Background(), "Failed to open OPML file: %v", err)
+	}
+	defer f.Close()
+
+	var doc opmlDocument
+	if err := xml.NewDecoder(f).Decode(&doc); err != nil {
+		fatalf(context.Background(), "Failed to parse OPML: %v", err)
+	}
+	return opmlFeeds(doc.Outlines)
+}
+
+// opmlFeeds returns the feeds among outlines and the folders they are in.
+// The names of the folders around a feed become tags of its podcast, see
+// feedCuration. A feed that is in several folders is listed once with the
+// tags of all of them.
+func opmlFeeds(outlines []opmlOutline) []feedEntry {
+	var feeds []feedEntry
+	index := make(map[string]int)
+	var walk func(outlines []opmlOutline, folders []string)
+	walk = func(outlines []opmlOutline, folders []string) {
+		for _, o := range outlines {
+			u := strings.TrimSpace(o.XMLURL)
+			if u == "" {
+				if name := o.name(); name != "" {
+					walk(o.Outlines, append(folders[:len(folders):len(folders)], name))
+				} else {
+					walk(o.Outlines, folders)
+				}
+				continue
+			}
+			if !isHTTPURL(u) {
+				warnf(context.Background(), "outline %q does not point to a feed URL: %q", o.name(), u)
+				continue
+			}
+			if i, ok := index[u]; ok {
+				feeds[i].Tags = uniqueCategories(append(feeds[i].Tags, folders...))
+				continue
+			}
+			index[u] = len(feeds)
+			feeds = append(feeds, feedEntry{URL: u, Tags: uniqueCategories(folders)})
+		}
+	}
+	walk(outlines, nil)
+	return feeds
+}
+
+// exportOPML is export opml: it writes the podcasts of store to w as an
+// OPML 2.0 subscription list, ordered by title. Retired podcasts are left
+// out.
+func exportOPML(ctx context.Context, store Store, w io.Writer) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	sort.Slice(podcasts, func(i, j int) bool {
+		if podcasts[i].Title != podcasts[j].Title {
+			return podcasts[i].Title < podcasts[j].Title
+		}
+		return podcasts[i].PodlistUrl < podcasts[j].PodlistUrl
+	})
+
+	doc := opmlDocument{
+		Version: "2.0",
+		Head:    opmlHead{Title: "PodGo podcasts", DateCreated: time.Now().UTC().Format(time.RFC1123Z)},
+	}
+	for _, p := range podcasts {
+		if !p.RetiredAt.IsZero() || p.Feed == "" {
+			continue
+		}
+		doc.Outlines = append(doc.Outlines, opmlOutline{
+			Type:    "rss",
+			Text:    p.Title,
+			Title:   p.Title,
+			XMLURL:  p.Feed,
+			HTMLURL: p.Link,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	infof(ctx, "Exported %d podcasts", len(doc.Outlines))
+	return err
+}
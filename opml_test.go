@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLoadFeedsFromOPML(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "subscriptions.OPML")
+	opml := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Tech">
+      <outline text="Tech Talk" type="rss" xmlUrl="https://a.example/feed"/>
+      <outline title="Languages">
+        <outline text="Go Time" xmlUrl=" https://b.example/feed "/>
+      </outline>
+    </outline>
+    <outline text="">
+      <outline text="Loose" xmlUrl="https://c.example/feed"/>
+    </outline>
+    <outline text="Favorites">
+      <outline text="Tech Talk" xmlUrl="https://a.example/feed"/>
+      <outline text="Newsletter" xmlUrl="mailto:news@example.com"/>
+    </outline>
+  </body>
+</opml>`
+	if err := os.WriteFile(list, []byte(opml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !isOPML(list) || isOPML("feeds.json") {
+		t.Fatal("OPML lists aren't told apart from JSON by their extension")
+	}
+
+	feeds := loadFeedsFromOPML(list)
+	want := []feedEntry{
+		{URL: "https://a.example/feed", Tags: []string{"Tech", "Favorites"}},
+		{URL: "https://b.example/feed", Tags: []string{"Tech", "Languages"}},
+		{URL: "https://c.example/feed"},
+	}
+	if fmt.Sprint(feeds) != fmt.Sprint(want) {
+		t.Errorf("got feeds %v, want %v", feeds, want)
+	}
+}
+
+func TestExportOPML(t *testing.T) {
+	server := newFeedServer(t)
+	techTalk := server.setFeed("/podcast.xml", "podcast.xml")
+	blog := server.setFeed("/no-itunes.xml", "no-itunes.xml")
+	retired := server.setFeed("/comedy.xml", "comedy.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	for _, feedURL := range []string{techTalk, blog, retired} {
+		in.crawl(feedURL)
+	}
+	ctx := context.Background()
+	if err := store.UpdatePodcast(ctx, in.podcast(retired).ID, bson.M{"retiredAt": time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := exportOPML(ctx, store, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// The export reads back in as a feed list, ordered by title.
+	list := filepath.Join(t.TempDir(), "export.opml")
+	if err := os.WriteFile(list, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	feeds := loadFeedsFromOPML(list)
+	if len(feeds) != 2 || feeds[0].URL != blog || feeds[1].URL != techTalk {
+		t.Errorf("exported feeds %v, want %s and %s", feeds, blog, techTalk)
+	}
+	if !strings.Contains(buf.String(), `htmlUrl="https://blog.example.com/"`) {
+		t.Errorf("export lacks the link of the podcast:\n%s", buf.String())
+	}
+}
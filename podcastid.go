@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// backfillPodcastIDs sets the podcastId of all episodes of every podcast,
+// which episodes stored before createEpisode set it lack. Episodes that
+// have the right one already are left alone, so it can be run any number
+// of times.
+func backfillPodcastIDs(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	updated, changed := 0, 0
+	for _, p := range podcasts {
+		n, err := store.SetEpisodesPodcastID(ctx, p.PodlistUrl, p.ID)
+		if err != nil {
+			return fmt.Errorf("error updating episodes of %s: %v", p.PodlistUrl, err)
+		}
+		if n > 0 {
+			infof(ctx, "Set the podcast ID of %d episodes of %s", n, p.PodlistUrl)
+			updated += n
+			changed++
+		}
+	}
+	infof(ctx, "Set the podcast ID of %d episodes of %d podcasts", updated, changed)
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Podcasting 2.0 namespace support. gofeed doesn't know about the
+// namespace, so its elements are read from the generic extension map.
+
+// podcastNamespace is the prefix the Podcasting 2.0 namespace is declared
+// with in practically every feed.
+const podcastNamespace = "podcast"
+
+// Person is a podcast:person credit, e.g. a host or a guest.
+type Person struct {
+	Name  string `bson:"name,omitempty" json:"name,omitempty"`
+	Role  string `bson:"role,omitempty" json:"role,omitempty"`
+	Group string `bson:"group,omitempty" json:"group,omitempty"`
+	Img   string `bson:"img,omitempty" json:"img,omitempty"`
+	Href  string `bson:"href,omitempty" json:"href,omitempty"`
+}
+
+// Soundbite is a podcast:soundbite, a highlight of an episode. StartTime
+// and Duration are in seconds.
+type Soundbite struct {
+	StartTime float64 `bson:"startTime" json:"startTime"`
+	Duration  float64 `bson:"duration" json:"duration"`
+	Title     string  `bson:"title,omitempty" json:"title,omitempty"`
+}
+
+// Transcript is a podcast:transcript of an episode. Type is its MIME type,
+// like text/vtt, and Rel is "captions" for transcripts meant as closed
+// captions.
+type Transcript struct {
+	URL      string `bson:"url" json:"url"`
+	Type     string `bson:"type" json:"type"`
+	Language string `bson:"language,omitempty" json:"language,omitempty"`
+	Rel      string `bson:"rel,omitempty" json:"rel,omitempty"`
+}
+
+// Chapter is a chapter of the JSON chapters document a podcast:chapters
+// links to. StartTime and EndTime are in seconds.
+type Chapter struct {
+	StartTime float64 `bson:"startTime" json:"startTime"`
+	EndTime   float64 `bson:"endTime,omitempty" json:"endTime,omitempty"`
+	Title     string  `bson:"title,omitempty" json:"title,omitempty"`
+	Img       string  `bson:"img,omitempty" json:"img,omitempty"`
+	URL       string  `bson:"url,omitempty" json:"url,omitempty"`
+}
+
+// Funding is a podcast:funding link to where listeners can support the
+// podcast.
+type Funding struct {
+	URL  string `bson:"url" json:"url"`
+	Text string `bson:"text,omitempty" json:"text,omitempty"`
+}
+
+// podcastElements returns all podcast:<name> elements in extensions.
+func podcastElements(extensions ext.Extensions, name string) []ext.Extension {
+	if extensions == nil {
+		return nil
+	}
+	return extensions[podcastNamespace][name]
+}
+
+func parsePeople(extensions ext.Extensions) []Person {
+	var people []Person
+	for _, e := range podcastElements(extensions, "person") {
+		name := strings.TrimSpace(e.Value)
+		if name == "" {
+			debugf(context.Background(), "Skipping podcast:person without a name")
+			continue
+		}
+		people = append(people, Person{
+			Name:  name,
+			Role:  e.Attrs["role"],
+			Group: e.Attrs["group"],
+			Img:   e.Attrs["img"],
+			Href:  e.Attrs["href"],
+		})
+	}
+	return people
+}
+
+// parsePodcastGUID returns the podcast:guid of a feed, "" if it has none.
+func parsePodcastGUID(extensions ext.Extensions) string {
+	for _, e := range podcastElements(extensions, "guid") {
+		if guid := strings.TrimSpace(e.Value); guid != "" {
+			return guid
+		}
+	}
+	return ""
+}
+
+func parseFunding(extensions ext.Extensions) []Funding {
+	var funding []Funding
+	for _, e := range podcastElements(extensions, "funding") {
+		u := strings.TrimSpace(e.Attrs["url"])
+		if !isHTTPURL(u) {
+			debugf(context.Background(), "Skipping podcast:funding with invalid url %q", u)
+			continue
+		}
+		funding = append(funding, Funding{URL: u, Text: strings.TrimSpace(e.Value)})
+	}
+	return funding
+}
+
+// parseLocked reports whether podcast:locked is yes, and the owner it
+// names.
+func parseLocked(extensions ext.Extensions) (bool, string) {
+	locked := podcastElements(extensions, "locked")
+	if len(locked) == 0 {
+		return false, ""
+	}
+	return strings.EqualFold(strings.TrimSpace(locked[0].Value), "yes"), strings.TrimSpace(locked[0].Attrs["owner"])
+}
+
+func parseSoundbites(extensions ext.Extensions) []Soundbite {
+	var soundbites []Soundbite
+	for _, e := range podcastElements(extensions, "soundbite") {
+		start, err := strconv.ParseFloat(strings.TrimSpace(e.Attrs["startTime"]), 64)
+		if err != nil || start < 0 {
+			debugf(context.Background(), "Skipping podcast:soundbite with invalid startTime %q", e.Attrs["startTime"])
+			continue
+		}
+		duration, err := strconv.ParseFloat(strings.TrimSpace(e.Attrs["duration"]), 64)
+		if err != nil || duration <= 0 {
+			debugf(context.Background(), "Skipping podcast:soundbite with invalid duration %q", e.Attrs["duration"])
+			continue
+		}
+		soundbites = append(soundbites, Soundbite{
+			StartTime: start,
+			Duration:  duration,
+			Title:     strings.TrimSpace(e.Value),
+		})
+	}
+	return soundbites
+}
+
+func parseTranscripts(extensions ext.Extensions) []Transcript {
+	var transcripts []Transcript
+	for _, e := range podcastElements(extensions, "transcript") {
+		u := strings.TrimSpace(e.Attrs["url"])
+		if !isHTTPURL(u) {
+			debugf(context.Background(), "Skipping podcast:transcript with invalid url %q", u)
+			continue
+		}
+		typ := strings.TrimSpace(e.Attrs["type"])
+		if typ == "" {
+			debugf(context.Background(), "Skipping podcast:transcript %s without a type", u)
+			continue
+		}
+		transcripts = append(transcripts, Transcript{
+			URL:      u,
+			Type:     typ,
+			Language: strings.TrimSpace(e.Attrs["language"]),
+			Rel:      strings.TrimSpace(e.Attrs["rel"]),
+		})
+	}
+	return transcripts
+}
+
+// parseChaptersURL returns the url of the podcast:chapters of an item, ""
+// if it has none.
+func parseChaptersURL(extensions ext.Extensions) string {
+	for _, e := range podcastElements(extensions, "chapters") {
+		u := strings.TrimSpace(e.Attrs["url"])
+		if isHTTPURL(u) {
+			return u
+		}
+		debugf(context.Background(), "Skipping podcast:chapters with invalid url %q", u)
+	}
+	return ""
+}
+
+// refreshCredits fills in soundbites, people, transcripts and chapters on
+// the stored episodes that were ingested before their feed items declared
+// them, and queues the chapters of known episodes that weren't fetched yet.
+// Items without a GUID can't be told apart and are skipped.
+func refreshCredits(ctx context.Context, store Store, podcast Podcast, episodes []Episode, items []*gofeed.Item) error {
+	type credits struct {
+		soundbites  []Soundbite
+		people      []Person
+		transcripts []Transcript
+		chaptersURL string
+	}
+	found := make(map[string]credits)
+	for _, item := range items {
+		c := credits{parseSoundbites(item.Extensions), parsePeople(item.Extensions), parseTranscripts(item.Extensions), parseChaptersURL(item.Extensions)}
+		guid := normalizeGUID(item.GUID)
+		if guid != "" && (len(c.soundbites) > 0 || len(c.people) > 0 || len(c.transcripts) > 0 || c.chaptersURL != "") {
+			found[guid] = c
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	updated := 0
+	var unfetched []Episode
+	for _, e := range episodes {
+		c, ok := found[normalizeGUID(e.Guid)]
+		if !ok {
+			continue
+		}
+		set := bson.M{}
+		if len(e.Soundbites) == 0 && len(c.soundbites) > 0 {
+			set["soundbites"] = c.soundbites
+		}
+		if len(e.People) == 0 && len(c.people) > 0 {
+			set["people"] = c.people
+		}
+		if len(e.Transcripts) == 0 && len(c.transcripts) > 0 {
+			set["transcripts"] = c.transcripts
+		}
+		if e.ChaptersURL == "" && c.chaptersURL != "" {
+			set["chaptersUrl"] = c.chaptersURL
+			e.ChaptersURL = c.chaptersURL
+		}
+		if e.ChaptersURL != "" && e.ChaptersURL != e.FetchedChapters {
+			unfetched = append(unfetched, e)
+		}
+		if len(set) == 0 {
+			continue
+		}
+		if err := store.UpdateEpisode(ctx, e.ID, set); err != nil {
+			return err
+		}
+		changes.episodeUpdated(e, set)
+		updated++
+	}
+	chapterFetches.Fetch(store, podcast, unfetched)
+	if updated > 0 {
+		infof(ctx, "Added soundbites, people, transcripts or chapters to %d known episodes of podcast %s", updated, podcast.Title)
+	}
+	return nil
+}
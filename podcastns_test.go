@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+func TestIngestPeople(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/openair.xml", "podcast20.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		podcast := in.podcast(feedURL)
+		host := Person{Name: "Sam Host", Role: "host", Img: "https://openair.example.com/sam.jpg", Href: "https://openair.example.com/sam"}
+		if !reflect.DeepEqual(podcast.People, []Person{host}) {
+			t.Errorf("podcast people %+v, want %+v", podcast.People, host)
+		}
+
+		episodes := in.episodes(podcast)
+		if len(episodes) != 2 {
+			t.Fatalf("%d episodes stored, want 2", len(episodes))
+		}
+		// The person without a name is skipped.
+		want := []Person{
+			{Name: "Sam Host", Role: "host"},
+			{Name: "Alex Guest", Role: "guest", Group: "cast", Href: "https://alex.example.com/"},
+		}
+		if got := episodes[0].People; !reflect.DeepEqual(got, want) {
+			t.Errorf("interview people %+v, want %+v", got, want)
+		}
+		if got := episodes[1].People; len(got) != 0 {
+			t.Errorf("solo episode people %+v, want none", got)
+		}
+	})
+}
+
+func TestIngestPodcastGUIDFundingAndLock(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/openair.xml", "podcast20.xml")
+		in := newIngester(t, store)
+
+		in.crawl(feedURL)
+		podcast := in.podcast(feedURL)
+		if podcast.PodcastGUID != "917393e3-1b1e-5cef-ace4-edaa54e1f810" {
+			t.Errorf("podcast guid %q", podcast.PodcastGUID)
+		}
+		// The funding link that isn't http(s) is skipped.
+		want := []Funding{{URL: "https://openair.example.com/support", Text: "Support the show"}}
+		if !reflect.DeepEqual(podcast.Funding, want) {
+			t.Errorf("funding %+v, want %+v", podcast.Funding, want)
+		}
+		if !podcast.Locked || podcast.LockedOwner != "sam@openair.example.com" {
+			t.Errorf("locked %v by %q", podcast.Locked, podcast.LockedOwner)
+		}
+
+		// The lock is refreshed with the feed.
+		server.setFeed("/openair.xml", "podcast20-unlocked.xml")
+		in.crawl(feedURL)
+		if podcast := in.podcast(feedURL); podcast.Locked {
+			t.Error("podcast is still locked after the feed unlocked it")
+		}
+	})
+}
+
+func TestIngestTranscripts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/openair.xml", "podcast20.xml")
+		in := newIngester(t, store)
+		in.crawl(feedURL)
+
+		// The episode is known already and gets its transcripts on the
+		// next crawl. Those without an http(s) url or a type are skipped.
+		server.setFeed("/openair.xml", "podcast20-transcripts.xml")
+		in.crawl(feedURL)
+		episodes := in.episodes(in.podcast(feedURL))
+		want := []Transcript{{URL: "https://openair.example.com/2.vtt", Type: "text/vtt", Language: "en", Rel: "captions"}}
+		if got := episodes[0].Transcripts; !reflect.DeepEqual(got, want) {
+			t.Errorf("interview transcripts %+v, want %+v", got, want)
+		}
+		if got := episodes[1].Transcripts; len(got) != 0 {
+			t.Errorf("solo episode transcripts %+v, want none", got)
+		}
+	})
+}
+
+func TestRefreshCreditsSkipsItemsWithoutGUID(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	podcast := testPodcast(t, store, "tech-talk")
+	e := testEpisode(podcast, "", feedEpoch)
+	if _, err := store.InsertEpisodes(ctx, []Episode{e}); err != nil {
+		t.Fatal(err)
+	}
+
+	item := &gofeed.Item{Extensions: ext.Extensions{podcastNamespace: {"person": {{Value: "Alex Guest"}}}}}
+	if err := refreshCredits(ctx, store, podcast, []Episode{e}, []*gofeed.Item{item}); err != nil {
+		t.Fatal(err)
+	}
+	episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 1 || len(episodes[0].People) != 0 {
+		t.Errorf("episode without a GUID got the people of another item: %+v", episodes)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// statsSampleSize is the number of most recent episodes the publishing
+// interval is averaged over.
+const statsSampleSize = 10
+
+type episodeStats struct {
+	PodlistUrl      string      `bson:"_id"`
+	LatestEpisodeAt time.Time   `bson:"latestEpisodeAt"`
+	EpisodeCount    int         `bson:"episodeCount"`
+	Recent          []time.Time `bson:"recent"`
+}
+
+// averageIntervalDays returns the mean gap in days between the given
+// publish dates, which are expected newest first.
+func averageIntervalDays(dates []time.Time) float64 {
+	if len(dates) < 2 {
+		return 0
+	}
+	span := dates[0].Sub(dates[len(dates)-1])
+	return span.Hours() / 24 / float64(len(dates)-1)
+}
+
+// fields returns the podcast fields to set for these statistics. A podcast
+// without episodes keeps its latestEpisodeAt but has its count reset.
+func (s episodeStats) fields() bson.M {
+	if s.EpisodeCount == 0 {
+		return bson.M{"episodeCount": 0, "averageIntervalDays": 0.0}
+	}
+	return bson.M{
+		"latestEpisodeAt":     s.LatestEpisodeAt,
+		"episodeCount":        s.EpisodeCount,
+		"averageIntervalDays": averageIntervalDays(s.Recent),
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressReporter collects the results of all feeds of a run and shows how
+// far along the crawl is. On a terminal the status line is redrawn after
+// every feed, otherwise a line is logged every so many feeds. A nil
+// reporter silently ignores everything.
+type progressReporter struct {
+	total       int
+	interactive bool
+	every       int
+	start       time.Time
+	results     chan feedResult
+	wg          sync.WaitGroup
+
+	mu      sync.Mutex
+	batch   int
+	batches int
+}
+
+var progress *progressReporter
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func newProgressReporter(total int, interactive bool, every int) *progressReporter {
+	if every < 1 {
+		every = 1
+	}
+	p := &progressReporter{
+		total:       total,
+		interactive: interactive,
+		every:       every,
+		start:       time.Now(),
+		results:     make(chan feedResult),
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// startBatch records that batch n of batches is being processed.
+func (p *progressReporter) startBatch(n, batches int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.batch, p.batches = n, batches
+	p.mu.Unlock()
+}
+
+// report hands the result of one feed to the reporter.
+func (p *progressReporter) report(res feedResult) {
+	if p == nil {
+		return
+	}
+	p.results <- res
+}
+
+// finish waits for all reported results to be shown and ends the status line.
+func (p *progressReporter) finish() {
+	if p == nil {
+		return
+	}
+	close(p.results)
+	p.wg.Wait()
+}
+
+func (p *progressReporter) run() {
+	defer p.wg.Done()
+	var done, failed, newEpisodes int
+	for res := range p.results {
+		done++
+		if res.Err != nil {
+			failed++
+		}
+		newEpisodes += res.NewEpisodes
+
+		if p.interactive {
+			fmt.Fprintf(os.Stdout, "\r\033[K%s", p.line(done, failed, newEpisodes))
+		} else if done%p.every == 0 || done == p.total {
+			infof(context.Background(), "Progress: %s", p.line(done, failed, newEpisodes))
+		}
+	}
+	if p.interactive && done > 0 {
+		fmt.Fprintln(os.Stdout)
+	}
+}
+
+func (p *progressReporter) line(done, failed, newEpisodes int) string {
+	p.mu.Lock()
+	batch, batches := p.batch, p.batches
+	p.mu.Unlock()
+
+	elapsed := time.Since(p.start)
+	eta := time.Duration(0)
+	if done > 0 && done < p.total {
+		eta = elapsed / time.Duration(done) * time.Duration(p.total-done)
+	}
+	return fmt.Sprintf("%d/%d feeds, batch %d/%d, %d failed, %d new episodes, elapsed %s, ETA %s",
+		done, p.total, batch, batches, failed, newEpisodes,
+		elapsed.Round(time.Second), eta.Round(time.Second))
+}
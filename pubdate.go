@@ -0,0 +1,154 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Some feeds have no publish dates, or useless ones: every item dated the
+// same, usually the time the feed was generated, or dated in the future.
+
+// sharedDateMin is how many items must have the same publish date before
+// the date is taken to be bogus rather than a batch upload.
+const sharedDateMin = 3
+
+// estimateStep is how far apart estimated dates are when there is no
+// valid date on one side to interpolate towards.
+const estimateStep = time.Minute
+
+// bogusDates returns the publish dates in items that are shared by at
+// least sharedDateMin items and by at least half of the dated items.
+func bogusDates(items []*gofeed.Item) map[int64]bool {
+	counts := make(map[int64]int)
+	dated := 0
+	for _, item := range items {
+		if item.PublishedParsed != nil {
+			counts[item.PublishedParsed.Unix()]++
+			dated++
+		}
+	}
+	bogus := make(map[int64]bool)
+	for t, n := range counts {
+		if n >= sharedDateMin && 2*n >= dated {
+			bogus[t] = true
+		}
+	}
+	return bogus
+}
+
+// usableDate reports whether t can be trusted as a publish date.
+func usableDate(t *time.Time, bogus map[int64]bool, now time.Time) bool {
+	return t != nil && !t.Before(earliestPublished) && !t.After(now) && !bogus[t.Unix()]
+}
+
+// estimatePublished returns an estimated publish date for each item of
+// items that has no usable one. The updated date (which includes dc:date)
+// is used if it is usable, otherwise the date is interpolated from the
+// nearest items above and below with usable dates, so that the estimates
+// decrease strictly in feed order. Items with a usable publish date are
+// never in the result.
+func estimatePublished(items []*gofeed.Item, now time.Time) map[*gofeed.Item]time.Time {
+	bogus := bogusDates(items)
+	known := make([]*time.Time, len(items))
+	missing := false
+	for i, item := range items {
+		switch {
+		case usableDate(item.PublishedParsed, bogus, now):
+			known[i] = item.PublishedParsed
+		case usableDate(item.UpdatedParsed, bogus, now):
+			known[i] = item.UpdatedParsed
+			missing = true
+		default:
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	estimated := make(map[*gofeed.Item]time.Time)
+	above := -1
+	for i, item := range items {
+		if usableDate(item.PublishedParsed, bogus, now) {
+			above = i
+			continue
+		}
+		if known[i] != nil {
+			estimated[item] = *known[i]
+			above = i
+			continue
+		}
+		below := -1
+		for j := i + 1; j < len(items); j++ {
+			if known[j] != nil {
+				below = j
+				break
+			}
+		}
+		switch {
+		case above >= 0 && below >= 0:
+			step := known[above].Sub(*known[below]) / time.Duration(below-above)
+			estimated[item] = known[above].Add(-step * time.Duration(i-above))
+		case above >= 0:
+			estimated[item] = known[above].Add(-estimateStep * time.Duration(i-above))
+		case below >= 0:
+			t := known[below].Add(estimateStep * time.Duration(below-i))
+			if t.After(now) {
+				t = now.Add(-estimateStep * time.Duration(i))
+			}
+			estimated[item] = t
+		default:
+			estimated[item] = now.Add(-estimateStep * time.Duration(i))
+		}
+	}
+	return estimated
+}
+
+// zoneSuffix matches the end of a date that names its time zone, as an
+// offset or as letters, like Z, GMT or (CET).
+var zoneSuffix = regexp.MustCompile(`(?i)([+-]\d{2}:?\d{2}|[a-z]\)?)$`)
+
+// naiveDate reports whether the date raw was parsed to t without a time
+// zone, in which case the parser took it to be UTC.
+func naiveDate(raw string, t *time.Time) bool {
+	if t == nil || t.Location() != time.UTC {
+		return false
+	}
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	if strings.HasSuffix(raw, "am") || strings.HasSuffix(raw, "pm") {
+		return true
+	}
+	return !zoneSuffix.MatchString(raw)
+}
+
+// inLocation returns the time with the same wall clock as t in loc.
+func inLocation(t time.Time, loc *time.Location) *time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+	return &t
+}
+
+// applyDefaultTimezone takes the dates of feed and its items that have no
+// time zone to be in loc rather than UTC. A nil loc leaves them as they
+// are.
+func applyDefaultTimezone(feed *gofeed.Feed, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	if naiveDate(feed.Published, feed.PublishedParsed) {
+		feed.PublishedParsed = inLocation(*feed.PublishedParsed, loc)
+	}
+	if naiveDate(feed.Updated, feed.UpdatedParsed) {
+		feed.UpdatedParsed = inLocation(*feed.UpdatedParsed, loc)
+	}
+	for _, item := range feed.Items {
+		if naiveDate(item.Published, item.PublishedParsed) {
+			item.PublishedParsed = inLocation(*item.PublishedParsed, loc)
+		}
+		if naiveDate(item.Updated, item.UpdatedParsed) {
+			item.UpdatedParsed = inLocation(*item.UpdatedParsed, loc)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const naiveDatesFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Tech Talk</title>
+    <pubDate>2024-05-15 06:00:00</pubDate>
+    <item>
+      <title>Naive</title>
+      <pubDate>2024-05-15 06:00:00</pubDate>
+    </item>
+    <item>
+      <title>GMT</title>
+      <pubDate>Wed, 15 May 2024 06:00:00 GMT</pubDate>
+    </item>
+    <item>
+      <title>Offset</title>
+      <pubDate>Wed, 15 May 2024 06:00:00 +0200</pubDate>
+    </item>
+  </channel>
+</rss>`
+
+func TestApplyDefaultTimezone(t *testing.T) {
+	utc := time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC)
+	offset := time.Date(2024, 5, 15, 4, 0, 0, 0, time.UTC)
+	tests := []struct {
+		zone  string
+		naive time.Time
+	}{
+		{"", utc},
+		{"Europe/Berlin", time.Date(2024, 5, 15, 4, 0, 0, 0, time.UTC)},
+		{"America/New_York", time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		var loc *time.Location
+		if tt.zone != "" {
+			var err error
+			if loc, err = time.LoadLocation(tt.zone); err != nil {
+				t.Skipf("no time zone data: %v", err)
+			}
+		}
+		feed, err := gofeed.NewParser().ParseString(naiveDatesFeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyDefaultTimezone(feed, loc)
+		want := map[string]time.Time{"Naive": tt.naive, "GMT": utc, "Offset": offset}
+		for _, item := range feed.Items {
+			if !item.PublishedParsed.Equal(want[item.Title]) {
+				t.Errorf("%q: %s published at %s, want %s", tt.zone, item.Title, item.PublishedParsed.UTC(), want[item.Title])
+			}
+		}
+		if !feed.PublishedParsed.Equal(tt.naive) {
+			t.Errorf("%q: feed published at %s, want %s", tt.zone, feed.PublishedParsed.UTC(), tt.naive)
+		}
+	}
+}
+
+func TestNaiveDate(t *testing.T) {
+	parsed := time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{"2024-05-15 06:00:00", true},
+		{"Wed, 15 May 2024 06:00:00", true},
+		{"May 15, 2024 6:00 AM", true},
+		{"Wed, 15 May 2024 06:00:00 GMT", false},
+		{"Wed, 15 May 2024 06:00:00 +0000", false},
+		{"2024-05-15T06:00:00Z", false},
+		{"2024-05-15T06:00:00+02:00", false},
+		{"Wed, 15 May 2024 06:00:00 (CET)", false},
+	}
+	for _, tt := range tests {
+		if got := naiveDate(tt.raw, &parsed); got != tt.want {
+			t.Errorf("naiveDate(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+	if naiveDate("2024-05-15 06:00:00", nil) {
+		t.Error("a date that didn't parse is naive")
+	}
+	berlin := time.FixedZone("CEST", 2*60*60)
+	if inBerlin := parsed.In(berlin); naiveDate("2024-05-15 06:00:00", &inBerlin) {
+		t.Error("a date parsed with a zone is naive")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Feed URLs may carry credentials, as user info or as a token in the query,
+// which must not end up in the logs.
+
+// secretParams are the query parameters whose values are redacted.
+var secretParams = map[string]bool{
+	"token": true, "access_token": true, "auth": true, "key": true, "api_key": true,
+	"apikey": true, "secret": true, "password": true, "signature": true, "sig": true,
+}
+
+const redacted = "REDACTED"
+
+// redactURL returns raw without user info and with the values of
+// secretParams replaced, for logging.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			name := p
+			if j := strings.Index(p, "="); j >= 0 {
+				name = p[:j]
+			}
+			if key, err := url.QueryUnescape(name); err == nil && secretParams[strings.ToLower(key)] {
+				params[i] = name + "=" + redacted
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.String()
+}
+
+// redactError returns the message of err, which came up fetching raw, with
+// the secrets in raw replaced. HTTP client errors quote the URL they failed
+// on.
+func redactError(err error, raw string) string {
+	msg := err.Error()
+	u, perr := url.Parse(raw)
+	if perr != nil {
+		return msg
+	}
+	var secrets []string
+	if u.User != nil {
+		password, _ := u.User.Password()
+		secrets = append(secrets, u.User.Username(), password)
+	}
+	for key, values := range u.Query() {
+		if secretParams[strings.ToLower(key)] {
+			secrets = append(secrets, values...)
+		}
+	}
+	for _, s := range secrets {
+		if s != "" {
+			msg = strings.ReplaceAll(msg, s, redacted)
+		}
+	}
+	return msg
+}
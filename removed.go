@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Publishers take episodes down, for a takedown notice or because they
+// retracted them. With --removed-episodes a stored episode that is missing
+// from its feed is logged, marked with removedAt or archived. Feeds often
+// only list their newest items, so only episodes published since the
+// oldest item still in the feed count as missing.
+const (
+	removedLog     = "log"
+	removedMark    = "mark"
+	removedArchive = "archive"
+)
+
+var removedActions = []string{removedLog, removedMark, removedArchive}
+
+// handleRemovedEpisodes looks for episodes of podcast that are missing
+// from items, the items of its feed, and handles them as action says.
+// Marked episodes that are back in the feed lose their mark again.
+func handleRemovedEpisodes(ctx context.Context, store Store, podcast Podcast, items []*gofeed.Item, action string) error {
+	inFeed := make(map[string]bool, len(items))
+	var oldest time.Time
+	for _, item := range items {
+		inFeed[normalizeGUID(item.GUID)] = true
+		if item.PublishedParsed != nil && (oldest.IsZero() || item.PublishedParsed.Before(oldest)) {
+			oldest = *item.PublishedParsed
+		}
+	}
+	// Without dates there is no telling a removed episode from one the
+	// feed no longer lists.
+	if oldest.IsZero() {
+		return nil
+	}
+
+	// The episodes are collected first, as a store may not be written to
+	// while it is walked.
+	var removed, restored []Episode
+	err := store.WalkEpisodes(ctx, podcast.PodlistUrl, func(e Episode) error {
+		switch {
+		case inFeed[normalizeGUID(e.Guid)]:
+			if !e.RemovedAt.IsZero() {
+				restored = append(restored, e)
+			}
+		case e.RemovedAt.IsZero() && !e.Published.Before(oldest):
+			removed = append(removed, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading episodes: %v", err)
+	}
+
+	if len(restored) > 0 {
+		if err := setRemovedAt(ctx, store, restored, time.Time{}); err != nil {
+			return fmt.Errorf("error restoring episodes: %v", err)
+		}
+		infof(ctx, "%d removed episodes of podcast %s are back in the feed", len(restored), podcast.PodlistUrl)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	for _, e := range removed {
+		debugf(ctx, "Episode %q of podcast %s is no longer in the feed", e.Title, podcast.PodlistUrl)
+	}
+
+	switch action {
+	case removedMark:
+		if err := setRemovedAt(ctx, store, removed, time.Now()); err != nil {
+			return fmt.Errorf("error marking removed episodes: %v", err)
+		}
+	case removedArchive:
+		if err := store.ArchiveEpisodes(ctx, removed); err != nil {
+			return fmt.Errorf("error archiving removed episodes: %v", err)
+		}
+		for _, e := range removed {
+			changes.record(podcast.Namespace, entityEpisode, e.ID, opDelete, nil)
+		}
+	}
+	verb := map[string]string{removedLog: "Found", removedMark: "Marked", removedArchive: "Archived"}[action]
+	infof(ctx, "%s %d episodes of podcast %s that are no longer in the feed", verb, len(removed), podcast.PodlistUrl)
+	return nil
+}
+
+// setRemovedAt sets removedAt on episodes, the zero time to clear it.
+func setRemovedAt(ctx context.Context, store Store, episodes []Episode, at time.Time) error {
+	set := bson.M{"removedAt": at}
+	updates := make([]EpisodeUpdate, len(episodes))
+	for i, e := range episodes {
+		updates[i] = EpisodeUpdate{ID: e.ID, Set: set}
+	}
+	if err := store.UpdateEpisodes(ctx, updates); err != nil {
+		return err
+	}
+	for _, e := range episodes {
+		changes.episodeUpdated(e, set)
+		indexUpdatedEpisode(e, set)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestIngestRemovedEpisodes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+		config.RemovedEpisodes = removedMark
+		in.crawl(feedURL)
+		podcast := in.podcast(feedURL)
+
+		removedAt := func() map[string]bool {
+			removed := map[string]bool{}
+			for _, e := range in.episodes(podcast) {
+				removed[e.Guid] = !e.RemovedAt.IsZero()
+			}
+			return removed
+		}
+
+		server.setFeed("/podcast.xml", "podcast-takedown.xml")
+		in.crawl(feedURL)
+		if removed := removedAt(); !removed["techtalk-2"] || removed["techtalk-1"] || removed["techtalk-3"] {
+			t.Errorf("after the takedown, removed: %v, want only techtalk-2", removed)
+		}
+
+		server.setFeed("/podcast.xml", "podcast.xml")
+		in.crawl(feedURL)
+		if removed := removedAt(); removed["techtalk-2"] {
+			t.Error("episode that is back in the feed is still marked removed")
+		}
+
+		// Episodes older than the oldest item in the feed aren't removed.
+		server.setFeed("/podcast.xml", "podcast-newest.xml")
+		in.crawl(feedURL)
+		if removed := removedAt(); removed["techtalk-1"] || removed["techtalk-2"] {
+			t.Errorf("after listing only the newest item, removed: %v", removed)
+		}
+
+		config.RemovedEpisodes = removedArchive
+		server.setFeed("/podcast.xml", "podcast-takedown.xml")
+		in.crawl(feedURL)
+		episodes := in.episodes(podcast)
+		if len(episodes) != 2 {
+			t.Fatalf("%d episodes left after archiving, want 2", len(episodes))
+		}
+		for _, e := range episodes {
+			if e.Guid == "techtalk-2" {
+				t.Error("removed episode wasn't archived")
+			}
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// stdoutReport is the --report value that writes the report to stdout.
+const stdoutReport = "-"
+
+// RunReport is the summary of a crawl --report writes, for scripts to read
+// instead of the log.
+type RunReport struct {
+	RunID          string    `json:"runId,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	ElapsedSeconds float64   `json:"elapsedSeconds"`
+	// Interrupted is set if the run was stopped or ran out of time before
+	// it got to every feed.
+	Interrupted bool `json:"interrupted"`
+
+	Feeds         int   `json:"feeds"`
+	Processed     int64 `json:"processed"`
+	NotModified   int64 `json:"notModified"`
+	Failed        int64 `json:"failed"`
+	SkippedRobots int64 `json:"skippedRobots"`
+	SkippedNotDue int64 `json:"skippedNotDue"`
+	LeftOut       int   `json:"leftOut"`
+	NewPodcasts   int64 `json:"newPodcasts"`
+	NewEpisodes   int64 `json:"newEpisodes"`
+
+	Failures []ReportFailure `json:"failures"`
+}
+
+// ReportFailure is a feed that failed in a RunReport.
+type ReportFailure struct {
+	Feed       string `json:"feed"`
+	PodlistUrl string `json:"podlistUrl,omitempty"`
+	Error      string `json:"error"`
+	// Kind is the FeedErrorKind of a failed fetch, empty for other
+	// failures.
+	Kind    string `json:"kind,omitempty"`
+	Timeout bool   `json:"timeout,omitempty"`
+}
+
+// runReporter collects the failures of a run for its report. A nil
+// reporter collects nothing.
+type runReporter struct {
+	start time.Time
+	feeds int
+
+	mu       sync.Mutex
+	failures []ReportFailure
+}
+
+var report *runReporter
+
+func newRunReporter(feeds int) *runReporter {
+	return &runReporter{start: time.Now(), feeds: feeds}
+}
+
+func (r *runReporter) record(res feedResult) {
+	if r == nil || res.Err == nil {
+		return
+	}
+	f := ReportFailure{
+		Feed:       redactURL(res.URL),
+		PodlistUrl: res.PodlistUrl,
+		Error:      redactError(res.Err, res.URL),
+		Timeout:    res.Timeout,
+	}
+	if kind, ok := feedErrorKind(res.Err); ok {
+		f.Kind = kind.String()
+	}
+	r.mu.Lock()
+	r.failures = append(r.failures, f)
+	r.mu.Unlock()
+}
+
+// write writes the report of the run, with the totals from stats, to
+// filename, or to stdout for stdoutReport. A report file is replaced
+// through a temporary file, so readers never see half of one.
+func (r *runReporter) write(filename string, interrupted bool) error {
+	if r == nil {
+		return nil
+	}
+	now := time.Now()
+	rep := RunReport{
+		StartedAt:      r.start,
+		FinishedAt:     now,
+		ElapsedSeconds: now.Sub(r.start).Seconds(),
+		Interrupted:    interrupted,
+		Feeds:          r.feeds,
+		Processed:      stats.get(&stats.processed),
+		NotModified:    stats.get(&stats.notModified),
+		Failed:         stats.get(&stats.failed),
+		SkippedRobots:  stats.get(&stats.skippedRobots),
+		SkippedNotDue:  stats.get(&stats.skippedNotDue),
+		LeftOut:        budget.leftOut(),
+		NewPodcasts:    stats.get(&stats.newPodcasts),
+		NewEpisodes:    stats.get(&stats.newEpisodes),
+		Failures:       []ReportFailure{},
+	}
+	if crawlRun != nil {
+		rep.RunID = crawlRun.run.ID.Hex()
+	}
+	r.mu.Lock()
+	rep.Failures = append(rep.Failures, r.failures...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if filename != stdoutReport {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(rep); err != nil {
+		return fmt.Errorf("error encoding report: %v", err)
+	}
+	if filename == stdoutReport {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing report: %v", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("error replacing report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCrawlWritesReport(t *testing.T) {
+	server := newFeedServer(t)
+	good := server.setFeed("/podcast.xml", "podcast.xml")
+	missing := server.fail("/missing.xml", http.StatusNotFound)
+	store := newMemoryStore()
+	newIngester(t, store)
+	config.Report = filepath.Join(t.TempDir(), "report.json")
+	stats = runStats{}
+	defer func() { report, crawlRun = nil, nil }()
+
+	crawl(context.Background(), store, []feedEntry{{URL: good}, {URL: missing}})
+
+	data, err := os.ReadFile(config.Report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep RunReport
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Feeds != 2 || rep.Processed != 1 || rep.Failed != 1 || rep.NewPodcasts != 1 || rep.NewEpisodes != 3 {
+		t.Errorf("got totals %+v", rep)
+	}
+	if rep.Interrupted || rep.FinishedAt.Before(rep.StartedAt) {
+		t.Errorf("run interrupted %v, from %s to %s", rep.Interrupted, rep.StartedAt, rep.FinishedAt)
+	}
+	if len(rep.Failures) != 1 || rep.Failures[0].Feed != missing || rep.Failures[0].Kind != "http-status" {
+		t.Errorf("got failures %+v", rep.Failures)
+	}
+	if _, err := os.Stat(config.Report + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary report file is left behind")
+	}
+}
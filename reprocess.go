@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Some fields of an episode are derived from others when it is ingested.
+// Episodes stored before such a field existed, or before the way it is
+// derived changed, only get it from --reprocess, which derives it again
+// from what is stored instead of fetching the feed. NormalizedGuid is left
+// to --repair-guids, since changing it may turn episodes into duplicates.
+
+// derivedEpisodeFields returns the fields derived from the stored values
+// of e, an episode of podcast, that differ from what e has.
+func derivedEpisodeFields(e Episode, podcast Podcast) bson.M {
+	set := bson.M{}
+	update := func(key string, old, derived interface{}) {
+		if !reflect.DeepEqual(old, derived) {
+			set[key] = derived
+		}
+	}
+
+	update("podcastId", e.PodcastId, podcast.ID)
+	if podcast.StableID != "" {
+		update("stableId", e.StableID, episodeStableID(podcast.StableID, e.NormalizedGuid))
+	}
+
+	notes := e.Content
+	if strings.TrimSpace(notes) == "" {
+		notes = e.Description
+	}
+	words, readingSeconds := showNotesStats(notes)
+	update("wordCount", e.WordCount, words)
+	update("readingTimeSeconds", e.ReadingTimeSeconds, readingSeconds)
+
+	enclosure := e.Enclosure
+	if len(e.Enclosures) > 0 {
+		primary, i := e.PrimaryEnclosure()
+		update("primaryEnclosureIndex", e.PrimaryEnclosureIndex, i)
+		if primary.Url != enclosure.Url {
+			// The check results belong to the old enclosure.
+			enclosure = primary
+		}
+	}
+	if enclosure.Size == 0 {
+		enclosure.Size = parseEnclosureSize(enclosure.Filesize)
+	}
+	update("enclosure", e.Enclosure, enclosure)
+	update("enclosureKey", e.EnclosureKey, enclosureKey(enclosure.Url))
+	return set
+}
+
+// reprocess derives the derived fields of all stored episodes again and
+// refreshes the episode statistics of all podcasts.
+func reprocess(ctx context.Context, store Store) error {
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	seen, updated := 0, 0
+	for _, p := range podcasts {
+		// The updates are collected first, as a store may not be written
+		// to while it is walked.
+		var updates []EpisodeUpdate
+		err := store.WalkEpisodes(ctx, p.PodlistUrl, func(e Episode) error {
+			seen++
+			if set := derivedEpisodeFields(e, p); len(set) > 0 {
+				updates = append(updates, EpisodeUpdate{ID: e.ID, Set: set})
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error reading episodes of %s: %v", p.PodlistUrl, err)
+		}
+		for start := 0; start < len(updates); start += insertBatchSize {
+			end := start + insertBatchSize
+			if end > len(updates) {
+				end = len(updates)
+			}
+			if err := store.UpdateEpisodes(ctx, updates[start:end]); err != nil {
+				return fmt.Errorf("error updating episodes of %s: %v", p.PodlistUrl, err)
+			}
+		}
+		if len(updates) > 0 {
+			debugf(ctx, "Reprocessed %d episodes of %s", len(updates), p.PodlistUrl)
+			updated += len(updates)
+		}
+	}
+	infof(ctx, "Reprocessed %d episodes of %d podcasts, %d of them changed", seen, len(podcasts), updated)
+
+	n, err := store.BackfillPodcastStats(ctx)
+	if err != nil {
+		return fmt.Errorf("error refreshing podcast stats: %v", err)
+	}
+	infof(ctx, "Refreshed the stats of %d podcasts", n)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A feed answering 410 Gone is the publisher telling us it is gone for
+// good, unlike a feed that merely keeps failing. Its podcast is retired and
+// not crawled anymore.
+
+// isGone reports whether err is a 410 Gone response.
+func isGone(err error) bool {
+	var fe *FeedError
+	return errors.As(err, &fe) && fe.Kind == FeedHTTPStatus && fe.StatusCode == http.StatusGone
+}
+
+// retirePodcast marks the podcast of feedURL as retired.
+func retirePodcast(ctx context.Context, store Store, feedURL string, existingPodcastFeeds map[string]bool) {
+	podcastIndex.Lock()
+	known := existingPodcastFeeds[feedURL]
+	podcastIndex.Unlock()
+	if !known {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	podcast, err := store.PodcastByFeed(ctx, feedURL)
+	if err != nil {
+		errorf(ctx, "Error fetching podcast to retire: %v", err)
+		return
+	}
+	if !podcast.RetiredAt.IsZero() {
+		return
+	}
+	set := bson.M{"retiredAt": time.Now()}
+	if err := store.UpdatePodcast(ctx, podcast.ID, set); err != nil {
+		errorf(ctx, "Error retiring podcast %s: %v", podcast.Title, err)
+		return
+	}
+	changes.podcastUpdated(podcast, set)
+	stats.add(&stats.retired)
+	infof(ctx, "Feed %s is gone, retired podcast %s", redactURL(feedURL), podcast.PodlistUrl)
+}
+
+// withoutRetired returns feeds without the retired and dead ones.
+func withoutRetired(feeds []string, retiredFeeds map[string]bool) []string {
+	if len(retiredFeeds) == 0 {
+		return feeds
+	}
+	active := make([]string, 0, len(feeds))
+	for _, f := range feeds {
+		if !retiredFeeds[f] {
+			active = append(active, f)
+		}
+	}
+	if skipped := len(feeds) - len(active); skipped > 0 {
+		infof(context.Background(), "Skipping %d feeds of retired or dead podcasts", skipped)
+	}
+	return active
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestIngestGoneFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+		in.crawl(feedURL)
+
+		server.fail("/podcast.xml", http.StatusGone)
+		feeds, titles, _ := loadExistingPodcasts(ctx, store)
+		result := processFeedURL(ctx, feedURL, store, feeds, titles)
+		if !isGone(result.Err) {
+			t.Fatalf("got error %v, want 410 Gone", result.Err)
+		}
+		podcast := in.podcast(feedURL)
+		if podcast.RetiredAt.IsZero() {
+			t.Error("podcast of a gone feed isn't retired")
+		}
+		// Retiring isn't counted as a failure towards marking it dead.
+		if !podcast.DeadAt.IsZero() {
+			t.Error("podcast of a gone feed is marked dead")
+		}
+		if _, _, retired := loadExistingPodcasts(ctx, store); !retired[feedURL] {
+			t.Error("retired feed is still crawled")
+		}
+	})
+}
+
+func TestIngestGoneFeedOnlyRetiresKnownPodcasts(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	server := newFeedServer(t)
+	feedURL := server.fail("/podcast.xml", http.StatusGone)
+	newIngester(t, store)
+
+	result := processFeedURL(ctx, feedURL, store, map[string]bool{}, map[string]bool{})
+	if !isGone(result.Err) {
+		t.Fatalf("got error %v, want 410 Gone", result.Err)
+	}
+	if _, err := store.PodcastByFeed(ctx, feedURL); err == nil {
+		t.Error("a podcast was stored for a gone feed")
+	}
+}
+
+func TestIsGone(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusGone}, true},
+		{&FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusNotFound}, false},
+		{&FeedError{Kind: FeedNetwork}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isGone(tt.err); got != tt.want {
+			t.Errorf("isGone(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
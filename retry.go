@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// loadFeedRetrying is loadFeed with up to --retries more attempts for
+// transient failures, each within its own --feed-timeout. A throttling
+// server is given at least the Retry-After it asked for. It gives up early
+// once the run is out of time or would be before the next attempt, and
+// returns the error of the last attempt.
+func loadFeedRetrying(ctx context.Context, url string, validators FeedMeta) (*gofeed.Feed, []feedRedirect, error) {
+	for attempt := 1; ; attempt++ {
+		fetchCtx, cancel := context.WithTimeout(ctx, config.FeedTimeout)
+		start := time.Now()
+		feed, redirects, err := loadFeed(fetchCtx, url, validators)
+		metrics.observeFetch(time.Since(start))
+		cancel()
+		if err == nil || attempt > config.Retries || !isTransient(err) || ctx.Err() != nil || budget.spent() {
+			return feed, redirects, err
+		}
+
+		wait := retryDelay(attempt)
+		var fe *FeedError
+		if errors.As(err, &fe) && fe.RetryAfter > wait {
+			wait = fe.RetryAfter
+		}
+		if !budget.allows(wait) {
+			return feed, redirects, err
+		}
+		warnf(ctx, "fetching feed %s failed, retrying in %s: %s", redactURL(url), wait.Round(time.Millisecond), redactError(err, url))
+		stats.add(&stats.retries)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return feed, redirects, err
+		case <-timer.C:
+		}
+		if hostLimits.Wait(ctx, hostOf(url)) != nil {
+			return feed, redirects, err
+		}
+	}
+}
+
+// retryDelay returns the pause before retry attempt, counted from 1:
+// --retry-delay doubled for every earlier retry, at most --max-backoff, of
+// which a random part up to half is left out so that feeds failing
+// together don't retry together.
+func retryDelay(attempt int) time.Duration {
+	d := config.RetryDelay
+	for i := 1; i < attempt && d < config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > config.MaxBackoff {
+		d = config.MaxBackoff
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
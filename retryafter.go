@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hosts that throttle us with 429 Too Many Requests or 503 Service
+// Unavailable may say with Retry-After when to come back.
+
+// parseRetryAfter returns how long from now the Retry-After header value
+// asks to wait: a number of seconds or an HTTP date. It returns zero for
+// an empty or invalid value and for dates that have passed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	t, err := http.ParseTime(value)
+	if err != nil || !t.After(now) {
+		return 0
+	}
+	return t.Sub(now)
+}
+
+// throttled reports whether status is one a server throttles us with.
+func throttled(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the Retry-After of err if it is a FeedError, or zero.
+func retryAfter(err error) time.Duration {
+	var fe *FeedError
+	if !errors.As(err, &fe) {
+		return 0
+	}
+	return fe.RetryAfter
+}
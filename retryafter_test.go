@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{" 120 ", 2 * time.Minute},
+		{"0", 0},
+		{"Sat, 01 Jun 2024 12:01:30 GMT", 90 * time.Second},
+		{"Saturday, 01-Jun-24 12:01:30 GMT", 90 * time.Second},
+		{"Sat, 01 Jun 2024 11:59:00 GMT", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestIngestThrottledFeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		value func(now time.Time) string
+	}{
+		{"seconds", func(time.Time) string { return "120" }},
+		{"date", func(now time.Time) string { return now.Add(2 * time.Minute).UTC().Format(http.TimeFormat) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newMemoryStore()
+			server := newFeedServer(t)
+			feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+			in := newIngester(t, store)
+			in.crawl(feedURL)
+			config.HonorUpdateHints = true
+			config.Retries = 2
+			config.RetryDelay = time.Millisecond
+			// The run ends before the host wants to be asked again.
+			budget = newRunBudget(time.Now(), config.FeedTimeout+config.DBTimeout+time.Minute)
+			defer func() { budget = nil }()
+
+			start := time.Now()
+			server.throttle("/podcast.xml", http.StatusTooManyRequests, tt.value(start))
+			feeds, titles, _ := loadExistingPodcasts(ctx, store)
+			result := processFeedURL(ctx, feedURL, store, feeds, titles)
+			// The date form only has whole seconds.
+			if result.RetryAfter < 119*time.Second || result.RetryAfter > 2*time.Minute {
+				t.Errorf("got Retry-After %s, want 2m", result.RetryAfter)
+			}
+			if got := server.fetches("/podcast.xml"); got != 2 {
+				t.Errorf("feed fetched %d times, want 2: a fetch the run has no time to retry isn't", got)
+			}
+			hostLimits.mu.Lock()
+			next := hostLimits.slot(hostOf(feedURL)).next
+			hostLimits.mu.Unlock()
+			if next.Before(start.Add(119 * time.Second)) {
+				t.Errorf("host deferred until %s, want 2m from now", next.Sub(start))
+			}
+
+			podcast := in.podcast(feedURL)
+			if d := podcast.RetryAfter.Sub(start); d < 119*time.Second || d > 2*time.Minute+time.Second {
+				t.Errorf("podcast to be retried after %s, want 2m", d)
+			}
+			if !notDueYet(podcast, start.Add(time.Minute)) {
+				t.Error("podcast is crawled again before Retry-After")
+			}
+			if notDueYet(podcast, start.Add(3*time.Minute)) {
+				t.Error("podcast isn't crawled again after Retry-After")
+			}
+		})
+	}
+}
+
+func TestLoadFeedRetryingHonorsRetryAfter(t *testing.T) {
+	newIngester(t, newMemoryStore())
+	config.Retries = 1
+	config.RetryDelay = time.Millisecond
+	server := newFeedServer(t)
+	feedURL := server.throttle("/busy.xml", http.StatusServiceUnavailable, "1")
+
+	start := time.Now()
+	if _, _, err := loadFeedRetrying(context.Background(), feedURL, FeedMeta{}); err == nil {
+		t.Fatal("throttled feed loaded")
+	}
+	if got := server.fetches("/busy.xml"); got != 2 {
+		t.Errorf("feed fetched %d times, want 2", got)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %s, want the 1s of Retry-After", d)
+	}
+}
+
+func TestBackoffHonorsRetryAfter(t *testing.T) {
+	failed := feedResult{Err: &FeedError{Kind: FeedHTTPStatus, StatusCode: http.StatusServiceUnavailable}}
+	throttled := failed
+	throttled.RetryAfter = 30 * time.Second
+
+	b := &backoff{min: time.Second, max: time.Minute}
+	if got := b.next([]feedResult{failed, throttled}); got != 30*time.Second {
+		t.Errorf("paused %s, want the 30s a host asked for", got)
+	}
+	throttled.RetryAfter = time.Hour
+	if got := b.next([]feedResult{failed, throttled}); got != time.Minute {
+		t.Errorf("paused %s, want at most 1m", got)
+	}
+	if got := b.next([]feedResult{{}, {}}); got != 0 {
+		t.Errorf("paused %s after a healthy batch", got)
+	}
+}
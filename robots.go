@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+// robotsAgent is the product token matched against User-agent lines.
+const robotsAgent = "PodGo"
+
+// robotsFailureTTL is how long a robots.txt that couldn't be fetched is
+// cached, shorter than the TTL of one that was, so that a host that was
+// down for a moment is asked again soon.
+const robotsFailureTTL = time.Minute
+
+// robotsCache fetches robots.txt once per host and keeps it for ttl, or for
+// robotsFailureTTL if it couldn't be fetched.
+// Crawl-delay values found on the way are handed to the host limiter.
+type robotsCache struct {
+	ttl     time.Duration
+	limiter *hostLimiter
+
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	ready   chan struct{}
+	expires time.Time
+	data    *robotstxt.RobotsData
+}
+
+func newRobotsCache(ttl time.Duration, limiter *hostLimiter) *robotsCache {
+	return &robotsCache{
+		ttl:     ttl,
+		limiter: limiter,
+		entries: make(map[string]*robotsEntry),
+	}
+}
+
+// Allowed reports whether robots.txt of the feed's host permits us to fetch it.
+func (c *robotsCache) Allowed(ctx context.Context, feedURL string) (bool, error) {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return false, err
+	}
+	e, err := c.entry(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return e.data.TestAgent(path, robotsAgent), nil
+}
+
+func (c *robotsCache) entry(ctx context.Context, u *url.URL) (*robotsEntry, error) {
+	key := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		e = &robotsEntry{ready: make(chan struct{}), expires: time.Now().Add(c.ttl)}
+		c.entries[key] = e
+		c.mu.Unlock()
+
+		data, ok := c.fetch(ctx, key, u.Host)
+		c.mu.Lock()
+		e.data = data
+		if !ok && c.ttl > robotsFailureTTL {
+			e.expires = time.Now().Add(robotsFailureTTL)
+		}
+		c.mu.Unlock()
+		close(e.ready)
+		return e, nil
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch loads and parses robots.txt. Anything that goes wrong on the way is
+// treated as "no robots.txt", which allows everything; ok is false then and
+// for server errors, which aren't worth keeping for long either. The
+// request waits for its turn at the host like feed fetches do.
+func (c *robotsCache) fetch(ctx context.Context, base, host string) (data *robotstxt.RobotsData, ok bool) {
+	allowAll, _ := robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+
+	// Hosts we may not talk to are rejected later by LoadFeed with a
+	// proper error, so don't even ask them for robots.txt.
+	if err := checkFeedURL(ctx, base+"/robots.txt"); err != nil {
+		return allowAll, true
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/robots.txt", nil)
+	if err != nil {
+		return allowAll, true
+	}
+	req.Header.Set("User-Agent", userAgent)
+	status, body, err := c.download(ctx, req, host)
+	if err != nil {
+		errorf(ctx, "Error fetching robots.txt for %s: %v", host, err)
+		return allowAll, false
+	}
+	data, err = robotstxt.FromStatusAndBytes(status, body)
+	if err != nil {
+		errorf(ctx, "Error parsing robots.txt for %s: %v", host, err)
+		return allowAll, false
+	}
+
+	if delay := data.FindGroup(robotsAgent).CrawlDelay; delay > 0 {
+		infof(ctx, "Honoring crawl-delay of %v for %s", delay, host)
+		c.limiter.SetDelay(host, delay)
+	}
+	return data, status != http.StatusTooManyRequests && status < 500
+}
+
+// download sends req once the limiter lets it and returns the status and
+// body of the response. The host is only held while the body is read.
+func (c *robotsCache) download(ctx context.Context, req *http.Request, host string) (int, []byte, error) {
+	release, err := c.limiter.Acquire(ctx, host)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer release()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 512*1024))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// robotsServer serves robots.txt with a status that can be changed and
+// counts the requests for it.
+type robotsServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests int
+}
+
+func newRobotsServer(t *testing.T, status int) *robotsServer {
+	defaults := config
+	t.Cleanup(func() { config = defaults })
+	config.AllowPrivate = true
+
+	s := &robotsServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		status := s.status
+		s.mu.Unlock()
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Write([]byte("User-agent: PodGo\nDisallow: /private/\n"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *robotsServer) fetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestRobotsFailureCachedBriefly(t *testing.T) {
+	server := newRobotsServer(t, http.StatusServiceUnavailable)
+	c := newRobotsCache(time.Hour, newHostLimiter())
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Allowed(ctx, server.URL+"/private/feed.xml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.fetches(); n != 1 {
+		t.Errorf("robots.txt fetched %d times, want 1", n)
+	}
+	if e := c.entries[server.URL]; time.Until(e.expires) > robotsFailureTTL {
+		t.Errorf("failed robots.txt cached until %s", e.expires)
+	}
+
+	// Once it expired, robots.txt is fetched again and then kept for ttl.
+	server.mu.Lock()
+	server.status = http.StatusOK
+	server.mu.Unlock()
+	c.entries[server.URL].expires = time.Now()
+	allowed, err := c.Allowed(ctx, server.URL+"/private/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("feed allowed although robots.txt disallows it")
+	}
+	if e := c.entries[server.URL]; time.Until(e.expires) < 59*time.Minute {
+		t.Errorf("robots.txt cached until %s, want an hour", e.expires)
+	}
+}
+
+func TestRobotsFetchWaitsForHost(t *testing.T) {
+	server := newRobotsServer(t, http.StatusOK)
+	limiter := newHostLimiter()
+	limiter.maxActive = 1
+	c := newRobotsCache(time.Hour, limiter)
+	ctx := context.Background()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := limiter.Acquire(ctx, u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.Allowed(ctx, server.URL+"/feed.xml"); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := server.fetches(); n != 0 {
+		t.Error("robots.txt fetched while a feed of the host was")
+	}
+	release()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("robots.txt not fetched once the host was free")
+	}
+
+	// The host isn't held once robots.txt is in.
+	release, err = limiter.Acquire(ctx, u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
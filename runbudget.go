@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runBudget keeps a crawl within --max-runtime. It stops feeds from being
+// started once one may no longer finish in time, so the crawl ends on its
+// own instead of being cut off mid-write, and remembers the feeds it held
+// back. A nil budget never runs out.
+type runBudget struct {
+	max    time.Duration
+	stopAt time.Time
+
+	mu   sync.Mutex
+	left []string
+}
+
+var budget *runBudget
+
+// newRunBudget returns a budget of max from start. A feed may take up to
+// --feed-timeout to fetch and --db-timeout to store, so none is started
+// later than that before the end.
+func newRunBudget(start time.Time, max time.Duration) *runBudget {
+	return &runBudget{max: max, stopAt: start.Add(max - config.FeedTimeout - config.DBTimeout)}
+}
+
+// spent reports whether it is too late to start another feed.
+func (b *runBudget) spent() bool {
+	return b != nil && !time.Now().Before(b.stopAt)
+}
+
+// allows reports whether a feed may still be started after waiting d.
+func (b *runBudget) allows(d time.Duration) bool {
+	return b == nil || time.Now().Add(d).Before(b.stopAt)
+}
+
+// leave notes feeds that were not started.
+func (b *runBudget) leave(feeds ...string) {
+	if b == nil || len(feeds) == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.left = append(b.left, feeds...)
+	b.mu.Unlock()
+}
+
+// stopped reports whether any feed was left out.
+func (b *runBudget) stopped() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.left) > 0
+}
+
+// leftOut returns the number of feeds that were left out.
+func (b *runBudget) leftOut() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.left)
+}
+
+// logSummary logs the feeds that were left out, for the next run to pick
+// up.
+func (b *runBudget) logSummary() {
+	if !b.stopped() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	infof(context.Background(), "Stopped starting feeds to keep within --max-runtime %s, %d feeds left unprocessed", b.max, len(b.left))
+	for _, feed := range b.left {
+		infof(context.Background(), "Left unprocessed: %s", redactURL(feed))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunBudget(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.FeedTimeout = time.Minute
+	config.DBTimeout = 30 * time.Second
+
+	var none *runBudget
+	none.leave("https://example.com/feed")
+	if none.spent() || none.stopped() {
+		t.Error("nil budget ran out")
+	}
+
+	// A feed started later than --feed-timeout plus --db-timeout before
+	// the end might not finish.
+	b := newRunBudget(time.Now().Add(-9*time.Minute), 10*time.Minute)
+	if !b.spent() {
+		t.Error("budget not spent 90s before the end")
+	}
+	if b = newRunBudget(time.Now().Add(-8*time.Minute), 10*time.Minute); b.spent() {
+		t.Error("budget spent 2m before the end")
+	}
+	if b.stopped() {
+		t.Error("budget stopped before leaving out feeds")
+	}
+	b.leave("https://a.example.com/feed", "https://b.example.com/feed")
+	b.leave()
+	if !b.stopped() || b.leftOut() != 2 {
+		t.Errorf("stopped %v with %d feeds left out, want 2", b.stopped(), b.leftOut())
+	}
+}
+
+// startRecorder records when podcasts start being stored, each of which
+// takes a while.
+type startRecorder struct {
+	Store
+
+	mu     sync.Mutex
+	starts []time.Time
+}
+
+func (s *startRecorder) InsertPodcast(ctx context.Context, podcast *Podcast) error {
+	s.mu.Lock()
+	s.starts = append(s.starts, time.Now())
+	s.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	return s.Store.InsertPodcast(ctx, podcast)
+}
+
+func TestProcessFeedsInBatchesStopsAtMaxRuntime(t *testing.T) {
+	server := newFeedServer(t)
+	var feeds []string
+	for i := 0; i < 12; i++ {
+		feeds = append(feeds, server.setFeed(fmt.Sprintf("/podcast-%d.xml", i), "podcast.xml"))
+	}
+	store := &startRecorder{Store: newMemoryStore()}
+	newIngester(t, store)
+	config.BatchSize = 4
+	config.Concurrency = 2
+	config.FeedTimeout = 100 * time.Millisecond
+	config.DBTimeout = 100 * time.Millisecond
+	// Two feeds start right away and two 100ms in, the next ones would
+	// start after the budget is spent 150ms in.
+	start := time.Now()
+	budget = newRunBudget(start, 350*time.Millisecond)
+	defer func() { budget = nil }()
+
+	processFeedsInBatches(context.Background(), feeds, store, make(map[string]bool), make(map[string]bool))
+	for _, s := range store.starts {
+		if d := s.Sub(start); d > 190*time.Millisecond {
+			t.Errorf("a feed started %s in, after the budget was spent", d)
+		}
+	}
+
+	ctx := context.Background()
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(podcasts) == 0 || len(podcasts) == len(feeds) {
+		t.Fatalf("%d of %d feeds processed, want the budget to stop some", len(podcasts), len(feeds))
+	}
+	// Feeds in flight were finished.
+	for _, p := range podcasts {
+		if episodes, err := store.Episodes(ctx, p.PodlistUrl); err != nil || len(episodes) != 3 {
+			t.Errorf("podcast %s has %d episodes, want 3: %v", p.PodlistUrl, len(episodes), err)
+		}
+	}
+	if !budget.stopped() || budget.leftOut() != len(feeds)-len(podcasts) {
+		t.Errorf("%d feeds left out, want the %d not processed", budget.leftOut(), len(feeds)-len(podcasts))
+	}
+	logs := captureLogs(t)
+	budget.logSummary()
+	if got := logs.String(); !strings.Contains(got, fmt.Sprintf("%d feeds left unprocessed", budget.leftOut())) || !strings.Contains(got, "Left unprocessed: "+feeds[len(feeds)-1]) {
+		t.Errorf("summary doesn't list the feeds left out:\n%s", got)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule tells when the daemon crawls next.
+type schedule interface {
+	// Next returns the first time after t to crawl at.
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule crawls every so often.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// cronSchedule crawls at the minutes a five field cron expression matches:
+// minute, hour, day of month, month and day of week. Each field is *, a
+// number, a range like 1-5 or a list of those, optionally with a step like
+// */15. Like with cron, a day matches if either day field does when both
+// are restricted. Times are in the local time zone.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set for a * day field.
+	domAny, dowAny bool
+}
+
+// cronSearchLimit bounds the search for the next match, which is needed
+// for expressions like 0 0 31 2 * that never match.
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(cronSearchLimit)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	// Only for dates that never come, which parseSchedule rejects.
+	return end
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseSchedule reads an --interval: a duration like 30m or a cron
+// expression like */15 * * * *.
+func parseSchedule(value string) (schedule, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval %s must be positive", value)
+		}
+		return intervalSchedule(d), nil
+	}
+	fields := strings.Fields(value)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q is neither a duration nor a cron expression of five fields", value)
+	}
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron field %q: %v", fields[i], err)
+		}
+		*b.bits = bits
+	}
+	// Sunday may be written as 7 as well.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	now := time.Now()
+	if next := s.Next(now); !next.Before(now.Add(cronSearchLimit)) {
+		return nil, fmt.Errorf("cron expression %q never matches", value)
+	}
+	return s, nil
+}
+
+// parseCronField returns the values between min and max field matches as
+// a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// 5/15 counts from 5 to the end, like cron.
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", rng, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
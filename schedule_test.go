@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, value := range []string{"0s", "-5m", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 31 2 *", "x * * * *"} {
+		if _, err := parseSchedule(value); err == nil {
+			t.Errorf("parseSchedule(%q) accepted", value)
+		}
+	}
+	s, err := parseSchedule("30m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 1, 12, 10, 20, 0, time.Local)
+	if got := s.Next(now); !got.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("next crawl at %s, want 30m after %s", got, now)
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// June 1st 2024 is a Saturday.
+	now := time.Date(2024, 6, 1, 12, 10, 20, 0, time.Local)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 6, 1, 12, 15, 0, 0, time.Local)},
+		{"5/15 * * * *", time.Date(2024, 6, 1, 12, 20, 0, 0, time.Local)},
+		{"0 3 * * *", time.Date(2024, 6, 2, 3, 0, 0, 0, time.Local)},
+		{"0 9 * * 1-5", time.Date(2024, 6, 3, 9, 0, 0, 0, time.Local)},
+		{"0 9 * * 7", time.Date(2024, 6, 2, 9, 0, 0, 0, time.Local)},
+		{"30 6 15 * 1", time.Date(2024, 6, 3, 6, 30, 0, 0, time.Local)},
+		{"0 0 1 1,7 *", time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)},
+		{"10 12 * * *", time.Date(2024, 6, 2, 12, 10, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(now); !got.Equal(tt.want) {
+			t.Errorf("%q: next crawl at %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerSkipsPodcastsUntilTheirTurn(t *testing.T) {
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	next := time.Now().Add(time.Hour).Truncate(time.Second)
+	scheduler = &crawlScheduler{schedule: intervalSchedule(time.Hour), next: next}
+	defer func() { scheduler = nil }()
+
+	ctx := context.Background()
+	crawl := func() feedResult {
+		feeds, titles, _ := loadExistingPodcasts(ctx, store)
+		res := processFeedURL(ctx, feedURL, store, feeds, titles)
+		scheduler.planNext(ctx, store, feedURL, res, feeds)
+		return res
+	}
+	crawl()
+	if got := in.podcast(feedURL).NextCrawlAt; !got.Equal(next) {
+		t.Errorf("next crawl planned at %s, want %s", got, next)
+	}
+	if res := crawl(); !res.Skipped {
+		t.Error("podcast crawled again before its next run")
+	}
+	if n := server.fetches("/podcast.xml"); n != 1 {
+		t.Errorf("feed fetched %d times, want 1", n)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	searchIndexAttempts  = 5
+	searchIndexQueueSize = 1000
+	searchIndexTimeout   = 30 * time.Second
+)
+
+// Indexer hands new and updated episodes to a search backend besides the
+// store. Implementations must not hold up ingestion: they deal with their
+// failures themselves, and the store stays the source of truth.
+type Indexer interface {
+	IndexEpisodes(episodes []Episode)
+}
+
+// noopIndexer is the Indexer used without --search-url.
+type noopIndexer struct{}
+
+func (noopIndexer) IndexEpisodes([]Episode) {}
+
+var searchIndex Indexer = noopIndexer{}
+
+// indexUpdatedEpisode passes e with set applied to the search index.
+func indexUpdatedEpisode(e Episode, set bson.M) {
+	if _, ok := searchIndex.(noopIndexer); ok {
+		return
+	}
+	if err := setFields(&e, set); err != nil {
+		errorf(context.Background(), "Error indexing episode %s of %s: %v", e.Guid, e.PodcastUrl, err)
+		return
+	}
+	searchIndex.IndexEpisodes([]Episode{e})
+}
+
+// searchDocument is what the search index gets of an episode.
+type searchDocument struct {
+	ID           string `json:"id"`
+	Namespace    string `json:"namespace,omitempty"`
+	PodcastUrl   string `json:"podcastUrl"`
+	PodcastTitle string `json:"podcastTitle"`
+	PodlistUrl   string `json:"podlistUrl"`
+	Guid         string `json:"guid"`
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	Published    int64  `json:"published"`
+	Enclosure    string `json:"enclosure,omitempty"`
+	Image        string `json:"image,omitempty"`
+	// People are the names of the podcast:person credits of the episode.
+	People []string `json:"people,omitempty"`
+}
+
+func newSearchDocument(e Episode) searchDocument {
+	description := e.Summary
+	if strings.TrimSpace(description) == "" {
+		description = e.Description
+	}
+	return searchDocument{
+		ID:           e.ID.Hex(),
+		Namespace:    e.Namespace,
+		PodcastUrl:   e.PodcastUrl,
+		PodcastTitle: e.PodcastTitle,
+		PodlistUrl:   e.PodlistUrl,
+		Guid:         e.Guid,
+		Title:        plainText(e.Title),
+		Description:  plainText(description),
+		Published:    e.Published.Unix(),
+		Enclosure:    e.Enclosure.Url,
+		Image:        e.Image,
+		People:       personNames(e.People),
+	}
+}
+
+func personNames(people []Person) []string {
+	var names []string
+	for _, p := range people {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// httpIndexer adds episodes to an index of a Meilisearch server, whose
+// documents API upserts by id. Batches are sent in the background and
+// retried a few times before they are given up on with a warning.
+type httpIndexer struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+	queue    chan []Episode
+	wg       sync.WaitGroup
+}
+
+// newHTTPIndexer returns an indexer for the index named index of the
+// server at baseURL.
+func newHTTPIndexer(baseURL, index, apiKey string) *httpIndexer {
+	x := &httpIndexer{
+		endpoint: strings.TrimSuffix(baseURL, "/") + "/indexes/" + url.PathEscape(index) + "/documents?primaryKey=id",
+		apiKey:   apiKey,
+		// The search server is configured by the operator, like the
+		// webhook target.
+		client: &http.Client{},
+		queue:  make(chan []Episode, searchIndexQueueSize),
+	}
+	x.wg.Add(1)
+	go x.run()
+	return x
+}
+
+// IndexEpisodes queues episodes for indexing. If the queue is full they are
+// left out of the index.
+func (x *httpIndexer) IndexEpisodes(episodes []Episode) {
+	if len(episodes) == 0 {
+		return
+	}
+	select {
+	case x.queue <- append([]Episode(nil), episodes...):
+	default:
+		warnf(context.Background(), "search index queue full, leaving %d episodes of %s unindexed", len(episodes), episodes[0].PodcastUrl)
+	}
+}
+
+// Close waits for the queued episodes to be indexed or given up on.
+func (x *httpIndexer) Close() {
+	close(x.queue)
+	x.wg.Wait()
+}
+
+func (x *httpIndexer) run() {
+	defer x.wg.Done()
+	for episodes := range x.queue {
+		var err error
+		for attempt := 1; attempt <= searchIndexAttempts; attempt++ {
+			if err = x.send(episodes); err == nil {
+				break
+			}
+			if attempt < searchIndexAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			warnf(context.Background(), "indexing %d episodes of %s failed: %v", len(episodes), episodes[0].PodcastUrl, err)
+		}
+	}
+}
+
+func (x *httpIndexer) send(episodes []Episode) error {
+	docs := make([]searchDocument, len(episodes))
+	for i, e := range episodes {
+		docs[i] = newSearchDocument(e)
+	}
+	body, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), searchIndexTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	if x.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+x.apiKey)
+	}
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
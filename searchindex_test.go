@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIndexer records what it is asked to index.
+type fakeIndexer struct {
+	mu       sync.Mutex
+	episodes []Episode
+}
+
+func (x *fakeIndexer) IndexEpisodes(episodes []Episode) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.episodes = append(x.episodes, episodes...)
+}
+
+// titles returns the titles of the indexed episodes by GUID, the latest
+// wins.
+func (x *fakeIndexer) titles() map[string]string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	titles := make(map[string]string)
+	for _, e := range x.episodes {
+		titles[e.Guid] = e.Title
+	}
+	return titles
+}
+
+func useIndexer(t *testing.T, x Indexer) {
+	old := searchIndex
+	searchIndex = x
+	t.Cleanup(func() { searchIndex = old })
+}
+
+func TestIngestIndexesEpisodes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		server := newFeedServer(t)
+		feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+		in := newIngester(t, store)
+		index := &fakeIndexer{}
+		useIndexer(t, index)
+
+		in.crawl(feedURL)
+		titles := index.titles()
+		if len(titles) != 3 || titles["techtalk-2"] != "Episode 2: Compilers" {
+			t.Fatalf("indexed %v after the first crawl", titles)
+		}
+		for _, e := range index.episodes {
+			if e.ID.IsZero() {
+				t.Errorf("episode %s indexed before it was stored", e.Guid)
+			}
+		}
+
+		// New episodes are indexed, as are those whose text changed.
+		index.episodes = nil
+		server.setFeed("/podcast.xml", "podcast-updated.xml")
+		in.crawl(feedURL)
+		titles = index.titles()
+		want := map[string]string{
+			"techtalk-4": "Episode 4: Networks",
+			"techtalk-2": "Episode 2: Compilers and Linkers",
+		}
+		if len(titles) != len(want) {
+			t.Errorf("indexed %v after the update, want %v", titles, want)
+		}
+		for guid, title := range want {
+			if titles[guid] != title {
+				t.Errorf("episode %s indexed as %q, want %q", guid, titles[guid], title)
+			}
+		}
+	})
+}
+
+func TestIngestDoesNotWaitForIndexer(t *testing.T) {
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	index := &httpIndexer{queue: make(chan []Episode)}
+	useIndexer(t, index)
+
+	// Nothing takes from the queue, so the episodes can't be handed over;
+	// they are stored all the same.
+	done := make(chan struct{})
+	go func() {
+		in.crawl(feedURL)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ingestion waits for the search index")
+	}
+	if got := len(in.episodes(in.podcast(feedURL))); got != 3 {
+		t.Errorf("stored %d episodes, want 3", got)
+	}
+}
+
+func TestHTTPIndexerRetries(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var docs []searchDocument
+	var auth, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		auth, path = r.Header.Get("Authorization"), r.URL.RequestURI()
+		if err := json.NewDecoder(r.Body).Decode(&docs); err != nil {
+			t.Errorf("decoding documents: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	store := newMemoryStore()
+	podcast := testPodcast(t, store, "tech-talk")
+	e := testEpisode(podcast, "techtalk-1", time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC))
+	x := newHTTPIndexer(server.URL+"/", "my episodes", "secret")
+	x.IndexEpisodes([]Episode{e})
+	x.Close()
+
+	if requests != 2 {
+		t.Errorf("sent %d requests, want the failed one and a retry", requests)
+	}
+	if path != "/indexes/my%20episodes/documents?primaryKey=id" {
+		t.Errorf("sent to %s", path)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("sent Authorization %q", auth)
+	}
+	if len(docs) != 1 || docs[0].Guid != "techtalk-1" || docs[0].ID != e.ID.Hex() {
+		t.Errorf("sent documents %+v", docs)
+	}
+}
+
+func TestSearchDocumentPeople(t *testing.T) {
+	e := Episode{People: []Person{{Name: "Sam Host", Role: "host"}, {Name: "Alex Guest", Role: "guest"}}}
+	data, err := json.Marshal(newSearchDocument(e))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(doc["people"]); got != "[Sam Host Alex Guest]" {
+		t.Errorf("document people %s, want the names of both", got)
+	}
+
+	// Episodes without people leave the field out.
+	data, err = json.Marshal(newSearchDocument(Episode{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"people"`) {
+		t.Errorf("document without people: %s", data)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// apiDefaultLimit and apiMaxLimit bound the limit of list endpoints.
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+
+	// serveTimeout bounds the store lookups of a request.
+	serveTimeout = 10 * time.Second
+	// serveShutdownTimeout is how long open requests get to finish when
+	// the server is stopped.
+	serveShutdownTimeout = 10 * time.Second
+)
+
+// apiServer serves the podcasts and episodes of a store as JSON:
+//
+//	GET /podcasts                    podcasts by title
+//	GET /podcasts/{slug}             one podcast
+//	GET /podcasts/{slug}/episodes    its episodes by publish date
+//	GET /episodes                    episodes of all podcasts by publish date
+//
+// Lists take limit and offset, episode lists order=asc for oldest first.
+// Former slugs of a podcast redirect to its current one.
+type apiServer struct {
+	store Store
+}
+
+func (s *apiServer) routes(mux *http.ServeMux) {
+	mux.HandleFunc("/podcasts", s.handlePodcasts)
+	mux.HandleFunc("/podcasts/", s.handlePodcast)
+	mux.HandleFunc("/episodes", s.handleEpisodes)
+}
+
+func (s *apiServer) handlePodcasts(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), serveTimeout)
+	defer cancel()
+	podcasts, err := s.store.Podcasts(ctx)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	sort.Slice(podcasts, func(i, j int) bool {
+		if podcasts[i].Title != podcasts[j].Title {
+			return podcasts[i].Title < podcasts[j].Title
+		}
+		return podcasts[i].PodlistUrl < podcasts[j].PodlistUrl
+	})
+	if offset > len(podcasts) {
+		offset = len(podcasts)
+	}
+	podcasts = podcasts[offset:]
+	if len(podcasts) > limit {
+		podcasts = podcasts[:limit]
+	}
+	out := make([]apiPodcast, len(podcasts))
+	for i, p := range podcasts {
+		out[i] = newAPIPodcast(p)
+	}
+	writeJSON(w, out)
+}
+
+// handlePodcast serves /podcasts/{slug} and /podcasts/{slug}/episodes.
+func (s *apiServer) handlePodcast(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/podcasts/")
+	slug, rest := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		slug, rest = path[:i], path[i:]
+	}
+	if slug == "" || (rest != "" && rest != "/episodes") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), serveTimeout)
+	defer cancel()
+	podcast, err := s.store.PodcastBySlug(ctx, slug)
+	if err == errNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	if podcast.PodlistUrl != slug {
+		target := "/podcasts/" + podcast.PodlistUrl + rest
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	if rest == "" {
+		writeJSON(w, newAPIPodcast(podcast))
+		return
+	}
+	s.writeEpisodes(ctx, w, r, podcast.PodlistUrl)
+}
+
+func (s *apiServer) handleEpisodes(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), serveTimeout)
+	defer cancel()
+	s.writeEpisodes(ctx, w, r, "")
+}
+
+// writeEpisodes answers with the page of episodes of the podcast
+// podlistUrl, or of all podcasts, that r asks for.
+func (s *apiServer) writeEpisodes(ctx context.Context, w http.ResponseWriter, r *http.Request, podlistUrl string) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var oldestFirst bool
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "desc":
+	case "asc":
+		oldestFirst = true
+	default:
+		http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+	episodes, err := s.store.EpisodePage(ctx, podlistUrl, offset, limit, oldestFirst)
+	if err != nil {
+		serverError(w, r, err)
+		return
+	}
+	writeJSON(w, newAPIEpisodes(episodes))
+}
+
+// pageParams returns the offset and limit query parameters of r.
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	q := r.URL.Query()
+	limit = apiDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > apiMaxLimit {
+			return 0, 0, errors.New("limit must be a number from 1 to " + strconv.Itoa(apiMaxLimit))
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a number of at least 0")
+		}
+	}
+	return offset, limit, nil
+}
+
+// allowGet answers anything but GET and HEAD with 405 Method Not Allowed.
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		errorf(context.Background(), "Error writing response: %v", err)
+	}
+}
+
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	errorf(context.Background(), "Error serving %s: %v", r.URL.Path, err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+// serve is the serve command: it answers API requests for the podcasts of
+// store on --listen until it is interrupted. /healthz is served as well,
+// and with --watch /stream/episodes.
+func serve(store Store, addr string, watch bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mux := http.NewServeMux()
+	(&apiServer{store: store}).routes(mux)
+	mux.Handle("/healthz", healthHandler(store))
+	if watch {
+		b := newEpisodeBroadcaster()
+		mux.Handle("/stream/episodes", streamEpisodesHandler(b))
+		go func() {
+			if err := watchEpisodes(ctx, store, b); err != nil && ctx.Err() == nil {
+				warnf(ctx, "no longer watching for new episodes: %v", err)
+			}
+		}()
+	}
+
+	// Requests, open event streams in particular, end with the server.
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	infof(ctx, "Serving the API on %s", addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
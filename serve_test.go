@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// apiClient gets the API of a store served by an apiServer.
+type apiClient struct {
+	t *testing.T
+	*httptest.Server
+}
+
+func newAPIClient(t *testing.T, store Store) *apiClient {
+	mux := http.NewServeMux()
+	(&apiServer{store: store}).routes(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &apiClient{t: t, Server: server}
+}
+
+// get requests path without following redirects and decodes a 200 answer
+// into v. It returns the response.
+func (c *apiClient) get(method, path string, v interface{}) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			c.t.Fatalf("decoding %s: %v", path, err)
+		}
+	}
+	return resp
+}
+
+// apiItem holds the fields of podcasts and episodes the tests look at.
+type apiItem struct {
+	Title      string
+	PodlistUrl string
+}
+
+func titlesOf(items []apiItem) []string {
+	var titles []string
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	return titles
+}
+
+func TestServePodcastsAndEpisodes(t *testing.T) {
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	in.crawl(feedURL)
+	api := newAPIClient(t, store)
+
+	var podcasts []apiItem
+	api.get(http.MethodGet, "/podcasts", &podcasts)
+	if len(podcasts) != 1 || podcasts[0].Title != "Tech Talk" || podcasts[0].PodlistUrl != "tech-talk" {
+		t.Errorf("got podcasts %+v", podcasts)
+	}
+	var podcast apiItem
+	api.get(http.MethodGet, "/podcasts/tech-talk", &podcast)
+	if podcast.Title != "Tech Talk" {
+		t.Errorf("got podcast %+v", podcast)
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/podcasts/tech-talk/episodes?limit=2", []string{"Episode 3: Databases", "Episode 2: Compilers"}},
+		{"/podcasts/tech-talk/episodes?order=asc&limit=1", []string{"Episode 1: Hello"}},
+		{"/episodes?offset=2", []string{"Episode 1: Hello"}},
+		{"/episodes?offset=5", nil},
+	}
+	for _, tt := range tests {
+		var episodes []apiItem
+		if resp := api.get(http.MethodGet, tt.path, &episodes); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", tt.path, resp.StatusCode)
+			continue
+		}
+		if got := titlesOf(episodes); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got episodes %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestServeFormerSlugsAndErrors(t *testing.T) {
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	in.crawl(feedURL)
+	if err := renamePodcast(context.Background(), store, "tech-talk", "tech-show"); err != nil {
+		t.Fatal(err)
+	}
+	api := newAPIClient(t, store)
+
+	resp := api.get(http.MethodGet, "/podcasts/tech-talk/episodes?limit=1", nil)
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/podcasts/tech-show/episodes?limit=1" {
+		t.Errorf("former slug answered %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/podcasts/missing", http.StatusNotFound},
+		{http.MethodGet, "/podcasts/tech-show/other", http.StatusNotFound},
+		{http.MethodGet, "/podcasts?limit=0", http.StatusBadRequest},
+		{http.MethodGet, "/episodes?offset=-1", http.StatusBadRequest},
+		{http.MethodGet, "/episodes?order=newest", http.StatusBadRequest},
+		{http.MethodPost, "/podcasts", http.StatusMethodNotAllowed},
+		{http.MethodHead, "/podcasts/tech-show", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if resp := api.get(tt.method, tt.path, nil); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestServeOnlyPublicFields(t *testing.T) {
+	server := newFeedServer(t)
+	server.setFeed("/podcast.xml", "podcast.xml")
+	feedURL := strings.Replace(server.URL, "http://", "http://jane:hunter2@", 1) + "/podcast.xml?token=s3cr3t"
+	store := newMemoryStore()
+	in := newIngester(t, store)
+	in.crawl(feedURL)
+	if err := store.UpdatePodcast(context.Background(), in.podcast(feedURL).ID, bson.M{"lastError": "http error: 500", "failureCount": 1}); err != nil {
+		t.Fatal(err)
+	}
+	api := newAPIClient(t, store)
+
+	var podcast map[string]interface{}
+	api.get(http.MethodGet, "/podcasts/tech-talk", &podcast)
+	if podcast["podlistUrl"] != "tech-talk" || podcast["status"] != "failing" {
+		t.Errorf("got podcast %v", podcast)
+	}
+	feed, _ := podcast["feed"].(string)
+	if strings.Contains(feed, "hunter2") || strings.Contains(feed, "s3cr3t") {
+		t.Errorf("feed URL %q isn't redacted", feed)
+	}
+	for _, key := range []string{"PodlistUrl", "lastError", "failureCount", "settings", "feedHash"} {
+		if _, ok := podcast[key]; ok {
+			t.Errorf("podcast has key %q", key)
+		}
+	}
+
+	var episodes []map[string]interface{}
+	api.get(http.MethodGet, "/podcasts/tech-talk/episodes?limit=1", &episodes)
+	if len(episodes) != 1 || episodes[0]["title"] != "Episode 3: Databases" {
+		t.Errorf("got episodes %v", episodes)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PodcastSettings are exceptions made for a single podcast with the set
+// command. Crawls read them but never change them.
+type PodcastSettings struct {
+	// SkipDescriptionUpdates keeps the stored description when the feed
+	// changes it.
+	SkipDescriptionUpdates bool `bson:"skipDescriptionUpdates,omitempty"`
+	// MaxEpisodes limits ingesting to the newest this many items of the
+	// feed, zero for no limit.
+	MaxEpisodes int `bson:"maxEpisodes,omitempty"`
+	// PinnedSlug is a slug chosen by hand, which rename won't change.
+	PinnedSlug string `bson:"pinnedSlug,omitempty"`
+	// ForcedCategories replace the categories of the feed and whatever
+	// the feed list curates, see feedCuration.
+	ForcedCategories []string `bson:"forcedCategories,omitempty"`
+	// EnrichmentDisabled leaves the podcast out of everything that looks
+	// it up elsewhere than in its feed, like --check-links.
+	EnrichmentDisabled bool `bson:"enrichmentDisabled,omitempty"`
+}
+
+// settingKeys are the keys the set command accepts.
+var settingKeys = []string{"skipDescriptionUpdates", "maxEpisodes", "pinnedSlug", "forcedCategories", "enrichmentDisabled"}
+
+// set sets key to value, an empty value resets it.
+func (s *PodcastSettings) set(key, value string) error {
+	value = strings.TrimSpace(value)
+	var err error
+	switch key {
+	case "skipDescriptionUpdates":
+		s.SkipDescriptionUpdates, err = parseSettingBool(value)
+	case "enrichmentDisabled":
+		s.EnrichmentDisabled, err = parseSettingBool(value)
+	case "maxEpisodes":
+		s.MaxEpisodes = 0
+		if value != "" {
+			s.MaxEpisodes, err = strconv.Atoi(value)
+			if err == nil && s.MaxEpisodes < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		}
+	case "pinnedSlug":
+		if value != "" && !validSlug.MatchString(value) {
+			err = fmt.Errorf("invalid slug %q, use lower case letters, digits and dashes", value)
+		}
+		s.PinnedSlug = value
+	case "forcedCategories":
+		s.ForcedCategories = uniqueCategories(strings.Split(value, ","))
+	default:
+		return fmt.Errorf("unknown setting %q, use %s", key, strings.Join(settingKeys, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value of %s: %v", key, err)
+	}
+	return nil
+}
+
+func parseSettingBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// curation returns the curation of a podcast whose feed list entry curates
+// it with c: forced categories override the feed list.
+func (s PodcastSettings) curation(c feedCuration) feedCuration {
+	if len(s.ForcedCategories) > 0 {
+		return feedCuration{OverrideCategories: s.ForcedCategories}
+	}
+	return c
+}
+
+// setPodcastSetting is the set command: it applies an assignment of the
+// form key=value to the settings of the podcast with the given slug.
+// Pinning another slug renames the podcast to it first.
+func setPodcastSetting(ctx context.Context, store Store, slug, assignment string) error {
+	i := strings.Index(assignment, "=")
+	if i < 0 {
+		return fmt.Errorf("expected key=value, got %q", assignment)
+	}
+	key, value := strings.TrimSpace(assignment[:i]), assignment[i+1:]
+
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	var podcast *Podcast
+	for i := range podcasts {
+		if podcasts[i].PodlistUrl == slug {
+			podcast = &podcasts[i]
+			break
+		}
+	}
+	if podcast == nil {
+		return fmt.Errorf("no podcast %q", slug)
+	}
+
+	settings := podcast.Settings
+	if err := settings.set(key, value); err != nil {
+		return err
+	}
+	if settings.PinnedSlug != "" && settings.PinnedSlug != slug {
+		if podcast.Settings.PinnedSlug != "" {
+			return fmt.Errorf("podcast %s is pinned to %s already, unpin it with pinnedSlug= first", podcast.Title, podcast.Settings.PinnedSlug)
+		}
+		if err := renamePodcast(ctx, store, slug, settings.PinnedSlug); err != nil {
+			return err
+		}
+	}
+	// The feed is processed again on the next crawl even if it didn't
+	// change, so the new settings take effect.
+	if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"settings": settings, "feedHash": ""}); err != nil {
+		return fmt.Errorf("error updating podcast: %v", err)
+	}
+	infof(ctx, "Set %s of podcast %s to %q", key, podcast.Title, strings.TrimSpace(value))
+	return nil
+}
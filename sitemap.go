@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sitemapMaxURLs is the most URLs a sitemap may hold.
+const sitemapMaxURLs = 50000
+
+// sitemapWriter writes URLs into gzipped sitemaps of at most sitemapMaxURLs
+// URLs each.
+type sitemapWriter struct {
+	dir   string
+	base  string
+	files []string
+	count int
+
+	f  *os.File
+	gz *gzip.Writer
+	w  *bufio.Writer
+}
+
+func (s *sitemapWriter) add(loc string, lastmod time.Time) error {
+	if s.w == nil || s.count == sitemapMaxURLs {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	s.count++
+	s.w.WriteString("<url><loc>")
+	xml.EscapeText(s.w, []byte(loc))
+	s.w.WriteString("</loc>")
+	if !lastmod.IsZero() {
+		fmt.Fprintf(s.w, "<lastmod>%s</lastmod>", lastmod.UTC().Format(time.RFC3339))
+	}
+	_, err := s.w.WriteString("</url>\n")
+	return err
+}
+
+// next closes the current sitemap and starts a new one.
+func (s *sitemapWriter) next() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("sitemap-%d.xml.gz", len(s.files)+1)
+	f, err := os.Create(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, name)
+	s.f, s.gz = f, gzip.NewWriter(f)
+	s.w = bufio.NewWriter(s.gz)
+	s.count = 0
+	_, err = s.w.WriteString(xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	return err
+}
+
+func (s *sitemapWriter) close() error {
+	if s.w == nil {
+		return nil
+	}
+	s.w.WriteString("</urlset>\n")
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	if err := s.gz.Close(); err != nil {
+		s.f.Close()
+		return err
+	}
+	s.w = nil
+	return s.f.Close()
+}
+
+// writeIndex writes the sitemap index pointing at all sitemaps written.
+func (s *sitemapWriter) writeIndex(now time.Time) error {
+	var b strings.Builder
+	b.WriteString(xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, name := range s.files {
+		b.WriteString("<sitemap><loc>")
+		xml.EscapeText(&b, []byte(s.base+"/"+name))
+		fmt.Fprintf(&b, "</loc><lastmod>%s</lastmod></sitemap>\n", now.UTC().Format(time.RFC3339))
+	}
+	b.WriteString("</sitemapindex>\n")
+	return ioutil.WriteFile(filepath.Join(s.dir, "sitemap.xml"), []byte(b.String()), 0644)
+}
+
+// writeSitemap writes sitemap.xml and the sitemaps it points to into dir,
+// with a page for every podcast and episode under baseURL. Retired podcasts
+// are left out. Episodes are read one podcast at a time.
+func writeSitemap(ctx context.Context, store Store, baseURL, dir string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base URL %q", baseURL)
+	}
+	base := strings.TrimRight(baseURL, "/")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	podcasts, err := store.Podcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching podcasts: %v", err)
+	}
+	s := &sitemapWriter{dir: dir, base: base}
+	urls := 0
+	for _, p := range podcasts {
+		if !p.RetiredAt.IsZero() || p.PodlistUrl == "" {
+			continue
+		}
+		podcastURL := base + "/podcast/" + url.PathEscape(p.PodlistUrl)
+		if err := s.add(podcastURL, p.Updated); err != nil {
+			s.close()
+			return err
+		}
+		urls++
+		err := store.WalkEpisodes(ctx, p.PodlistUrl, func(e Episode) error {
+			if e.PodlistUrl == "" {
+				return nil
+			}
+			urls++
+			return s.add(podcastURL+"/"+url.PathEscape(e.PodlistUrl), e.Published)
+		})
+		if err != nil {
+			s.close()
+			return fmt.Errorf("error writing episodes of %s: %v", p.PodlistUrl, err)
+		}
+	}
+	if err := s.close(); err != nil {
+		return err
+	}
+	if err := s.writeIndex(time.Now()); err != nil {
+		return err
+	}
+	infof(ctx, "Wrote %d URLs in %d sitemaps to %s", urls, len(s.files), dir)
+	return nil
+}
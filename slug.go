@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// GetTitleUrl picks the slug for a new podcast. If the title's slug is taken
+// it is disambiguated with the author and then numbered: tech-talk-2,
+// tech-talk-3 and so on. otherPodcasts holds the slugs and aliases stored
+// already, so numbers aren't handed out twice across runs. Which podcast
+// gets which number depends on the order they are named in; processBatch
+// names new podcasts in the order of their feed URLs.
+func GetTitleUrl(title, author, feedURL string, otherPodcasts map[string]bool) string {
+	base := slugify(title)
+	if base == "" {
+		// Titles that can't be transliterated, e.g. emoji only or CJK
+		// titles, are named after the feed's host instead.
+		base = slugify(strings.ReplaceAll(hostOf(feedURL), ".", " "))
+	}
+	if base == "" {
+		base = TitleUrl(title)
+	}
+	if !otherPodcasts[base] {
+		return base
+	}
+
+	if a := slugify(author); a != "" && a != base {
+		if t := capSlug(base + "-by-" + a); !otherPodcasts[t] {
+			return t
+		}
+	}
+
+	for i := 2; ; i++ {
+		if t := numberedSlug(base, i); !otherPodcasts[t] {
+			return t
+		}
+	}
+}
+
+// numberedSlug returns slug with the suffix -n, shortening slug to a word
+// boundary if that would make it longer than maxSlugLength.
+func numberedSlug(slug string, n int) string {
+	suffix := fmt.Sprintf("-%d", n)
+	if len(slug)+len(suffix) > maxSlugLength {
+		slug = slug[:maxSlugLength-len(suffix)]
+		if i := strings.LastIndex(slug, "-"); i > 0 {
+			slug = slug[:i]
+		}
+		slug = strings.TrimRight(slug, "-")
+	}
+	return slug + suffix
+}
+
+// slugTurn is the place of a feed in the order in which the new podcasts
+// of a batch claim their slugs. Feeds load in whatever order their hosts
+// answer, so without it two new podcasts with the same title could swap
+// numbers between runs. A nil *slugTurn never waits.
+type slugTurn struct {
+	before  []chan struct{}
+	claimed chan struct{}
+	once    sync.Once
+}
+
+// newSlugTurns returns n turns, each waiting for the ones before it.
+func newSlugTurns(n int) []*slugTurn {
+	claimed := make([]chan struct{}, n)
+	turns := make([]*slugTurn, n)
+	for i := range turns {
+		claimed[i] = make(chan struct{})
+		turns[i] = &slugTurn{before: claimed[:i], claimed: claimed[i]}
+	}
+	return turns
+}
+
+// wait blocks until every feed before t has claimed its slug or turned out
+// not to need one, or until ctx is done.
+func (t *slugTurn) wait(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	for _, c := range t.before {
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// done lets the feeds after t claim their slugs. It may be called more
+// than once.
+func (t *slugTurn) done() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() { close(t.claimed) })
+}
+
+type slugTurnKey struct{}
+
+func withSlugTurn(ctx context.Context, t *slugTurn) context.Context {
+	return context.WithValue(ctx, slugTurnKey{}, t)
+}
+
+// slugTurnFrom returns the turn of the feed processed with ctx, or nil if
+// it isn't processed as part of a batch.
+func slugTurnFrom(ctx context.Context) *slugTurn {
+	t, _ := ctx.Value(slugTurnKey{}).(*slugTurn)
+	return t
+}
+
+// TitleUrl turns title into a slug. Titles that leave nothing to build a
+// slug from get one derived from a hash of the title.
+func TitleUrl(title string) string {
+	if t := slugify(title); t != "" {
+		return t
+	}
+	sum := sha1.Sum([]byte(title))
+	return "p-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// episodeSlug picks the slug of an episode. Episodes without a title are
+// named after a hash of their GUID, or of their enclosure URL if they have
+// no GUID either, so they don't all end up with the slug of the empty
+// title.
+func episodeSlug(title, guid, enclosureURL string) string {
+	if strings.TrimSpace(title) == "" {
+		for _, s := range []string{normalizeGUID(guid), strings.TrimSpace(enclosureURL)} {
+			if s != "" {
+				sum := sha1.Sum([]byte(s))
+				return "e-" + hex.EncodeToString(sum[:])[:8]
+			}
+		}
+	}
+	return TitleUrl(title)
+}
+
+var (
+	slugInvalid = regexp.MustCompile(`[^a-zA-Z0-9 ]`)
+	slugSpaces  = regexp.MustCompile(` +`)
+	slugDashes  = regexp.MustCompile(`-{2,10}`)
+)
+
+// slugify transliterates title to ASCII and joins its words with dashes. It
+// returns "" if nothing of the title is left.
+func slugify(title string) string {
+	t := strings.ToLower(title)
+	t = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss").Replace(t)
+	t = transliterate(t)
+	t = slugInvalid.ReplaceAllString(t, "")
+	t = slugSpaces.ReplaceAllString(t, "-")
+	t = slugDashes.ReplaceAllString(t, "-")
+	return url.PathEscape(capSlug(t))
+}
+
+// transliterate maps latin letters with diacritics to their base letters
+// and Cyrillic and Greek letters to latin ones. Everything else is left for
+// the caller to drop. The German umlauts are replaced before, so existing
+// slugs keep their "ae", "oe" and "ue".
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if t, ok := translitTable[r]; ok {
+			b.WriteString(t)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// translitTable holds the lower case letters that don't decompose into a
+// latin base letter and a mark.
+var translitTable = map[rune]string{
+	// Latin
+	'ł': "l", 'ø': "o", 'æ': "ae", 'œ': "oe", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ı': "i", 'ħ': "h", 'ŋ': "ng",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n",
+	'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "iu", 'я': "ia", 'ё': "e",
+	'є': "ie", 'і': "i", 'ї': "i", 'ґ': "g", 'ў': "u", 'ђ': "dj", 'ј': "j",
+	'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѓ': "g", 'ќ': "k", 'ѕ': "dz",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// maxSlugLength bounds the length of generated slugs. Some feeds have whole
+// paragraphs as their title.
+const maxSlugLength = 80
+
+// capSlug shortens slugs longer than maxSlugLength to a word boundary and
+// appends a short hash of the full slug, so long titles that share a prefix
+// still get different slugs.
+func capSlug(slug string) string {
+	if len(slug) <= maxSlugLength {
+		return slug
+	}
+	sum := sha1.Sum([]byte(slug))
+	hash := hex.EncodeToString(sum[:])[:8]
+
+	t := slug[:maxSlugLength-len(hash)-1]
+	if i := strings.LastIndex(t, "-"); i > 0 {
+		t = t[:i]
+	}
+	return strings.TrimRight(t, "-") + "-" + hash
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTitleUrlCapsLongTitles(t *testing.T) {
+	paragraph := strings.Repeat("This feed puts its whole description into the title. ", 40)
+	slug := TitleUrl(paragraph)
+	if len(slug) > maxSlugLength {
+		t.Errorf("slug is %d long, more than %d", len(slug), maxSlugLength)
+	}
+	// The title is cut at a word, and a hash of all of it keeps titles
+	// that only differ further on apart.
+	full := strings.Trim(strings.Repeat("this-feed-puts-its-whole-description-into-the-title-", 40), "-")
+	i := strings.LastIndex(slug, "-")
+	if !strings.HasPrefix(full, slug[:i+1]) {
+		t.Errorf("slug %q isn't cut at a word of the title", slug)
+	}
+	if len(slug)-i-1 != 8 {
+		t.Errorf("slug %q doesn't end in an 8 character hash", slug)
+	}
+	if other := TitleUrl(paragraph + " Part two"); other == slug {
+		t.Errorf("long titles differing at the end share slug %q", slug)
+	}
+	if got := TitleUrl("Tech Talk"); got != "tech-talk" {
+		t.Errorf("short title got slug %q", got)
+	}
+	if got := TitleUrl(strings.Repeat("x", 500)); len(got) > maxSlugLength {
+		t.Errorf("title without spaces got a %d long slug", len(got))
+	}
+}
+
+func TestGetTitleUrlDisambiguates(t *testing.T) {
+	taken := map[string]bool{"tech-talk": true}
+	feed := "https://a.example/feed"
+	if got := GetTitleUrl("Tech Talk", "Jane Doe", feed, taken); got != "tech-talk-by-jane-doe" {
+		t.Errorf("got slug %q, want tech-talk-by-jane-doe", got)
+	}
+	taken["tech-talk-by-jane-doe"] = true
+	if got := GetTitleUrl("Tech Talk", "Jane Doe", feed, taken); got != "tech-talk-2" {
+		t.Errorf("got slug %q, want tech-talk-2", got)
+	}
+	if got := GetTitleUrl("🎙️", "", "https://radio.example.com/feed", taken); got != "radio-example-com" {
+		t.Errorf("title without letters got slug %q, want radio-example-com", got)
+	}
+}
+
+func TestTitleUrlTransliterates(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Détour", "detour"},
+		{"¿Qué pasa, señor?", "que-pasa-senor"},
+		{"Größe über Maß", "groesse-ueber-mass"},
+		{"Łódź nocą", "lodz-noca"},
+		{"Ærø Smørrebrød", "aero-smorrebrod"},
+		{"Привет мир", "privet-mir"},
+		{"Καλημέρα", "kalimera"},
+		// What can't be transliterated is named after a hash.
+		{"你好世界", "p-dabaa5fe"},
+		{"こんにちは", "p-20427a70"},
+		{"שלום", "p-9e4a90a0"},
+	}
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		got := TitleUrl(tt.title)
+		if got != tt.want {
+			t.Errorf("TitleUrl(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+		if got == "" {
+			t.Errorf("TitleUrl(%q) is empty", tt.title)
+		}
+		if prev, ok := seen[got]; ok {
+			t.Errorf("%q and %q both get slug %q", prev, tt.title, got)
+		}
+		seen[got] = tt.title
+	}
+}
+
+func TestGetTitleUrlNumbersCollisions(t *testing.T) {
+	taken := make(map[string]bool)
+	feeds := []string{"https://a.example/feed", "https://b.example/feed", "https://c.example/feed"}
+	want := []string{"tech-talk", "tech-talk-2", "tech-talk-3"}
+	for i, feed := range feeds {
+		got := GetTitleUrl("Tech Talk", "", feed, taken)
+		if got != want[i] {
+			t.Errorf("podcast %d: got slug %q, want %q", i+1, got, want[i])
+		}
+		taken[got] = true
+	}
+}
+
+func TestGetTitleUrlSkipsStoredSlugs(t *testing.T) {
+	// Slugs and aliases stored by earlier runs are taken as well.
+	taken := map[string]bool{"tech-talk": true, "tech-talk-2": true}
+	if got := GetTitleUrl("Tech Talk", "", "https://d.example/feed", taken); got != "tech-talk-3" {
+		t.Errorf("got slug %q, want tech-talk-3", got)
+	}
+}
+
+func TestGetTitleUrlCapsNumberedSlugs(t *testing.T) {
+	title := strings.Repeat("very long title ", 10)
+	taken := make(map[string]bool)
+	first := GetTitleUrl(title, "", "https://a.example/feed", taken)
+	taken[first] = true
+	for n := 2; n <= 12; n++ {
+		got := GetTitleUrl(title, "", "https://a.example/feed", taken)
+		if len(got) > maxSlugLength {
+			t.Errorf("slug %q is %d long, more than %d", got, len(got), maxSlugLength)
+		}
+		if taken[got] {
+			t.Fatalf("slug %q handed out twice", got)
+		}
+		if !strings.HasSuffix(got, "-"+strconv.Itoa(n)) {
+			t.Errorf("slug %q doesn't end in -%d", got, n)
+		}
+		taken[got] = true
+	}
+}
+
+func TestNumberedSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		n    int
+		want string
+	}{
+		{"tech-talk", 2, "tech-talk-2"},
+		{"tech-talk", 10, "tech-talk-10"},
+		{strings.Repeat("a", maxSlugLength), 2, strings.Repeat("a", maxSlugLength-2) + "-2"},
+		{strings.Repeat("word-", 16)[:maxSlugLength], 3, strings.Repeat("word-", 15)[:74] + "-3"},
+	}
+	for _, tt := range tests {
+		if got := numberedSlug(tt.slug, tt.n); got != tt.want {
+			t.Errorf("numberedSlug(%q, %d) = %q, want %q", tt.slug, tt.n, got, tt.want)
+		}
+	}
+}
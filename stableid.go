@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Stable IDs identify podcasts and episodes by what the feed says about
+// them rather than by the database, so the same podcast gets the same ID
+// in every database it is imported into.
+
+// stableHash returns a hash of parts for use as a stable ID.
+func stableHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// podcastStableID returns the stable ID of the podcast of feed, derived
+// from its podcast:guid or, lacking one, from its normalized feed URL.
+func podcastStableID(feed *gofeed.Feed) string {
+	if guid := parsePodcastGUID(feed.Extensions); guid != "" {
+		return stableHash("guid", strings.ToLower(guid))
+	}
+	return stableHash("feed", normalizeFeedURL(feed.FeedLink))
+}
+
+// episodeStableID returns the stable ID of the episode with normalizedGuid
+// of the podcast with podcastID, or "" if the podcast has none.
+func episodeStableID(podcastID, normalizedGuid string) string {
+	if podcastID == "" {
+		return ""
+	}
+	return stableHash("episode", podcastID, normalizedGuid)
+}
+
+// normalizeFeedURL maps the spellings of a feed URL that lead to the same
+// feed onto one: the scheme, a leading www., default ports and trailing
+// slashes don't count, and neither does the case of the host.
+func normalizeFeedURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return host + path
+}
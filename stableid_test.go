@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+func TestNormalizeFeedURL(t *testing.T) {
+	same := []string{
+		"https://feeds.example.com/show.xml",
+		"http://feeds.example.com/show.xml",
+		"https://www.feeds.example.com/show.xml",
+		"https://FEEDS.example.com/show.xml/",
+		"https://feeds.example.com:443/show.xml",
+		" http://feeds.example.com:80/show.xml ",
+	}
+	want := normalizeFeedURL(same[0])
+	for _, raw := range same[1:] {
+		if got := normalizeFeedURL(raw); got != want {
+			t.Errorf("normalizeFeedURL(%q) = %q, want %q", raw, got, want)
+		}
+	}
+	for _, raw := range []string{
+		"https://feeds.example.com/Show.xml",
+		"https://feeds.example.com:8080/show.xml",
+		"https://feeds.example.com/show.xml?season=2",
+		"https://other.example.com/show.xml",
+	} {
+		if got := normalizeFeedURL(raw); got == want {
+			t.Errorf("normalizeFeedURL(%q) is the same as for %s", raw, same[0])
+		}
+	}
+}
+
+func TestPodcastStableID(t *testing.T) {
+	withGUID := func(feedURL, guid string) *gofeed.Feed {
+		feed := &gofeed.Feed{FeedLink: feedURL}
+		if guid != "" {
+			feed.Extensions = ext.Extensions{"podcast": {"guid": {{Value: guid}}}}
+		}
+		return feed
+	}
+	byURL := podcastStableID(withGUID("https://feeds.example.com/show.xml", ""))
+	if byURL == "" || byURL != podcastStableID(withGUID("http://www.feeds.example.com/show.xml/", "")) {
+		t.Error("spellings of the same feed URL get different stable IDs")
+	}
+	if byURL == podcastStableID(withGUID("https://feeds.example.com/other.xml", "")) {
+		t.Error("different feeds get the same stable ID")
+	}
+
+	// The podcast:guid wins over the feed URL, so a podcast keeps its ID
+	// when it moves.
+	guid := "917393e3-1b1e-5cef-ace4-edaa54e1f810"
+	byGUID := podcastStableID(withGUID("https://feeds.example.com/show.xml", guid))
+	if byGUID == byURL {
+		t.Error("podcast:guid is ignored")
+	}
+	if got := podcastStableID(withGUID("https://new-host.example.com/feed", " "+guid+" ")); got != byGUID {
+		t.Errorf("moved podcast got stable ID %s, want %s", got, byGUID)
+	}
+}
+
+func TestEpisodeStableID(t *testing.T) {
+	if got := episodeStableID("", "ep-1"); got != "" {
+		t.Errorf("episode of a podcast without a stable ID got %q", got)
+	}
+	a := episodeStableID("podcast-a", "ep-1")
+	if a == "" || a != episodeStableID("podcast-a", "ep-1") {
+		t.Error("the same episode gets different stable IDs")
+	}
+	if a == episodeStableID("podcast-b", "ep-1") || a == episodeStableID("podcast-a", "ep-2") {
+		t.Error("different episodes get the same stable ID")
+	}
+}
+
+func TestIngestStableIDs(t *testing.T) {
+	// The same feed ingested into two databases gets the same stable IDs,
+	// though the database IDs differ.
+	server := newFeedServer(t)
+	feedURL := server.setFeed("/podcast.xml", "podcast.xml")
+	var podcasts []Podcast
+	var episodes [][]Episode
+	for i := 0; i < 2; i++ {
+		in := newIngester(t, newMemoryStore())
+		in.crawl(feedURL)
+		p := in.podcast(feedURL)
+		podcasts = append(podcasts, p)
+		episodes = append(episodes, in.episodes(p))
+	}
+	if podcasts[0].StableID == "" || podcasts[0].StableID != podcasts[1].StableID {
+		t.Errorf("podcast stable IDs %q and %q", podcasts[0].StableID, podcasts[1].StableID)
+	}
+	if podcasts[0].ID == podcasts[1].ID {
+		t.Error("both databases gave the podcast the same ID")
+	}
+	if len(episodes[0]) != 3 || len(episodes[1]) != 3 {
+		t.Fatalf("%d and %d episodes stored, want 3", len(episodes[0]), len(episodes[1]))
+	}
+	for i := range episodes[0] {
+		a, b := episodes[0][i], episodes[1][i]
+		if a.StableID == "" || a.StableID != b.StableID {
+			t.Errorf("episode %s stable IDs %q and %q", a.Guid, a.StableID, b.StableID)
+		}
+		if want := episodeStableID(podcasts[0].StableID, normalizeGUID(a.Guid)); a.StableID != want {
+			t.Errorf("episode %s stable ID %q, want %q", a.Guid, a.StableID, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// runStats counts feed outcomes over a whole run. Feeds are processed
+// concurrently, so all counters are updated atomically.
+type runStats struct {
+	processed     int64
+	failed        int64
+	skippedRobots int64
+	fetchTimeouts int64
+	retries       int64
+	dbTimeouts    int64
+	newPodcasts   int64
+	newEpisodes   int64
+	skippedNotDue int64
+	notModified   int64
+	retired       int64
+	dead          int64
+	moved         int64
+
+	mu          sync.Mutex
+	quarantined map[string]int // by feed
+}
+
+var stats runStats
+
+func (s *runStats) add(counter *int64) {
+	atomic.AddInt64(counter, 1)
+}
+
+func (s *runStats) get(counter *int64) int64 {
+	return atomic.LoadInt64(counter)
+}
+
+// addQuarantined counts n quarantined episodes of feed.
+func (s *runStats) addQuarantined(feed string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.quarantined == nil {
+		s.quarantined = make(map[string]int)
+	}
+	s.quarantined[feed] += n
+}
+
+func (s *runStats) logSummary() {
+	infof(context.Background(), "Summary: %d feeds processed, %d failed (%d fetch timeouts, %d database timeouts), %d fetches retried, %d skipped by robots.txt, %d not due yet, %d not modified, %d retired, %d marked dead, %d moved, %d new podcasts, %d new episodes",
+		atomic.LoadInt64(&s.processed), atomic.LoadInt64(&s.failed),
+		atomic.LoadInt64(&s.fetchTimeouts), atomic.LoadInt64(&s.dbTimeouts),
+		atomic.LoadInt64(&s.retries),
+		atomic.LoadInt64(&s.skippedRobots), atomic.LoadInt64(&s.skippedNotDue),
+		atomic.LoadInt64(&s.notModified),
+		atomic.LoadInt64(&s.retired), atomic.LoadInt64(&s.dead), atomic.LoadInt64(&s.moved),
+		atomic.LoadInt64(&s.newPodcasts), atomic.LoadInt64(&s.newEpisodes))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feeds := make([]string, 0, len(s.quarantined))
+	for feed := range s.quarantined {
+		feeds = append(feeds, feed)
+	}
+	sort.Strings(feeds)
+	for _, feed := range feeds {
+		infof(context.Background(), "Quarantined %d episodes of %s", s.quarantined[feed], redactURL(feed))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errNotFound is returned by a Store when a requested document doesn't exist.
+var errNotFound = errors.New("not found")
+
+// Store is everything the crawler needs from its database. Documents keep
+// their bson field names in every implementation, so field updates are
+// expressed as bson.M keyed by those names.
+type Store interface {
+	// Init creates indexes or schema as needed. Check verifies that the
+	// store can be reached and that Init has nothing left to do.
+	Init(ctx context.Context) error
+	Check(ctx context.Context) error
+	Close(ctx context.Context) error
+	// InNamespace returns a view of the store that only sees podcasts and
+	// episodes of namespace ns and puts new ones there. Crawl runs are
+	// shared by all namespaces.
+	InNamespace(ns string) Store
+
+	Podcasts(ctx context.Context) ([]Podcast, error)
+	PodcastByFeed(ctx context.Context, feed string) (Podcast, error)
+	// PodcastBySlug returns the podcast with slug slug or, failing that,
+	// the one that had it as a former slug among its aliases. It fails
+	// with errNotFound if there is none.
+	PodcastBySlug(ctx context.Context, slug string) (Podcast, error)
+	// InsertPodcast stores a new podcast and sets its ID.
+	InsertPodcast(ctx context.Context, podcast *Podcast) error
+	UpdatePodcast(ctx context.Context, id primitive.ObjectID, set bson.M) error
+
+	// EpisodeGUIDs returns which of guids belong to stored episodes of a
+	// podcast, and EpisodesByGUID those episodes. GUIDs are compared
+	// normalized and the returned ones are normalized. Blank GUIDs match
+	// nothing.
+	EpisodeGUIDs(ctx context.Context, podlistUrl string, guids []string) (map[string]bool, error)
+	EpisodesByGUID(ctx context.Context, podlistUrl string, guids []string) ([]Episode, error)
+	Episodes(ctx context.Context, podlistUrl string) ([]Episode, error)
+	// WalkEpisodes calls fn for every stored episode of a podcast without
+	// holding them all in memory, and stops at the first error of fn.
+	WalkEpisodes(ctx context.Context, podlistUrl string, fn func(Episode) error) error
+	// EpisodePage returns up to limit episodes of a podcast, or of all
+	// podcasts if podlistUrl is "", ordered by publish date, newest first
+	// unless oldestFirst, and skips the first offset of them. Removed
+	// episodes are left out.
+	EpisodePage(ctx context.Context, podlistUrl string, offset, limit int, oldestFirst bool) ([]Episode, error)
+	// EpisodeByEnclosure returns the first published episode with the
+	// enclosure key key of a podcast other than otherThan, or errNotFound.
+	EpisodeByEnclosure(ctx context.Context, key, otherThan string) (Episode, error)
+	// InsertEpisodes stores new episodes and returns those it inserted,
+	// with their ID and namespace set. An episode whose GUID is stored for
+	// its podcast already is left out; episodes without a GUID are always
+	// inserted.
+	InsertEpisodes(ctx context.Context, episodes []Episode) ([]Episode, error)
+	UpdateEpisode(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// UpdateEpisodes applies many updates at once.
+	UpdateEpisodes(ctx context.Context, updates []EpisodeUpdate) error
+	DeleteEpisodes(ctx context.Context, ids []primitive.ObjectID) error
+	// ArchiveEpisodes moves episodes to an archive nothing is read from.
+	ArchiveEpisodes(ctx context.Context, episodes []Episode) error
+	// MoveEpisodes points all episodes of the podcast from at the podcast
+	// to and returns how many there were.
+	MoveEpisodes(ctx context.Context, from, to string) (int, error)
+	// SetEpisodesPodcastImage sets the podcastImage of all episodes of a
+	// podcast and returns how many of them had a different one.
+	SetEpisodesPodcastImage(ctx context.Context, podlistUrl, image string) (int, error)
+	// SetEpisodesPodcastID does the same for the podcastId.
+	SetEpisodesPodcastID(ctx context.Context, podlistUrl string, id primitive.ObjectID) (int, error)
+	// MoveToNamespace moves a podcast and its episodes to namespace ns and
+	// returns how many episodes there were.
+	MoveToNamespace(ctx context.Context, podcast Podcast, ns string) (int, error)
+
+	// RefreshPodcastStats recomputes the episode statistics of one podcast,
+	// BackfillPodcastStats those of all podcasts. The latter returns the
+	// number of podcasts updated. Removed episodes don't count.
+	RefreshPodcastStats(ctx context.Context, podlistUrl string) error
+	BackfillPodcastStats(ctx context.Context) (int, error)
+
+	// QuarantineEpisode stores an episode that failed validation in the
+	// namespace, replacing an earlier entry with the same podcast and key.
+	QuarantineEpisode(ctx context.Context, q QuarantinedEpisode) error
+
+	// InsertCrawlRun stores the start of a run and sets its ID.
+	InsertCrawlRun(ctx context.Context, run *CrawlRun) error
+	UpdateCrawlRun(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// CrawlRuns returns the most recent runs, newest first.
+	CrawlRuns(ctx context.Context, limit int) ([]CrawlRun, error)
+
+	// InsertChanges stores changes of a crawl. Changes calls fn for every
+	// change recorded at or after since, oldest first, and stops at the
+	// first error of fn. Like crawl runs they are shared by all namespaces.
+	InsertChanges(ctx context.Context, changes []Change) error
+	Changes(ctx context.Context, since time.Time, fn func(Change) error) error
+
+	// SetFeedWarnings replaces the warnings of a feed, removing the entry
+	// if there are none. FeedWarnings calls fn for every feed with
+	// warnings, ordered by feed URL, and stops at the first error of fn.
+	// They are shared by all namespaces.
+	SetFeedWarnings(ctx context.Context, w FeedWarnings) error
+	FeedWarnings(ctx context.Context, fn func(FeedWarnings) error) error
+
+	// SetFeedMeta replaces the validators of a feed, removing the entry if
+	// there are none. FeedMeta returns those of feed, or errNotFound. They
+	// are shared by all namespaces.
+	SetFeedMeta(ctx context.Context, m FeedMeta) error
+	FeedMeta(ctx context.Context, feed string) (FeedMeta, error)
+
+	// ListedFeeds returns the feed list kept in the store, disabled feeds
+	// included, in the order they were added. InsertListedFeed adds f
+	// unless its URL is listed in its namespace already, and reports
+	// whether it did. UpdateListedFeed sets fields other than the URL of
+	// the feed url of namespace ns, DeleteListedFeed takes it off the
+	// list; both fail with errNotFound if it isn't listed. The feed list
+	// is shared by all namespaces, its entries name their own.
+	ListedFeeds(ctx context.Context) ([]ListedFeed, error)
+	InsertListedFeed(ctx context.Context, f ListedFeed) (bool, error)
+	UpdateListedFeed(ctx context.Context, url, ns string, set bson.M) error
+	DeleteListedFeed(ctx context.Context, url, ns string) error
+}
+
+// EpisodeUpdate sets the fields in Set on the episode with ID, see
+// Store.UpdateEpisodes.
+type EpisodeUpdate struct {
+	ID  primitive.ObjectID
+	Set bson.M
+}
+
+// storeCloseTimeout is how long closing a store may take.
+const storeCloseTimeout = 5 * time.Second
+
+// closeStore closes store with a context of its own, as the one it was
+// opened with may have expired by the end of a long run.
+func closeStore(store Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeCloseTimeout)
+	defer cancel()
+	if err := store.Close(ctx); err != nil {
+		errorf(ctx, "Error closing store: %v", err)
+	}
+}
+
+// openStore connects to the store described by dsn: a MongoDB URI, a
+// postgres:// URL, "sqlite:<file>" for an SQLite database or "memory:" for
+// a throwaway in-memory store.
+func openStore(ctx context.Context, dsn string) (Store, error) {
+	switch {
+	case dsn == "memory:":
+		return newMemoryStore(), nil
+	case strings.HasPrefix(dsn, "sqlite:"):
+		return openSQLiteStore(strings.TrimPrefix(dsn, "sqlite:"))
+	case strings.HasPrefix(dsn, "mongodb://"), strings.HasPrefix(dsn, "mongodb+srv://"):
+		return openMongoStore(ctx, dsn)
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return openPostgresStore(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported store %q", dsn)
+	}
+}
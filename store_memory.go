@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps everything in memory and forgets it on exit. It is
+// handy for dry runs against a feed list and as a stand-in for a real
+// database wherever one isn't available.
+type memoryStore struct {
+	*memoryData
+	// namespace is the stored namespace of the podcasts and episodes the
+	// store sees, "" for the default namespace.
+	namespace string
+}
+
+// memoryData is what all namespaces of a memoryStore share.
+type memoryData struct {
+	mu         sync.Mutex
+	podcasts   map[primitive.ObjectID]Podcast
+	episodes   map[primitive.ObjectID]Episode
+	crawlRuns  map[primitive.ObjectID]CrawlRun
+	quarantine map[string]QuarantinedEpisode
+	changes    []Change
+	warnings   map[string]FeedWarnings
+	feedMeta   map[string]FeedMeta
+	archive    map[primitive.ObjectID]Episode
+	feeds      map[[2]string]ListedFeed
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{memoryData: &memoryData{
+		podcasts:   make(map[primitive.ObjectID]Podcast),
+		episodes:   make(map[primitive.ObjectID]Episode),
+		crawlRuns:  make(map[primitive.ObjectID]CrawlRun),
+		quarantine: make(map[string]QuarantinedEpisode),
+		warnings:   make(map[string]FeedWarnings),
+		feedMeta:   make(map[string]FeedMeta),
+		archive:    make(map[primitive.ObjectID]Episode),
+		feeds:      make(map[[2]string]ListedFeed),
+	}}
+}
+
+func (s *memoryStore) Init(ctx context.Context) error  { return nil }
+func (s *memoryStore) Close(ctx context.Context) error { return nil }
+func (s *memoryStore) Check(ctx context.Context) error { return nil }
+
+func (s *memoryStore) InNamespace(ns string) Store {
+	return &memoryStore{memoryData: s.memoryData, namespace: storedNamespace(ns)}
+}
+
+// setFields applies set to doc the way $set would for top level fields,
+// by round-tripping doc through its bson representation.
+func setFields(doc interface{}, set bson.M) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for k, v := range set {
+		m[k] = v
+	}
+	if data, err = bson.Marshal(m); err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, doc)
+}
+
+func (s *memoryStore) Podcasts(ctx context.Context) ([]Podcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	podcasts := make([]Podcast, 0, len(s.podcasts))
+	for _, p := range s.podcasts {
+		if p.Namespace == s.namespace {
+			podcasts = append(podcasts, p)
+		}
+	}
+	return podcasts, nil
+}
+
+func (s *memoryStore) PodcastByFeed(ctx context.Context, feed string) (Podcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.podcasts {
+		if p.Feed == feed && p.Namespace == s.namespace {
+			return p, nil
+		}
+	}
+	return Podcast{}, errNotFound
+}
+
+func (s *memoryStore) PodcastBySlug(ctx context.Context, slug string) (Podcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var alias *Podcast
+	for _, p := range s.podcasts {
+		if p.Namespace != s.namespace {
+			continue
+		}
+		if p.PodlistUrl == slug {
+			return p, nil
+		}
+		if alias == nil && containsString(p.Aliases, slug) {
+			p := p
+			alias = &p
+		}
+	}
+	if alias == nil {
+		return Podcast{}, errNotFound
+	}
+	return *alias, nil
+}
+
+func (s *memoryStore) InsertPodcast(ctx context.Context, podcast *Podcast) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if podcast.ID.IsZero() {
+		podcast.ID = primitive.NewObjectID()
+	}
+	podcast.Namespace = s.namespace
+	s.podcasts[podcast.ID] = *podcast
+	return nil
+}
+
+func (s *memoryStore) UpdatePodcast(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.podcasts[id]
+	if !ok {
+		return nil
+	}
+	if err := setFields(&p, set); err != nil {
+		return err
+	}
+	s.podcasts[id] = p
+	return nil
+}
+
+func (s *memoryStore) EpisodeGUIDs(ctx context.Context, podlistUrl string, guids []string) (map[string]bool, error) {
+	episodes, _ := s.EpisodesByGUID(ctx, podlistUrl, guids)
+	found := make(map[string]bool)
+	for _, e := range episodes {
+		found[normalizeGUID(e.Guid)] = true
+	}
+	return found, nil
+}
+
+func (s *memoryStore) EpisodesByGUID(ctx context.Context, podlistUrl string, guids []string) ([]Episode, error) {
+	wanted := normalizedSet(guids)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var episodes []Episode
+	for _, e := range s.episodes {
+		if e.PodcastUrl == podlistUrl && e.Namespace == s.namespace && wanted[normalizeGUID(e.Guid)] {
+			episodes = append(episodes, e)
+		}
+	}
+	return episodes, nil
+}
+
+func (s *memoryStore) Episodes(ctx context.Context, podlistUrl string) ([]Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var episodes []Episode
+	for _, e := range s.episodes {
+		if e.PodcastUrl == podlistUrl && e.Namespace == s.namespace {
+			episodes = append(episodes, e)
+		}
+	}
+	return episodes, nil
+}
+
+func (s *memoryStore) EpisodePage(ctx context.Context, podlistUrl string, offset, limit int, oldestFirst bool) ([]Episode, error) {
+	s.mu.Lock()
+	var episodes []Episode
+	for _, e := range s.episodes {
+		if (podlistUrl == "" || e.PodcastUrl == podlistUrl) && e.Namespace == s.namespace && e.RemovedAt.IsZero() {
+			episodes = append(episodes, e)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(episodes, func(i, j int) bool {
+		a, b := episodes[i], episodes[j]
+		if oldestFirst {
+			a, b = b, a
+		}
+		if !a.Published.Equal(b.Published) {
+			return a.Published.After(b.Published)
+		}
+		return a.ID.Hex() > b.ID.Hex()
+	})
+	if offset >= len(episodes) {
+		return nil, nil
+	}
+	episodes = episodes[offset:]
+	if len(episodes) > limit {
+		episodes = episodes[:limit]
+	}
+	return episodes, nil
+}
+
+func (s *memoryStore) EpisodeByEnclosure(ctx context.Context, key, otherThan string) (Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *Episode
+	for _, e := range s.episodes {
+		if e.EnclosureKey != key || e.PodcastUrl == otherThan || e.Namespace != s.namespace {
+			continue
+		}
+		if found == nil || e.Published.Before(found.Published) ||
+			(e.Published.Equal(found.Published) && e.ID.Hex() < found.ID.Hex()) {
+			e := e
+			found = &e
+		}
+	}
+	if found == nil {
+		return Episode{}, errNotFound
+	}
+	return *found, nil
+}
+
+func (s *memoryStore) WalkEpisodes(ctx context.Context, podlistUrl string, fn func(Episode) error) error {
+	episodes, _ := s.Episodes(ctx, podlistUrl)
+	for _, e := range episodes {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertEpisodes skips episodes already stored under the same podcast and
+// normalized GUID, as the unique index of the other stores does.
+func (s *memoryStore) InsertEpisodes(ctx context.Context, episodes []Episode) ([]Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inserted []Episode
+	stored := make(map[[2]string]bool)
+	for _, e := range s.episodes {
+		if e.Namespace == s.namespace && e.NormalizedGuid != "" {
+			stored[[2]string{e.PodcastUrl, e.NormalizedGuid}] = true
+		}
+	}
+	for _, e := range episodes {
+		key := [2]string{e.PodcastUrl, e.NormalizedGuid}
+		if e.NormalizedGuid != "" && stored[key] {
+			continue
+		}
+		stored[key] = true
+		if e.ID.IsZero() {
+			e.ID = primitive.NewObjectID()
+		}
+		e.Namespace = s.namespace
+		s.episodes[e.ID] = e
+		inserted = append(inserted, e)
+	}
+	return inserted, nil
+}
+
+func (s *memoryStore) UpdateEpisode(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.episodes[id]
+	if !ok {
+		return nil
+	}
+	if err := setFields(&e, set); err != nil {
+		return err
+	}
+	s.episodes[id] = e
+	return nil
+}
+
+func (s *memoryStore) UpdateEpisodes(ctx context.Context, updates []EpisodeUpdate) error {
+	for _, u := range updates {
+		if err := s.UpdateEpisode(ctx, u.ID, u.Set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) MoveEpisodes(ctx context.Context, from, to string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := 0
+	for id, e := range s.episodes {
+		if e.PodcastUrl == from && e.Namespace == s.namespace {
+			e.PodcastUrl = to
+			s.episodes[id] = e
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+func (s *memoryStore) SetEpisodesPodcastImage(ctx context.Context, podlistUrl, image string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := 0
+	for id, e := range s.episodes {
+		if e.PodcastUrl == podlistUrl && e.Namespace == s.namespace && e.PodcastImage != image {
+			e.PodcastImage = image
+			s.episodes[id] = e
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (s *memoryStore) SetEpisodesPodcastID(ctx context.Context, podlistUrl string, podcastID primitive.ObjectID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := 0
+	for id, e := range s.episodes {
+		if e.PodcastUrl == podlistUrl && e.Namespace == s.namespace && e.PodcastId != podcastID {
+			e.PodcastId = podcastID
+			s.episodes[id] = e
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (s *memoryStore) MoveToNamespace(ctx context.Context, podcast Podcast, ns string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := 0
+	for id, e := range s.episodes {
+		if e.PodcastUrl == podcast.PodlistUrl && e.Namespace == s.namespace {
+			e.Namespace = storedNamespace(ns)
+			s.episodes[id] = e
+			moved++
+		}
+	}
+	if p, ok := s.podcasts[podcast.ID]; ok {
+		p.Namespace = storedNamespace(ns)
+		s.podcasts[podcast.ID] = p
+	}
+	return moved, nil
+}
+
+func (s *memoryStore) DeleteEpisodes(ctx context.Context, ids []primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.episodes, id)
+	}
+	return nil
+}
+
+func (s *memoryStore) ArchiveEpisodes(ctx context.Context, episodes []Episode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range episodes {
+		s.archive[e.ID] = e
+		delete(s.episodes, e.ID)
+	}
+	return nil
+}
+
+func (s *memoryStore) episodeStats(podlistUrl string) episodeStats {
+	es := episodeStats{PodlistUrl: podlistUrl}
+	for _, e := range s.episodes {
+		if e.PodcastUrl == podlistUrl && e.Namespace == s.namespace && e.RemovedAt.IsZero() {
+			es.Recent = append(es.Recent, e.Published)
+		}
+	}
+	sort.Slice(es.Recent, func(i, j int) bool { return es.Recent[i].After(es.Recent[j]) })
+	es.EpisodeCount = len(es.Recent)
+	if len(es.Recent) > statsSampleSize {
+		es.Recent = es.Recent[:statsSampleSize]
+	}
+	if len(es.Recent) > 0 {
+		es.LatestEpisodeAt = es.Recent[0]
+	}
+	return es
+}
+
+func (s *memoryStore) RefreshPodcastStats(ctx context.Context, podlistUrl string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	es := s.episodeStats(podlistUrl)
+	for id, p := range s.podcasts {
+		if p.PodlistUrl != podlistUrl || p.Namespace != s.namespace {
+			continue
+		}
+		if err := setFields(&p, es.fields()); err != nil {
+			return err
+		}
+		s.podcasts[id] = p
+	}
+	return nil
+}
+
+func (s *memoryStore) BackfillPodcastStats(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := 0
+	for id, p := range s.podcasts {
+		if p.Namespace != s.namespace {
+			continue
+		}
+		es := s.episodeStats(p.PodlistUrl)
+		if err := setFields(&p, es.fields()); err != nil {
+			return updated, err
+		}
+		s.podcasts[id] = p
+		updated++
+	}
+	return updated, nil
+}
+
+func (s *memoryStore) QuarantineEpisode(ctx context.Context, q QuarantinedEpisode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q.Namespace = s.namespace
+	s.quarantine[q.Namespace+"\x00"+q.PodcastUrl+"\x00"+q.Key] = q
+	return nil
+}
+
+func (s *memoryStore) InsertCrawlRun(ctx context.Context, run *CrawlRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	s.crawlRuns[run.ID] = *run
+	return nil
+}
+
+func (s *memoryStore) UpdateCrawlRun(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.crawlRuns[id]
+	if !ok {
+		return nil
+	}
+	if err := setFields(&run, set); err != nil {
+		return err
+	}
+	s.crawlRuns[id] = run
+	return nil
+}
+
+func (s *memoryStore) CrawlRuns(ctx context.Context, limit int) ([]CrawlRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]CrawlRun, 0, len(s.crawlRuns))
+	for _, run := range s.crawlRuns {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (s *memoryStore) InsertChanges(ctx context.Context, changes []Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range changes {
+		if c.ID.IsZero() {
+			c.ID = primitive.NewObjectID()
+		}
+		s.changes = append(s.changes, c)
+	}
+	return nil
+}
+
+func (s *memoryStore) Changes(ctx context.Context, since time.Time, fn func(Change) error) error {
+	s.mu.Lock()
+	changes := make([]Change, 0, len(s.changes))
+	for _, c := range s.changes {
+		if !c.At.Before(since) {
+			changes = append(changes, c)
+		}
+	}
+	s.mu.Unlock()
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].At.Before(changes[j].At) })
+	for _, c := range changes {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetFeedWarnings(ctx context.Context, w FeedWarnings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(w.Warnings) == 0 {
+		delete(s.warnings, w.Feed)
+	} else {
+		s.warnings[w.Feed] = w
+	}
+	return nil
+}
+
+func (s *memoryStore) FeedWarnings(ctx context.Context, fn func(FeedWarnings) error) error {
+	s.mu.Lock()
+	warnings := make([]FeedWarnings, 0, len(s.warnings))
+	for _, w := range s.warnings {
+		warnings = append(warnings, w)
+	}
+	s.mu.Unlock()
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Feed < warnings[j].Feed })
+	for _, w := range warnings {
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetFeedMeta(ctx context.Context, m FeedMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !m.conditional() {
+		delete(s.feedMeta, m.Feed)
+	} else {
+		s.feedMeta[m.Feed] = m
+	}
+	return nil
+}
+
+func (s *memoryStore) FeedMeta(ctx context.Context, feed string) (FeedMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.feedMeta[feed]
+	if !ok {
+		return FeedMeta{}, errNotFound
+	}
+	return m, nil
+}
+
+func (s *memoryStore) ListedFeeds(ctx context.Context) ([]ListedFeed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feeds := make([]ListedFeed, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		feeds = append(feeds, f)
+	}
+	sort.SliceStable(feeds, func(i, j int) bool {
+		if !feeds[i].AddedAt.Equal(feeds[j].AddedAt) {
+			return feeds[i].AddedAt.Before(feeds[j].AddedAt)
+		}
+		return feeds[i].URL < feeds[j].URL
+	})
+	return feeds, nil
+}
+
+func (s *memoryStore) InsertListedFeed(ctx context.Context, f ListedFeed) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{f.URL, f.Namespace}
+	if _, ok := s.feeds[key]; ok {
+		return false, nil
+	}
+	s.feeds[key] = f
+	return true, nil
+}
+
+func (s *memoryStore) UpdateListedFeed(ctx context.Context, url, ns string, set bson.M) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{url, ns}
+	f, ok := s.feeds[key]
+	if !ok {
+		return errNotFound
+	}
+	if err := setFields(&f, set); err != nil {
+		return err
+	}
+	s.feeds[key] = f
+	return nil
+}
+
+func (s *memoryStore) DeleteListedFeed(ctx context.Context, url, ns string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{url, ns}
+	if _, ok := s.feeds[key]; !ok {
+		return errNotFound
+	}
+	delete(s.feeds, key)
+	return nil
+}
@@ -0,0 +1,1056 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/auth"
+)
+
+// mongoStore is the default Store, keeping podcasts and episodes in two
+// MongoDB collections.
+type mongoStore struct {
+	client     *mongo.Client
+	podcasts   *mongo.Collection
+	episodes   *mongo.Collection
+	crawlRuns  *mongo.Collection
+	quarantine *mongo.Collection
+	changes    *mongo.Collection
+	warnings   *mongo.Collection
+	feedMeta   *mongo.Collection
+	archive    *mongo.Collection
+	feeds      *mongo.Collection
+
+	// namespace is the stored namespace of the podcasts and episodes the
+	// store sees, "" for the default namespace.
+	namespace string
+}
+
+func openMongoStore(ctx context.Context, uri string) (*mongoStore, error) {
+	opts, err := mongoClientOptions(uri)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MongoDB client: %v", err)
+	}
+
+	if err := waitForMongo(ctx, client); err != nil {
+		// ctx may be what ran out while waiting.
+		disconnectCtx, cancel := context.WithTimeout(context.Background(), storeCloseTimeout)
+		client.Disconnect(disconnectCtx)
+		cancel()
+		return nil, mongoConnectError(err)
+	}
+
+	infof(ctx, "Successfully connected to MongoDB")
+	database := client.Database(dbName)
+	return &mongoStore{
+		client:     client,
+		podcasts:   database.Collection(podcastCollection),
+		episodes:   database.Collection(episodeCollection),
+		crawlRuns:  database.Collection(crawlRunCollection),
+		quarantine: database.Collection(quarantineCollection),
+		changes:    database.Collection(changeCollection),
+		warnings:   database.Collection(warningCollection),
+		feedMeta:   database.Collection(feedMetaCollection),
+		archive:    database.Collection(archiveCollection),
+		feeds:      database.Collection(feedListCollection),
+	}, nil
+}
+
+// mongoPingTimeout bounds a single ping while waiting for MongoDB to come
+// up.
+const mongoPingTimeout = 5 * time.Second
+
+// waitForMongo pings the server until it answers, for up to
+// --mongo-startup-wait, so PodGo may start before its database does.
+// Rejected credentials won't get better by waiting and fail right away.
+func waitForMongo(ctx context.Context, client *mongo.Client) error {
+	if config.MongoStartupWait <= 0 {
+		return client.Ping(ctx, nil)
+	}
+	deadline := time.Now().Add(config.MongoStartupWait)
+	delay := time.Second
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, mongoPingTimeout)
+		err := client.Ping(pingCtx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		var authErr *auth.Error
+		if errors.As(err, &authErr) || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		warnf(ctx, "MongoDB is not reachable yet, retrying in %s: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		if delay *= 2; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
+	}
+}
+
+// mongoClientOptions returns the client options for uri with the TLS, pool
+// and write concern settings of config applied on top.
+func mongoClientOptions(uri string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri)
+	if config.MongoCAFile != "" || config.MongoTLSInsecure {
+		tlsConfig := &tls.Config{}
+		if opts.TLSConfig != nil {
+			tlsConfig = opts.TLSConfig.Clone()
+		}
+		if config.MongoCAFile != "" {
+			pem, err := ioutil.ReadFile(config.MongoCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading CA file: %v", err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file %s", config.MongoCAFile)
+			}
+			tlsConfig.RootCAs = roots
+		}
+		if config.MongoTLSInsecure {
+			warnf(context.Background(), "Not verifying the certificate of the MongoDB server")
+			tlsConfig.InsecureSkipVerify = true
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if config.MongoMaxPoolSize > 0 {
+		opts.SetMaxPoolSize(config.MongoMaxPoolSize)
+	}
+	if config.MongoMinPoolSize > 0 {
+		opts.SetMinPoolSize(config.MongoMinPoolSize)
+	}
+	if config.MongoServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(config.MongoServerSelectionTimeout)
+	}
+	if config.MongoMajority {
+		opts.SetWriteConcern(writeconcern.Majority())
+	}
+	return opts, opts.Validate()
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+func (s *mongoStore) InNamespace(ns string) Store {
+	scoped := *s
+	scoped.namespace = storedNamespace(ns)
+	return &scoped
+}
+
+// scoped adds the namespace of s to filter. Documents of the default
+// namespace have no namespace field, which a nil value matches.
+func (s *mongoStore) scoped(filter bson.M) bson.M {
+	if s.namespace == "" {
+		filter["namespace"] = nil
+	} else {
+		filter["namespace"] = s.namespace
+	}
+	return filter
+}
+
+// mongoIndex is an index the store needs on one of its collections.
+type mongoIndex struct {
+	collection *mongo.Collection
+	model      mongo.IndexModel
+	// failure is logged if the index can't be created.
+	failure string
+}
+
+func (s *mongoStore) indexes() []mongoIndex {
+	return []mongoIndex{
+		{s.podcasts, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "podlistUrl", Value: 1}},
+		}, "Error creating index on podcasts collection"},
+		{s.podcasts, mongo.IndexModel{
+			Keys: bson.D{{Key: "latestEpisodeAt", Value: -1}},
+		}, "Error creating index on podcasts collection"},
+		{s.podcasts, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "feed", Value: 1}},
+		}, "Error creating index on podcasts collection"},
+		{s.episodes, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "podcastUrl", Value: 1}},
+		}, "Error creating index on episodes collection"},
+		// Episodes are unique per podcast and normalized GUID. Episodes
+		// stored before normalizedGuid existed are left out until
+		// --repair-guids or dedupe-episodes fills it in.
+		{s.episodes, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "podcastUrl", Value: 1}, {Key: "normalizedGuid", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"normalizedGuid": bson.M{"$type": "string"}}),
+		}, "Error creating unique index on episodes collection, run dedupe-episodes to remove duplicates"},
+		{s.episodes, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "enclosureKey", Value: 1}},
+		}, "Error creating index on episodes collection"},
+		{s.episodes, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "podcastUrl", Value: 1}, {Key: "published", Value: -1}},
+		}, "Error creating index on episodes collection"},
+		{s.episodes, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "published", Value: -1}},
+		}, "Error creating index on episodes collection"},
+		// Entries quarantined before key existed are left out.
+		{s.quarantine, mongo.IndexModel{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "podcastUrl", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"key": bson.M{"$type": "string"}}),
+		}, "Error creating unique index on quarantine collection"},
+		{s.crawlRuns, mongo.IndexModel{
+			Keys: bson.D{{Key: "startedAt", Value: -1}},
+		}, "Error creating index on crawl runs collection"},
+		{s.changes, mongo.IndexModel{
+			Keys: bson.D{{Key: "at", Value: 1}},
+		}, "Error creating index on changes collection"},
+		{s.feeds, mongo.IndexModel{
+			Keys:    bson.D{{Key: "url", Value: 1}, {Key: "namespace", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}, "Error creating index on feeds collection"},
+	}
+}
+
+// Init creates the indexes. A missing unique index would let duplicates
+// in, so failing to create one fails Init, after the others were created;
+// the rest only cost speed and are logged.
+func (s *mongoStore) Init(ctx context.Context) error {
+	// The quarantine used to be unique per podcast and GUID alone, which
+	// turns away the same episode in another namespace and a second one
+	// without a GUID.
+	_, err := s.quarantine.Indexes().DropOne(ctx, "podcastUrl_1_normalizedGuid_1")
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Code == mongoIndexNotFound || cmdErr.Code == mongoNamespaceNotFound)) {
+		return fmt.Errorf("error dropping old index on quarantine collection: %v", err)
+	}
+	var uniqueErr error
+	for _, index := range s.indexes() {
+		_, err := index.collection.Indexes().CreateOne(ctx, index.model)
+		if err == nil {
+			continue
+		}
+		if opts := index.model.Options; opts != nil && opts.Unique != nil && *opts.Unique {
+			if uniqueErr == nil {
+				uniqueErr = fmt.Errorf("%s: %v", index.failure, err)
+			}
+			continue
+		}
+		warnf(ctx, "%s: %v", index.failure, err)
+	}
+	return uniqueErr
+}
+
+// Check pings the server and looks for the indexes Init creates.
+func (s *mongoStore) Check(ctx context.Context) error {
+	if err := s.client.Ping(ctx, nil); err != nil {
+		return mongoConnectError(err)
+	}
+	existing := make(map[string]bool)
+	var missing []string
+	for _, index := range s.indexes() {
+		coll := index.collection.Name()
+		if !existing[coll] {
+			specs, err := index.collection.Indexes().ListSpecifications(ctx)
+			if err != nil {
+				return fmt.Errorf("error listing indexes of %s: %v", coll, err)
+			}
+			for _, spec := range specs {
+				existing[coll+" "+indexKeys(spec.KeysDocument)] = true
+			}
+			existing[coll] = true
+		}
+		keys, err := bson.Marshal(index.model.Keys)
+		if err != nil {
+			return err
+		}
+		if name := coll + " " + indexKeys(keys); !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing indexes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// indexKeys describes the keys of an index, e.g. "namespace_1_feed_1".
+// Directions are compared by value, whatever number type they are stored
+// as.
+func indexKeys(keys bson.Raw) string {
+	elems, err := keys.Elements()
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for _, e := range elems {
+		v := e.Value()
+		var direction string
+		switch v.Type {
+		case bsontype.Int32:
+			direction = fmt.Sprint(v.Int32())
+		case bsontype.Int64:
+			direction = fmt.Sprint(v.Int64())
+		case bsontype.Double:
+			direction = fmt.Sprint(int64(v.Double()))
+		default:
+			direction = v.String()
+		}
+		parts = append(parts, e.Key()+"_"+direction)
+	}
+	return strings.Join(parts, "_")
+}
+
+func (s *mongoStore) Podcasts(ctx context.Context) ([]Podcast, error) {
+	cursor, err := s.podcasts.Find(ctx, s.scoped(bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+	var podcasts []Podcast
+	if err := cursor.All(ctx, &podcasts); err != nil {
+		return nil, err
+	}
+	return podcasts, nil
+}
+
+func (s *mongoStore) PodcastByFeed(ctx context.Context, feed string) (Podcast, error) {
+	var podcast Podcast
+	err := s.podcasts.FindOne(ctx, s.scoped(bson.M{"feed": feed})).Decode(&podcast)
+	if err == mongo.ErrNoDocuments {
+		return podcast, errNotFound
+	}
+	return podcast, err
+}
+
+func (s *mongoStore) PodcastBySlug(ctx context.Context, slug string) (Podcast, error) {
+	var podcast Podcast
+	err := s.podcasts.FindOne(ctx, s.scoped(bson.M{"podlistUrl": slug})).Decode(&podcast)
+	if err == mongo.ErrNoDocuments {
+		err = s.podcasts.FindOne(ctx, s.scoped(bson.M{"aliases": slug})).Decode(&podcast)
+	}
+	if err == mongo.ErrNoDocuments {
+		return podcast, errNotFound
+	}
+	return podcast, err
+}
+
+// Writes are retried on transient errors. Inserts get their IDs up front,
+// so a retry of an insert that did reach the server shows up as a
+// duplicate key and can be told apart from a genuine conflict.
+
+func (s *mongoStore) InsertPodcast(ctx context.Context, podcast *Podcast) error {
+	if podcast.ID.IsZero() {
+		podcast.ID = primitive.NewObjectID()
+	}
+	podcast.Namespace = s.namespace
+	return retryMongo(ctx, "insert podcast", func(attempt int) error {
+		_, err := s.podcasts.InsertOne(ctx, podcast)
+		if attempt > 1 && mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (s *mongoStore) UpdatePodcast(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return retryMongo(ctx, "update podcast", func(int) error {
+		_, err := s.podcasts.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+		return err
+	})
+}
+
+// guidFilter matches the episodes of a podcast with one of guids. Episodes
+// stored before normalizedGuid existed are matched by their raw GUID, so
+// callers still have to compare the normalized GUIDs of what they get.
+func (s *mongoStore) guidFilter(podlistUrl string, guids []string) bson.M {
+	normalized := make([]string, len(guids))
+	for i, g := range guids {
+		normalized[i] = normalizeGUID(g)
+	}
+	return s.scoped(bson.M{
+		"podcastUrl": podlistUrl,
+		"$or": bson.A{
+			bson.M{"normalizedGuid": bson.M{"$in": normalized}},
+			bson.M{"guid": bson.M{"$in": guids}},
+		},
+	})
+}
+
+func (s *mongoStore) EpisodeGUIDs(ctx context.Context, podlistUrl string, guids []string) (map[string]bool, error) {
+	if guids = lookupGUIDs(guids); len(guids) == 0 {
+		return make(map[string]bool), nil
+	}
+	wanted := normalizedSet(guids)
+	opts := options.Find().SetProjection(bson.M{"_id": 0, "guid": 1})
+	cursor, err := s.episodes.Find(ctx, s.guidFilter(podlistUrl, guids), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	found := make(map[string]bool)
+	for cursor.Next(ctx) {
+		var e struct {
+			Guid string `bson:"guid"`
+		}
+		if err := cursor.Decode(&e); err != nil {
+			return nil, err
+		}
+		if n := normalizeGUID(e.Guid); wanted[n] {
+			found[n] = true
+		}
+	}
+	return found, cursor.Err()
+}
+
+func (s *mongoStore) EpisodesByGUID(ctx context.Context, podlistUrl string, guids []string) ([]Episode, error) {
+	if guids = lookupGUIDs(guids); len(guids) == 0 {
+		return nil, nil
+	}
+	wanted := normalizedSet(guids)
+	cursor, err := s.episodes.Find(ctx, s.guidFilter(podlistUrl, guids))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var episodes []Episode
+	for cursor.Next(ctx) {
+		var e Episode
+		if err := cursor.Decode(&e); err != nil {
+			return nil, err
+		}
+		if wanted[normalizeGUID(e.Guid)] {
+			episodes = append(episodes, e)
+		}
+	}
+	return episodes, cursor.Err()
+}
+
+func (s *mongoStore) EpisodeByEnclosure(ctx context.Context, key, otherThan string) (Episode, error) {
+	filter := s.scoped(bson.M{"enclosureKey": key, "podcastUrl": bson.M{"$ne": otherThan}})
+	opts := options.FindOne().SetSort(bson.D{{Key: "published", Value: 1}, {Key: "_id", Value: 1}})
+	var episode Episode
+	err := s.episodes.FindOne(ctx, filter, opts).Decode(&episode)
+	if err == mongo.ErrNoDocuments {
+		return Episode{}, errNotFound
+	}
+	return episode, err
+}
+
+func (s *mongoStore) Episodes(ctx context.Context, podlistUrl string) ([]Episode, error) {
+	cursor, err := s.episodes.Find(ctx, s.scoped(bson.M{"podcastUrl": podlistUrl}))
+	if err != nil {
+		return nil, err
+	}
+	var episodes []Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+func (s *mongoStore) EpisodePage(ctx context.Context, podlistUrl string, offset, limit int, oldestFirst bool) ([]Episode, error) {
+	// Episodes that were restored have a zero removedAt.
+	filter := bson.M{"removedAt": bson.M{"$not": bson.M{"$gt": time.Time{}}}}
+	if podlistUrl != "" {
+		filter["podcastUrl"] = podlistUrl
+	}
+	order := -1
+	if oldestFirst {
+		order = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "published", Value: order}, {Key: "_id", Value: order}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	cursor, err := s.episodes.Find(ctx, s.scoped(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []Episode
+	if err := cursor.All(ctx, &episodes); err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+func (s *mongoStore) WalkEpisodes(ctx context.Context, podlistUrl string, fn func(Episode) error) error {
+	cursor, err := s.episodes.Find(ctx, s.scoped(bson.M{"podcastUrl": podlistUrl}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var e Episode
+		if err := cursor.Decode(&e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// InsertEpisodes upserts the episodes keyed on podcast and normalized GUID,
+// so an episode another run stored in the meantime is left as it is. Two
+// runs upserting the same episode at once may still collide on the unique
+// index, which means the episode is stored and is fine. Episodes without a
+// GUID can't be told apart and are inserted as they are.
+func (s *mongoStore) InsertEpisodes(ctx context.Context, episodes []Episode) ([]Episode, error) {
+	var operations []mongo.WriteModel
+	prepared := make([]Episode, len(episodes))
+	for i, episode := range episodes {
+		if episode.ID.IsZero() {
+			episode.ID = primitive.NewObjectID()
+		}
+		episode.Namespace = s.namespace
+		prepared[i] = episode
+		if episode.NormalizedGuid == "" {
+			operations = append(operations, mongo.NewInsertOneModel().SetDocument(episode))
+			continue
+		}
+		filter := s.scoped(bson.M{"podcastUrl": episode.PodcastUrl, "normalizedGuid": episode.NormalizedGuid})
+		operations = append(operations, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$setOnInsert": episode}).
+			SetUpsert(true))
+	}
+
+	// An attempt that is retried may have written some episodes already,
+	// so what each attempt inserted is collected.
+	inserted := make(map[int]bool)
+	err := retryMongo(ctx, "insert episodes", func(attempt int) error {
+		res, err := s.episodes.BulkWrite(ctx, operations, options.BulkWrite().SetOrdered(false))
+		if res != nil {
+			for i := range res.UpsertedIDs {
+				inserted[int(i)] = true
+			}
+		}
+		// Write errors tell which of the plain inserts failed; other errors
+		// leave it unknown until the next attempt.
+		failed := make(map[int]bool)
+		bwe, ok := err.(mongo.BulkWriteException)
+		if ok {
+			for _, we := range bwe.WriteErrors {
+				// A plain insert that collides on _id in a retry was
+				// written by an earlier attempt.
+				if we.Code != 11000 || attempt == 1 || prepared[we.Index].NormalizedGuid != "" {
+					failed[we.Index] = true
+				}
+			}
+		}
+		if err == nil || ok {
+			for i, e := range prepared {
+				if e.NormalizedGuid == "" && !failed[i] {
+					inserted[i] = true
+				}
+			}
+		}
+		if mongo.IsDuplicateKeyError(err) && !hasOtherWriteErrors(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stored []Episode
+	for i, e := range prepared {
+		if inserted[i] {
+			stored = append(stored, e)
+		}
+	}
+	return stored, nil
+}
+
+// hasOtherWriteErrors reports whether a bulk write error contains anything
+// but duplicate key errors.
+func hasOtherWriteErrors(err error) bool {
+	bwe, ok := err.(mongo.BulkWriteException)
+	if !ok {
+		return true
+	}
+	if bwe.WriteConcernError != nil {
+		return true
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Code != 11000 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *mongoStore) UpdateEpisode(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return retryMongo(ctx, "update episode", func(int) error {
+		_, err := s.episodes.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+		return err
+	})
+}
+
+func (s *mongoStore) UpdateEpisodes(ctx context.Context, updates []EpisodeUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, len(updates))
+	for i, u := range updates {
+		models[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": u.ID}).SetUpdate(bson.M{"$set": u.Set})
+	}
+	return retryMongo(ctx, "update episodes", func(int) error {
+		_, err := s.episodes.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+}
+
+func (s *mongoStore) MoveEpisodes(ctx context.Context, from, to string) (int, error) {
+	var moved int
+	err := retryMongo(ctx, "move episodes", func(int) error {
+		result, err := s.episodes.UpdateMany(ctx, s.scoped(bson.M{"podcastUrl": from}), bson.M{"$set": bson.M{"podcastUrl": to}})
+		if err == nil {
+			moved = int(result.ModifiedCount)
+		}
+		return err
+	})
+	return moved, err
+}
+
+func (s *mongoStore) SetEpisodesPodcastImage(ctx context.Context, podlistUrl, image string) (int, error) {
+	var updated int
+	err := retryMongo(ctx, "set episode images", func(int) error {
+		filter := s.scoped(bson.M{"podcastUrl": podlistUrl, "podcastImage": bson.M{"$ne": image}})
+		result, err := s.episodes.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"podcastImage": image}})
+		if err == nil {
+			updated = int(result.ModifiedCount)
+		}
+		return err
+	})
+	return updated, err
+}
+
+func (s *mongoStore) SetEpisodesPodcastID(ctx context.Context, podlistUrl string, id primitive.ObjectID) (int, error) {
+	var updated int
+	err := retryMongo(ctx, "set episode podcast IDs", func(int) error {
+		filter := s.scoped(bson.M{"podcastUrl": podlistUrl, "podcastId": bson.M{"$ne": id}})
+		result, err := s.episodes.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"podcastId": id}})
+		if err == nil {
+			updated = int(result.ModifiedCount)
+		}
+		return err
+	})
+	return updated, err
+}
+
+func (s *mongoStore) MoveToNamespace(ctx context.Context, podcast Podcast, ns string) (int, error) {
+	set := bson.M{"$set": bson.M{"namespace": storedNamespace(ns)}}
+	if storedNamespace(ns) == "" {
+		set = bson.M{"$unset": bson.M{"namespace": ""}}
+	}
+	var moved int
+	err := retryMongo(ctx, "move episodes to namespace", func(int) error {
+		result, err := s.episodes.UpdateMany(ctx, s.scoped(bson.M{"podcastUrl": podcast.PodlistUrl}), set)
+		if err == nil {
+			moved = int(result.ModifiedCount)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = retryMongo(ctx, "move podcast to namespace", func(int) error {
+		_, err := s.podcasts.UpdateOne(ctx, bson.M{"_id": podcast.ID}, set)
+		return err
+	})
+	return moved, err
+}
+
+func (s *mongoStore) DeleteEpisodes(ctx context.Context, ids []primitive.ObjectID) error {
+	return retryMongo(ctx, "delete episodes", func(int) error {
+		_, err := s.episodes.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		return err
+	})
+}
+
+func (s *mongoStore) ArchiveEpisodes(ctx context.Context, episodes []Episode) error {
+	ids := make([]primitive.ObjectID, len(episodes))
+	var operations []mongo.WriteModel
+	for i, e := range episodes {
+		ids[i] = e.ID
+		operations = append(operations, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": e.ID}).
+			SetReplacement(e).
+			SetUpsert(true))
+	}
+	// Copies archived by an earlier attempt are replaced, so archiving
+	// again after a failure is safe.
+	err := retryMongo(ctx, "archive episodes", func(int) error {
+		_, err := s.archive.BulkWrite(ctx, operations, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.DeleteEpisodes(ctx, ids)
+}
+
+// episodeStatsPipeline groups the matched episodes by podcast and collects
+// what we need for the denormalized statistics on the podcast document.
+// Removed episodes are left out.
+func episodeStatsPipeline(match bson.M) mongo.Pipeline {
+	match["removedAt"] = bson.M{"$not": bson.M{"$gt": time.Time{}}}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "published", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$podcastUrl"},
+			{Key: "latestEpisodeAt", Value: bson.M{"$first": "$published"}},
+			{Key: "episodeCount", Value: bson.M{"$sum": 1}},
+			{Key: "recent", Value: bson.M{"$push": "$published"}},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "latestEpisodeAt", Value: 1},
+			{Key: "episodeCount", Value: 1},
+			{Key: "recent", Value: bson.M{"$slice": bson.A{"$recent", statsSampleSize}}},
+		}}},
+	}
+}
+
+func (s *mongoStore) RefreshPodcastStats(ctx context.Context, podlistUrl string) error {
+	cursor, err := s.episodes.Aggregate(ctx, episodeStatsPipeline(s.scoped(bson.M{"podcastUrl": podlistUrl})))
+	if err != nil {
+		return fmt.Errorf("error aggregating episode stats: %v", err)
+	}
+	var results []episodeStats
+	if err := cursor.All(ctx, &results); err != nil {
+		return fmt.Errorf("error decoding episode stats: %v", err)
+	}
+	es := episodeStats{PodlistUrl: podlistUrl}
+	if len(results) > 0 {
+		es = results[0]
+	}
+
+	err = retryMongo(ctx, "update podcast stats", func(int) error {
+		_, err := s.podcasts.UpdateOne(ctx, s.scoped(bson.M{"podlistUrl": podlistUrl}), bson.M{"$set": es.fields()})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error updating podcast stats: %v", err)
+	}
+	return nil
+}
+
+// BackfillPodcastStats computes the statistics for all podcasts with one
+// aggregation over the episodes collection and writes them back in bulk.
+func (s *mongoStore) BackfillPodcastStats(ctx context.Context) (int, error) {
+	cursor, err := s.episodes.Aggregate(ctx, episodeStatsPipeline(s.scoped(bson.M{})), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, fmt.Errorf("error aggregating episode stats: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var operations []mongo.WriteModel
+	var counted []string
+	for cursor.Next(ctx) {
+		var es episodeStats
+		if err := cursor.Decode(&es); err != nil {
+			return 0, fmt.Errorf("error decoding episode stats: %v", err)
+		}
+		counted = append(counted, es.PodlistUrl)
+		operations = append(operations, mongo.NewUpdateOneModel().
+			SetFilter(s.scoped(bson.M{"podlistUrl": es.PodlistUrl})).
+			SetUpdate(bson.M{"$set": es.fields()}))
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, fmt.Errorf("error reading episode stats: %v", err)
+	}
+	// Podcasts whose episodes are all gone don't show up in the
+	// aggregation, but their counts must drop to zero as well.
+	operations = append(operations, mongo.NewUpdateManyModel().
+		SetFilter(s.scoped(bson.M{"podlistUrl": bson.M{"$nin": counted}, "episodeCount": bson.M{"$gt": 0}})).
+		SetUpdate(bson.M{"$set": episodeStats{}.fields()}))
+
+	var result *mongo.BulkWriteResult
+	err = retryMongo(ctx, "write podcast stats", func(int) error {
+		result, err = s.podcasts.BulkWrite(ctx, operations, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error writing podcast stats: %v", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+func (s *mongoStore) QuarantineEpisode(ctx context.Context, q QuarantinedEpisode) error {
+	q.Namespace = s.namespace
+	filter := s.scoped(bson.M{"podcastUrl": q.PodcastUrl, "key": q.Key})
+	return retryMongo(ctx, "quarantine episode", func(int) error {
+		_, err := s.quarantine.ReplaceOne(ctx, filter, q, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (s *mongoStore) InsertCrawlRun(ctx context.Context, run *CrawlRun) error {
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	return retryMongo(ctx, "insert crawl run", func(attempt int) error {
+		_, err := s.crawlRuns.InsertOne(ctx, run)
+		if attempt > 1 && mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (s *mongoStore) UpdateCrawlRun(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return retryMongo(ctx, "update crawl run", func(int) error {
+		_, err := s.crawlRuns.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+		return err
+	})
+}
+
+func (s *mongoStore) CrawlRuns(ctx context.Context, limit int) ([]CrawlRun, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := s.crawlRuns.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var runs []CrawlRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (s *mongoStore) InsertChanges(ctx context.Context, changes []Change) error {
+	docs := make([]interface{}, len(changes))
+	for i, c := range changes {
+		if c.ID.IsZero() {
+			c.ID = primitive.NewObjectID()
+		}
+		docs[i] = c
+	}
+	return retryMongo(ctx, "insert changes", func(attempt int) error {
+		_, err := s.changes.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if attempt > 1 && mongo.IsDuplicateKeyError(err) && !hasOtherWriteErrors(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (s *mongoStore) Changes(ctx context.Context, since time.Time, fn func(Change) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.changes.Find(ctx, bson.M{"at": bson.M{"$gte": since}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var c Change
+		if err := cursor.Decode(&c); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *mongoStore) SetFeedWarnings(ctx context.Context, w FeedWarnings) error {
+	filter := bson.M{"_id": w.Feed}
+	return retryMongo(ctx, "store feed warnings", func(int) error {
+		if len(w.Warnings) == 0 {
+			_, err := s.warnings.DeleteOne(ctx, filter)
+			return err
+		}
+		_, err := s.warnings.ReplaceOne(ctx, filter, w, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (s *mongoStore) FeedWarnings(ctx context.Context, fn func(FeedWarnings) error) error {
+	cursor, err := s.warnings.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var w FeedWarnings
+		if err := cursor.Decode(&w); err != nil {
+			return err
+		}
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *mongoStore) SetFeedMeta(ctx context.Context, m FeedMeta) error {
+	filter := bson.M{"_id": m.Feed}
+	return retryMongo(ctx, "store feed validators", func(int) error {
+		if !m.conditional() {
+			_, err := s.feedMeta.DeleteOne(ctx, filter)
+			return err
+		}
+		_, err := s.feedMeta.ReplaceOne(ctx, filter, m, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (s *mongoStore) FeedMeta(ctx context.Context, feed string) (FeedMeta, error) {
+	var m FeedMeta
+	err := s.feedMeta.FindOne(ctx, bson.M{"_id": feed}).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return m, errNotFound
+	}
+	return m, err
+}
+
+// listedFeedFilter matches the listed feed url of namespace ns. Feeds of
+// the default namespace have no namespace field, which a nil value
+// matches.
+func listedFeedFilter(url, ns string) bson.M {
+	filter := bson.M{"url": url, "namespace": nil}
+	if ns != "" {
+		filter["namespace"] = ns
+	}
+	return filter
+}
+
+func (s *mongoStore) ListedFeeds(ctx context.Context) ([]ListedFeed, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "addedAt", Value: 1}, {Key: "url", Value: 1}})
+	cursor, err := s.feeds.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var feeds []ListedFeed
+	err = cursor.All(ctx, &feeds)
+	return feeds, err
+}
+
+func (s *mongoStore) InsertListedFeed(ctx context.Context, f ListedFeed) (bool, error) {
+	var inserted bool
+	err := retryMongo(ctx, "add feed", func(int) error {
+		res, err := s.feeds.UpdateOne(ctx, listedFeedFilter(f.URL, f.Namespace),
+			bson.M{"$setOnInsert": f}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		inserted = res.UpsertedCount > 0
+		return nil
+	})
+	return inserted, err
+}
+
+func (s *mongoStore) UpdateListedFeed(ctx context.Context, url, ns string, set bson.M) error {
+	var matched int64
+	err := retryMongo(ctx, "update feed", func(int) error {
+		res, err := s.feeds.UpdateOne(ctx, listedFeedFilter(url, ns), bson.M{"$set": set})
+		if err != nil {
+			return err
+		}
+		matched = res.MatchedCount
+		return nil
+	})
+	if err == nil && matched == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+func (s *mongoStore) DeleteListedFeed(ctx context.Context, url, ns string) error {
+	var deleted int64
+	err := retryMongo(ctx, "remove feed", func(int) error {
+		res, err := s.feeds.DeleteOne(ctx, listedFeedFilter(url, ns))
+		if err != nil {
+			return err
+		}
+		deleted = res.DeletedCount
+		return nil
+	})
+	if err == nil && deleted == 0 {
+		return errNotFound
+	}
+	return err
+}
+
+// mongoNamespaceNotFound and mongoIndexNotFound are the error codes of
+// dropping an index of a collection or an index that doesn't exist.
+const (
+	mongoNamespaceNotFound = 26
+	mongoIndexNotFound     = 27
+)
+
+// mongoChangeStreamUnsupported is the error code of MongoDB servers that
+// aren't part of a replica set when asked for a change stream.
+const mongoChangeStreamUnsupported = 40573
+
+// WatchEpisodes follows the episodes collection with a change stream.
+// When the stream breaks it is reopened after the last event it
+// delivered, so a short outage loses nothing.
+func (s *mongoStore) WatchEpisodes(ctx context.Context, fn func(Episode)) error {
+	match := bson.D{{Key: "operationType", Value: "insert"}}
+	if s.namespace == "" {
+		match = append(match, bson.E{Key: "fullDocument.namespace", Value: nil})
+	} else {
+		match = append(match, bson.E{Key: "fullDocument.namespace", Value: s.namespace})
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+
+	var resumeToken bson.Raw
+	for {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+		stream, err := s.episodes.Watch(ctx, pipeline, opts)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == mongoChangeStreamUnsupported {
+			return errWatchUnsupported
+		}
+		if err == nil {
+			for stream.Next(ctx) {
+				var event struct {
+					FullDocument Episode `bson:"fullDocument"`
+				}
+				if err := stream.Decode(&event); err != nil {
+					errorf(ctx, "Error decoding change event: %v", err)
+				} else {
+					fn(event.FullDocument)
+				}
+				resumeToken = stream.ResumeToken()
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		warnf(ctx, "episode change stream broke, reopening: %v", err)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
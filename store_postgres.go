@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations are the schema migrations of a PostgreSQL database,
+// which sqlStore uses the same way as SQLite. The first entry is the schema
+// sqlMigrations had arrived at when PostgreSQL was added; append changes to
+// both lists from then on.
+var postgresMigrations = []string{
+	`CREATE TABLE podcasts (
+		id TEXT PRIMARY KEY,
+		namespace TEXT NOT NULL DEFAULT '',
+		feed TEXT NOT NULL,
+		podlist_url TEXT NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX podcasts_namespace_feed ON podcasts (namespace, feed);
+	CREATE INDEX podcasts_namespace_podlist_url ON podcasts (namespace, podlist_url);
+	CREATE TABLE episodes (
+		id TEXT PRIMARY KEY,
+		namespace TEXT NOT NULL DEFAULT '',
+		podcast_url TEXT NOT NULL,
+		guid TEXT NOT NULL,
+		normalized_guid TEXT NOT NULL DEFAULT '',
+		enclosure_key TEXT NOT NULL DEFAULT '',
+		published BIGINT NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX episodes_namespace_guid ON episodes (namespace, podcast_url, normalized_guid);
+	CREATE UNIQUE INDEX episodes_unique_guid ON episodes (namespace, podcast_url, normalized_guid) WHERE normalized_guid != '';
+	CREATE INDEX episodes_enclosure_key ON episodes (namespace, enclosure_key);
+	CREATE INDEX episodes_namespace_published ON episodes (namespace, published);
+	CREATE INDEX episodes_namespace_podcast_published ON episodes (namespace, podcast_url, published);
+	CREATE TABLE crawl_runs (
+		id TEXT PRIMARY KEY,
+		started_at BIGINT NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX crawl_runs_started_at ON crawl_runs (started_at);
+	CREATE TABLE quarantine (
+		podcast_url TEXT NOT NULL,
+		normalized_guid TEXT NOT NULL,
+		doc TEXT NOT NULL,
+		PRIMARY KEY (podcast_url, normalized_guid)
+	);
+	CREATE TABLE changes (
+		id TEXT PRIMARY KEY,
+		at BIGINT NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX changes_at ON changes (at);
+	CREATE TABLE feed_warnings (
+		feed TEXT PRIMARY KEY,
+		doc TEXT NOT NULL
+	);
+	CREATE TABLE feed_meta (
+		feed TEXT PRIMARY KEY,
+		doc TEXT NOT NULL
+	);`,
+	`ALTER TABLE episodes ADD COLUMN removed_at BIGINT NOT NULL DEFAULT 0;
+	CREATE TABLE episodes_archive (
+		id TEXT PRIMARY KEY,
+		namespace TEXT NOT NULL DEFAULT '',
+		podcast_url TEXT NOT NULL,
+		doc TEXT NOT NULL
+	);`,
+	`CREATE TABLE feeds (
+		url TEXT NOT NULL,
+		namespace TEXT NOT NULL DEFAULT '',
+		added_at BIGINT NOT NULL,
+		doc TEXT NOT NULL,
+		PRIMARY KEY (url, namespace)
+	);`,
+	`CREATE TABLE quarantine_keyed (
+		namespace TEXT NOT NULL DEFAULT '',
+		podcast_url TEXT NOT NULL,
+		episode_key TEXT NOT NULL,
+		doc TEXT NOT NULL,
+		PRIMARY KEY (namespace, podcast_url, episode_key)
+	);
+	INSERT INTO quarantine_keyed (podcast_url, episode_key, doc) SELECT podcast_url, normalized_guid, doc FROM quarantine;
+	DROP TABLE quarantine;
+	ALTER TABLE quarantine_keyed RENAME TO quarantine;`,
+}
+
+// openPostgresStore connects to the PostgreSQL database of the
+// postgres:// URL dsn.
+func openPostgresStore(dsn string) (*sqlStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+	}
+	infof(context.Background(), "Successfully connected to PostgreSQL")
+	return &sqlStore{db: &sqlDB{DB: db, postgres: true}}, nil
+}
@@ -0,0 +1,1181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sqlStore keeps podcasts and episodes in an SQL database. Each row holds
+// the whole document as relaxed Extended JSON, exactly as it would be stored
+// in MongoDB, plus the few columns we look documents up by.
+type sqlStore struct {
+	db *sqlDB
+	// namespace is the stored namespace of the podcasts and episodes the
+	// store sees, "" for the default namespace.
+	namespace string
+}
+
+// sqlMigrations are applied in order on Init of an SQLite database. Never
+// change an existing entry, append a new one instead, and to
+// postgresMigrations as well.
+var sqlMigrations = []string{
+	`CREATE TABLE podcasts (
+		id TEXT PRIMARY KEY,
+		feed TEXT NOT NULL,
+		podlist_url TEXT NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX podcasts_feed ON podcasts (feed);
+	CREATE INDEX podcasts_podlist_url ON podcasts (podlist_url);
+	CREATE TABLE episodes (
+		id TEXT PRIMARY KEY,
+		podcast_url TEXT NOT NULL,
+		guid TEXT NOT NULL,
+		published INTEGER NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX episodes_podcast_url ON episodes (podcast_url, published);`,
+	`ALTER TABLE episodes ADD COLUMN normalized_guid TEXT NOT NULL DEFAULT '';
+	CREATE INDEX episodes_normalized_guid ON episodes (podcast_url, normalized_guid);`,
+	`CREATE TABLE crawl_runs (
+		id TEXT PRIMARY KEY,
+		started_at INTEGER NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX crawl_runs_started_at ON crawl_runs (started_at);`,
+	`CREATE TABLE quarantine (
+		podcast_url TEXT NOT NULL,
+		normalized_guid TEXT NOT NULL,
+		doc TEXT NOT NULL,
+		PRIMARY KEY (podcast_url, normalized_guid)
+	);`,
+	`ALTER TABLE podcasts ADD COLUMN namespace TEXT NOT NULL DEFAULT '';
+	ALTER TABLE episodes ADD COLUMN namespace TEXT NOT NULL DEFAULT '';
+	CREATE INDEX podcasts_namespace_feed ON podcasts (namespace, feed);
+	CREATE INDEX podcasts_namespace_podlist_url ON podcasts (namespace, podlist_url);
+	CREATE INDEX episodes_namespace_guid ON episodes (namespace, podcast_url, normalized_guid);`,
+	// Duplicates have to go before episodes can be unique; the first
+	// inserted copy is kept.
+	`DELETE FROM episodes WHERE normalized_guid != '' AND rowid NOT IN (
+		SELECT MIN(rowid) FROM episodes WHERE normalized_guid != '' GROUP BY namespace, podcast_url, normalized_guid
+	);
+	CREATE UNIQUE INDEX episodes_unique_guid ON episodes (namespace, podcast_url, normalized_guid) WHERE normalized_guid != '';`,
+	`CREATE TABLE changes (
+		id TEXT PRIMARY KEY,
+		at INTEGER NOT NULL,
+		doc TEXT NOT NULL
+	);
+	CREATE INDEX changes_at ON changes (at);`,
+	`CREATE TABLE feed_warnings (
+		feed TEXT PRIMARY KEY,
+		doc TEXT NOT NULL
+	);`,
+	`ALTER TABLE episodes ADD COLUMN enclosure_key TEXT NOT NULL DEFAULT '';
+	CREATE INDEX episodes_enclosure_key ON episodes (namespace, enclosure_key);`,
+	`CREATE TABLE feed_meta (
+		feed TEXT PRIMARY KEY,
+		doc TEXT NOT NULL
+	);`,
+	`CREATE INDEX episodes_namespace_published ON episodes (namespace, published);
+	CREATE INDEX episodes_namespace_podcast_published ON episodes (namespace, podcast_url, published);`,
+	`ALTER TABLE episodes ADD COLUMN removed_at INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE episodes_archive (
+		id TEXT PRIMARY KEY,
+		namespace TEXT NOT NULL DEFAULT '',
+		podcast_url TEXT NOT NULL,
+		doc TEXT NOT NULL
+	);`,
+	`CREATE TABLE feeds (
+		url TEXT NOT NULL,
+		namespace TEXT NOT NULL DEFAULT '',
+		added_at INTEGER NOT NULL,
+		doc TEXT NOT NULL,
+		PRIMARY KEY (url, namespace)
+	);`,
+	`CREATE TABLE quarantine_keyed (
+		namespace TEXT NOT NULL DEFAULT '',
+		podcast_url TEXT NOT NULL,
+		episode_key TEXT NOT NULL,
+		doc TEXT NOT NULL,
+		PRIMARY KEY (namespace, podcast_url, episode_key)
+	);
+	INSERT INTO quarantine_keyed (podcast_url, episode_key, doc) SELECT podcast_url, normalized_guid, doc FROM quarantine;
+	DROP TABLE quarantine;
+	ALTER TABLE quarantine_keyed RENAME TO quarantine;`,
+}
+
+func openSQLiteStore(path string) (*sqlStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+	// SQLite only allows one writer at a time anyway.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+	infof(context.Background(), "Successfully opened SQLite database %s", path)
+	return &sqlStore{db: &sqlDB{DB: db}}, nil
+}
+
+// sqlDB is an SQL database with the queries of sqlStore, which are written
+// with ? placeholders, rewritten for its dialect.
+type sqlDB struct {
+	*sql.DB
+	// postgres is set for PostgreSQL, which numbers its placeholders, takes
+	// row locks and has migrations of its own.
+	postgres bool
+}
+
+// rebind returns query with its placeholders written the way the database
+// takes them.
+func (db *sqlDB) rebind(query string) string {
+	if !db.postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		fmt.Fprintf(&b, "$%d", n)
+	}
+	return b.String()
+}
+
+// forUpdate returns the select query locking the rows it reads until the
+// transaction ends, where that is needed. SQLite has a single writer anyway.
+func (db *sqlDB) forUpdate(query string) string {
+	if db.postgres {
+		return query + " FOR UPDATE"
+	}
+	return query
+}
+
+// migrations returns the schema migrations of the database.
+func (db *sqlDB) migrations() []string {
+	if db.postgres {
+		return postgresMigrations
+	}
+	return sqlMigrations
+}
+
+func (db *sqlDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.rebind(query), args...)
+}
+
+func (db *sqlDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.rebind(query), args...)
+}
+
+func (db *sqlDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.rebind(query), args...)
+}
+
+func (db *sqlDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sqlTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, db: db}, nil
+}
+
+// sqlTx is a transaction of an sqlDB.
+type sqlTx struct {
+	*sql.Tx
+	db *sqlDB
+}
+
+func (tx *sqlTx) forUpdate(query string) string {
+	return tx.db.forUpdate(query)
+}
+
+func (tx *sqlTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.db.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.db.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.db.rebind(query), args...)
+}
+
+func (tx *sqlTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.Tx.PrepareContext(ctx, tx.db.rebind(query))
+}
+
+func (s *sqlStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// Check pings the database and looks for pending migrations.
+func (s *sqlStore) Check(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("schema is not set up: %v", err)
+	}
+	if migrations := s.db.migrations(); version < len(migrations) {
+		return fmt.Errorf("schema is at version %d, %d migrations pending", version, len(migrations)-version)
+	}
+	return nil
+}
+
+func (s *sqlStore) InNamespace(ns string) Store {
+	return &sqlStore{db: s.db, namespace: storedNamespace(ns)}
+}
+
+func (s *sqlStore) Init(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("error creating migrations table: %v", err)
+	}
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	migrations := s.db.migrations()
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error applying migration %d: %v", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		infof(ctx, "Applied schema migration %d", i+1)
+	}
+	return nil
+}
+
+// unixOrZero returns t as a Unix time, 0 for the zero time.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func marshalDoc(doc interface{}) (string, error) {
+	b, err := bson.MarshalExtJSON(doc, false, false)
+	return string(b), err
+}
+
+func unmarshalDoc(data string, doc interface{}) error {
+	return bson.UnmarshalExtJSON([]byte(data), false, doc)
+}
+
+// applySet applies set to a stored document the way $set would for top
+// level fields.
+func applySet(data string, set bson.M) (string, error) {
+	var doc bson.M
+	if err := unmarshalDoc(data, &doc); err != nil {
+		return "", err
+	}
+	for k, v := range set {
+		doc[k] = v
+	}
+	return marshalDoc(doc)
+}
+
+func (s *sqlStore) Podcasts(ctx context.Context) ([]Podcast, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM podcasts WHERE namespace = ?`, s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var podcasts []Podcast
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var p Podcast
+		if err := unmarshalDoc(data, &p); err != nil {
+			return nil, err
+		}
+		podcasts = append(podcasts, p)
+	}
+	return podcasts, rows.Err()
+}
+
+func (s *sqlStore) PodcastByFeed(ctx context.Context, feed string) (Podcast, error) {
+	var podcast Podcast
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT doc FROM podcasts WHERE namespace = ? AND feed = ? LIMIT 1`, s.namespace, feed).Scan(&data)
+	if err == sql.ErrNoRows {
+		return podcast, errNotFound
+	}
+	if err != nil {
+		return podcast, err
+	}
+	err = unmarshalDoc(data, &podcast)
+	return podcast, err
+}
+
+// PodcastBySlug looks slugs up by the podlist_url column. Aliases are only
+// kept in the document, and looked up in its JSON.
+func (s *sqlStore) PodcastBySlug(ctx context.Context, slug string) (Podcast, error) {
+	var podcast Podcast
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT doc FROM podcasts WHERE namespace = ? AND podlist_url = ? LIMIT 1`, s.namespace, slug).Scan(&data)
+	if err == sql.ErrNoRows {
+		query := `SELECT doc FROM podcasts WHERE namespace = ? AND EXISTS (SELECT 1 FROM json_each(doc, '$.aliases') WHERE value = ?) LIMIT 1`
+		if s.db.postgres {
+			query = `SELECT doc FROM podcasts WHERE namespace = ? AND (doc::jsonb -> 'aliases') @> to_jsonb(?::text) LIMIT 1`
+		}
+		err = s.db.QueryRowContext(ctx, query, s.namespace, slug).Scan(&data)
+	}
+	if err == sql.ErrNoRows {
+		return podcast, errNotFound
+	}
+	if err != nil {
+		return podcast, err
+	}
+	err = unmarshalDoc(data, &podcast)
+	return podcast, err
+}
+
+func (s *sqlStore) InsertPodcast(ctx context.Context, podcast *Podcast) error {
+	if podcast.ID.IsZero() {
+		podcast.ID = primitive.NewObjectID()
+	}
+	podcast.Namespace = s.namespace
+	data, err := marshalDoc(podcast)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO podcasts (id, namespace, feed, podlist_url, doc) VALUES (?, ?, ?, ?, ?)`,
+		podcast.ID.Hex(), s.namespace, podcast.Feed, podcast.PodlistUrl, data)
+	return err
+}
+
+// UpdatePodcast applies set to the stored document the way $set would for
+// top level fields.
+func (s *sqlStore) UpdatePodcast(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var data string
+	err = tx.QueryRowContext(ctx, tx.forUpdate(`SELECT doc FROM podcasts WHERE id = ?`), id.Hex()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if data, err = applySet(data, set); err != nil {
+		return err
+	}
+	var podcast Podcast
+	if err := unmarshalDoc(data, &podcast); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE podcasts SET namespace = ?, feed = ?, podlist_url = ?, doc = ? WHERE id = ?`,
+		podcast.Namespace, podcast.Feed, podcast.PodlistUrl, data, id.Hex())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// guidQuery returns the condition and arguments matching the episodes of a
+// podcast with one of guids. Rows stored before normalized_guid existed are
+// matched by their raw GUID, so callers still have to compare the
+// normalized GUIDs of what they get.
+func (s *sqlStore) guidQuery(podlistUrl string, guids []string) (string, []interface{}) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(guids)), ", ")
+	args := []interface{}{s.namespace, podlistUrl}
+	for _, g := range guids {
+		args = append(args, normalizeGUID(g))
+	}
+	for _, g := range guids {
+		args = append(args, g)
+	}
+	return `namespace = ? AND podcast_url = ? AND (normalized_guid IN (` + placeholders + `) OR guid IN (` + placeholders + `))`, args
+}
+
+func (s *sqlStore) EpisodeGUIDs(ctx context.Context, podlistUrl string, guids []string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	if guids = lookupGUIDs(guids); len(guids) == 0 {
+		return found, nil
+	}
+	wanted := normalizedSet(guids)
+	where, args := s.guidQuery(podlistUrl, guids)
+	rows, err := s.db.QueryContext(ctx, `SELECT guid FROM episodes WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var guid string
+		if err := rows.Scan(&guid); err != nil {
+			return nil, err
+		}
+		if n := normalizeGUID(guid); wanted[n] {
+			found[n] = true
+		}
+	}
+	return found, rows.Err()
+}
+
+func (s *sqlStore) EpisodesByGUID(ctx context.Context, podlistUrl string, guids []string) ([]Episode, error) {
+	if guids = lookupGUIDs(guids); len(guids) == 0 {
+		return nil, nil
+	}
+	wanted := normalizedSet(guids)
+	where, args := s.guidQuery(podlistUrl, guids)
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM episodes WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []Episode
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e Episode
+		if err := unmarshalDoc(data, &e); err != nil {
+			return nil, err
+		}
+		if wanted[normalizeGUID(e.Guid)] {
+			episodes = append(episodes, e)
+		}
+	}
+	return episodes, rows.Err()
+}
+
+func (s *sqlStore) Episodes(ctx context.Context, podlistUrl string) ([]Episode, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM episodes WHERE namespace = ? AND podcast_url = ?`, s.namespace, podlistUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []Episode
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e Episode
+		if err := unmarshalDoc(data, &e); err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, rows.Err()
+}
+
+func (s *sqlStore) EpisodePage(ctx context.Context, podlistUrl string, offset, limit int, oldestFirst bool) ([]Episode, error) {
+	query := `SELECT doc FROM episodes WHERE namespace = ? AND removed_at = 0`
+	args := []interface{}{s.namespace}
+	if podlistUrl != "" {
+		query += ` AND podcast_url = ?`
+		args = append(args, podlistUrl)
+	}
+	if oldestFirst {
+		query += ` ORDER BY published, id`
+	} else {
+		query += ` ORDER BY published DESC, id DESC`
+	}
+	query += ` LIMIT ? OFFSET ?`
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []Episode
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var e Episode
+		if err := unmarshalDoc(data, &e); err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, rows.Err()
+}
+
+func (s *sqlStore) EpisodeByEnclosure(ctx context.Context, key, otherThan string) (Episode, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT doc FROM episodes WHERE namespace = ? AND enclosure_key = ? AND podcast_url != ?
+		ORDER BY published, id LIMIT 1`, s.namespace, key, otherThan).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Episode{}, errNotFound
+	}
+	if err != nil {
+		return Episode{}, err
+	}
+	var e Episode
+	err = unmarshalDoc(data, &e)
+	return e, err
+}
+
+func (s *sqlStore) WalkEpisodes(ctx context.Context, podlistUrl string, fn func(Episode) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM episodes WHERE namespace = ? AND podcast_url = ?`, s.namespace, podlistUrl)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var e Episode
+		if err := unmarshalDoc(data, &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) InsertEpisodes(ctx context.Context, episodes []Episode) ([]Episode, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO episodes (id, namespace, podcast_url, guid, normalized_guid, enclosure_key, published, doc) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var inserted []Episode
+	for _, e := range episodes {
+		if e.ID.IsZero() {
+			e.ID = primitive.NewObjectID()
+		}
+		e.Namespace = s.namespace
+		data, err := marshalDoc(e)
+		if err != nil {
+			return nil, err
+		}
+		res, err := stmt.ExecContext(ctx, e.ID.Hex(), s.namespace, e.PodcastUrl, e.Guid, e.NormalizedGuid, e.EnclosureKey, e.Published.Unix(), data)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			inserted = append(inserted, e)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+func (s *sqlStore) UpdateEpisode(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	return s.UpdateEpisodes(ctx, []EpisodeUpdate{{ID: id, Set: set}})
+}
+
+func (s *sqlStore) UpdateEpisodes(ctx context.Context, updates []EpisodeUpdate) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, u := range updates {
+		if err := updateEpisodeTx(ctx, tx, u.ID, u.Set); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateEpisodeTx applies set to the episode with id within tx.
+func updateEpisodeTx(ctx context.Context, tx *sqlTx, id primitive.ObjectID, set bson.M) error {
+	var data string
+	err := tx.QueryRowContext(ctx, tx.forUpdate(`SELECT doc FROM episodes WHERE id = ?`), id.Hex()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if data, err = applySet(data, set); err != nil {
+		return err
+	}
+	var e Episode
+	if err := unmarshalDoc(data, &e); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE episodes SET namespace = ?, podcast_url = ?, guid = ?, normalized_guid = ?, enclosure_key = ?, published = ?, removed_at = ?, doc = ? WHERE id = ?`,
+		e.Namespace, e.PodcastUrl, e.Guid, e.NormalizedGuid, e.EnclosureKey, e.Published.Unix(), unixOrZero(e.RemovedAt), data, id.Hex())
+	return err
+}
+
+func (s *sqlStore) MoveEpisodes(ctx context.Context, from, to string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, tx.forUpdate(`SELECT id, doc FROM episodes WHERE namespace = ? AND podcast_url = ?`), s.namespace, from)
+	if err != nil {
+		return 0, err
+	}
+	docs := make(map[string]string)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		docs[id] = data
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, data := range docs {
+		if data, err = applySet(data, bson.M{"podcastUrl": to}); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE episodes SET podcast_url = ?, doc = ? WHERE id = ?`, to, data, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(docs), tx.Commit()
+}
+
+func (s *sqlStore) SetEpisodesPodcastImage(ctx context.Context, podlistUrl, image string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, tx.forUpdate(`SELECT id, doc FROM episodes WHERE namespace = ? AND podcast_url = ?`), s.namespace, podlistUrl)
+	if err != nil {
+		return 0, err
+	}
+	docs := make(map[string]string)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		var e Episode
+		if err := unmarshalDoc(data, &e); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if e.PodcastImage != image {
+			docs[id] = data
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, data := range docs {
+		if data, err = applySet(data, bson.M{"podcastImage": image}); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE episodes SET doc = ? WHERE id = ?`, data, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(docs), tx.Commit()
+}
+
+func (s *sqlStore) SetEpisodesPodcastID(ctx context.Context, podlistUrl string, podcastID primitive.ObjectID) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, tx.forUpdate(`SELECT id, doc FROM episodes WHERE namespace = ? AND podcast_url = ?`), s.namespace, podlistUrl)
+	if err != nil {
+		return 0, err
+	}
+	docs := make(map[string]string)
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		var e Episode
+		if err := unmarshalDoc(data, &e); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if e.PodcastId != podcastID {
+			docs[id] = data
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, data := range docs {
+		if data, err = applySet(data, bson.M{"podcastId": podcastID}); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE episodes SET doc = ? WHERE id = ?`, data, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(docs), tx.Commit()
+}
+
+func (s *sqlStore) MoveToNamespace(ctx context.Context, podcast Podcast, ns string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	target := storedNamespace(ns)
+	docs := make(map[string]string)
+	rows, err := tx.QueryContext(ctx, tx.forUpdate(`SELECT id, doc FROM episodes WHERE namespace = ? AND podcast_url = ?`), s.namespace, podcast.PodlistUrl)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		docs[id] = data
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for id, data := range docs {
+		if data, err = applySet(data, bson.M{"namespace": target}); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE episodes SET namespace = ?, doc = ? WHERE id = ?`, target, data, id); err != nil {
+			return 0, err
+		}
+	}
+
+	var data string
+	err = tx.QueryRowContext(ctx, tx.forUpdate(`SELECT doc FROM podcasts WHERE id = ?`), podcast.ID.Hex()).Scan(&data)
+	if err != nil {
+		return 0, err
+	}
+	if data, err = applySet(data, bson.M{"namespace": target}); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE podcasts SET namespace = ?, doc = ? WHERE id = ?`, target, data, podcast.ID.Hex()); err != nil {
+		return 0, err
+	}
+	return len(docs), tx.Commit()
+}
+
+func (s *sqlStore) DeleteEpisodes(ctx context.Context, ids []primitive.ObjectID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM episodes WHERE id = ?`, id.Hex()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) ArchiveEpisodes(ctx context.Context, episodes []Episode) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range episodes {
+		data, err := marshalDoc(e)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO episodes_archive (id, namespace, podcast_url, doc) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET doc = excluded.doc`, e.ID.Hex(), s.namespace, e.PodcastUrl, data); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM episodes WHERE id = ?`, e.ID.Hex()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) episodeStats(ctx context.Context, podlistUrl string) (episodeStats, error) {
+	es := episodeStats{PodlistUrl: podlistUrl}
+	rows, err := s.db.QueryContext(ctx, `SELECT published FROM episodes WHERE namespace = ? AND podcast_url = ? AND removed_at = 0 ORDER BY published DESC`, s.namespace, podlistUrl)
+	if err != nil {
+		return es, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var published int64
+		if err := rows.Scan(&published); err != nil {
+			return es, err
+		}
+		if es.EpisodeCount < statsSampleSize {
+			es.Recent = append(es.Recent, time.Unix(published, 0).UTC())
+		}
+		es.EpisodeCount++
+	}
+	if len(es.Recent) > 0 {
+		es.LatestEpisodeAt = es.Recent[0]
+	}
+	return es, rows.Err()
+}
+
+func (s *sqlStore) RefreshPodcastStats(ctx context.Context, podlistUrl string) error {
+	es, err := s.episodeStats(ctx, podlistUrl)
+	if err != nil {
+		return fmt.Errorf("error reading episode stats: %v", err)
+	}
+	var id string
+	err = s.db.QueryRowContext(ctx, `SELECT id FROM podcasts WHERE namespace = ? AND podlist_url = ?`, s.namespace, podlistUrl).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	return s.UpdatePodcast(ctx, oid, es.fields())
+}
+
+func (s *sqlStore) BackfillPodcastStats(ctx context.Context) (int, error) {
+	podcasts, err := s.Podcasts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, p := range podcasts {
+		es, err := s.episodeStats(ctx, p.PodlistUrl)
+		if err != nil {
+			return updated, fmt.Errorf("error reading episode stats: %v", err)
+		}
+		if err := s.UpdatePodcast(ctx, p.ID, es.fields()); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (s *sqlStore) QuarantineEpisode(ctx context.Context, q QuarantinedEpisode) error {
+	q.Namespace = s.namespace
+	data, err := marshalDoc(q)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO quarantine (namespace, podcast_url, episode_key, doc) VALUES (?, ?, ?, ?)
+		ON CONFLICT (namespace, podcast_url, episode_key) DO UPDATE SET doc = excluded.doc`,
+		q.Namespace, q.PodcastUrl, q.Key, data)
+	return err
+}
+
+func (s *sqlStore) InsertCrawlRun(ctx context.Context, run *CrawlRun) error {
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	data, err := marshalDoc(run)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO crawl_runs (id, started_at, doc) VALUES (?, ?, ?)`,
+		run.ID.Hex(), run.StartedAt.Unix(), data)
+	return err
+}
+
+func (s *sqlStore) UpdateCrawlRun(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var data string
+	err = tx.QueryRowContext(ctx, tx.forUpdate(`SELECT doc FROM crawl_runs WHERE id = ?`), id.Hex()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if data, err = applySet(data, set); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE crawl_runs SET doc = ? WHERE id = ?`, data, id.Hex()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) CrawlRuns(ctx context.Context, limit int) ([]CrawlRun, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM crawl_runs ORDER BY started_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []CrawlRun
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var run CrawlRun
+		if err := unmarshalDoc(data, &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *sqlStore) InsertChanges(ctx context.Context, changes []Change) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO changes (id, at, doc) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range changes {
+		if c.ID.IsZero() {
+			c.ID = primitive.NewObjectID()
+		}
+		data, err := marshalDoc(c)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, c.ID.Hex(), c.At.UnixNano(), data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Changes(ctx context.Context, since time.Time, fn func(Change) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM changes WHERE at >= ? ORDER BY at, id`, since.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var c Change
+		if err := unmarshalDoc(data, &c); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) SetFeedWarnings(ctx context.Context, w FeedWarnings) error {
+	if len(w.Warnings) == 0 {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM feed_warnings WHERE feed = ?`, w.Feed)
+		return err
+	}
+	data, err := marshalDoc(w)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO feed_warnings (feed, doc) VALUES (?, ?)
+		ON CONFLICT (feed) DO UPDATE SET doc = excluded.doc`, w.Feed, data)
+	return err
+}
+
+func (s *sqlStore) FeedWarnings(ctx context.Context, fn func(FeedWarnings) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM feed_warnings ORDER BY feed`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var w FeedWarnings
+		if err := unmarshalDoc(data, &w); err != nil {
+			return err
+		}
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) SetFeedMeta(ctx context.Context, m FeedMeta) error {
+	if !m.conditional() {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM feed_meta WHERE feed = ?`, m.Feed)
+		return err
+	}
+	data, err := marshalDoc(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO feed_meta (feed, doc) VALUES (?, ?)
+		ON CONFLICT (feed) DO UPDATE SET doc = excluded.doc`, m.Feed, data)
+	return err
+}
+
+func (s *sqlStore) FeedMeta(ctx context.Context, feed string) (FeedMeta, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT doc FROM feed_meta WHERE feed = ?`, feed).Scan(&data)
+	if err == sql.ErrNoRows {
+		return FeedMeta{}, errNotFound
+	}
+	if err != nil {
+		return FeedMeta{}, err
+	}
+	var m FeedMeta
+	err = unmarshalDoc(data, &m)
+	return m, err
+}
+
+func (s *sqlStore) ListedFeeds(ctx context.Context) ([]ListedFeed, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM feeds ORDER BY added_at, url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []ListedFeed
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var f ListedFeed
+		if err := unmarshalDoc(data, &f); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+func (s *sqlStore) InsertListedFeed(ctx context.Context, f ListedFeed) (bool, error) {
+	data, err := marshalDoc(f)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO feeds (url, namespace, added_at, doc) VALUES (?, ?, ?, ?)
+		ON CONFLICT (url, namespace) DO NOTHING`, f.URL, f.Namespace, f.AddedAt.Unix(), data)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) UpdateListedFeed(ctx context.Context, url, ns string, set bson.M) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var data string
+	err = tx.QueryRowContext(ctx, tx.forUpdate(`SELECT doc FROM feeds WHERE url = ? AND namespace = ?`), url, ns).Scan(&data)
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if data, err = applySet(data, set); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE feeds SET doc = ? WHERE url = ? AND namespace = ?`, data, url, ns); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) DeleteListedFeed(ctx context.Context, url, ns string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM feeds WHERE url = ? AND namespace = ?`, url, ns)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
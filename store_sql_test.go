@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "podgo.db")
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Check(ctx); err == nil {
+		t.Error("Check passed before the schema was set up")
+	}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Check(ctx); err != nil {
+		t.Errorf("Check after Init: %v", err)
+	}
+	podcast := testPodcast(t, store, "tech-talk")
+	store.Close(ctx)
+
+	// Reopening finds the schema and the data; Init has nothing to do.
+	store, err = openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close(ctx)
+	if err := store.Check(ctx); err != nil {
+		t.Errorf("Check after reopening: %v", err)
+	}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var applied int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(sqlMigrations); applied != want {
+		t.Errorf("%d migrations recorded, want %d", applied, want)
+	}
+	if _, err := store.PodcastByFeed(ctx, podcast.Feed); err != nil {
+		t.Errorf("podcast lost after reopening: %v", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `SELECT doc FROM episodes WHERE namespace = ? AND podcast_url = ? LIMIT ?`
+	sqlite := &sqlDB{}
+	if got := sqlite.rebind(query); got != query {
+		t.Errorf("SQLite query rewritten to %q", got)
+	}
+	postgres := &sqlDB{postgres: true}
+	want := `SELECT doc FROM episodes WHERE namespace = $1 AND podcast_url = $2 LIMIT $3`
+	if got := postgres.rebind(query); got != want {
+		t.Errorf("PostgreSQL query %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sharedStores are the environment variables that name the stores of
+// database servers to test against as well, such as
+// PODGO_TEST_MONGO=mongodb://localhost:27017. They should point at
+// throwaway servers: each test works in a namespace of its own there, but
+// its data is left behind.
+var sharedStores = map[string]string{
+	"mongo": "PODGO_TEST_MONGO",
+}
+
+// forEachStore runs fn against a fresh, initialized store of each kind that
+// can be tested here: the memory and SQLite stores, PostgreSQL if
+// PODGO_TEST_POSTGRES is set to a postgres:// URL, and those sharedStores
+// names.
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	dsns := map[string]string{
+		"memory": "memory:",
+		"sqlite": "sqlite:" + filepath.Join(t.TempDir(), "podgo.db"),
+	}
+	if dsn := os.Getenv("PODGO_TEST_POSTGRES"); dsn != "" {
+		dsns["postgres"] = postgresTestSchema(t, dsn)
+	}
+	for name, env := range sharedStores {
+		if dsn := os.Getenv(env); dsn != "" {
+			dsns[name] = dsn
+		}
+	}
+	for name, dsn := range dsns {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := openStore(ctx, dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close(ctx) })
+			if err := store.Init(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, shared := sharedStores[name]; shared {
+				fn(t, store.InNamespace("test-"+primitive.NewObjectID().Hex()))
+				return
+			}
+			fn(t, store)
+		})
+	}
+}
+
+// postgresTestSchema creates a schema of its own for the test in the
+// PostgreSQL database at dsn, dropped again when the test is done, and
+// returns dsn with that schema as its search path.
+func postgresTestSchema(t *testing.T, dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid PODGO_TEST_POSTGRES: %v", err)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	schema := "podgo_test_" + primitive.NewObjectID().Hex()
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// testEpisode returns an episode of podcast with guid, not stored yet.
+func testEpisode(podcast Podcast, guid string, published time.Time) Episode {
+	return Episode{
+		ID:             primitive.NewObjectID(),
+		PodcastUrl:     podcast.PodlistUrl,
+		PodlistUrl:     guid,
+		Guid:           guid,
+		NormalizedGuid: normalizeGUID(guid),
+		Title:          "Episode " + guid,
+		Published:      published,
+		Enclosure:      EpisodeEnclosure{Url: "https://cdn.example.com/" + guid + ".mp3"},
+	}
+}
+
+// testPodcast inserts a podcast with slug into store.
+func testPodcast(t testing.TB, store Store, slug string) Podcast {
+	t.Helper()
+	podcast := Podcast{Title: "Podcast " + slug, PodlistUrl: slug, Feed: "https://feeds.example.com/" + slug}
+	if err := store.InsertPodcast(context.Background(), &podcast); err != nil {
+		t.Fatal(err)
+	}
+	return podcast
+}
+
+func TestPodcastStatsSkipRemovedEpisodes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := Podcast{Title: "Tech Talk", PodlistUrl: "tech-talk", Feed: "https://a.example/feed"}
+		if err := store.InsertPodcast(ctx, &podcast); err != nil {
+			t.Fatal(err)
+		}
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		episodes := []Episode{
+			testEpisode(podcast, "ep1", day),
+			testEpisode(podcast, "ep2", day.AddDate(0, 0, 7)),
+			testEpisode(podcast, "ep3", day.AddDate(0, 0, 14)),
+		}
+		if _, err := store.InsertEpisodes(ctx, episodes); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateEpisode(ctx, episodes[2].ID, bson.M{"removedAt": day.AddDate(0, 0, 20)}); err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(what string) {
+			t.Helper()
+			got, err := store.PodcastByFeed(ctx, podcast.Feed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.EpisodeCount != 2 {
+				t.Errorf("%s: episode count %d, want 2", what, got.EpisodeCount)
+			}
+			if want := episodes[1].Published; !got.LatestEpisodeAt.Equal(want) {
+				t.Errorf("%s: latest episode at %s, want %s", what, got.LatestEpisodeAt, want)
+			}
+		}
+		if err := store.RefreshPodcastStats(ctx, podcast.PodlistUrl); err != nil {
+			t.Fatal(err)
+		}
+		check("refresh")
+		if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"episodeCount": 0}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.BackfillPodcastStats(ctx); err != nil {
+			t.Fatal(err)
+		}
+		check("backfill")
+	})
+}
+
+// testPodcast inserts a podcast with slug into store.
+
+func TestInsertEpisodesKeepsThemUnique(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := testPodcast(t, store, "tech-talk")
+		other := testPodcast(t, store, "other")
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		if _, err := store.InsertEpisodes(ctx, []Episode{testEpisode(podcast, "ep1", day)}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Another run got to ep1 first; its copy is skipped, the rest
+		// is inserted. The same GUID in another podcast is no duplicate.
+		again := testEpisode(podcast, " ep1 ", day)
+		again.Title = "Episode 1 again"
+		batch := []Episode{again, testEpisode(podcast, "ep2", day.AddDate(0, 0, 7)), testEpisode(other, "ep1", day)}
+		if _, err := store.InsertEpisodes(ctx, batch); err != nil {
+			t.Fatal(err)
+		}
+		episodes, err := store.Episodes(ctx, podcast.PodlistUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(episodes) != 2 {
+			t.Fatalf("%d episodes stored, want 2", len(episodes))
+		}
+		for _, e := range episodes {
+			if e.Title == again.Title {
+				t.Error("the stored episode was replaced by its duplicate")
+			}
+		}
+		if episodes, _ := store.Episodes(ctx, other.PodlistUrl); len(episodes) != 1 {
+			t.Errorf("%d episodes of the other podcast, want 1", len(episodes))
+		}
+	})
+}
+
+func TestStorePodcasts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := testPodcast(t, store, "tech-talk")
+		if podcast.ID.IsZero() {
+			t.Fatal("InsertPodcast didn't set the ID")
+		}
+		testPodcast(t, store, "other")
+
+		if err := store.UpdatePodcast(ctx, podcast.ID, bson.M{"author": "Jane Doe", "aliases": []string{"old-talk"}}); err != nil {
+			t.Fatal(err)
+		}
+		byFeed, err := store.PodcastByFeed(ctx, podcast.Feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if byFeed.ID != podcast.ID || byFeed.Author != "Jane Doe" {
+			t.Errorf("PodcastByFeed returned %s by %q", byFeed.ID.Hex(), byFeed.Author)
+		}
+		for _, slug := range []string{"tech-talk", "old-talk"} {
+			got, err := store.PodcastBySlug(ctx, slug)
+			if err != nil {
+				t.Errorf("PodcastBySlug(%q): %v", slug, err)
+			} else if got.ID != podcast.ID {
+				t.Errorf("PodcastBySlug(%q) returned %s", slug, got.PodlistUrl)
+			}
+		}
+		if _, err := store.PodcastBySlug(ctx, "missing"); err != errNotFound {
+			t.Errorf("PodcastBySlug of a missing slug: %v, want errNotFound", err)
+		}
+		podcasts, err := store.Podcasts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(podcasts) != 2 {
+			t.Errorf("%d podcasts, want 2", len(podcasts))
+		}
+	})
+}
+
+func TestStoreInsertEpisodes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := testPodcast(t, store, "tech-talk")
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		guidless := testEpisode(podcast, "", day)
+		guidless.PodlistUrl = "no-guid"
+
+		inserted, err := store.InsertEpisodes(ctx, []Episode{testEpisode(podcast, "ep1", day), testEpisode(podcast, "ep2", day), guidless})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inserted) != 3 {
+			t.Fatalf("inserted %d episodes, want 3", len(inserted))
+		}
+
+		// Stored GUIDs are left out, compared normalized, but episodes
+		// without a GUID are always inserted.
+		again := testEpisode(podcast, " ep1 ", day)
+		again.NormalizedGuid = normalizeGUID(again.Guid)
+		guidless.ID = primitive.NewObjectID()
+		inserted, err = store.InsertEpisodes(ctx, []Episode{again, testEpisode(podcast, "ep3", day), guidless})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range inserted {
+			got = append(got, e.PodlistUrl)
+		}
+		if want := []string{"ep3", "no-guid"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("second insert returned %q, want %q", got, want)
+		}
+
+		// Blank GUIDs don't match the episodes without one.
+		found, err := store.EpisodeGUIDs(ctx, podcast.PodlistUrl, []string{"ep1", " ep2", "ep9", ""})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]bool{"ep1": true, "ep2": true}; !reflect.DeepEqual(found, want) {
+			t.Errorf("EpisodeGUIDs returned %v, want %v", found, want)
+		}
+		episodes, err := store.EpisodesByGUID(ctx, podcast.PodlistUrl, []string{"ep2", " "})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(episodes) != 1 || episodes[0].Guid != "ep2" {
+			t.Errorf("EpisodesByGUID returned %d episodes", len(episodes))
+		}
+		all, err := store.Episodes(ctx, podcast.PodlistUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 5 {
+			t.Errorf("%d episodes stored, want 5", len(all))
+		}
+	})
+}
+
+func TestStoreUpdateEpisodes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := testPodcast(t, store, "tech-talk")
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		episodes := []Episode{testEpisode(podcast, "ep1", day), testEpisode(podcast, "ep2", day), testEpisode(podcast, "ep3", day)}
+		if _, err := store.InsertEpisodes(ctx, episodes); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.UpdateEpisode(ctx, episodes[0].ID, bson.M{"title": "Renamed"}); err != nil {
+			t.Fatal(err)
+		}
+		err := store.UpdateEpisodes(ctx, []EpisodeUpdate{
+			{ID: episodes[1].ID, Set: bson.M{"Duration": "10:00"}},
+			{ID: episodes[2].ID, Set: bson.M{"Duration": "20:00"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := store.SetEpisodesPodcastImage(ctx, podcast.PodlistUrl, "https://img.example.com/new.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("SetEpisodesPodcastImage changed %d episodes, want 3", n)
+		}
+		if err := store.DeleteEpisodes(ctx, []primitive.ObjectID{episodes[2].ID}); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := store.Episodes(ctx, podcast.PodlistUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byGUID := make(map[string]Episode)
+		for _, e := range stored {
+			byGUID[e.Guid] = e
+		}
+		if len(byGUID) != 2 {
+			t.Fatalf("%d episodes left, want 2", len(byGUID))
+		}
+		if got := byGUID["ep1"].Title; got != "Renamed" {
+			t.Errorf("ep1 title %q, want Renamed", got)
+		}
+		if got := byGUID["ep2"].Duration; got != "10:00" {
+			t.Errorf("ep2 duration %q, want 10:00", got)
+		}
+		if got := byGUID["ep2"].PodcastImage; got != "https://img.example.com/new.jpg" {
+			t.Errorf("ep2 podcast image %q", got)
+		}
+
+		moved, err := store.MoveEpisodes(ctx, podcast.PodlistUrl, "new-talk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != 2 {
+			t.Errorf("MoveEpisodes moved %d episodes, want 2", moved)
+		}
+		if left, _ := store.Episodes(ctx, podcast.PodlistUrl); len(left) != 0 {
+			t.Errorf("%d episodes left after moving them", len(left))
+		}
+	})
+}
+
+func TestStoreEpisodePage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		podcast := testPodcast(t, store, "tech-talk")
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		var episodes []Episode
+		for i := 1; i <= 5; i++ {
+			episodes = append(episodes, testEpisode(podcast, fmt.Sprintf("ep%d", i), day.AddDate(0, 0, i)))
+		}
+		if _, err := store.InsertEpisodes(ctx, episodes); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateEpisode(ctx, episodes[3].ID, bson.M{"removedAt": day}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			offset, limit int
+			oldestFirst   bool
+			want          []string
+		}{
+			{0, 10, false, []string{"ep5", "ep3", "ep2", "ep1"}},
+			{1, 2, false, []string{"ep3", "ep2"}},
+			{0, 2, true, []string{"ep1", "ep2"}},
+			{3, 10, true, []string{"ep5"}},
+		}
+		for _, tt := range tests {
+			page, err := store.EpisodePage(ctx, podcast.PodlistUrl, tt.offset, tt.limit, tt.oldestFirst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range page {
+				got = append(got, e.Guid)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EpisodePage(%d, %d, %v) = %q, want %q", tt.offset, tt.limit, tt.oldestFirst, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestStoreNamespaces(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		a, b := store.InNamespace("a"), store.InNamespace("b")
+		// The same slug may be used in each namespace.
+		inA := testPodcast(t, a, "tech-talk")
+		inB := testPodcast(t, b, "tech-talk")
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		if _, err := a.InsertEpisodes(ctx, []Episode{testEpisode(inA, "ep1", day)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.InsertEpisodes(ctx, []Episode{testEpisode(inB, "ep1", day), testEpisode(inB, "ep2", day)}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			store    Store
+			ns       string
+			episodes int
+		}{{a, "a", 1}, {b, "b", 2}} {
+			podcasts, err := tt.store.Podcasts(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(podcasts) != 1 || podcasts[0].Namespace != tt.ns {
+				t.Errorf("namespace %s sees %d podcasts", tt.ns, len(podcasts))
+			}
+			episodes, err := tt.store.Episodes(ctx, "tech-talk")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(episodes) != tt.episodes {
+				t.Errorf("namespace %s sees %d episodes, want %d", tt.ns, len(episodes), tt.episodes)
+			}
+		}
+	})
+}
+
+func TestStoreQuarantine(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		other := store.InNamespace("other-" + primitive.NewObjectID().Hex())
+		podcast := testPodcast(t, store, "tech-talk")
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		errs := []string{"title: missing title"}
+
+		// Quarantining an episode again replaces the entry.
+		quarantineEpisode(ctx, store, testEpisode(podcast, "ep1", day), errs)
+		quarantineEpisode(ctx, store, testEpisode(podcast, "ep1", day), errs)
+		// Episodes without a GUID are told apart by their enclosures.
+		for _, enclosure := range []string{"a.mp3", "b.mp3"} {
+			e := testEpisode(podcast, "", day)
+			e.Enclosure.Url = "https://cdn.example.com/" + enclosure
+			quarantineEpisode(ctx, store, e, errs)
+		}
+		// The same episode in another namespace has an entry of its own.
+		quarantineEpisode(ctx, other, testEpisode(podcast, "ep1", day), errs)
+
+		if got := quarantineKeys(t, store); fmt.Sprint(got) != "[enclosure cdn.example.com/a.mp3 enclosure cdn.example.com/b.mp3 ep1]" {
+			t.Errorf("quarantined %q", got)
+		}
+		if got := quarantineKeys(t, other); fmt.Sprint(got) != "[ep1]" {
+			t.Errorf("quarantined %q in the other namespace", got)
+		}
+	})
+}
+
+// quarantineKeys returns the sorted keys of the quarantine entries in the
+// namespace of store.
+func quarantineKeys(t *testing.T, store Store) []string {
+	t.Helper()
+	ctx := context.Background()
+	var keys []string
+	switch s := store.(type) {
+	case *memoryStore:
+		s.mu.Lock()
+		for _, q := range s.quarantine {
+			if q.Namespace == s.namespace {
+				keys = append(keys, q.Key)
+			}
+		}
+		s.mu.Unlock()
+	case *sqlStore:
+		rows, err := s.db.QueryContext(ctx, `SELECT episode_key FROM quarantine WHERE namespace = ?`, s.namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, key)
+		}
+	case *mongoStore:
+		var entries []QuarantinedEpisode
+		cursor, err := s.quarantine.Find(ctx, s.scoped(bson.M{}))
+		if err == nil {
+			err = cursor.All(ctx, &entries)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, q := range entries {
+			keys = append(keys, q.Key)
+		}
+	default:
+		t.Fatalf("can't read the quarantine of %T", store)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStoreCrawlRuns(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		start := time.Now().Add(time.Hour).Truncate(time.Second)
+		var ids []primitive.ObjectID
+		for i := 0; i < 3; i++ {
+			run := CrawlRun{StartedAt: start.Add(time.Duration(i) * time.Minute), FeedCount: i}
+			if err := store.InsertCrawlRun(ctx, &run); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, run.ID)
+		}
+		if err := store.UpdateCrawlRun(ctx, ids[2], bson.M{"processed": int64(7)}); err != nil {
+			t.Fatal(err)
+		}
+		runs, err := store.CrawlRuns(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 2 || runs[0].ID != ids[2] || runs[1].ID != ids[1] {
+			t.Fatalf("CrawlRuns didn't return the last two runs, newest first: %+v", runs)
+		}
+		if runs[0].Processed != 7 {
+			t.Errorf("processed %d, want 7", runs[0].Processed)
+		}
+	})
+}
+
+func TestStoreListedFeeds(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		// The feed list is shared by all namespaces, so the URLs are
+		// unique to the test.
+		feed := "https://" + primitive.NewObjectID().Hex() + ".example.com/feed"
+		for i, want := range []bool{true, false} {
+			added, err := store.InsertListedFeed(ctx, ListedFeed{URL: feed, Enabled: true, AddedAt: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != want {
+				t.Errorf("insert %d reported added %v, want %v", i+1, added, want)
+			}
+		}
+		if err := store.UpdateListedFeed(ctx, feed, "", bson.M{"enabled": false}); err != nil {
+			t.Fatal(err)
+		}
+		listed := func() []ListedFeed {
+			all, err := store.ListedFeeds(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ours []ListedFeed
+			for _, f := range all {
+				if f.URL == feed {
+					ours = append(ours, f)
+				}
+			}
+			return ours
+		}
+		if got := listed(); len(got) != 1 || got[0].Enabled {
+			t.Errorf("listed %+v, want the feed disabled", got)
+		}
+		if err := store.DeleteListedFeed(ctx, feed, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got := listed(); len(got) != 0 {
+			t.Errorf("feed still listed after deleting it")
+		}
+		if err := store.DeleteListedFeed(ctx, feed, ""); err != errNotFound {
+			t.Errorf("deleting an unlisted feed: %v, want errNotFound", err)
+		}
+		if err := store.UpdateListedFeed(ctx, feed, "", bson.M{"enabled": true}); err != errNotFound {
+			t.Errorf("updating an unlisted feed: %v, want errNotFound", err)
+		}
+	})
+}
+
+func TestStoreFeedMeta(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		feed := "https://" + primitive.NewObjectID().Hex() + ".example.com/feed"
+		meta := FeedMeta{Feed: feed, ETag: `"abc"`, Hash: "h1", FetchedAt: time.Now().UTC().Truncate(time.Second)}
+		if err := store.SetFeedMeta(ctx, meta); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.FeedMeta(ctx, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ETag != meta.ETag || got.Hash != meta.Hash || !got.FetchedAt.Equal(meta.FetchedAt) {
+			t.Errorf("FeedMeta returned %+v, want %+v", got, meta)
+		}
+		// Without validators the entry goes.
+		if err := store.SetFeedMeta(ctx, FeedMeta{Feed: feed}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.FeedMeta(ctx, feed); err != errNotFound {
+			t.Errorf("FeedMeta after clearing it: %v, want errNotFound", err)
+		}
+	})
+}
+
+// closeRecorder records the context it is closed with.
+type closeRecorder struct {
+	Store
+	closed   bool
+	err      error
+	deadline time.Time
+}
+
+func (s *closeRecorder) Close(ctx context.Context) error {
+	s.closed = true
+	s.err = ctx.Err()
+	s.deadline, _ = ctx.Deadline()
+	return s.Store.Close(ctx)
+}
+
+func TestCloseStoreAfterRunTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	opened, err := openStore(ctx, "sqlite:"+filepath.Join(t.TempDir(), "podgo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+
+	logs := captureLogs(t)
+	store := &closeRecorder{Store: opened}
+	closeStore(store)
+	if !store.closed {
+		t.Fatal("store wasn't closed")
+	}
+	if store.err != nil {
+		t.Errorf("store closed with a done context: %v", store.err)
+	}
+	if left := time.Until(store.deadline); left <= 0 || left > storeCloseTimeout {
+		t.Errorf("store closed with %s left, want up to %s", left, storeCloseTimeout)
+	}
+	if out := logs.String(); out != "" {
+		t.Errorf("closing logged:\n%s", out)
+	}
+}
+
+func TestMongoInitFailsOnDuplicates(t *testing.T) {
+	dsn := os.Getenv("PODGO_TEST_MONGO")
+	if dsn == "" {
+		t.Skip("PODGO_TEST_MONGO isn't set")
+	}
+	ctx := context.Background()
+	opened, err := openStore(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer opened.Close(ctx)
+	if err := opened.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store := opened.InNamespace("test-" + primitive.NewObjectID().Hex()).(*mongoStore)
+	podcast := testPodcast(t, store, "tech-talk")
+
+	// Duplicates stored while the unique index was missing.
+	if _, err := store.episodes.Indexes().DropOne(ctx, "namespace_1_podcastUrl_1_normalizedGuid_1"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		store.episodes.DeleteMany(ctx, bson.M{"namespace": store.namespace})
+		opened.Init(ctx)
+	}()
+	first, copied := testEpisode(podcast, "ep1", feedEpoch), testEpisode(podcast, "ep1", feedEpoch)
+	first.Namespace, copied.Namespace = store.namespace, store.namespace
+	if _, err := store.episodes.InsertMany(ctx, []interface{}{first, copied}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Init(ctx); err == nil {
+		t.Error("Init succeeded without the unique episode index")
+	}
+	if err := repairGUIDs(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(ctx); err != nil {
+		t.Errorf("Init after removing the duplicates: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errWatchUnsupported is returned when the store can't report new
+// episodes as they are inserted.
+var errWatchUnsupported = errors.New("watching for new episodes needs MongoDB running as a replica set")
+
+// episodeWatcher is implemented by stores that can report episodes as they
+// are inserted, which so far is MongoDB with change streams.
+type episodeWatcher interface {
+	// WatchEpisodes calls fn with every episode inserted into the
+	// namespace of the store until ctx is done.
+	WatchEpisodes(ctx context.Context, fn func(Episode)) error
+}
+
+// streamKeepAlive is how often an idle event stream gets a comment, so
+// proxies don't close it.
+const streamKeepAlive = 30 * time.Second
+
+// streamClientBuffer is how many episodes may queue up for a client
+// before further ones are dropped for it.
+const streamClientBuffer = 64
+
+// episodeBroadcaster hands new episodes to every connected client. Slow
+// clients miss episodes rather than hold up the others.
+type episodeBroadcaster struct {
+	mu      sync.Mutex
+	clients map[chan Episode]struct{}
+}
+
+func newEpisodeBroadcaster() *episodeBroadcaster {
+	return &episodeBroadcaster{clients: make(map[chan Episode]struct{})}
+}
+
+func (b *episodeBroadcaster) subscribe() chan Episode {
+	ch := make(chan Episode, streamClientBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[ch] = struct{}{}
+	return ch
+}
+
+func (b *episodeBroadcaster) unsubscribe(ch chan Episode) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, ch)
+}
+
+func (b *episodeBroadcaster) publish(e Episode) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// watchEpisodes publishes the episodes inserted into store to b until ctx
+// is done.
+func watchEpisodes(ctx context.Context, store Store, b *episodeBroadcaster) error {
+	w, ok := store.(episodeWatcher)
+	if !ok {
+		return errWatchUnsupported
+	}
+	return w.WatchEpisodes(ctx, b.publish)
+}
+
+// streamEpisodesHandler serves GET /stream/episodes, which sends every
+// new episode as a Server-Sent Event.
+func streamEpisodesHandler(b *episodeBroadcaster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ch := b.subscribe()
+		defer b.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case e := <-ch:
+				data, err := json.Marshal(newAPIEpisode(e))
+				if err != nil {
+					errorf(context.Background(), "Error encoding episode %s of %s: %v", e.Guid, e.PodcastUrl, err)
+					continue
+				}
+				fmt.Fprintf(w, "id: %s\nevent: episode\ndata: %s\n\n", e.ID.Hex(), data)
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// readingWordsPerMinute is the reading speed reading time estimates assume.
+const readingWordsPerMinute = 200
+
+// plainText strips all markup from an HTML fragment such as show notes and
+// collapses whitespace. Text inside script and style elements is dropped.
+func plainText(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var b strings.Builder
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		case html.StartTagToken:
+			if name, _ := z.TagName(); isRawTextTag(name) {
+				skip++
+			}
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			if name, _ := z.TagName(); isRawTextTag(name) && skip > 0 {
+				skip--
+			}
+			b.WriteByte(' ')
+		case html.SelfClosingTagToken:
+			b.WriteByte(' ')
+		}
+	}
+}
+
+func isRawTextTag(name []byte) bool {
+	return string(name) == "script" || string(name) == "style"
+}
+
+// showNotesStats returns the number of words in the show notes and the time
+// in seconds it takes to read them.
+func showNotesStats(notes string) (words, readingSeconds int) {
+	words = len(strings.Fields(plainText(notes)))
+	readingSeconds = (words*60 + readingWordsPerMinute - 1) / readingWordsPerMinute
+	return words, readingSeconds
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"Just   plain\n\ttext", "Just plain text"},
+		{"<p>Show <b>notes</b></p><p>Second&nbsp;paragraph &amp; more</p>", "Show notes Second paragraph & more"},
+		{"one<br/>two<br>three", "one two three"},
+		{"<style>p { color: red }</style><p>Visible</p><script>alert('x')</script>", "Visible"},
+		{"<ul><li>Links:</li><li><a href=\"https://example.com\">example</a></li></ul>", "Links: example"},
+	}
+	for _, tt := range tests {
+		if got := plainText(tt.in); got != tt.want {
+			t.Errorf("plainText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestShowNotesStats(t *testing.T) {
+	tests := []struct {
+		name           string
+		notes          string
+		words, seconds int
+	}{
+		{"empty", "", 0, 0},
+		{"whitespace", " \n\t ", 0, 0},
+		{"plain text", "In this episode we talk about compilers.", 7, 3},
+		{"HTML", `<p>In this <a href="https://example.com/a-very-long-link">episode</a></p><ul><li>compilers</li><li>linkers</li></ul>`, 5, 2},
+		{"markup only", `<p><img src="cover.jpg"/></p><script>var words = "not counted";</script>`, 0, 0},
+		{"a minute", strings.Repeat("word ", readingWordsPerMinute), readingWordsPerMinute, 60},
+	}
+	for _, tt := range tests {
+		words, seconds := showNotesStats(tt.notes)
+		if words != tt.words || seconds != tt.seconds {
+			t.Errorf("%s: %d words, %d seconds, want %d words, %d seconds", tt.name, words, seconds, tt.words, tt.seconds)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// Feeds hint at how often they change with the RSS <ttl> element or the
+// syndication namespace (sy:updatePeriod, sy:updateFrequency).
+
+// rssTranslator is gofeed's RSS translator, except that it keeps the <ttl>
+// of the channel and the isPermaLink attribute of item GUIDs, which the
+// universal feed has no fields for, in Custom.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := feed.(*rss.Feed); ok && strings.TrimSpace(f.TTL) != "" {
+		if result.Custom == nil {
+			result.Custom = make(map[string]string)
+		}
+		result.Custom["ttl"] = strings.TrimSpace(f.TTL)
+	}
+	if f, ok := feed.(*rss.Feed); ok && len(f.Items) == len(result.Items) {
+		for i, item := range f.Items {
+			if item.GUID == nil || item.GUID.IsPermalink == "" {
+				continue
+			}
+			if result.Items[i].Custom == nil {
+				result.Items[i].Custom = make(map[string]string)
+			}
+			result.Items[i].Custom[guidPermalinkKey] = item.GUID.IsPermalink
+		}
+	}
+	return result, nil
+}
+
+// syndicationPeriods maps the values of sy:updatePeriod to minutes.
+var syndicationPeriods = map[string]int{
+	"hourly":  60,
+	"daily":   24 * 60,
+	"weekly":  7 * 24 * 60,
+	"monthly": 30 * 24 * 60,
+	"yearly":  365 * 24 * 60,
+}
+
+// updateIntervalMinutes returns how often feed says it is updated, or zero
+// if it doesn't say. The ttl wins over the syndication namespace.
+func updateIntervalMinutes(feed *gofeed.Feed) int {
+	if ttl, err := strconv.Atoi(feed.Custom["ttl"]); err == nil && ttl > 0 {
+		return ttl
+	}
+
+	sy := feed.Extensions["sy"]
+	if sy == nil {
+		return 0
+	}
+	var period string
+	if e := sy["updatePeriod"]; len(e) > 0 {
+		period = strings.ToLower(strings.TrimSpace(e[0].Value))
+	}
+	minutes, ok := syndicationPeriods[period]
+	if !ok {
+		return 0
+	}
+	frequency := 1
+	if e := sy["updateFrequency"]; len(e) > 0 {
+		if f, err := strconv.Atoi(strings.TrimSpace(e[0].Value)); err == nil && f > 0 {
+			frequency = f
+		}
+	}
+	if minutes/frequency < 1 {
+		return 1
+	}
+	return minutes / frequency
+}
+
+// lastBuildDate returns the lastBuildDate of feed, or the zero time.
+func lastBuildDate(feed *gofeed.Feed) time.Time {
+	if feed.UpdatedParsed == nil {
+		return time.Time{}
+	}
+	return *feed.UpdatedParsed
+}
+
+// notDueForFetch looks up the podcast of feedURL and reports whether it is
+// notDueYet.
+func notDueForFetch(ctx context.Context, store Store, feedURL string, existingPodcastFeeds map[string]bool) bool {
+	podcastIndex.Lock()
+	known := existingPodcastFeeds[feedURL]
+	podcastIndex.Unlock()
+	if !known {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.DBTimeout)
+	defer cancel()
+	podcast, err := store.PodcastByFeed(ctx, feedURL)
+	if err != nil {
+		return false
+	}
+	return notDueYet(podcast, time.Now())
+}
+
+// notDueYet reports whether podcast was fetched successfully more recently
+// than the update interval its feed declares, so fetching it again can't
+// turn up anything new, or whether its host asked us to wait longer.
+func notDueYet(podcast Podcast, now time.Time) bool {
+	if scheduler.waiting(podcast, now) {
+		return true
+	}
+	if !config.HonorUpdateHints {
+		return false
+	}
+	if now.Before(podcast.RetryAfter) {
+		return true
+	}
+	if podcast.UpdateIntervalMinutes <= 0 || podcast.LastSuccessAt.IsZero() {
+		return false
+	}
+	interval := time.Duration(podcast.UpdateIntervalMinutes) * time.Minute
+	return now.Before(podcast.LastSuccessAt.Add(interval))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// hintsFeed returns a feed whose channel holds channel, which may declare
+// update hints.
+func hintsFeed(channel string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:sy="http://purl.org/rss/1.0/modules/syndication/">
+  <channel>
+    <title>Tech Talk</title>
+    %s
+  </channel>
+</rss>`, channel)
+}
+
+func TestUpdateIntervalMinutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		want    int
+	}{
+		{"none", "", 0},
+		{"ttl", "<ttl>90</ttl>", 90},
+		{"invalid ttl", "<ttl>soon</ttl>", 0},
+		{"negative ttl", "<ttl>-5</ttl>", 0},
+		{"hourly", "<sy:updatePeriod>hourly</sy:updatePeriod>", 60},
+		{"daily", "<sy:updatePeriod> Daily </sy:updatePeriod>", 24 * 60},
+		{"twice weekly", "<sy:updatePeriod>weekly</sy:updatePeriod><sy:updateFrequency>2</sy:updateFrequency>", 7 * 24 * 60 / 2},
+		{"invalid frequency", "<sy:updatePeriod>daily</sy:updatePeriod><sy:updateFrequency>often</sy:updateFrequency>", 24 * 60},
+		{"more than once a minute", "<sy:updatePeriod>hourly</sy:updatePeriod><sy:updateFrequency>120</sy:updateFrequency>", 1},
+		{"unknown period", "<sy:updatePeriod>fortnightly</sy:updatePeriod>", 0},
+		{"ttl over syndication", "<ttl>30</ttl><sy:updatePeriod>daily</sy:updatePeriod>", 30},
+	}
+	for _, tt := range tests {
+		fp := gofeed.NewParser()
+		fp.RSSTranslator = &rssTranslator{}
+		feed, err := fp.ParseString(hintsFeed(tt.channel))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := updateIntervalMinutes(feed); got != tt.want {
+			t.Errorf("%s: %d minutes, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLastBuildDate(t *testing.T) {
+	feed, err := gofeed.NewParser().ParseString(hintsFeed("<lastBuildDate>Wed, 15 May 2024 06:00:00 GMT</lastBuildDate>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lastBuildDate(feed), time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("last build date %s, want %s", got, want)
+	}
+	feed, err = gofeed.NewParser().ParseString(hintsFeed(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lastBuildDate(feed); !got.IsZero() {
+		t.Errorf("feed without lastBuildDate built at %s", got)
+	}
+}
+
+func TestNotDueYet(t *testing.T) {
+	defaults := config
+	defer func() { config = defaults }()
+	config.HonorUpdateHints = true
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		podcast Podcast
+		want    bool
+	}{
+		{"no hint", Podcast{LastSuccessAt: now.Add(-time.Minute)}, false},
+		{"never fetched", Podcast{UpdateIntervalMinutes: 60}, false},
+		{"within interval", Podcast{UpdateIntervalMinutes: 60, LastSuccessAt: now.Add(-30 * time.Minute)}, true},
+		{"interval passed", Podcast{UpdateIntervalMinutes: 60, LastSuccessAt: now.Add(-90 * time.Minute)}, false},
+		{"retry after", Podcast{RetryAfter: now.Add(time.Hour)}, true},
+	}
+	for _, tt := range tests {
+		if got := notDueYet(tt.podcast, now); got != tt.want {
+			t.Errorf("%s: not due %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	config.HonorUpdateHints = false
+	if notDueYet(tests[2].podcast, now) {
+		t.Error("hints are honored without --honor-update-hints")
+	}
+}
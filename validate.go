@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Validation rules, each of which can be turned off with --disable-rules
+// for feeds that legitimately break it.
+const (
+	ruleTitle             = "title"
+	rulePublished         = "published"
+	ruleEnclosureURL      = "enclosure-url"
+	ruleDescriptionLength = "description-length"
+)
+
+var validationRules = []string{ruleTitle, rulePublished, ruleEnclosureURL, ruleDescriptionLength}
+
+const maxDescriptionLength = 100000
+
+// earliestPublished is the earliest plausible publish date. Anything before
+// it, typically 1970-01-01, is a broken date.
+var earliestPublished = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// QuarantinedEpisode is an episode that failed validation, kept apart from
+// the real episodes together with what was wrong with it. Key tells the
+// episodes of a podcast apart, also those without a GUID; see episodeKey.
+type QuarantinedEpisode struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	Namespace      string             `bson:"namespace,omitempty"`
+	PodcastUrl     string             `bson:"podcastUrl"`
+	NormalizedGuid string             `bson:"normalizedGuid"`
+	Key            string             `bson:"key"`
+	Errors         []string           `bson:"errors"`
+	QuarantinedAt  time.Time          `bson:"quarantinedAt"`
+	Episode        Episode            `bson:"episode"`
+}
+
+func ruleEnabled(rule string) bool {
+	return !containsString(config.DisabledRules, rule)
+}
+
+func validTitle(title string) bool {
+	t := strings.TrimSpace(title)
+	return t != "" && !strings.EqualFold(t, "null") && !strings.EqualFold(t, "undefined")
+}
+
+// validatePodcast returns the validation errors of podcast.
+func validatePodcast(p Podcast) []string {
+	var errs []string
+	if ruleEnabled(ruleTitle) && !validTitle(p.Title) {
+		errs = append(errs, fmt.Sprintf("%s: missing title %q", ruleTitle, p.Title))
+	}
+	if ruleEnabled(ruleDescriptionLength) && len(p.Description) > maxDescriptionLength {
+		errs = append(errs, fmt.Sprintf("%s: description is %d bytes long", ruleDescriptionLength, len(p.Description)))
+	}
+	return errs
+}
+
+// validateEpisode returns the validation errors of e.
+func validateEpisode(e Episode, now time.Time) []string {
+	var errs []string
+	if ruleEnabled(ruleTitle) && !validTitle(e.Title) {
+		errs = append(errs, fmt.Sprintf("%s: missing title %q", ruleTitle, e.Title))
+	}
+	if ruleEnabled(rulePublished) && (e.Published.Before(earliestPublished) || e.Published.After(now.Add(48*time.Hour))) {
+		errs = append(errs, fmt.Sprintf("%s: implausible publish date %s", rulePublished, e.Published.Format(time.RFC3339)))
+	}
+	if ruleEnabled(ruleEnclosureURL) && !validEnclosureURL(e.Enclosure.Url) {
+		errs = append(errs, fmt.Sprintf("%s: invalid enclosure URL %q", ruleEnclosureURL, e.Enclosure.Url))
+	}
+	if ruleEnabled(ruleDescriptionLength) && (len(e.Description) > maxDescriptionLength || len(e.Content) > maxDescriptionLength) {
+		errs = append(errs, fmt.Sprintf("%s: description or content longer than %d bytes", ruleDescriptionLength, maxDescriptionLength))
+	}
+	return errs
+}
+
+func validEnclosureURL(raw string) bool {
+	return isHTTPURL(raw)
+}
+
+// isHTTPURL reports whether raw is an absolute http or https URL.
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// quarantineEpisode stores e in the quarantine instead of the episodes.
+func quarantineEpisode(ctx context.Context, store Store, e Episode, errs []string) {
+	q := QuarantinedEpisode{
+		PodcastUrl:     e.PodcastUrl,
+		NormalizedGuid: e.NormalizedGuid,
+		Key:            episodeKey(e),
+		Errors:         errs,
+		QuarantinedAt:  time.Now(),
+		Episode:        e,
+	}
+	if err := store.QuarantineEpisode(ctx, q); err != nil {
+		errorf(ctx, "Error quarantining episode %s of %s: %v", e.Guid, e.PodcastUrl, err)
+	}
+	debugf(ctx, "Quarantined episode %s of %s: %s", e.Guid, e.PodcastUrl, strings.Join(errs, "; "))
+}
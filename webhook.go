@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	webhookAttempts  = 3
+	webhookQueueSize = 100
+)
+
+type webhookPayload struct {
+	PodcastTitle string           `json:"podcastTitle"`
+	PodlistUrl   string           `json:"podlistUrl"`
+	Episodes     []webhookEpisode `json:"episodes"`
+}
+
+type webhookEpisode struct {
+	Title string `json:"title"`
+	Guid  string `json:"guid"`
+}
+
+// webhookNotifier delivers new episode notifications in the background so
+// a slow or broken receiver never holds up ingestion. A nil notifier
+// silently drops everything.
+type webhookNotifier struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+	queue   chan webhookPayload
+	wg      sync.WaitGroup
+}
+
+var webhooks *webhookNotifier
+
+func newWebhookNotifier(url string, timeout time.Duration) *webhookNotifier {
+	n := &webhookNotifier{
+		url:     url,
+		timeout: timeout,
+		// The webhook target is configured by the operator, so it must not
+		// be subject to the private address guard of the feed client.
+		client: &http.Client{},
+		queue:  make(chan webhookPayload, webhookQueueSize),
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n
+}
+
+// Notify queues a notification about new episodes of podcast.
+func (n *webhookNotifier) Notify(podcast Podcast, episodes []Episode) {
+	if n == nil || len(episodes) == 0 {
+		return
+	}
+	p := webhookPayload{PodcastTitle: podcast.Title, PodlistUrl: podcast.PodlistUrl}
+	for _, e := range episodes {
+		p.Episodes = append(p.Episodes, webhookEpisode{Title: e.Title, Guid: e.Guid})
+	}
+	select {
+	case n.queue <- p:
+	default:
+		warnf(context.Background(), "webhook queue full, dropping notification for %s", podcast.Title)
+	}
+}
+
+// Close waits for all queued notifications to be delivered or given up on.
+func (n *webhookNotifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	n.wg.Wait()
+}
+
+func (n *webhookNotifier) run() {
+	defer n.wg.Done()
+	for p := range n.queue {
+		var err error
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			if err = n.deliver(p); err == nil {
+				break
+			}
+			if attempt < webhookAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			warnf(context.Background(), "webhook delivery for %s failed: %v", p.PodlistUrl, err)
+		}
+	}
+}
+
+func (n *webhookNotifier) deliver(p webhookPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}